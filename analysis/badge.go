@@ -0,0 +1,49 @@
+package analysis
+
+import (
+	"test-analyzer/ingestion"
+)
+
+// BadgeStats summarizes the last runs of a branch for a status badge.
+// FlakyTests counts the tests classified as flaky over those runs only.
+type BadgeStats struct {
+	Runs       int
+	PassCount  int
+	FailCount  int
+	PassRate   float64
+	FlakyTests int
+}
+
+// ComputeBadgeStats summarizes outcomes of the last runs runs of a branch,
+// all branches if empty.
+func ComputeBadgeStats(outcomes []ingestion.TestOutcome, branch string, runs int) BadgeStats {
+	outcomes = filterBranch(outcomes, branch)
+	recent := ComputeRunMetrics(outcomes)
+	if runs > 0 && len(recent) > runs {
+		recent = recent[:runs]
+	}
+
+	stats := BadgeStats{Runs: len(recent)}
+	included := make(map[uint]bool)
+	for _, run := range recent {
+		included[run.ReportGroupID] = true
+		stats.PassCount += run.PassCount
+		stats.FailCount += run.FailCount
+	}
+	if stats.PassCount+stats.FailCount > 0 {
+		stats.PassRate = float64(stats.PassCount) / float64(stats.PassCount+stats.FailCount)
+	}
+
+	window := make([]ingestion.TestOutcome, 0)
+	for _, o := range outcomes {
+		if included[o.ReportGroupID] {
+			window = append(window, o)
+		}
+	}
+	for _, f := range ComputeFlakiness(window, DefaultFlakinessOptions()) {
+		if f.Classification == ClassificationFlaky {
+			stats.FlakyTests += 1
+		}
+	}
+	return stats
+}
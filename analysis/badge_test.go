@@ -0,0 +1,54 @@
+package analysis
+
+import (
+	"test-analyzer/ingestion"
+	"testing"
+)
+
+// badgeHistory turns a string of 'p' and 'f' into the results of test case
+// id in runs first, first + 1 and so on of branch.
+func badgeHistory(id uint, branch string, first uint, results string) []ingestion.TestOutcome {
+	outcomes := make([]ingestion.TestOutcome, 0, len(results))
+	for i, r := range results {
+		status := "pass"
+		if r == 'f' {
+			status = "fail"
+		}
+		run := first + uint(i)
+		outcomes = append(outcomes, ingestion.TestOutcome{
+			TestCaseID:    id,
+			Label:         "TestBadge",
+			Status:        status,
+			ReportGroupID: run,
+			RunID:         int64(run),
+			HeadBranch:    branch,
+			Start:         int64(run),
+		})
+	}
+	return outcomes
+}
+
+func TestComputeBadgeStats(t *testing.T) {
+	outcomes := badgeHistory(1, "master", 1, "pfpfpfpf")
+	outcomes = append(outcomes, badgeHistory(2, "master", 1, "pppppppp")...)
+	outcomes = append(outcomes, badgeHistory(1, "feature", 100, "fff")...)
+
+	tests := []struct {
+		name   string
+		branch string
+		runs   int
+		want   BadgeStats
+	}{
+		{name: "branch", branch: "master", want: BadgeStats{Runs: 8, PassCount: 12, FailCount: 4, PassRate: 0.75, FlakyTests: 1}},
+		// flakiness only counts the results of the window, too few here
+		{name: "last runs", branch: "master", runs: 4, want: BadgeStats{Runs: 4, PassCount: 6, FailCount: 2, PassRate: 0.75}},
+		{name: "more runs than stored", branch: "master", runs: 20, want: BadgeStats{Runs: 8, PassCount: 12, FailCount: 4, PassRate: 0.75, FlakyTests: 1}},
+		{name: "all branches", runs: 3, want: BadgeStats{Runs: 3, FailCount: 3}},
+		{name: "unknown branch", branch: "release", want: BadgeStats{}},
+	}
+	for _, tt := range tests {
+		if got := ComputeBadgeStats(outcomes, tt.branch, tt.runs); got != tt.want {
+			t.Errorf("%s: got %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
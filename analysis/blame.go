@@ -0,0 +1,148 @@
+package analysis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/go-github/v48/github"
+	"strings"
+	"test-analyzer/ingestion"
+	"time"
+)
+
+// ErrNotFailing is returned by BlameTest for a test whose latest result on
+// the branch is not a failure.
+var ErrNotFailing = errors.New("test is not currently failing")
+
+type SuspectCommit struct {
+	SHA     string
+	Message string
+	Author  string
+	Date    time.Time
+	URL     string
+}
+
+// CommitComparer lists the commits that are reachable from head but not from
+// base, oldest first.
+type CommitComparer interface {
+	CompareCommits(ctx context.Context, base string, head string) ([]SuspectCommit, error)
+}
+
+// GitHubCommitComparer compares commits with the GitHub API.
+type GitHubCommitComparer struct {
+	Client *github.Client
+	Owner  string
+	Repo   string
+	// MaxPages caps the number of pages of commits fetched per comparison
+	MaxPages int
+}
+
+// comparePageSize is the most commits GitHub returns per page of a comparison
+const comparePageSize = 100
+
+func (c GitHubCommitComparer) CompareCommits(ctx context.Context, base string, head string) ([]SuspectCommit, error) {
+	result := make([]SuspectCommit, 0)
+	maxPages := c.MaxPages
+	if maxPages <= 0 {
+		maxPages = 10
+	}
+
+	for page := 1; page <= maxPages; page++ {
+		comparison, _, err := c.Client.Repositories.CompareCommits(ctx, c.Owner, c.Repo, base, head, &github.ListOptions{
+			Page:    page,
+			PerPage: comparePageSize,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to compare %s...%s: %w", base, head, err)
+		}
+
+		for _, rc := range comparison.Commits {
+			sc := SuspectCommit{
+				SHA: rc.GetSHA(),
+				URL: rc.GetHTMLURL(),
+			}
+			if commit := rc.GetCommit(); commit != nil {
+				sc.Message = strings.SplitN(commit.GetMessage(), "\n", 2)[0]
+				sc.Author = commit.GetAuthor().GetName()
+				sc.Date = commit.GetAuthor().GetDate()
+			}
+			if login := rc.GetAuthor().GetLogin(); login != "" {
+				sc.Author = login
+			}
+			result = append(result, sc)
+		}
+
+		if len(comparison.Commits) == 0 || len(result) >= comparison.GetTotalCommits() {
+			break
+		}
+	}
+
+	return result, nil
+}
+
+// Blame narrows a test that is failing on a branch down to the commits that
+// landed between its last passing and its first failing run.
+type Blame struct {
+	TestCaseID uint
+	TestLabel  string
+	Package    string
+	Branch     string
+	FailStreak int
+
+	LastGoodSHA      string
+	LastGoodRunID    int64
+	FirstBadSHA      string
+	FirstBadRunID    int64
+	FirstBadRunLabel string
+
+	Commits []SuspectCommit
+}
+
+// BlameTest finds the last good and first bad SHAs of the test's current
+// failure streak on branch in history, oldest first, and asks comparer for
+// the commits in between. Without a passing run before the streak there is
+// nothing to compare and Commits is empty.
+func BlameTest(ctx context.Context, history []ingestion.TestOutcome, branch string, comparer CommitComparer) (*Blame, error) {
+	history = filterBranch(history, branch)
+	if len(history) == 0 || !history[len(history)-1].Failed() {
+		return nil, ErrNotFailing
+	}
+
+	firstBad := len(history) - 1
+	for firstBad > 0 && history[firstBad-1].Failed() {
+		firstBad -= 1
+	}
+	bad := history[firstBad]
+
+	blame := &Blame{
+		TestCaseID:       bad.TestCaseID,
+		TestLabel:        bad.Label,
+		Package:          bad.Package,
+		Branch:           branch,
+		FailStreak:       len(history) - firstBad,
+		FirstBadSHA:      bad.HeadSHA,
+		FirstBadRunID:    bad.RunID,
+		FirstBadRunLabel: bad.RunLabel,
+		Commits:          make([]SuspectCommit, 0),
+	}
+
+	if firstBad == 0 {
+		return blame, nil
+	}
+
+	good := history[firstBad-1]
+	blame.LastGoodSHA = good.HeadSHA
+	blame.LastGoodRunID = good.RunID
+
+	if blame.LastGoodSHA == "" || blame.FirstBadSHA == "" || blame.LastGoodSHA == blame.FirstBadSHA {
+		return blame, nil
+	}
+
+	if commits, err := comparer.CompareCommits(ctx, blame.LastGoodSHA, blame.FirstBadSHA); err != nil {
+		return blame, err
+	} else {
+		blame.Commits = commits
+	}
+
+	return blame, nil
+}
@@ -0,0 +1,254 @@
+package analysis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/google/go-github/v48/github"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"test-analyzer/ingestion"
+	"testing"
+)
+
+// fakeCommitComparer answers comparisons from a linear history held in memory,
+// oldest commit first.
+type fakeCommitComparer struct {
+	Commits []SuspectCommit
+}
+
+func (c fakeCommitComparer) CompareCommits(_ context.Context, base string, head string) ([]SuspectCommit, error) {
+	baseIndex, headIndex := -1, -1
+	for i, commit := range c.Commits {
+		if commit.SHA == base {
+			baseIndex = i
+		}
+		if commit.SHA == head {
+			headIndex = i
+		}
+	}
+	if baseIndex < 0 || headIndex < 0 {
+		return nil, fmt.Errorf("unknown commit in %s...%s", base, head)
+	}
+	if headIndex < baseIndex {
+		return []SuspectCommit{}, nil
+	}
+
+	return append([]SuspectCommit{}, c.Commits[baseIndex+1:headIndex+1]...), nil
+}
+
+func fakeHistory(n int) fakeCommitComparer {
+	c := fakeCommitComparer{}
+	for i := 0; i < n; i++ {
+		c.Commits = append(c.Commits, SuspectCommit{SHA: fmt.Sprintf("c%d", i)})
+	}
+	return c
+}
+
+func outcome(status string, sha string, branch string) ingestion.TestOutcome {
+	return ingestion.TestOutcome{TestCaseID: 1, Label: "TestA", Status: status, HeadSHA: sha, HeadBranch: branch}
+}
+
+func shas(commits []SuspectCommit) []string {
+	result := make([]string, 0, len(commits))
+	for _, c := range commits {
+		result = append(result, c.SHA)
+	}
+	return result
+}
+
+func TestFakeCommitComparer(t *testing.T) {
+	c := fakeHistory(5)
+	tests := []struct {
+		base, head string
+		want       []string
+		err        bool
+	}{
+		{base: "c1", head: "c3", want: []string{"c2", "c3"}},
+		{base: "c0", head: "c1", want: []string{"c1"}},
+		{base: "c2", head: "c2", want: []string{}},
+		{base: "c3", head: "c1", want: []string{}},
+		{base: "c1", head: "nope", err: true},
+	}
+	for _, tt := range tests {
+		got, err := c.CompareCommits(context.Background(), tt.base, tt.head)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s...%s: expected an error", tt.base, tt.head)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s...%s: %v", tt.base, tt.head, err)
+		}
+		if fmt.Sprint(shas(got)) != fmt.Sprint(tt.want) {
+			t.Errorf("%s...%s = %v, want %v", tt.base, tt.head, shas(got), tt.want)
+		}
+	}
+}
+
+func TestBlameTest(t *testing.T) {
+	comparer := fakeHistory(10)
+	tests := []struct {
+		name         string
+		history      []ingestion.TestOutcome
+		branch       string
+		err          error
+		lastGood     string
+		firstBad     string
+		streak       int
+		wantCommits  []string
+		compareError bool
+	}{
+		{
+			name: "streak after a pass",
+			history: []ingestion.TestOutcome{
+				outcome("pass", "c1", "master"),
+				outcome("pass", "c2", "master"),
+				outcome("fail", "c5", "master"),
+				outcome("fail", "c6", "master"),
+			},
+			branch:      "master",
+			lastGood:    "c2",
+			firstBad:    "c5",
+			streak:      2,
+			wantCommits: []string{"c3", "c4", "c5"},
+		},
+		{
+			name: "other branches are ignored",
+			history: []ingestion.TestOutcome{
+				outcome("pass", "c1", "master"),
+				outcome("pass", "c7", "feature"),
+				outcome("fail", "c3", "master"),
+				outcome("fail", "c8", "feature"),
+			},
+			branch:      "master",
+			lastGood:    "c1",
+			firstBad:    "c3",
+			streak:      1,
+			wantCommits: []string{"c2", "c3"},
+		},
+		{
+			name: "failing since the first run",
+			history: []ingestion.TestOutcome{
+				outcome("fail", "c1", "master"),
+				outcome("fail", "c2", "master"),
+			},
+			branch:      "master",
+			firstBad:    "c1",
+			streak:      2,
+			wantCommits: []string{},
+		},
+		{
+			name: "same commit",
+			history: []ingestion.TestOutcome{
+				outcome("pass", "c4", "master"),
+				outcome("fail", "c4", "master"),
+			},
+			branch:      "master",
+			lastGood:    "c4",
+			firstBad:    "c4",
+			streak:      1,
+			wantCommits: []string{},
+		},
+		{
+			name: "passing",
+			history: []ingestion.TestOutcome{
+				outcome("fail", "c1", "master"),
+				outcome("pass", "c2", "master"),
+			},
+			branch: "master",
+			err:    ErrNotFailing,
+		},
+		{
+			name:   "no history",
+			branch: "master",
+			err:    ErrNotFailing,
+		},
+		{
+			name: "unknown commit",
+			history: []ingestion.TestOutcome{
+				outcome("pass", "gone", "master"),
+				outcome("fail", "c2", "master"),
+			},
+			branch:       "master",
+			lastGood:     "gone",
+			firstBad:     "c2",
+			streak:       1,
+			wantCommits:  []string{},
+			compareError: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			blame, err := BlameTest(context.Background(), tt.history, tt.branch, comparer)
+			if tt.err != nil {
+				if !errors.Is(err, tt.err) {
+					t.Fatalf("got error %v, want %v", err, tt.err)
+				}
+				return
+			}
+			if tt.compareError {
+				if err == nil {
+					t.Fatalf("expected the comparison to fail")
+				}
+			} else if err != nil {
+				t.Fatal(err)
+			}
+
+			if blame.LastGoodSHA != tt.lastGood || blame.FirstBadSHA != tt.firstBad {
+				t.Errorf("got %s...%s, want %s...%s", blame.LastGoodSHA, blame.FirstBadSHA, tt.lastGood, tt.firstBad)
+			}
+			if blame.FailStreak != tt.streak {
+				t.Errorf("got a streak of %d, want %d", blame.FailStreak, tt.streak)
+			}
+			if fmt.Sprint(shas(blame.Commits)) != fmt.Sprint(tt.wantCommits) {
+				t.Errorf("got commits %v, want %v", shas(blame.Commits), tt.wantCommits)
+			}
+		})
+	}
+}
+
+// TestGitHubCommitComparerPaging checks that ranges longer than a page are
+// fetched in full.
+func TestGitHubCommitComparerPaging(t *testing.T) {
+	const total = 234
+	requests := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests += 1
+		page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+		perPage, _ := strconv.Atoi(r.URL.Query().Get("per_page"))
+		if perPage > 100 {
+			perPage = 100
+		}
+
+		comparison := github.CommitsComparison{TotalCommits: github.Int(total)}
+		for i := (page - 1) * perPage; i < page*perPage && i < total; i++ {
+			comparison.Commits = append(comparison.Commits, &github.RepositoryCommit{SHA: github.String(fmt.Sprintf("c%d", i))})
+		}
+		_ = json.NewEncoder(w).Encode(comparison)
+	}))
+	defer server.Close()
+
+	client := github.NewClient(nil)
+	client.BaseURL, _ = url.Parse(server.URL + "/")
+	comparer := GitHubCommitComparer{Client: client, Owner: "o", Repo: "r"}
+
+	commits, err := comparer.CompareCommits(context.Background(), "a", "b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(commits) != total {
+		t.Fatalf("got %d commits, want %d", len(commits), total)
+	}
+	if commits[total-1].SHA != fmt.Sprintf("c%d", total-1) {
+		t.Errorf("got last commit %s", commits[total-1].SHA)
+	}
+	if requests != 3 {
+		t.Errorf("got %d requests, want 3", requests)
+	}
+}
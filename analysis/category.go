@@ -0,0 +1,242 @@
+package analysis
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+	"test-analyzer/ingestion"
+)
+
+// Failure categories assigned by the default rules.
+const (
+	CategoryInfra      = "infra"
+	CategoryDependency = "dependency"
+	CategoryTimeout    = "timeout"
+	CategoryPanic      = "panic"
+	CategoryAssertion  = "assertion"
+	// CategoryUnknown is assigned to failures no rule matches
+	CategoryUnknown = "unknown"
+)
+
+// CategoryRule assigns Category to a failure whose output matches any of the
+// regular expressions in Patterns or contains any of Keywords, compared case
+// insensitively.
+type CategoryRule struct {
+	Category string   `json:"category"`
+	Patterns []string `json:"patterns,omitempty"`
+	Keywords []string `json:"keywords,omitempty"`
+}
+
+// CategoryRulesFile is the format of the rules config file. The rules of the
+// project being analyzed are evaluated before the default ones; when the file
+// has no default rules DefaultCategoryRules are used.
+type CategoryRulesFile struct {
+	Default  []CategoryRule            `json:"default,omitempty"`
+	Projects map[string][]CategoryRule `json:"projects,omitempty"`
+}
+
+// DefaultCategoryRules tell infrastructure and dependency trouble apart from
+// failures of the test itself. Rules are evaluated in order, so the more
+// specific ones come first.
+func DefaultCategoryRules() []CategoryRule {
+	return []CategoryRule{
+		{
+			Category: CategoryInfra,
+			Keywords: []string{
+				"no space left on device", "ImagePullBackOff", "ErrImagePull", "CrashLoopBackOff", "OOMKilled",
+				"Insufficient cpu", "Insufficient memory", "the server is currently unable to handle the request",
+				"etcdserver", "TLS handshake timeout", "connection reset by peer", "503 Service Unavailable",
+			},
+			Patterns: []string{`\d+ node\(s\) (had|didn't)`, `dial tcp: lookup \S+`},
+		},
+		{
+			Category: CategoryDependency,
+			Patterns: []string{
+				`(?i)(redis|kafka|mongo|postgres|mysql|rabbitmq|zookeeper|cosmos|consul|mqtt)\S*.*(refused|unavailable|not ready|timed out|timeout)`,
+			},
+			Keywords: []string{"connection refused", "service unavailable"},
+		},
+		{
+			Category: CategoryTimeout,
+			Keywords: []string{"test timed out after", "context deadline exceeded", "i/o timeout", "timed out waiting", "deadline exceeded"},
+		},
+		{
+			Category: CategoryPanic,
+			Patterns: []string{`(?m)^\s*panic: `, `(?m)^fatal error: `, `goroutine \d+ \[running\]`},
+		},
+		{
+			Category: CategoryAssertion,
+			Keywords: []string{"Error Trace:", "Not equal:", "Should be", "Should not be"},
+			// expected alone also matches errors such as unexpected EOF
+			Patterns: []string{`\w+_test\.go:\d+:`, `(?i)\bexpected\b.*\b(got|actual)\b`},
+		},
+	}
+}
+
+type compiledRule struct {
+	category string
+	patterns []*regexp.Regexp
+	keywords []string
+}
+
+// Classifier assigns a failure category to the output of a failed test.
+type Classifier struct {
+	rules []compiledRule
+}
+
+func NewClassifier(rules []CategoryRule) (*Classifier, error) {
+	c := &Classifier{}
+	for _, r := range rules {
+		if r.Category == "" {
+			return nil, fmt.Errorf("category rule without a category")
+		}
+		cr := compiledRule{category: r.Category}
+		for _, p := range r.Patterns {
+			if re, err := regexp.Compile(p); err != nil {
+				return nil, fmt.Errorf("invalid pattern for category %s: %w", r.Category, err)
+			} else {
+				cr.patterns = append(cr.patterns, re)
+			}
+		}
+		for _, k := range r.Keywords {
+			cr.keywords = append(cr.keywords, strings.ToLower(k))
+		}
+		c.rules = append(c.rules, cr)
+	}
+
+	return c, nil
+}
+
+func DefaultClassifier() *Classifier {
+	c, _ := NewClassifier(DefaultCategoryRules())
+	return c
+}
+
+// LoadClassifier builds the classifier of a project from a rules file, the
+// default rules if path is empty.
+func LoadClassifier(path string, project string) (*Classifier, error) {
+	if path == "" {
+		return DefaultClassifier(), nil
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("unable to read category rules: %w", err)
+	}
+
+	var file CategoryRulesFile
+	if err := json.Unmarshal(b, &file); err != nil {
+		return nil, fmt.Errorf("unable to parse category rules %s: %w", path, err)
+	}
+
+	rules := append([]CategoryRule{}, file.Projects[project]...)
+	if len(file.Default) > 0 {
+		rules = append(rules, file.Default...)
+	} else {
+		rules = append(rules, DefaultCategoryRules()...)
+	}
+
+	return NewClassifier(rules)
+}
+
+// Classify returns the category of the first rule matching text, or
+// CategoryUnknown.
+func (c *Classifier) Classify(text string) string {
+	lower := strings.ToLower(text)
+	for _, r := range c.rules {
+		for _, k := range r.keywords {
+			if strings.Contains(lower, k) {
+				return r.category
+			}
+		}
+		for _, p := range r.patterns {
+			if p.MatchString(text) {
+				return r.category
+			}
+		}
+	}
+
+	return CategoryUnknown
+}
+
+func (c *Classifier) ClassifyLogs(logs []ingestion.TestLog) string {
+	var sb strings.Builder
+	for _, l := range logs {
+		sb.WriteString(ansiPattern.ReplaceAllString(l.Text, ""))
+	}
+	return c.Classify(sb.String())
+}
+
+// FailureCategoryStage assigns a failure category to every failed test of a
+// newly ingested report.
+type FailureCategoryStage struct {
+	Classifier *Classifier
+}
+
+func (FailureCategoryStage) Name() string {
+	return "failure-categories"
+}
+
+func (s FailureCategoryStage) Process(db *ingestion.RecordDB, report *ingestion.Report) error {
+	classifier := s.Classifier
+	if classifier == nil {
+		classifier = DefaultClassifier()
+	}
+
+	for _, group := range report.TestGroups {
+		for _, test := range group.Tests {
+			if test.Status != "fail" {
+				continue
+			}
+
+			if err := db.SetTestFailureCategory(test.ID, classifier.ClassifyLogs(test.Logs)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// FailureCategory is the category of a failed outcome, CategoryUnknown for
+// results stored before categories were assigned.
+func FailureCategory(category string) string {
+	if category == "" {
+		return CategoryUnknown
+	}
+	return category
+}
+
+// CountTestFailureCategories counts the failures of every test by category,
+// keyed on TestKey.
+func CountTestFailureCategories(outcomes []ingestion.TestOutcome) map[string]map[string]int {
+	result := make(map[string]map[string]int)
+	for _, o := range outcomes {
+		if !o.Failed() {
+			continue
+		}
+		key := TestKey(o.TestCaseID, o.Label)
+		if _, ok := result[key]; !ok {
+			result[key] = make(map[string]int)
+		}
+		result[key][FailureCategory(o.FailureCategory)] += 1
+	}
+
+	return result
+}
+
+// CountRunFailureCategories counts the failures of every run by category,
+// keyed on report group ID.
+func CountRunFailureCategories(db *ingestion.RecordDB) map[uint]map[string]int {
+	result := make(map[uint]map[string]int)
+	for runId, counts := range db.GetFailureCategoryCounts() {
+		result[runId] = make(map[string]int)
+		for category, count := range counts {
+			result[runId][FailureCategory(category)] += count
+		}
+	}
+
+	return result
+}
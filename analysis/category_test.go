@@ -0,0 +1,106 @@
+package analysis
+
+import (
+	"os"
+	"path/filepath"
+	"test-analyzer/ingestion"
+	"testing"
+)
+
+func TestClassify(t *testing.T) {
+	c := DefaultClassifier()
+	tests := []struct {
+		text string
+		want string
+	}{
+		{text: "write /tmp/x: no space left on device", want: CategoryInfra},
+		{text: "0/3 nodes are available: 3 node(s) had taint", want: CategoryInfra},
+		{text: "redis-master:6379 connection refused", want: CategoryDependency},
+		{text: "dial tcp 10.0.0.1:80: connect: connection refused", want: CategoryDependency},
+		{text: "panic: test timed out after 10m0s", want: CategoryTimeout},
+		{text: "Get \"http://svc\": context deadline exceeded", want: CategoryTimeout},
+		{text: "=== RUN TestA\npanic: runtime error: invalid memory address\ngoroutine 7 [running]:", want: CategoryPanic},
+		{text: "Error Trace: a_test.go:12\nError: Not equal:", want: CategoryAssertion},
+		{text: "    a_test.go:42: got 1", want: CategoryAssertion},
+		{text: "EXPECTED three items, got two", want: CategoryAssertion},
+		{text: "expected: 1, actual: 2", want: CategoryAssertion},
+		{text: "read response: unexpected EOF", want: CategoryUnknown},
+		{text: "expected three items", want: CategoryUnknown},
+		{text: "something went wrong", want: CategoryUnknown},
+		{text: "", want: CategoryUnknown},
+	}
+	for _, tt := range tests {
+		if got := c.Classify(tt.text); got != tt.want {
+			t.Errorf("Classify(%q) = %s, want %s", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestClassifyLogs(t *testing.T) {
+	logs := []ingestion.TestLog{
+		{Text: "=== RUN TestA\n"},
+		{Text: "\x1b[31mpanic: \x1b[0mboom\n"},
+	}
+	if got := DefaultClassifier().ClassifyLogs(logs); got != CategoryPanic {
+		t.Errorf("got %s, want %s", got, CategoryPanic)
+	}
+}
+
+func TestNewClassifier(t *testing.T) {
+	if _, err := NewClassifier([]CategoryRule{{Keywords: []string{"x"}}}); err == nil {
+		t.Error("expected an error for a rule without a category")
+	}
+	if _, err := NewClassifier([]CategoryRule{{Category: "x", Patterns: []string{"("}}}); err == nil {
+		t.Error("expected an error for an invalid pattern")
+	}
+}
+
+func TestLoadClassifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "rules.json")
+	rules := `{"projects": {"dapr": [{"category": "sidecar", "keywords": ["daprd"]}]}}`
+	if err := os.WriteFile(path, []byte(rules), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	c, err := LoadClassifier(path, "dapr")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// project rules come before the default ones, which still apply
+	if got := c.Classify("daprd exited: connection refused"); got != "sidecar" {
+		t.Errorf("got %s, want sidecar", got)
+	}
+	if got := c.Classify("connection refused"); got != CategoryDependency {
+		t.Errorf("got %s, want %s", got, CategoryDependency)
+	}
+
+	other, err := LoadClassifier(path, "other")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := other.Classify("daprd exited"); got != CategoryUnknown {
+		t.Errorf("got %s for another project, want %s", got, CategoryUnknown)
+	}
+
+	if _, err := LoadClassifier(filepath.Join(t.TempDir(), "missing.json"), "dapr"); err == nil {
+		t.Error("expected an error for a missing rules file")
+	}
+}
+
+func TestCountTestFailureCategories(t *testing.T) {
+	outcomes := []ingestion.TestOutcome{
+		{TestCaseID: 1, Status: "fail", FailureCategory: CategoryTimeout},
+		{TestCaseID: 1, Status: "fail", FailureCategory: CategoryTimeout},
+		{TestCaseID: 1, Status: "fail"},
+		{TestCaseID: 1, Status: "pass", FailureCategory: CategoryPanic},
+		{Label: "TestB", Status: "fail", FailureCategory: CategoryPanic},
+	}
+
+	counts := CountTestFailureCategories(outcomes)
+	if counts["#1"][CategoryTimeout] != 2 || counts["#1"][CategoryUnknown] != 1 || len(counts["#1"]) != 2 {
+		t.Errorf("got %v for #1", counts["#1"])
+	}
+	if counts["TestB"][CategoryPanic] != 1 {
+		t.Errorf("got %v for TestB", counts["TestB"])
+	}
+}
@@ -0,0 +1,192 @@
+package analysis
+
+import (
+	"math"
+	"sort"
+	"test-analyzer/ingestion"
+)
+
+type ChangepointOptions struct {
+	// Branch restricts the history to runs of one head branch, all if empty
+	Branch string
+	// Window is the number of most recent results per test to look at
+	Window int
+	// MinSegment is the minimum number of results on either side of a change
+	MinSegment int
+	// MinDelta is the minimum absolute change in failure rate to report
+	MinDelta float64
+	// Threshold is the minimum log-likelihood ratio statistic for a change
+	Threshold float64
+}
+
+func DefaultChangepointOptions() ChangepointOptions {
+	return ChangepointOptions{
+		Window:     100,
+		MinSegment: 5,
+		MinDelta:   0.3,
+		Threshold:  13.8,
+	}
+}
+
+// Changepoint is the point in a test's history at which its failure rate
+// changed the most. Index is the first result after the change.
+type Changepoint struct {
+	Index      int
+	BeforeRate float64
+	AfterRate  float64
+	BeforeRuns int
+	AfterRuns  int
+	Statistic  float64
+}
+
+// Regression is a test whose failure rate went up abruptly, pinned to the
+// first failing run after the change and the last passing run before it.
+type Regression struct {
+	TestCaseID       uint
+	TestLabel        string
+	Package          string
+	HeadBranch       string
+	PreviousFailRate float64
+	NewFailRate      float64
+	PreviousRuns     int
+	NewRuns          int
+	Statistic        float64
+
+	FirstBadReportGroupID uint
+	FirstBadRunID         int64
+	FirstBadRunLabel      string
+	FirstBadSHA           string
+	FirstBadStart         int64
+	LastGoodRunID         int64
+	LastGoodSHA           string
+}
+
+func bernoulliLogLikelihood(failures int, n int) float64 {
+	if failures == 0 || failures == n {
+		return 0
+	}
+	p := float64(failures) / float64(n)
+	return float64(failures)*math.Log(p) + float64(n-failures)*math.Log(1-p)
+}
+
+// FindChangepoint searches history, oldest first, for the single split that
+// best separates it into two segments with different failure rates, using the
+// Bernoulli log-likelihood ratio against a constant rate. It reports false if
+// no split is significant under opts.
+func FindChangepoint(history []ingestion.TestOutcome, opts ChangepointOptions) (Changepoint, bool) {
+	n := len(history)
+	if n < 2*opts.MinSegment || n < 2 {
+		return Changepoint{}, false
+	}
+
+	// cumulative failures, so any segment's count is a subtraction away
+	failures := make([]int, n+1)
+	for i, o := range history {
+		failures[i+1] = failures[i]
+		if o.Failed() {
+			failures[i+1] += 1
+		}
+	}
+
+	base := bernoulliLogLikelihood(failures[n], n)
+	best := Changepoint{Index: -1}
+	minSegment := opts.MinSegment
+	if minSegment < 1 {
+		minSegment = 1
+	}
+
+	for k := minSegment; k <= n-minSegment; k++ {
+		before := failures[k]
+		after := failures[n] - failures[k]
+		statistic := 2 * (bernoulliLogLikelihood(before, k) + bernoulliLogLikelihood(after, n-k) - base)
+		if statistic > best.Statistic {
+			best = Changepoint{
+				Index:      k,
+				BeforeRate: float64(before) / float64(k),
+				AfterRate:  float64(after) / float64(n-k),
+				BeforeRuns: k,
+				AfterRuns:  n - k,
+				Statistic:  statistic,
+			}
+		}
+	}
+
+	if best.Index < 0 || best.Statistic < opts.Threshold || math.Abs(best.AfterRate-best.BeforeRate) < opts.MinDelta {
+		return Changepoint{}, false
+	}
+
+	return best, true
+}
+
+// DetectRegressions finds every test in outcomes, ordered oldest first, whose
+// failure rate increased significantly, most recent change first.
+func DetectRegressions(outcomes []ingestion.TestOutcome, opts ChangepointOptions) []Regression {
+	result := make([]Regression, 0)
+
+	for _, history := range GroupOutcomes(filterBranch(outcomes, opts.Branch)) {
+		if opts.Window > 0 && len(history) > opts.Window {
+			history = history[len(history)-opts.Window:]
+		}
+
+		cp, ok := FindChangepoint(history, opts)
+		if !ok || cp.AfterRate <= cp.BeforeRate {
+			continue
+		}
+
+		firstBad := cp.Index
+		for firstBad < len(history)-1 && !history[firstBad].Failed() {
+			firstBad += 1
+		}
+		bad := history[firstBad]
+
+		regression := Regression{
+			TestCaseID:            bad.TestCaseID,
+			TestLabel:             bad.Label,
+			Package:               bad.Package,
+			HeadBranch:            bad.HeadBranch,
+			PreviousFailRate:      cp.BeforeRate,
+			NewFailRate:           cp.AfterRate,
+			PreviousRuns:          cp.BeforeRuns,
+			NewRuns:               cp.AfterRuns,
+			Statistic:             cp.Statistic,
+			FirstBadReportGroupID: bad.ReportGroupID,
+			FirstBadRunID:         bad.RunID,
+			FirstBadRunLabel:      bad.RunLabel,
+			FirstBadSHA:           bad.HeadSHA,
+			FirstBadStart:         bad.Start,
+		}
+		for i := firstBad - 1; i >= 0; i-- {
+			if history[i].Passed() {
+				regression.LastGoodRunID = history[i].RunID
+				regression.LastGoodSHA = history[i].HeadSHA
+				break
+			}
+		}
+
+		result = append(result, regression)
+	}
+
+	sort.Slice(result, func(i int, j int) bool {
+		if result[i].FirstBadStart != result[j].FirstBadStart {
+			return result[i].FirstBadStart > result[j].FirstBadStart
+		}
+		return result[i].TestLabel < result[j].TestLabel
+	})
+
+	return result
+}
+
+func filterBranch(outcomes []ingestion.TestOutcome, branch string) []ingestion.TestOutcome {
+	if branch == "" {
+		return outcomes
+	}
+
+	result := make([]ingestion.TestOutcome, 0)
+	for _, o := range outcomes {
+		if o.HeadBranch == branch {
+			result = append(result, o)
+		}
+	}
+
+	return result
+}
@@ -0,0 +1,85 @@
+package analysis
+
+import (
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestBernoulliLogLikelihood(t *testing.T) {
+	if got := bernoulliLogLikelihood(0, 10); got != 0 {
+		t.Errorf("no failures: got %f, want 0", got)
+	}
+	if got := bernoulliLogLikelihood(10, 10); got != 0 {
+		t.Errorf("all failures: got %f, want 0", got)
+	}
+	if got, want := bernoulliLogLikelihood(5, 10), 10*math.Log(0.5); math.Abs(got-want) > 1e-9 {
+		t.Errorf("half failures: got %f, want %f", got, want)
+	}
+}
+
+func TestFindChangepoint(t *testing.T) {
+	opts := DefaultChangepointOptions()
+
+	cp, ok := FindChangepoint(history(1, strings.Repeat("p", 20)+strings.Repeat("f", 10)), opts)
+	if !ok {
+		t.Fatal("expected a changepoint")
+	}
+	if cp.Index != 20 || cp.BeforeRate != 0 || cp.AfterRate != 1 || cp.BeforeRuns != 20 || cp.AfterRuns != 10 {
+		t.Errorf("got %+v", cp)
+	}
+	// a perfect split's statistic is -2 times the constant rate likelihood
+	if want := -2 * bernoulliLogLikelihood(10, 30); math.Abs(cp.Statistic-want) > 1e-9 {
+		t.Errorf("got statistic %f, want %f", cp.Statistic, want)
+	}
+
+	tests := []struct {
+		name    string
+		results string
+	}{
+		{name: "stable", results: strings.Repeat("p", 30)},
+		{name: "constant flakiness", results: strings.Repeat("pf", 15)},
+		{name: "too short", results: "ppppfffff"},
+		{name: "change inside the minimum segment", results: strings.Repeat("p", 27) + "fff"},
+	}
+	for _, tt := range tests {
+		if cp, ok := FindChangepoint(history(1, tt.results), opts); ok {
+			t.Errorf("%s: unexpected changepoint %+v", tt.name, cp)
+		}
+	}
+
+	strict := opts
+	strict.MinDelta = 0.9
+	if cp, ok := FindChangepoint(history(1, strings.Repeat("p", 20)+strings.Repeat("pf", 10)), strict); ok {
+		t.Errorf("change below the minimum delta: unexpected changepoint %+v", cp)
+	}
+}
+
+func TestDetectRegressions(t *testing.T) {
+	regressed := history(1, strings.Repeat("p", 20)+strings.Repeat("f", 10))
+	regressed[19].HeadSHA = "good"
+	regressed[20].HeadSHA = "bad"
+	outcomes := append(regressed, history(2, strings.Repeat("p", 30))...)
+	// a test that got better is not a regression
+	outcomes = append(outcomes, history(3, strings.Repeat("f", 15)+strings.Repeat("p", 15))...)
+
+	result := DetectRegressions(outcomes, DefaultChangepointOptions())
+	if len(result) != 1 {
+		t.Fatalf("got %d regressions, want 1", len(result))
+	}
+
+	r := result[0]
+	if r.TestCaseID != 1 || r.PreviousFailRate != 0 || r.NewFailRate != 1 {
+		t.Errorf("got %+v", r)
+	}
+	if r.FirstBadRunID != 21 || r.FirstBadSHA != "bad" || r.LastGoodRunID != 20 || r.LastGoodSHA != "good" {
+		t.Errorf("got first bad run %d (%s) and last good run %d (%s)",
+			r.FirstBadRunID, r.FirstBadSHA, r.LastGoodRunID, r.LastGoodSHA)
+	}
+
+	window := DefaultChangepointOptions()
+	window.Window = 10
+	if result := DetectRegressions(outcomes, window); len(result) != 0 {
+		t.Errorf("got %d regressions within a window after the change, want 0", len(result))
+	}
+}
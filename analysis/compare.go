@@ -0,0 +1,212 @@
+package analysis
+
+import (
+	"math"
+	"path"
+	"sort"
+	"strings"
+	"test-analyzer/ingestion"
+)
+
+// Slice selects runs by head branch and triggering event. Either may be a
+// path.Match pattern such as release-1.*, and matches everything if empty.
+type Slice struct {
+	Branch string
+	Event  string
+}
+
+func (s Slice) Matches(o ingestion.TestOutcome) bool {
+	return matchPattern(s.Branch, o.HeadBranch) && matchPattern(s.Event, o.Event)
+}
+
+func (s Slice) String() string {
+	parts := make([]string, 0, 2)
+	if s.Branch != "" {
+		parts = append(parts, "branch "+s.Branch)
+	}
+	if s.Event != "" {
+		parts = append(parts, "event "+s.Event)
+	}
+	if len(parts) == 0 {
+		return "all runs"
+	}
+	return strings.Join(parts, ", ")
+}
+
+func matchPattern(pattern string, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	matched, err := path.Match(pattern, value)
+	return err == nil && matched
+}
+
+type CompareOptions struct {
+	// Window is the number of most recent results per test and slice to
+	// compare, all if 0
+	Window int
+	// MinRuns is the number of results a test needs in both slices to be
+	// compared
+	MinRuns int
+	// Alpha is the significance level of the difference in pass rates
+	Alpha float64
+}
+
+func DefaultCompareOptions() CompareOptions {
+	return CompareOptions{
+		Window:  100,
+		MinRuns: 5,
+		Alpha:   0.05,
+	}
+}
+
+// SliceSummary holds the overall figures of one slice.
+type SliceSummary struct {
+	Slice     string
+	Runs      int
+	GreenRuns int
+	GreenRate float64
+	Results   int
+	PassRate  float64
+}
+
+// TestComparison compares the pass rate of a test between two slices. Delta
+// is B's pass rate minus A's, so a negative delta means the test is sicker in
+// B. PValue is the two sided p-value of a two proportion z-test.
+type TestComparison struct {
+	TestCaseID  uint
+	TestLabel   string
+	Package     string
+	A           PassRateInterval
+	B           PassRateInterval
+	Delta       float64
+	PValue      float64
+	Significant bool
+}
+
+type Comparison struct {
+	A     SliceSummary
+	B     SliceSummary
+	Tests []TestComparison
+}
+
+// CompareSlices compares the reliability of the runs in two slices, overall
+// and per test, ordered by significance and then by the size of the change.
+func CompareSlices(outcomes []ingestion.TestOutcome, a Slice, b Slice, opts CompareOptions) Comparison {
+	sliceOutcomes := func(s Slice) []ingestion.TestOutcome {
+		result := make([]ingestion.TestOutcome, 0)
+		for _, o := range outcomes {
+			if s.Matches(o) {
+				result = append(result, o)
+			}
+		}
+		return result
+	}
+	outcomesA, outcomesB := sliceOutcomes(a), sliceOutcomes(b)
+
+	comparison := Comparison{
+		A:     summarizeSlice(a, outcomesA),
+		B:     summarizeSlice(b, outcomesB),
+		Tests: make([]TestComparison, 0),
+	}
+
+	historyB := GroupOutcomes(outcomesB)
+	for key, ha := range GroupOutcomes(outcomesA) {
+		hb, ok := historyB[key]
+		if !ok {
+			continue
+		}
+		if opts.Window > 0 && len(ha) > opts.Window {
+			ha = ha[len(ha)-opts.Window:]
+		}
+		if opts.Window > 0 && len(hb) > opts.Window {
+			hb = hb[len(hb)-opts.Window:]
+		}
+		if len(ha) < opts.MinRuns || len(hb) < opts.MinRuns {
+			continue
+		}
+
+		passA, passB := countPasses(ha), countPasses(hb)
+		last := hb[len(hb)-1]
+		tc := TestComparison{
+			TestCaseID: last.TestCaseID,
+			TestLabel:  last.Label,
+			Package:    last.Package,
+			A:          WilsonInterval(uint(passA), uint(len(ha)), DefaultConfidenceZ),
+			B:          WilsonInterval(uint(passB), uint(len(hb)), DefaultConfidenceZ),
+			PValue:     twoProportionPValue(passA, len(ha), passB, len(hb)),
+		}
+		tc.Delta = tc.B.Rate - tc.A.Rate
+		tc.Significant = tc.PValue < opts.Alpha
+		comparison.Tests = append(comparison.Tests, tc)
+	}
+
+	sort.Slice(comparison.Tests, func(i int, j int) bool {
+		ti, tj := comparison.Tests[i], comparison.Tests[j]
+		if ti.Significant != tj.Significant {
+			return ti.Significant
+		}
+		if math.Abs(ti.Delta) != math.Abs(tj.Delta) {
+			return math.Abs(ti.Delta) > math.Abs(tj.Delta)
+		}
+		return ti.TestLabel < tj.TestLabel
+	})
+
+	return comparison
+}
+
+func countPasses(history []ingestion.TestOutcome) int {
+	passes := 0
+	for _, o := range history {
+		if o.Passed() {
+			passes += 1
+		}
+	}
+	return passes
+}
+
+func summarizeSlice(s Slice, outcomes []ingestion.TestOutcome) SliceSummary {
+	summary := SliceSummary{Slice: s.String(), Results: len(outcomes)}
+
+	red := make(map[uint]bool)
+	passes := 0
+	for _, o := range outcomes {
+		if _, ok := red[o.ReportGroupID]; !ok {
+			red[o.ReportGroupID] = false
+		}
+		if o.Passed() {
+			passes += 1
+		} else {
+			red[o.ReportGroupID] = true
+		}
+	}
+
+	summary.Runs = len(red)
+	for _, r := range red {
+		if !r {
+			summary.GreenRuns += 1
+		}
+	}
+	if summary.Runs > 0 {
+		summary.GreenRate = float64(summary.GreenRuns) / float64(summary.Runs)
+	}
+	if summary.Results > 0 {
+		summary.PassRate = float64(passes) / float64(summary.Results)
+	}
+
+	return summary
+}
+
+// twoProportionPValue is the two sided p-value of the pooled two proportion
+// z-test for passesA/nA and passesB/nB being equal.
+func twoProportionPValue(passesA int, nA int, passesB int, nB int) float64 {
+	pooled := float64(passesA+passesB) / float64(nA+nB)
+	variance := pooled * (1 - pooled) * (1/float64(nA) + 1/float64(nB))
+	if variance == 0 {
+		// Both slices always or never pass
+		return 1
+	}
+
+	z := (float64(passesA)/float64(nA) - float64(passesB)/float64(nB)) / math.Sqrt(variance)
+	return math.Erfc(math.Abs(z) / math.Sqrt2)
+}
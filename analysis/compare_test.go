@@ -0,0 +1,94 @@
+package analysis
+
+import (
+	"math"
+	"strings"
+	"test-analyzer/ingestion"
+	"testing"
+)
+
+func TestTwoProportionPValue(t *testing.T) {
+	tests := []struct {
+		passesA, nA, passesB, nB int
+		want                     float64
+	}{
+		{passesA: 90, nA: 100, passesB: 70, nB: 100, want: 0.000407},
+		{passesA: 50, nA: 100, passesB: 40, nB: 100, want: 0.155218},
+		{passesA: 8, nA: 10, passesB: 2, nB: 10, want: 0.007290},
+		{passesA: 10, nA: 10, passesB: 20, nB: 20, want: 1},
+		{passesA: 0, nA: 10, passesB: 0, nB: 20, want: 1},
+		{passesA: 5, nA: 10, passesB: 5, nB: 10, want: 1},
+	}
+	for _, tt := range tests {
+		got := twoProportionPValue(tt.passesA, tt.nA, tt.passesB, tt.nB)
+		if math.Abs(got-tt.want) > 1e-6 {
+			t.Errorf("%d/%d vs %d/%d: got %f, want %f", tt.passesA, tt.nA, tt.passesB, tt.nB, got, tt.want)
+		}
+		if swapped := twoProportionPValue(tt.passesB, tt.nB, tt.passesA, tt.nA); math.Abs(swapped-got) > 1e-12 {
+			t.Errorf("%d/%d vs %d/%d: not symmetric", tt.passesA, tt.nA, tt.passesB, tt.nB)
+		}
+	}
+}
+
+func TestSliceMatches(t *testing.T) {
+	o := ingestion.TestOutcome{HeadBranch: "release-1.12", Event: "schedule"}
+	tests := []struct {
+		slice Slice
+		want  bool
+	}{
+		{slice: Slice{}, want: true},
+		{slice: Slice{Branch: "release-1.*"}, want: true},
+		{slice: Slice{Branch: "release-1.*", Event: "push"}, want: false},
+		{slice: Slice{Branch: "master"}, want: false},
+		{slice: Slice{Branch: "["}, want: false},
+	}
+	for _, tt := range tests {
+		if got := tt.slice.Matches(o); got != tt.want {
+			t.Errorf("%s: got %t, want %t", tt.slice, got, tt.want)
+		}
+	}
+}
+
+// sliceHistory is history on a branch, with each run its own report group.
+func sliceHistory(id uint, branch string, results string) []ingestion.TestOutcome {
+	outcomes := history(id, results)
+	for i := range outcomes {
+		outcomes[i].HeadBranch = branch
+		outcomes[i].ReportGroupID = uint(i + 1)
+		if branch != "master" {
+			outcomes[i].ReportGroupID += 1000
+		}
+	}
+	return outcomes
+}
+
+func TestCompareSlices(t *testing.T) {
+	outcomes := sliceHistory(1, "master", strings.Repeat("p", 20))
+	outcomes = append(outcomes, sliceHistory(1, "feature", strings.Repeat("pf", 10))...)
+	outcomes = append(outcomes, sliceHistory(2, "master", strings.Repeat("p", 19)+"f")...)
+	outcomes = append(outcomes, sliceHistory(2, "feature", strings.Repeat("p", 20))...)
+	// too few runs on the feature branch to compare
+	outcomes = append(outcomes, sliceHistory(3, "master", strings.Repeat("p", 20))...)
+	outcomes = append(outcomes, sliceHistory(3, "feature", "ff")...)
+
+	c := CompareSlices(outcomes, Slice{Branch: "master"}, Slice{Branch: "feature"}, DefaultCompareOptions())
+
+	if c.A.Slice != "branch master" || c.A.Runs != 20 || c.A.GreenRuns != 19 || c.A.Results != 60 {
+		t.Errorf("got summary %+v for A", c.A)
+	}
+	if c.B.Runs != 20 || c.B.GreenRuns != 9 || c.B.Results != 42 {
+		t.Errorf("got summary %+v for B", c.B)
+	}
+
+	if len(c.Tests) != 2 {
+		t.Fatalf("got %d tests, want 2", len(c.Tests))
+	}
+	first := c.Tests[0]
+	if first.TestCaseID != 1 || !first.Significant || first.Delta != -0.5 {
+		t.Errorf("got first test %+v", first)
+	}
+	second := c.Tests[1]
+	if second.TestCaseID != 2 || second.Significant || math.Abs(second.Delta-0.05) > 1e-9 {
+		t.Errorf("got second test %+v", second)
+	}
+}
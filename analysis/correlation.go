@@ -0,0 +1,224 @@
+package analysis
+
+import (
+	"sort"
+	"test-analyzer/ingestion"
+)
+
+type CorrelationOptions struct {
+	// Branch restricts the history to runs of one head branch, all if empty
+	Branch string
+	// Window is the number of most recent runs to look at, all if 0
+	Window int
+	// MaxRunFailures skips runs with more failed tests than this, a broken
+	// cluster makes everything fail together and drowns out real coupling
+	MaxRunFailures int
+	// MinCoFailures is the minimum number of runs two tests failed together in
+	MinCoFailures int
+	// MinJaccard and MinLift are the thresholds for a pair to be considered
+	// strongly correlated
+	MinJaccard float64
+	MinLift    float64
+}
+
+func DefaultCorrelationOptions() CorrelationOptions {
+	return CorrelationOptions{
+		Window:         200,
+		MaxRunFailures: 50,
+		MinCoFailures:  3,
+		MinJaccard:     0.5,
+		MinLift:        2,
+	}
+}
+
+// CorrelatedTest is a test that failed in at least one of the analyzed runs.
+type CorrelatedTest struct {
+	Key        string
+	TestCaseID uint
+	TestLabel  string
+	Package    string
+	// Failures is the number of runs the test failed in
+	Failures int
+}
+
+// TestPair holds the co-failure statistics of two tests over the runs they
+// failed in. Jaccard is the share of runs in which either failed where both
+// did; Lift is how much more often they failed together than if their
+// failures were independent.
+type TestPair struct {
+	Source     string
+	Target     string
+	CoFailures int
+	Jaccard    float64
+	Lift       float64
+}
+
+// CorrelationGroup is a set of tests connected by strongly correlated pairs.
+type CorrelationGroup struct {
+	Tests       []CorrelatedTest
+	Pairs       int
+	MeanJaccard float64
+}
+
+type CorrelationReport struct {
+	// Runs is the number of runs analyzed and SkippedRuns the number left out
+	// for exceeding MaxRunFailures
+	Runs        int
+	SkippedRuns int
+	Tests       []CorrelatedTest
+	// Pairs are the strongly correlated pairs, by descending Jaccard
+	Pairs  []TestPair
+	Groups []CorrelationGroup
+}
+
+// ComputeCorrelations finds tests that tend to fail in the same runs.
+func ComputeCorrelations(outcomes []ingestion.TestOutcome, opts CorrelationOptions) CorrelationReport {
+	outcomes = filterBranch(outcomes, opts.Branch)
+
+	runOrder := make([]uint, 0)
+	runFailures := make(map[uint]map[string]bool)
+	tests := make(map[string]*CorrelatedTest)
+	for _, o := range outcomes {
+		if _, ok := runFailures[o.ReportGroupID]; !ok {
+			runFailures[o.ReportGroupID] = make(map[string]bool)
+			runOrder = append(runOrder, o.ReportGroupID)
+		}
+		if !o.Failed() {
+			continue
+		}
+
+		key := TestKey(o.TestCaseID, o.Label)
+		runFailures[o.ReportGroupID][key] = true
+		if _, ok := tests[key]; !ok {
+			tests[key] = &CorrelatedTest{Key: key, TestCaseID: o.TestCaseID, TestLabel: o.Label, Package: o.Package}
+		}
+	}
+
+	if opts.Window > 0 && len(runOrder) > opts.Window {
+		runOrder = runOrder[len(runOrder)-opts.Window:]
+	}
+
+	report := CorrelationReport{
+		Tests:  make([]CorrelatedTest, 0),
+		Pairs:  make([]TestPair, 0),
+		Groups: make([]CorrelationGroup, 0),
+	}
+
+	failures := make(map[string]int)
+	together := make(map[[2]string]int)
+	for _, runId := range runOrder {
+		failed := runFailures[runId]
+		if opts.MaxRunFailures > 0 && len(failed) > opts.MaxRunFailures {
+			report.SkippedRuns += 1
+			continue
+		}
+		report.Runs += 1
+
+		keys := make([]string, 0, len(failed))
+		for k := range failed {
+			keys = append(keys, k)
+			failures[k] += 1
+		}
+		sort.Strings(keys)
+		for i := range keys {
+			for j := i + 1; j < len(keys); j++ {
+				together[[2]string{keys[i], keys[j]}] += 1
+			}
+		}
+	}
+
+	for key, count := range failures {
+		t := tests[key]
+		t.Failures = count
+		report.Tests = append(report.Tests, *t)
+	}
+	sort.Slice(report.Tests, func(i int, j int) bool {
+		if report.Tests[i].Failures != report.Tests[j].Failures {
+			return report.Tests[i].Failures > report.Tests[j].Failures
+		}
+		return report.Tests[i].Key < report.Tests[j].Key
+	})
+
+	for pair, both := range together {
+		if both < opts.MinCoFailures {
+			continue
+		}
+		a, b := failures[pair[0]], failures[pair[1]]
+		p := TestPair{
+			Source:     pair[0],
+			Target:     pair[1],
+			CoFailures: both,
+			Jaccard:    float64(both) / float64(a+b-both),
+			Lift:       float64(both) * float64(report.Runs) / (float64(a) * float64(b)),
+		}
+		if p.Jaccard >= opts.MinJaccard && p.Lift >= opts.MinLift {
+			report.Pairs = append(report.Pairs, p)
+		}
+	}
+	sort.Slice(report.Pairs, func(i int, j int) bool {
+		if report.Pairs[i].Jaccard != report.Pairs[j].Jaccard {
+			return report.Pairs[i].Jaccard > report.Pairs[j].Jaccard
+		}
+		if report.Pairs[i].Source != report.Pairs[j].Source {
+			return report.Pairs[i].Source < report.Pairs[j].Source
+		}
+		return report.Pairs[i].Target < report.Pairs[j].Target
+	})
+
+	report.Groups = groupCorrelatedTests(report.Tests, report.Pairs)
+
+	return report
+}
+
+// groupCorrelatedTests returns the connected components of the pair graph,
+// largest first.
+func groupCorrelatedTests(tests []CorrelatedTest, pairs []TestPair) []CorrelationGroup {
+	parent := make(map[string]string)
+	var find func(k string) string
+	find = func(k string) string {
+		if parent[k] != k {
+			parent[k] = find(parent[k])
+		}
+		return parent[k]
+	}
+	for _, p := range pairs {
+		for _, k := range []string{p.Source, p.Target} {
+			if _, ok := parent[k]; !ok {
+				parent[k] = k
+			}
+		}
+		parent[find(p.Source)] = find(p.Target)
+	}
+
+	members := make(map[string]*CorrelationGroup)
+	jaccardSums := make(map[string]float64)
+	for _, t := range tests {
+		if _, ok := parent[t.Key]; !ok {
+			continue
+		}
+		root := find(t.Key)
+		if _, ok := members[root]; !ok {
+			members[root] = &CorrelationGroup{}
+		}
+		members[root].Tests = append(members[root].Tests, t)
+	}
+	for _, p := range pairs {
+		root := find(p.Source)
+		members[root].Pairs += 1
+		jaccardSums[root] += p.Jaccard
+	}
+
+	groups := make([]CorrelationGroup, 0, len(members))
+	for root, g := range members {
+		g.MeanJaccard = jaccardSums[root] / float64(g.Pairs)
+		groups = append(groups, *g)
+	}
+	sort.Slice(groups, func(i int, j int) bool {
+		if len(groups[i].Tests) != len(groups[j].Tests) {
+			return len(groups[i].Tests) > len(groups[j].Tests)
+		}
+		return groups[i].Tests[0].Key < groups[j].Tests[0].Key
+	})
+
+	return groups
+}
@@ -0,0 +1,110 @@
+package analysis
+
+import (
+	"math"
+	"test-analyzer/ingestion"
+	"testing"
+)
+
+// runsWithFailures builds runs 1 to n in which every test of ids ran, failing
+// in the runs listed for it in failed.
+func runsWithFailures(n int, ids []uint, failed map[uint][]int) []ingestion.TestOutcome {
+	outcomes := make([]ingestion.TestOutcome, 0)
+	for run := 1; run <= n; run++ {
+		for _, id := range ids {
+			status := "pass"
+			for _, r := range failed[id] {
+				if r == run {
+					status = "fail"
+				}
+			}
+			outcomes = append(outcomes, ingestion.TestOutcome{
+				TestCaseID:    id,
+				Status:        status,
+				ReportGroupID: uint(run),
+				RunID:         int64(run),
+			})
+		}
+	}
+	return outcomes
+}
+
+func TestComputeCorrelations(t *testing.T) {
+	outcomes := runsWithFailures(11, []uint{1, 2, 3, 4, 5}, map[uint][]int{
+		1: {1, 2, 3, 4, 5, 11},
+		2: {1, 2, 3, 4, 11},
+		3: {6, 7, 8, 11},
+		4: {6, 7, 8, 11},
+		5: {9, 11},
+	})
+	opts := DefaultCorrelationOptions()
+	opts.MaxRunFailures = 4
+
+	report := ComputeCorrelations(outcomes, opts)
+	if report.Runs != 10 || report.SkippedRuns != 1 {
+		t.Fatalf("got %d runs and %d skipped, want 10 and 1", report.Runs, report.SkippedRuns)
+	}
+	if len(report.Tests) != 5 || report.Tests[0].Key != "#1" || report.Tests[0].Failures != 5 {
+		t.Errorf("got tests %+v", report.Tests)
+	}
+
+	if len(report.Pairs) != 2 {
+		t.Fatalf("got %d pairs, want 2", len(report.Pairs))
+	}
+	tests := []struct {
+		source, target string
+		coFailures     int
+		jaccard, lift  float64
+	}{
+		// failing in 3 of the same 10 runs: 3 * 10 / (3 * 3)
+		{source: "#3", target: "#4", coFailures: 3, jaccard: 1, lift: 10.0 / 3},
+		// failing together in 4 runs, one more apart: 4 * 10 / (5 * 4)
+		{source: "#1", target: "#2", coFailures: 4, jaccard: 0.8, lift: 2},
+	}
+	for i, tt := range tests {
+		p := report.Pairs[i]
+		if p.Source != tt.source || p.Target != tt.target || p.CoFailures != tt.coFailures ||
+			math.Abs(p.Jaccard-tt.jaccard) > 1e-9 || math.Abs(p.Lift-tt.lift) > 1e-9 {
+			t.Errorf("pair %d: got %+v, want %+v", i, p, tt)
+		}
+	}
+
+	if len(report.Groups) != 2 {
+		t.Fatalf("got %d groups, want 2", len(report.Groups))
+	}
+	if g := report.Groups[0]; len(g.Tests) != 2 || g.Tests[0].Key != "#1" || g.Pairs != 1 || g.MeanJaccard != 0.8 {
+		t.Errorf("got first group %+v", g)
+	}
+
+	strict := opts
+	strict.MinLift = 2.5
+	if report := ComputeCorrelations(outcomes, strict); len(report.Pairs) != 1 || report.Pairs[0].Source != "#3" {
+		t.Errorf("got pairs %+v above a lift of 2.5", report.Pairs)
+	}
+
+	window := opts
+	window.Window = 4
+	if report := ComputeCorrelations(outcomes, window); report.Runs != 3 || len(report.Pairs) != 0 {
+		t.Errorf("got %d runs and %d pairs in a window of 4, want 3 and 0", report.Runs, len(report.Pairs))
+	}
+}
+
+func TestGroupCorrelatedTests(t *testing.T) {
+	tests := []CorrelatedTest{{Key: "a"}, {Key: "b"}, {Key: "c"}, {Key: "d"}, {Key: "e"}}
+	pairs := []TestPair{
+		{Source: "a", Target: "b", Jaccard: 0.6},
+		{Source: "b", Target: "c", Jaccard: 0.8},
+		{Source: "d", Target: "e", Jaccard: 1},
+	}
+
+	groups := groupCorrelatedTests(tests, pairs)
+	if len(groups) != 2 {
+		t.Fatalf("got %d groups, want 2", len(groups))
+	}
+	if len(groups[0].Tests) != 3 || groups[0].Pairs != 2 || math.Abs(groups[0].MeanJaccard-0.7) > 1e-9 {
+		t.Errorf("got first group %+v", groups[0])
+	}
+	if len(groups[1].Tests) != 2 || groups[1].Tests[0].Key != "d" {
+		t.Errorf("got second group %+v", groups[1])
+	}
+}
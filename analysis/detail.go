@@ -0,0 +1,149 @@
+package analysis
+
+import (
+	"sort"
+	"test-analyzer/ingestion"
+)
+
+// DefaultPassRateWindow is the number of results the rolling pass rate of a
+// test's history is computed over.
+const DefaultPassRateWindow = 10
+
+// TestResult is a single result in the history of a test. PassRate is the
+// pass rate over the window of results up to and including this one.
+type TestResult struct {
+	TestID        uint
+	ReportID      uint
+	ReportGroupID uint
+	RunID         int64
+	RunLabel      string
+	HeadSHA       string
+	HeadBranch    string
+	Event         string
+	Status        string
+	Start         int64
+	Duration      int64
+	PassRate      float64
+
+	FailureMessage   string
+	FailureSignature string
+	FailureCategory  string
+}
+
+// SignatureCount counts the failures of a test with the same signature.
+type SignatureCount struct {
+	Signature string
+	Example   string
+	Category  string
+	Failures  int
+	FirstSeen int64
+	LastSeen  int64
+}
+
+// TestDetail summarizes the history of a single test.
+type TestDetail struct {
+	TestCaseID uint
+	TestLabel  string
+	Package    string
+	Runs       int
+	Failures   int
+	PassRate   PassRateInterval
+	Flakiness  TestFlakiness
+	// MeanDuration and MaxDuration are in ms, over passing results only since
+	// failures often end early or run into a timeout
+	MeanDuration int64
+	MaxDuration  int64
+	Categories   map[string]int
+	Signatures   []SignatureCount
+	// History holds every result, oldest first
+	History []TestResult
+}
+
+// ComputeTestDetail summarizes the results of a single test, oldest first,
+// with a rolling pass rate over the last window results.
+func ComputeTestDetail(history []ingestion.TestOutcome, window int) TestDetail {
+	detail := TestDetail{
+		Categories: make(map[string]int),
+		Signatures: make([]SignatureCount, 0),
+		History:    make([]TestResult, 0, len(history)),
+		Flakiness:  ScoreFlakiness(history, DefaultFlakinessOptions()),
+	}
+	if len(history) == 0 {
+		return detail
+	}
+	detail.TestCaseID = history[0].TestCaseID
+	detail.TestLabel = history[len(history)-1].Label
+	detail.Package = history[len(history)-1].Package
+
+	signatures := make(map[string]*SignatureCount)
+	passes := 0
+	var totalDuration int64
+	for i, o := range history {
+		detail.Runs += 1
+		if o.Passed() {
+			passes += 1
+			duration := o.End - o.Start
+			totalDuration += duration
+			if duration > detail.MaxDuration {
+				detail.MaxDuration = duration
+			}
+		} else {
+			detail.Failures += 1
+			category := FailureCategory(o.FailureCategory)
+			detail.Categories[category] += 1
+
+			s, ok := signatures[o.FailureSignature]
+			if !ok {
+				s = &SignatureCount{Signature: o.FailureSignature, Example: o.FailureMessage, Category: category, FirstSeen: o.Start}
+				signatures[o.FailureSignature] = s
+			}
+			s.Failures += 1
+			s.LastSeen = o.Start
+		}
+
+		windowStart := i + 1 - window
+		if windowStart < 0 {
+			windowStart = 0
+		}
+		windowPasses := 0
+		for _, w := range history[windowStart : i+1] {
+			if w.Passed() {
+				windowPasses += 1
+			}
+		}
+
+		detail.History = append(detail.History, TestResult{
+			TestID:           o.TestID,
+			ReportID:         o.ReportID,
+			ReportGroupID:    o.ReportGroupID,
+			RunID:            o.RunID,
+			RunLabel:         o.RunLabel,
+			HeadSHA:          o.HeadSHA,
+			HeadBranch:       o.HeadBranch,
+			Event:            o.Event,
+			Status:           o.Status,
+			Start:            o.Start,
+			Duration:         o.End - o.Start,
+			PassRate:         float64(windowPasses) / float64(i+1-windowStart),
+			FailureMessage:   o.FailureMessage,
+			FailureSignature: o.FailureSignature,
+			FailureCategory:  o.FailureCategory,
+		})
+	}
+
+	detail.PassRate = WilsonInterval(uint(passes), uint(detail.Runs), DefaultConfidenceZ)
+	if passes > 0 {
+		detail.MeanDuration = totalDuration / int64(passes)
+	}
+	for _, s := range signatures {
+		detail.Signatures = append(detail.Signatures, *s)
+	}
+	sort.Slice(detail.Signatures, func(i int, j int) bool {
+		if detail.Signatures[i].Failures != detail.Signatures[j].Failures {
+			return detail.Signatures[i].Failures > detail.Signatures[j].Failures
+		}
+		return detail.Signatures[i].Signature < detail.Signatures[j].Signature
+	})
+
+	return detail
+}
@@ -0,0 +1,102 @@
+package analysis
+
+import (
+	"fmt"
+	"test-analyzer/ingestion"
+	"testing"
+)
+
+func TestComputeTestDetail(t *testing.T) {
+	result := func(run int, status string, duration int64, signature string, category string) ingestion.TestOutcome {
+		o := ingestion.TestOutcome{
+			TestCaseID:    7,
+			Label:         "TestA",
+			Package:       "pkg",
+			Status:        status,
+			ReportGroupID: uint(run),
+			RunID:         int64(run),
+			Start:         int64(run * 1000),
+			End:           int64(run*1000) + duration,
+		}
+		if status == "fail" {
+			o.FailureSignature = signature
+			o.FailureMessage = fmt.Sprintf("%s in run %d", signature, run)
+			o.FailureCategory = category
+		}
+		return o
+	}
+	history := []ingestion.TestOutcome{
+		result(1, "pass", 100, "", ""),
+		result(2, "pass", 300, "", ""),
+		// failures run into a timeout and don't count towards the durations
+		result(3, "fail", 5000, "timed out", CategoryTimeout),
+		result(4, "pass", 200, "", ""),
+		result(5, "fail", 5000, "boom", ""),
+		result(6, "fail", 5000, "timed out", CategoryTimeout),
+	}
+
+	detail := ComputeTestDetail(history, 3)
+
+	if detail.TestCaseID != 7 || detail.TestLabel != "TestA" || detail.Package != "pkg" {
+		t.Errorf("got test %d %s in %s", detail.TestCaseID, detail.TestLabel, detail.Package)
+	}
+	if detail.Runs != 6 || detail.Failures != 3 || detail.PassRate.Samples != 6 || detail.PassRate.Rate != 0.5 {
+		t.Errorf("got %d failures out of %d, pass rate %+v", detail.Failures, detail.Runs, detail.PassRate)
+	}
+	if detail.MeanDuration != 200 || detail.MaxDuration != 300 {
+		t.Errorf("got mean duration %d and max %d, want 200 and 300", detail.MeanDuration, detail.MaxDuration)
+	}
+	if len(detail.Categories) != 2 || detail.Categories[CategoryTimeout] != 2 || detail.Categories[CategoryUnknown] != 1 {
+		t.Errorf("got categories %v", detail.Categories)
+	}
+
+	rates := make([]string, 0, len(detail.History))
+	for _, r := range detail.History {
+		rates = append(rates, fmt.Sprintf("%.2f", r.PassRate))
+	}
+	if got := fmt.Sprint(rates); got != "[1.00 1.00 0.67 0.67 0.33 0.33]" {
+		t.Errorf("got rolling pass rates %s", got)
+	}
+	if r := detail.History[2]; r.RunID != 3 || r.Duration != 5000 || r.FailureSignature != "timed out" || r.FailureCategory != CategoryTimeout {
+		t.Errorf("got result %+v", r)
+	}
+
+	want := []SignatureCount{
+		{Signature: "timed out", Example: "timed out in run 3", Category: CategoryTimeout, Failures: 2, FirstSeen: 3000, LastSeen: 6000},
+		{Signature: "boom", Example: "boom in run 5", Category: CategoryUnknown, Failures: 1, FirstSeen: 5000, LastSeen: 5000},
+	}
+	if len(detail.Signatures) != len(want) {
+		t.Fatalf("got signatures %+v", detail.Signatures)
+	}
+	for i := range want {
+		if detail.Signatures[i] != want[i] {
+			t.Errorf("signature %d: got %+v, want %+v", i, detail.Signatures[i], want[i])
+		}
+	}
+}
+
+func TestComputeTestDetailWindow(t *testing.T) {
+	history := history(1, "ffpp")
+
+	// a window covering the whole history is the cumulative pass rate
+	detail := ComputeTestDetail(history, DefaultPassRateWindow)
+	if got := detail.History[3].PassRate; got != 0.5 {
+		t.Errorf("got pass rate %v, want 0.5", got)
+	}
+	if len(detail.Signatures) != 1 || detail.Signatures[0].Failures != 2 {
+		t.Errorf("got detail %+v", detail)
+	}
+
+	detail = ComputeTestDetail(history, 1)
+	if got := detail.History[1].PassRate; got != 0 {
+		t.Errorf("got pass rate %v for a failure, want 0", got)
+	}
+	if got := detail.History[2].PassRate; got != 1 {
+		t.Errorf("got pass rate %v for a pass, want 1", got)
+	}
+
+	detail = ComputeTestDetail(nil, DefaultPassRateWindow)
+	if detail.Runs != 0 || detail.History == nil || detail.Categories == nil {
+		t.Errorf("got detail %+v without results", detail)
+	}
+}
@@ -0,0 +1,61 @@
+package analysis
+
+import (
+	"sort"
+	"test-analyzer/ingestion"
+)
+
+// TestDuration summarizes how long a test takes to pass. Durations are in ms,
+// over passing results only since failures often end early or time out.
+type TestDuration struct {
+	TestCaseID     uint
+	TestLabel      string
+	Package        string
+	Runs           int
+	MeanDuration   int64
+	MedianDuration int64
+	MaxDuration    int64
+	TotalDuration  int64
+}
+
+// SlowestTests ranks tests by their mean passing duration, slowest first.
+func SlowestTests(outcomes []ingestion.TestOutcome) []TestDuration {
+	result := make([]TestDuration, 0)
+	for _, history := range GroupOutcomes(outcomes) {
+		durations := make([]int64, 0, len(history))
+		for _, o := range history {
+			if o.Passed() && o.End >= o.Start {
+				durations = append(durations, o.End-o.Start)
+			}
+		}
+		if len(durations) == 0 {
+			continue
+		}
+		sort.Slice(durations, func(i int, j int) bool {
+			return durations[i] < durations[j]
+		})
+
+		last := history[len(history)-1]
+		d := TestDuration{
+			TestCaseID:     last.TestCaseID,
+			TestLabel:      last.Label,
+			Package:        last.Package,
+			Runs:           len(durations),
+			MedianDuration: durations[len(durations)/2],
+			MaxDuration:    durations[len(durations)-1],
+		}
+		for _, duration := range durations {
+			d.TotalDuration += duration
+		}
+		d.MeanDuration = d.TotalDuration / int64(len(durations))
+		result = append(result, d)
+	}
+
+	sort.Slice(result, func(i int, j int) bool {
+		if result[i].MeanDuration != result[j].MeanDuration {
+			return result[i].MeanDuration > result[j].MeanDuration
+		}
+		return result[i].TestLabel < result[j].TestLabel
+	})
+	return result
+}
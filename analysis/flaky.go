@@ -0,0 +1,135 @@
+package analysis
+
+import (
+	"math"
+	"sort"
+	"test-analyzer/ingestion"
+)
+
+const (
+	ClassificationStable = "stable"
+	ClassificationFlaky  = "flaky"
+	ClassificationBroken = "broken"
+)
+
+type FlakinessOptions struct {
+	// Decay is the weight multiplier per result going back in time, so a
+	// transition n results ago counts Decay^n as much as the latest one
+	Decay float64
+	// FlakyThreshold is the score at or above which a test is flaky
+	FlakyThreshold float64
+	// BrokenAfter is the number of consecutive failures, up to and including
+	// the latest result, after which a test is broken rather than flaky
+	BrokenAfter int
+	// MinRuns is the number of results below which a test is never classified
+	// as anything but stable
+	MinRuns int
+}
+
+func DefaultFlakinessOptions() FlakinessOptions {
+	return FlakinessOptions{
+		Decay:          0.95,
+		FlakyThreshold: 0.1,
+		BrokenAfter:    3,
+		MinRuns:        5,
+	}
+}
+
+// TestFlakiness scores a test on how often its result flips between pass and
+// fail. Unlike the pass rate, a test that failed consistently since a
+// regression scores low here and is classified as broken instead.
+type TestFlakiness struct {
+	TestCaseID     uint
+	TestLabel      string
+	Package        string
+	Runs           int
+	Failures       int
+	Transitions    int
+	Score          float64
+	FailStreak     int
+	LastStatus     string
+	Classification string
+}
+
+// GroupOutcomes splits outcomes by test, keeping each test's results in the
+// order they were given.
+func GroupOutcomes(outcomes []ingestion.TestOutcome) map[string][]ingestion.TestOutcome {
+	result := make(map[string][]ingestion.TestOutcome)
+	for _, o := range outcomes {
+		key := TestKey(o.TestCaseID, o.Label)
+		result[key] = append(result[key], o)
+	}
+
+	return result
+}
+
+// ScoreFlakiness computes the flakiness of a single test from its results,
+// oldest first. The score is the recency weighted fraction of consecutive
+// result pairs that differ, between 0 (never flips) and 1 (flips every run).
+func ScoreFlakiness(history []ingestion.TestOutcome, opts FlakinessOptions) TestFlakiness {
+	result := TestFlakiness{
+		Classification: ClassificationStable,
+	}
+	if len(history) == 0 {
+		return result
+	}
+
+	last := history[len(history)-1]
+	result.TestCaseID = last.TestCaseID
+	result.TestLabel = last.Label
+	result.Package = last.Package
+	result.LastStatus = last.Status
+	result.Runs = len(history)
+
+	var weighted, total float64
+	for i, o := range history {
+		if o.Failed() {
+			result.Failures += 1
+		}
+		if i == 0 {
+			continue
+		}
+
+		w := math.Pow(opts.Decay, float64(len(history)-1-i))
+		total += w
+		if o.Passed() != history[i-1].Passed() {
+			result.Transitions += 1
+			weighted += w
+		}
+	}
+	if total > 0 {
+		result.Score = weighted / total
+	}
+
+	for i := len(history) - 1; i >= 0 && history[i].Failed(); i-- {
+		result.FailStreak += 1
+	}
+
+	if result.Runs >= opts.MinRuns {
+		if result.FailStreak >= opts.BrokenAfter {
+			result.Classification = ClassificationBroken
+		} else if result.Score >= opts.FlakyThreshold {
+			result.Classification = ClassificationFlaky
+		}
+	}
+
+	return result
+}
+
+// ComputeFlakiness scores every test in outcomes, which must be ordered
+// oldest first, and returns them sorted by descending score.
+func ComputeFlakiness(outcomes []ingestion.TestOutcome, opts FlakinessOptions) []TestFlakiness {
+	result := make([]TestFlakiness, 0)
+	for _, history := range GroupOutcomes(outcomes) {
+		result = append(result, ScoreFlakiness(history, opts))
+	}
+
+	sort.Slice(result, func(i int, j int) bool {
+		if result[i].Score != result[j].Score {
+			return result[i].Score > result[j].Score
+		}
+		return result[i].TestLabel < result[j].TestLabel
+	})
+
+	return result
+}
@@ -0,0 +1,88 @@
+package analysis
+
+import (
+	"math"
+	"strings"
+	"test-analyzer/ingestion"
+	"testing"
+)
+
+// history turns a string of 'p' and 'f' into the results of test case id,
+// oldest first.
+func history(id uint, results string) []ingestion.TestOutcome {
+	outcomes := make([]ingestion.TestOutcome, 0, len(results))
+	for i, r := range results {
+		status := "pass"
+		if r == 'f' {
+			status = "fail"
+		}
+		outcomes = append(outcomes, ingestion.TestOutcome{
+			TestCaseID: id,
+			Label:      "Test" + strings.Repeat("X", int(id)),
+			Status:     status,
+			RunID:      int64(i + 1),
+			Start:      int64(i + 1),
+		})
+	}
+	return outcomes
+}
+
+func TestScoreFlakiness(t *testing.T) {
+	opts := DefaultFlakinessOptions()
+	tests := []struct {
+		results        string
+		transitions    int
+		streak         int
+		classification string
+	}{
+		{results: "", classification: ClassificationStable},
+		{results: "pppppppp", classification: ClassificationStable},
+		{results: "pfpfpfpf", transitions: 7, streak: 1, classification: ClassificationFlaky},
+		{results: "ppppppfff", transitions: 1, streak: 3, classification: ClassificationBroken},
+		{results: "pfpf", transitions: 3, streak: 1, classification: ClassificationStable},
+		{results: "ffffffff", streak: 8, classification: ClassificationBroken},
+	}
+	for _, tt := range tests {
+		f := ScoreFlakiness(history(1, tt.results), opts)
+		if f.Transitions != tt.transitions || f.FailStreak != tt.streak || f.Classification != tt.classification {
+			t.Errorf("%q: got %d transitions, a streak of %d and %s, want %d, %d and %s", tt.results,
+				f.Transitions, f.FailStreak, f.Classification, tt.transitions, tt.streak, tt.classification)
+		}
+		if f.Runs != len(tt.results) {
+			t.Errorf("%q: got %d runs", tt.results, f.Runs)
+		}
+	}
+
+	if f := ScoreFlakiness(history(1, "pfpfpfpf"), opts); f.Score != 1 {
+		t.Errorf("flipping every run scored %f, want 1", f.Score)
+	}
+
+	// the same flip weighs more when it is recent
+	old := ScoreFlakiness(history(1, "pfpppppppp"), opts)
+	recent := ScoreFlakiness(history(1, "ppppppppfp"), opts)
+	if old.Score >= recent.Score {
+		t.Errorf("old flips scored %f, recent flips %f", old.Score, recent.Score)
+	}
+
+	// without decay the score is the fraction of pairs that differ
+	undecayed := opts
+	undecayed.Decay = 1
+	if f := ScoreFlakiness(history(1, "ppfp"), undecayed); math.Abs(f.Score-2.0/3) > 1e-9 {
+		t.Errorf("got score %f, want 2/3", f.Score)
+	}
+}
+
+func TestComputeFlakiness(t *testing.T) {
+	outcomes := append(history(1, "pppppp"), history(2, "pfpfpf")...)
+	outcomes = append(outcomes, history(3, "ppppfp")...)
+
+	result := ComputeFlakiness(outcomes, DefaultFlakinessOptions())
+	if len(result) != 3 {
+		t.Fatalf("got %d tests, want 3", len(result))
+	}
+	for i, id := range []uint{2, 3, 1} {
+		if result[i].TestCaseID != id {
+			t.Errorf("position %d: got test case %d, want %d", i, result[i].TestCaseID, id)
+		}
+	}
+}
@@ -0,0 +1,230 @@
+package analysis
+
+import (
+	"sort"
+	"test-analyzer/ingestion"
+	"time"
+)
+
+type HealthOptions struct {
+	// Branch restricts the history to runs of one head branch, all if empty
+	Branch string
+	// Since drops runs started before it, in ms since the epoch, 0 for all
+	Since int64
+}
+
+// HealthPoint summarizes the runs started on one day (UTC).
+type HealthPoint struct {
+	Day         string
+	Runs        int
+	GreenRuns   int
+	GreenRate   float64
+	FailedTests int
+}
+
+// TestHealth holds the reliability metrics of a single test. A failure
+// episode is a streak of runs the test failed in; MTTR is the mean time from
+// the first failing run of an episode to the next passing run and MTBF the
+// observed time span divided by the number of episodes. Both are in ms and 0
+// when there is nothing to measure.
+type TestHealth struct {
+	TestCaseID uint
+	TestLabel  string
+	Package    string
+	Runs       int
+	Failures   int
+	Episodes   int
+	Recoveries int
+	MTTR       int64
+	MTBF       int64
+	// Failing is set if the test failed in its latest run
+	Failing bool
+}
+
+// RunHealth holds run level reliability metrics over a window. A run is
+// green when none of its tests failed.
+type RunHealth struct {
+	Branch    string
+	From      int64
+	To        int64
+	Runs      int
+	GreenRuns int
+	GreenRate float64
+	// LongestRedStreak is the longest sequence of consecutive red runs, from
+	// LongestRedStreakStart to LongestRedStreakEnd
+	LongestRedStreak      int
+	LongestRedStreakStart string
+	LongestRedStreakEnd   string
+	CurrentRedStreak      int
+	// TimeToGreen is the mean time in ms from the first red run after a green
+	// one to the next green run
+	TimeToGreen int64
+	// TestMTTR is the mean time to recovery over the failure episodes of all
+	// tests
+	TestMTTR int64
+	Timeline []HealthPoint
+	Tests    []TestHealth
+}
+
+type healthRun struct {
+	id      uint
+	label   string
+	start   int64
+	failed  map[string]bool
+	results map[string]bool
+}
+
+// ComputeRunHealth computes the run and test level reliability metrics of the
+// runs in the window, oldest to newest by the start of their first test.
+func ComputeRunHealth(outcomes []ingestion.TestOutcome, opts HealthOptions) RunHealth {
+	outcomes = filterBranch(outcomes, opts.Branch)
+
+	runs := make(map[uint]*healthRun)
+	tests := make(map[string]*TestHealth)
+	for _, o := range outcomes {
+		run, ok := runs[o.ReportGroupID]
+		if !ok {
+			run = &healthRun{id: o.ReportGroupID, label: o.RunLabel, start: o.Start, failed: make(map[string]bool), results: make(map[string]bool)}
+			runs[o.ReportGroupID] = run
+		}
+		if o.Start < run.start {
+			run.start = o.Start
+		}
+
+		key := TestKey(o.TestCaseID, o.Label)
+		run.results[key] = true
+		if o.Failed() {
+			run.failed[key] = true
+		}
+		if _, ok := tests[key]; !ok {
+			tests[key] = &TestHealth{TestCaseID: o.TestCaseID, TestLabel: o.Label, Package: o.Package}
+		}
+	}
+
+	ordered := make([]*healthRun, 0, len(runs))
+	for _, r := range runs {
+		if r.start >= opts.Since {
+			ordered = append(ordered, r)
+		}
+	}
+	sort.Slice(ordered, func(i int, j int) bool {
+		if ordered[i].start != ordered[j].start {
+			return ordered[i].start < ordered[j].start
+		}
+		return ordered[i].id < ordered[j].id
+	})
+
+	health := RunHealth{
+		Branch:   opts.Branch,
+		Timeline: make([]HealthPoint, 0),
+		Tests:    make([]TestHealth, 0),
+	}
+	if len(ordered) == 0 {
+		return health
+	}
+	health.From = ordered[0].start
+	health.To = ordered[len(ordered)-1].start
+	health.Runs = len(ordered)
+
+	days := make(map[string]*HealthPoint)
+	var redSince int64 = -1
+	redStreak, greenGaps, timeToGreen := 0, 0, int64(0)
+	for i, r := range ordered {
+		green := len(r.failed) == 0
+		day := time.UnixMilli(r.start).UTC().Format("2006-01-02")
+		if _, ok := days[day]; !ok {
+			days[day] = &HealthPoint{Day: day}
+			health.Timeline = append(health.Timeline, HealthPoint{Day: day})
+		}
+		days[day].Runs += 1
+		days[day].FailedTests += len(r.failed)
+
+		if green {
+			health.GreenRuns += 1
+			days[day].GreenRuns += 1
+			if redSince >= 0 {
+				timeToGreen += r.start - redSince
+				greenGaps += 1
+			}
+			redSince = -1
+			redStreak = 0
+			continue
+		}
+
+		// Only count recoveries from red streaks that started inside the window
+		if redStreak == 0 && i > 0 {
+			redSince = r.start
+		}
+		redStreak += 1
+		if redStreak > health.LongestRedStreak {
+			health.LongestRedStreak = redStreak
+			health.LongestRedStreakStart = ordered[i-redStreak+1].label
+			health.LongestRedStreakEnd = r.label
+		}
+	}
+	health.CurrentRedStreak = redStreak
+	health.GreenRate = float64(health.GreenRuns) / float64(health.Runs)
+	if greenGaps > 0 {
+		health.TimeToGreen = timeToGreen / int64(greenGaps)
+	}
+
+	for i, p := range health.Timeline {
+		point := days[p.Day]
+		point.GreenRate = float64(point.GreenRuns) / float64(point.Runs)
+		health.Timeline[i] = *point
+	}
+
+	var recoveryTotal int64
+	recoveries := 0
+	for key, t := range tests {
+		var failingSince, first, last int64 = -1, -1, 0
+		for _, r := range ordered {
+			if !r.results[key] {
+				continue
+			}
+			if first < 0 {
+				first = r.start
+			}
+			last = r.start
+			t.Runs += 1
+
+			if r.failed[key] {
+				t.Failures += 1
+				if failingSince < 0 {
+					failingSince = r.start
+					t.Episodes += 1
+				}
+			} else if failingSince >= 0 {
+				t.MTTR += r.start - failingSince
+				t.Recoveries += 1
+				failingSince = -1
+			}
+		}
+		if t.Runs == 0 {
+			continue
+		}
+
+		t.Failing = failingSince >= 0
+		recoveryTotal += t.MTTR
+		recoveries += t.Recoveries
+		if t.Recoveries > 0 {
+			t.MTTR /= int64(t.Recoveries)
+		}
+		if t.Episodes > 0 {
+			t.MTBF = (last - first) / int64(t.Episodes)
+		}
+		health.Tests = append(health.Tests, *t)
+	}
+	if recoveries > 0 {
+		health.TestMTTR = recoveryTotal / int64(recoveries)
+	}
+
+	sort.Slice(health.Tests, func(i int, j int) bool {
+		if health.Tests[i].Episodes != health.Tests[j].Episodes {
+			return health.Tests[i].Episodes > health.Tests[j].Episodes
+		}
+		return health.Tests[i].TestLabel < health.Tests[j].TestLabel
+	})
+
+	return health
+}
@@ -0,0 +1,172 @@
+package analysis
+
+import (
+	"fmt"
+	"test-analyzer/ingestion"
+	"testing"
+	"time"
+)
+
+// healthOutcomes turns strings of 'p', 'f' and '-' for no result into the
+// results of each test, one character per run started hour apart.
+func healthOutcomes(results map[string]string) []ingestion.TestOutcome {
+	outcomes := make([]ingestion.TestOutcome, 0)
+	for label, statuses := range results {
+		for i, r := range statuses {
+			if r == '-' {
+				continue
+			}
+			status := "pass"
+			if r == 'f' {
+				status = "fail"
+			}
+			outcomes = append(outcomes, ingestion.TestOutcome{
+				Label:         label,
+				Status:        status,
+				ReportGroupID: uint(i + 1),
+				RunLabel:      fmt.Sprintf("run %d", i+1),
+				HeadBranch:    "master",
+				Start:         int64(i) * time.Hour.Milliseconds(),
+			})
+		}
+	}
+	return outcomes
+}
+
+func findTestHealth(t *testing.T, health RunHealth, label string) TestHealth {
+	t.Helper()
+	for _, th := range health.Tests {
+		if th.TestLabel == label {
+			return th
+		}
+	}
+	t.Fatalf("no health of %s in %+v", label, health.Tests)
+	return TestHealth{}
+}
+
+func TestComputeRunHealth(t *testing.T) {
+	hour := time.Hour.Milliseconds()
+	health := ComputeRunHealth(healthOutcomes(map[string]string{
+		"TestA": "ffpffp",
+		"TestB": "pppppp",
+		"TestC": "pp-f--",
+	}), HealthOptions{})
+
+	if health.Runs != 6 || health.GreenRuns != 2 || health.GreenRate != 2.0/6 {
+		t.Errorf("got %d green runs out of %d, rate %v", health.GreenRuns, health.Runs, health.GreenRate)
+	}
+	if health.From != 0 || health.To != 5*hour {
+		t.Errorf("got window %d to %d", health.From, health.To)
+	}
+	// the red streak the window starts with is never a recovery
+	if health.TimeToGreen != 2*hour {
+		t.Errorf("got time to green %d, want %d", health.TimeToGreen, 2*hour)
+	}
+	if health.LongestRedStreak != 2 || health.LongestRedStreakStart != "run 1" || health.LongestRedStreakEnd != "run 2" {
+		t.Errorf("got longest red streak %d from %s to %s", health.LongestRedStreak, health.LongestRedStreakStart, health.LongestRedStreakEnd)
+	}
+	if health.CurrentRedStreak != 0 {
+		t.Errorf("got current red streak %d, want 0", health.CurrentRedStreak)
+	}
+	if len(health.Timeline) != 1 || health.Timeline[0].Day != "1970-01-01" || health.Timeline[0].Runs != 6 || health.Timeline[0].FailedTests != 5 {
+		t.Errorf("got timeline %+v", health.Timeline)
+	}
+
+	if len(health.Tests) != 3 || health.Tests[0].TestLabel != "TestA" || health.Tests[1].TestLabel != "TestC" {
+		t.Fatalf("got tests %+v, want TestA, TestC and TestB", health.Tests)
+	}
+	a := findTestHealth(t, health, "TestA")
+	if a.Runs != 6 || a.Failures != 4 || a.Episodes != 2 || a.Recoveries != 2 || a.Failing {
+		t.Errorf("got TestA %+v", a)
+	}
+	if a.MTTR != 2*hour || a.MTBF != 5*hour/2 {
+		t.Errorf("got TestA MTTR %d and MTBF %d", a.MTTR, a.MTBF)
+	}
+	c := findTestHealth(t, health, "TestC")
+	if c.Runs != 3 || c.Episodes != 1 || c.Recoveries != 0 || c.MTTR != 0 || c.MTBF != 3*hour || !c.Failing {
+		t.Errorf("got TestC %+v", c)
+	}
+	b := findTestHealth(t, health, "TestB")
+	if b.Episodes != 0 || b.MTTR != 0 || b.MTBF != 0 || b.Failing {
+		t.Errorf("got TestB %+v", b)
+	}
+	if health.TestMTTR != 2*hour {
+		t.Errorf("got test MTTR %d, want %d", health.TestMTTR, 2*hour)
+	}
+}
+
+func TestComputeRunHealthEpisodes(t *testing.T) {
+	hour := time.Hour.Milliseconds()
+	tests := []struct {
+		name        string
+		results     string
+		episodes    int
+		recoveries  int
+		mttr        int64
+		timeToGreen int64
+		longest     int
+		current     int
+	}{
+		{name: "all green", results: "pppp"},
+		{name: "red from the start", results: "ffpp", episodes: 1, recoveries: 1, mttr: 2 * hour, longest: 2},
+		{name: "red at the end", results: "ppff", episodes: 1, longest: 2, current: 2},
+		{name: "back to back", results: "pfpfp", episodes: 2, recoveries: 2, mttr: hour, timeToGreen: hour, longest: 1},
+		{name: "all red", results: "fff", episodes: 1, longest: 3, current: 3},
+	}
+	for _, tt := range tests {
+		health := ComputeRunHealth(healthOutcomes(map[string]string{"TestA": tt.results}), HealthOptions{})
+		if health.TimeToGreen != tt.timeToGreen || health.LongestRedStreak != tt.longest || health.CurrentRedStreak != tt.current {
+			t.Errorf("%s: got time to green %d, longest red streak %d and current %d", tt.name, health.TimeToGreen, health.LongestRedStreak, health.CurrentRedStreak)
+		}
+		a := findTestHealth(t, health, "TestA")
+		if a.Episodes != tt.episodes || a.Recoveries != tt.recoveries || a.MTTR != tt.mttr || health.TestMTTR != tt.mttr {
+			t.Errorf("%s: got %+v and test MTTR %d", tt.name, a, health.TestMTTR)
+		}
+	}
+
+	health := ComputeRunHealth(healthOutcomes(map[string]string{"TestA": "pppp"}), HealthOptions{})
+	if health.GreenRate != 1 || health.LongestRedStreakStart != "" {
+		t.Errorf("got all green health %+v", health)
+	}
+}
+
+func TestComputeRunHealthOptions(t *testing.T) {
+	hour := time.Hour.Milliseconds()
+	outcomes := healthOutcomes(map[string]string{"TestA": "ffpf"})
+	outcomes[0].HeadBranch = "feature"
+
+	// the red streak now starts the window
+	health := ComputeRunHealth(outcomes, HealthOptions{Since: hour})
+	if health.Runs != 3 || health.From != hour || health.TimeToGreen != 0 {
+		t.Errorf("got %d runs from %d, time to green %d", health.Runs, health.From, health.TimeToGreen)
+	}
+	health = ComputeRunHealth(outcomes, HealthOptions{Branch: "feature"})
+	if health.Runs != 1 || health.Branch != "feature" || health.CurrentRedStreak != 1 {
+		t.Errorf("got feature health %+v", health)
+	}
+	health = ComputeRunHealth(outcomes, HealthOptions{Since: 10 * hour})
+	if health.Runs != 0 || health.Timeline == nil || health.Tests == nil {
+		t.Errorf("got empty health %+v", health)
+	}
+}
+
+func TestComputeRunHealthTimeline(t *testing.T) {
+	outcomes := healthOutcomes(map[string]string{"TestA": "pfp"})
+	// the last run starts on the next day
+	for i := range outcomes {
+		if outcomes[i].ReportGroupID == 3 {
+			outcomes[i].Start = 25 * time.Hour.Milliseconds()
+		}
+	}
+
+	health := ComputeRunHealth(outcomes, HealthOptions{})
+	if len(health.Timeline) != 2 {
+		t.Fatalf("got timeline %+v", health.Timeline)
+	}
+	if p := health.Timeline[0]; p.Day != "1970-01-01" || p.Runs != 2 || p.GreenRuns != 1 || p.GreenRate != 0.5 || p.FailedTests != 1 {
+		t.Errorf("got first day %+v", p)
+	}
+	if p := health.Timeline[1]; p.Day != "1970-01-02" || p.Runs != 1 || p.GreenRate != 1 {
+		t.Errorf("got second day %+v", p)
+	}
+}
@@ -0,0 +1,236 @@
+package analysis
+
+import (
+	"sort"
+	"strings"
+	"test-analyzer/ingestion"
+)
+
+const (
+	// HeatmapSortPassRate puts the tests with the lowest average pass rate
+	// over the runs on top
+	HeatmapSortPassRate = "pass-rate"
+	// HeatmapSortFailures puts the tests with the most failures on top
+	HeatmapSortFailures = "failures"
+	// HeatmapSortName orders tests by name
+	HeatmapSortName = "name"
+)
+
+type HeatmapOptions struct {
+	// Group is the top level test whose subtests are the rows, the top level
+	// tests themselves if empty
+	Group string
+	// Limit is the number of most recent runs to show, all if 0
+	Limit int
+	// Branch restricts the runs to one head branch, all if empty
+	Branch string
+	// Category only counts failures of one category and only shows tests that
+	// had such a failure, all failures if empty
+	Category string
+	// Sort is one of the HeatmapSort orders, HeatmapSortPassRate by default
+	Sort string
+	// Run restricts the heatmap to the column of a single run, given by its
+	// report group ID, all runs if 0
+	Run uint
+}
+
+// HeatmapColumn is a run, Start is the start of its first test.
+type HeatmapColumn struct {
+	ReportGroupID uint
+	RunID         int64
+	Label         string
+	HeadBranch    string
+	Start         int64
+}
+
+// HeatmapRow is a test. Label is relative to the group, Subtests is set if
+// it is a top level test with subtests to expand. PassRate is the average of
+// its pass rate in each run it ran in.
+type HeatmapRow struct {
+	Label      string
+	TestCaseID uint
+	Subtests   bool
+	Passed     int
+	Failed     int
+	PassRate   float64
+}
+
+// HeatmapCell counts the results of the test of row Row in the run of column
+// Column, both indexes.
+type HeatmapCell struct {
+	Row    int
+	Column int
+	Passed int
+	Failed int
+}
+
+// Heatmap is the matrix of pass / fail counts of tests (rows) by run
+// (columns), newest run first. Only cells with results are included.
+// Categories lists the failure categories seen in the runs, to filter on.
+type Heatmap struct {
+	Group      string
+	Columns    []HeatmapColumn
+	Rows       []HeatmapRow
+	Cells      []HeatmapCell
+	Categories []string
+}
+
+// BuildHeatmap aggregates outcomes into the heatmap described by opts.
+func BuildHeatmap(outcomes []ingestion.TestOutcome, opts HeatmapOptions) Heatmap {
+	outcomes = filterBranch(outcomes, opts.Branch)
+	if opts.Run != 0 {
+		run := make([]ingestion.TestOutcome, 0)
+		for _, o := range outcomes {
+			if o.ReportGroupID == opts.Run {
+				run = append(run, o)
+			}
+		}
+		outcomes = run
+	}
+
+	// Runs, newest first
+	runs := make(map[uint]*HeatmapColumn)
+	for _, o := range outcomes {
+		run, ok := runs[o.ReportGroupID]
+		if !ok {
+			run = &HeatmapColumn{ReportGroupID: o.ReportGroupID, RunID: o.RunID, Label: o.RunLabel, HeadBranch: o.HeadBranch, Start: o.Start}
+			runs[o.ReportGroupID] = run
+		}
+		if o.Start < run.Start {
+			run.Start = o.Start
+		}
+	}
+	heatmap := Heatmap{
+		Group:      opts.Group,
+		Columns:    make([]HeatmapColumn, 0, len(runs)),
+		Rows:       make([]HeatmapRow, 0),
+		Cells:      make([]HeatmapCell, 0),
+		Categories: make([]string, 0),
+	}
+	for _, run := range runs {
+		heatmap.Columns = append(heatmap.Columns, *run)
+	}
+	sort.Slice(heatmap.Columns, func(i int, j int) bool {
+		if heatmap.Columns[i].Start != heatmap.Columns[j].Start {
+			return heatmap.Columns[i].Start > heatmap.Columns[j].Start
+		}
+		return heatmap.Columns[i].ReportGroupID > heatmap.Columns[j].ReportGroupID
+	})
+	if opts.Limit > 0 && len(heatmap.Columns) > opts.Limit {
+		heatmap.Columns = heatmap.Columns[:opts.Limit]
+	}
+	columns := make(map[uint]int)
+	for i, c := range heatmap.Columns {
+		columns[c.ReportGroupID] = i
+	}
+
+	prefix := ""
+	if opts.Group != "" {
+		prefix = opts.Group + "/"
+	}
+	rows := make(map[string]*HeatmapRow)
+	cells := make(map[string]map[int]*HeatmapCell)
+	categories := make(map[string]bool)
+	for _, o := range outcomes {
+		column, ok := columns[o.ReportGroupID]
+		if !ok {
+			continue
+		}
+		if o.Failed() {
+			categories[FailureCategory(o.FailureCategory)] = true
+			if opts.Category != "" && FailureCategory(o.FailureCategory) != opts.Category {
+				continue
+			}
+		}
+
+		// Top level tests are rows of the top level view, their subtests
+		// make them expandable
+		if !strings.HasPrefix(o.Label, prefix) {
+			continue
+		}
+		label := strings.TrimPrefix(o.Label, prefix)
+		if opts.Group == "" && strings.Contains(label, "/") {
+			parent := strings.SplitN(label, "/", 2)[0]
+			if row, ok := rows[parent]; ok {
+				row.Subtests = true
+			} else {
+				rows[parent] = &HeatmapRow{Label: parent, Subtests: true}
+				cells[parent] = make(map[int]*HeatmapCell)
+			}
+			continue
+		}
+
+		row, ok := rows[label]
+		if !ok {
+			row = &HeatmapRow{Label: label}
+			rows[label] = row
+			cells[label] = make(map[int]*HeatmapCell)
+		}
+		if o.TestCaseID != 0 {
+			row.TestCaseID = o.TestCaseID
+		}
+		cell, ok := cells[label][column]
+		if !ok {
+			cell = &HeatmapCell{Column: column}
+			cells[label][column] = cell
+		}
+		if o.Passed() {
+			row.Passed += 1
+			cell.Passed += 1
+		} else {
+			row.Failed += 1
+			cell.Failed += 1
+		}
+	}
+
+	for label, row := range rows {
+		// Rows that only stand for their subtests have no results of their own
+		if len(cells[label]) == 0 || (opts.Category != "" && row.Failed == 0) {
+			continue
+		}
+		sum := 0.0
+		for _, cell := range cells[label] {
+			sum += float64(cell.Passed) / float64(cell.Passed+cell.Failed)
+		}
+		row.PassRate = sum / float64(len(cells[label]))
+		heatmap.Rows = append(heatmap.Rows, *row)
+	}
+	sortHeatmapRows(heatmap.Rows, opts.Sort)
+
+	for i, row := range heatmap.Rows {
+		for _, cell := range cells[row.Label] {
+			cell.Row = i
+			heatmap.Cells = append(heatmap.Cells, *cell)
+		}
+	}
+	sort.Slice(heatmap.Cells, func(i int, j int) bool {
+		if heatmap.Cells[i].Row != heatmap.Cells[j].Row {
+			return heatmap.Cells[i].Row < heatmap.Cells[j].Row
+		}
+		return heatmap.Cells[i].Column < heatmap.Cells[j].Column
+	})
+
+	for category := range categories {
+		heatmap.Categories = append(heatmap.Categories, category)
+	}
+	sort.Strings(heatmap.Categories)
+
+	return heatmap
+}
+
+func sortHeatmapRows(rows []HeatmapRow, order string) {
+	sort.Slice(rows, func(i int, j int) bool {
+		switch order {
+		case HeatmapSortFailures:
+			if rows[i].Failed != rows[j].Failed {
+				return rows[i].Failed > rows[j].Failed
+			}
+		case HeatmapSortName:
+		default:
+			if rows[i].PassRate != rows[j].PassRate {
+				return rows[i].PassRate < rows[j].PassRate
+			}
+		}
+		return rows[i].Label < rows[j].Label
+	})
+}
@@ -0,0 +1,129 @@
+package analysis
+
+import (
+	"fmt"
+	"math"
+	"test-analyzer/ingestion"
+	"testing"
+)
+
+func heatmapOutcomes() []ingestion.TestOutcome {
+	result := func(run uint, label string, status string, category string) ingestion.TestOutcome {
+		branch := "master"
+		if run == 3 {
+			branch = "feature"
+		}
+		return ingestion.TestOutcome{
+			Label:           label,
+			Status:          status,
+			ReportGroupID:   run,
+			RunID:           int64(run),
+			RunLabel:        fmt.Sprintf("run %d", run),
+			HeadBranch:      branch,
+			Start:           int64(run * 100),
+			FailureCategory: category,
+		}
+	}
+	return []ingestion.TestOutcome{
+		result(1, "TestA", "pass", ""),
+		result(1, "TestB", "fail", CategoryTimeout),
+		result(1, "TestB/sub", "fail", CategoryTimeout),
+		result(1, "TestC", "pass", ""),
+		result(2, "TestA", "pass", ""),
+		result(2, "TestA", "pass", ""),
+		result(2, "TestB", "pass", ""),
+		result(2, "TestB/sub", "pass", ""),
+		result(2, "TestC", "fail", CategoryPanic),
+		result(2, "TestD/only", "pass", ""),
+		result(3, "TestA", "fail", CategoryInfra),
+		result(3, "TestC", "pass", ""),
+	}
+}
+
+func rowLabels(h Heatmap) []string {
+	labels := make([]string, 0, len(h.Rows))
+	for _, r := range h.Rows {
+		labels = append(labels, r.Label)
+	}
+	return labels
+}
+
+func TestBuildHeatmap(t *testing.T) {
+	h := BuildHeatmap(heatmapOutcomes(), HeatmapOptions{})
+
+	if len(h.Columns) != 3 || h.Columns[0].RunID != 3 || h.Columns[2].RunID != 1 {
+		t.Fatalf("got columns %+v, want runs 3, 2 and 1", h.Columns)
+	}
+	// TestD only stands for its subtests and has no results of its own
+	if got := fmt.Sprint(rowLabels(h)); got != "[TestB TestA TestC]" {
+		t.Fatalf("got rows %s", got)
+	}
+	if !h.Rows[0].Subtests || h.Rows[1].Subtests {
+		t.Errorf("got subtests %t and %t for TestB and TestA", h.Rows[0].Subtests, h.Rows[1].Subtests)
+	}
+
+	a := h.Rows[1]
+	if a.Passed != 3 || a.Failed != 1 || math.Abs(a.PassRate-2.0/3) > 1e-9 {
+		t.Errorf("got row %+v for TestA", a)
+	}
+	if h.Rows[0].PassRate != 0.5 {
+		t.Errorf("got pass rate %f for TestB, want 0.5", h.Rows[0].PassRate)
+	}
+
+	want := []HeatmapCell{
+		{Row: 0, Column: 1, Passed: 1},
+		{Row: 0, Column: 2, Failed: 1},
+		{Row: 1, Column: 0, Failed: 1},
+		{Row: 1, Column: 1, Passed: 2},
+		{Row: 1, Column: 2, Passed: 1},
+		{Row: 2, Column: 0, Passed: 1},
+		{Row: 2, Column: 1, Failed: 1},
+		{Row: 2, Column: 2, Passed: 1},
+	}
+	if fmt.Sprint(h.Cells) != fmt.Sprint(want) {
+		t.Errorf("got cells %v, want %v", h.Cells, want)
+	}
+
+	if got := fmt.Sprint(h.Categories); got != "[infra panic timeout]" {
+		t.Errorf("got categories %s", got)
+	}
+}
+
+func TestBuildHeatmapOptions(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    HeatmapOptions
+		columns int
+		rows    string
+	}{
+		{name: "group", opts: HeatmapOptions{Group: "TestB"}, columns: 3, rows: "[sub]"},
+		{name: "branch", opts: HeatmapOptions{Branch: "master"}, columns: 2, rows: "[TestB TestC TestA]"},
+		{name: "limit", opts: HeatmapOptions{Limit: 1}, columns: 1, rows: "[TestA TestC]"},
+		{name: "run", opts: HeatmapOptions{Run: 2}, columns: 1, rows: "[TestC TestA TestB]"},
+		{name: "run on another branch", opts: HeatmapOptions{Run: 3, Branch: "master"}, columns: 0, rows: "[]"},
+		{name: "category", opts: HeatmapOptions{Category: CategoryTimeout}, columns: 3, rows: "[TestB]"},
+		{name: "by name", opts: HeatmapOptions{Sort: HeatmapSortName}, columns: 3, rows: "[TestA TestB TestC]"},
+		{name: "unknown group", opts: HeatmapOptions{Group: "TestX"}, columns: 3, rows: "[]"},
+	}
+	for _, tt := range tests {
+		h := BuildHeatmap(heatmapOutcomes(), tt.opts)
+		if len(h.Columns) != tt.columns {
+			t.Errorf("%s: got %d columns, want %d", tt.name, len(h.Columns), tt.columns)
+		}
+		if got := fmt.Sprint(rowLabels(h)); got != tt.rows {
+			t.Errorf("%s: got rows %s, want %s", tt.name, got, tt.rows)
+		}
+	}
+}
+
+func TestSortHeatmapRows(t *testing.T) {
+	rows := []HeatmapRow{
+		{Label: "b", Failed: 1, PassRate: 0.9},
+		{Label: "c", Failed: 5, PassRate: 0.5},
+		{Label: "a", Failed: 1, PassRate: 0.9},
+	}
+	sortHeatmapRows(rows, HeatmapSortFailures)
+	if got := fmt.Sprint(rowLabels(Heatmap{Rows: rows})); got != "[c a b]" {
+		t.Errorf("got %s by failures", got)
+	}
+}
@@ -0,0 +1,70 @@
+package analysis
+
+import (
+	"math"
+	"sort"
+)
+
+// DefaultConfidenceZ is the standard normal quantile for 95% confidence.
+const DefaultConfidenceZ = 1.96
+
+// PassRateInterval is a pass rate together with its Wilson score confidence
+// interval. With few samples the interval is wide, so a test that passed 1 of
+// 1 runs gets a much lower Low bound than one that passed 500 of 500.
+type PassRateInterval struct {
+	Samples uint
+	Rate    float64
+	Low     float64
+	High    float64
+}
+
+// WilsonInterval estimates the pass rate from passes out of total samples.
+// Without samples the rate is unknown: Rate is 0 and the interval is [0, 1].
+func WilsonInterval(passes uint, total uint, z float64) PassRateInterval {
+	if total == 0 {
+		return PassRateInterval{Low: 0, High: 1}
+	}
+
+	n := float64(total)
+	p := float64(passes) / n
+	z2 := z * z
+	center := (p + z2/(2*n)) / (1 + z2/n)
+	margin := z / (1 + z2/n) * math.Sqrt(p*(1-p)/n+z2/(4*n*n))
+
+	return PassRateInterval{
+		Samples: total,
+		Rate:    p,
+		Low:     math.Max(0, center-margin),
+		High:    math.Min(1, center+margin),
+	}
+}
+
+// LessReliable orders intervals from most to least unreliable: by the upper
+// bound first, so a test is only ranked as unreliable as the evidence
+// allows, then by the point estimate.
+func (i PassRateInterval) LessReliable(o PassRateInterval) bool {
+	if i.High != o.High {
+		return i.High < o.High
+	}
+	return i.Rate < o.Rate
+}
+
+func (t TestMetrics) PassRateInterval() PassRateInterval {
+	return WilsonInterval(t.PassCount, t.PassCount+t.FailCount, DefaultConfidenceZ)
+}
+
+func (m ReportMetrics) PassRateInterval() PassRateInterval {
+	return WilsonInterval(m.PassCount, m.PassCount+m.FailCount, DefaultConfidenceZ)
+}
+
+// SortByUnreliability sorts metrics with the most unreliable tests first,
+// ranking by confidence interval so rarely run tests don't dominate.
+func SortByUnreliability(metrics []TestMetrics) {
+	sort.SliceStable(metrics, func(i int, j int) bool {
+		a, b := metrics[i].PassRateInterval(), metrics[j].PassRateInterval()
+		if a.High == b.High && a.Rate == b.Rate {
+			return metrics[i].TestLabel < metrics[j].TestLabel
+		}
+		return a.LessReliable(b)
+	})
+}
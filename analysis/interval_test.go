@@ -0,0 +1,54 @@
+package analysis
+
+import (
+	"math"
+	"testing"
+)
+
+func TestWilsonInterval(t *testing.T) {
+	tests := []struct {
+		passes, total uint
+		rate          float64
+		low, high     float64
+	}{
+		{passes: 0, total: 0, rate: 0, low: 0, high: 1},
+		{passes: 1, total: 1, rate: 1, low: 0.2065, high: 1},
+		{passes: 0, total: 1, rate: 0, low: 0, high: 0.7935},
+		{passes: 5, total: 10, rate: 0.5, low: 0.2366, high: 0.7634},
+		{passes: 500, total: 500, rate: 1, low: 0.9924, high: 1},
+	}
+	for _, tt := range tests {
+		i := WilsonInterval(tt.passes, tt.total, DefaultConfidenceZ)
+		if i.Samples != tt.total || math.Abs(i.Rate-tt.rate) > 1e-9 ||
+			math.Abs(i.Low-tt.low) > 1e-4 || math.Abs(i.High-tt.high) > 1e-4 {
+			t.Errorf("%d/%d: got %.4f [%.4f, %.4f], want %.4f [%.4f, %.4f]", tt.passes, tt.total,
+				i.Rate, i.Low, i.High, tt.rate, tt.low, tt.high)
+		}
+		if i.Low > i.Rate+1e-9 || i.Rate > i.High+1e-9 {
+			t.Errorf("%d/%d: rate %f outside [%f, %f]", tt.passes, tt.total, i.Rate, i.Low, i.High)
+		}
+	}
+
+	wide := WilsonInterval(1, 2, DefaultConfidenceZ)
+	narrow := WilsonInterval(100, 200, DefaultConfidenceZ)
+	if narrow.High-narrow.Low >= wide.High-wide.Low {
+		t.Errorf("more samples did not narrow the interval: %+v and %+v", wide, narrow)
+	}
+}
+
+func TestSortByUnreliability(t *testing.T) {
+	metrics := []TestMetrics{
+		{TestLabel: "RarelyFailing", PassCount: 95, FailCount: 5},
+		{TestLabel: "RunOnce", PassCount: 0, FailCount: 1},
+		{TestLabel: "OftenFailing", PassCount: 50, FailCount: 50},
+		{TestLabel: "Stable", PassCount: 500},
+	}
+	SortByUnreliability(metrics)
+
+	want := []string{"OftenFailing", "RunOnce", "RarelyFailing", "Stable"}
+	for i, label := range want {
+		if metrics[i].TestLabel != label {
+			t.Errorf("position %d: got %s, want %s", i, metrics[i].TestLabel, label)
+		}
+	}
+}
@@ -0,0 +1,78 @@
+package analysis
+
+import (
+	"fmt"
+	"test-analyzer/ingestion"
+)
+
+// TestMetricsStage generates the ReportTestMetrics of every newly ingested
+// report.
+type TestMetricsStage struct{}
+
+func (TestMetricsStage) Name() string {
+	return "test-metrics"
+}
+
+func (TestMetricsStage) Process(db *ingestion.RecordDB, report *ingestion.Report) error {
+	metrics := GenerateTestMetrics(*report)
+	rows := make([]ingestion.ReportTestMetrics, 0, len(metrics))
+	for _, tm := range metrics {
+		rows = append(rows, ingestion.ReportTestMetrics{
+			ReportID:   report.ID,
+			TestCaseID: tm.TestCaseID,
+			TestLabel:  tm.TestLabel,
+			PassCount:  tm.PassCount,
+			FailCount:  tm.FailCount,
+		})
+	}
+
+	return db.ReplaceReportTestMetrics(report.ID, rows)
+}
+
+// IngestionStages are the stages run over every report as it is extracted,
+// classifying failures with the given classifier, the default one if nil.
+func IngestionStages(classifier *Classifier) []ingestion.Stage {
+	return []ingestion.Stage{
+		TestMetricsStage{},
+		FailureSignatureStage{},
+		FailureCategoryStage{Classifier: classifier},
+	}
+}
+
+// GenerateMissingTestMetrics runs the metrics stage for reports that were
+// stored before it existed, returning how many reports were processed.
+func GenerateMissingTestMetrics(db *ingestion.RecordDB) (int, error) {
+	return processReports(db, db.GetReportIDsWithoutTestMetrics(), false, TestMetricsStage{})
+}
+
+// RebuildTestMetrics regenerates the metrics of every stored report.
+func RebuildTestMetrics(db *ingestion.RecordDB) (int, error) {
+	return processReports(db, db.AllReportIDs(), false, TestMetricsStage{})
+}
+
+// RebuildFailures re-extracts the failure message, signature and category of
+// every failed test from its logs, e.g. after the normalization or category
+// rules changed.
+func RebuildFailures(db *ingestion.RecordDB, classifier *Classifier) (int, error) {
+	return processReports(db, db.GetReportIDsWithFailures(), true, FailureSignatureStage{}, FailureCategoryStage{Classifier: classifier})
+}
+
+func processReports(db *ingestion.RecordDB, reportIds []uint, withLogs bool, stages ...ingestion.Stage) (int, error) {
+	for i, id := range reportIds {
+		fmt.Fprintf(db.Progress, "[%d/%d] Processing report %d\r", i+1, len(reportIds), id)
+		if report := db.LoadReport(id, withLogs); report == nil {
+			return i, fmt.Errorf("unable to load report %d", id)
+		} else if err := db.Transaction(func(tx *ingestion.RecordDB) error {
+			return ingestion.RunStages(tx, report, stages)
+		}); err != nil {
+			return i, err
+		}
+	}
+
+	if len(reportIds) > 0 {
+		fmt.Fprintln(db.Progress)
+		db.BumpDataVersion()
+	}
+
+	return len(reportIds), nil
+}
@@ -0,0 +1,158 @@
+package analysis
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"test-analyzer/ingestion"
+	"time"
+)
+
+const (
+	ActionQuarantine = "quarantine"
+	ActionRelease    = "release"
+)
+
+type QuarantineOptions struct {
+	// Window is the number of most recent results per test that are scored
+	Window int
+	// Threshold is the flakiness score over the window at or above which a
+	// test is recommended for quarantine
+	Threshold float64
+	// MinRuns is the number of results in the window below which a test is
+	// never recommended for quarantine
+	MinRuns int
+	// ReleaseAfter is the number of consecutive passing results after which
+	// a quarantined test is recommended for release
+	ReleaseAfter int
+}
+
+func DefaultQuarantineOptions() QuarantineOptions {
+	return QuarantineOptions{
+		Window:       30,
+		Threshold:    0.2,
+		MinRuns:      10,
+		ReleaseAfter: 20,
+	}
+}
+
+// QuarantineRecommendation suggests quarantining a flaky test or releasing a
+// quarantined one that has been passing since.
+type QuarantineRecommendation struct {
+	Action     string
+	TestCaseID uint
+	TestLabel  string
+	Package    string
+	Flakiness  float64
+	Runs       int
+	Failures   int
+	// StableRuns is the number of consecutive passing results up to the latest
+	StableRuns int
+	Reason     string
+}
+
+// RecommendQuarantine scores every test over its last opts.Window results,
+// which must be ordered oldest first, and recommends quarantining the flaky
+// ones that are not in active and releasing the ones in active that passed
+// the last opts.ReleaseAfter times.
+func RecommendQuarantine(outcomes []ingestion.TestOutcome, active []ingestion.QuarantineEntry, opts QuarantineOptions) []QuarantineRecommendation {
+	quarantined := make(map[string]ingestion.QuarantineEntry)
+	for _, e := range active {
+		quarantined[TestKey(e.TestCaseID, e.Name)] = e
+	}
+
+	flakinessOpts := DefaultFlakinessOptions()
+	result := make([]QuarantineRecommendation, 0)
+	for key, history := range GroupOutcomes(outcomes) {
+		if opts.Window > 0 && len(history) > opts.Window {
+			history = history[len(history)-opts.Window:]
+		}
+
+		f := ScoreFlakiness(history, flakinessOpts)
+		stable := 0
+		for i := len(history) - 1; i >= 0 && history[i].Passed(); i-- {
+			stable += 1
+		}
+		r := QuarantineRecommendation{
+			TestCaseID: f.TestCaseID,
+			TestLabel:  f.TestLabel,
+			Package:    f.Package,
+			Flakiness:  f.Score,
+			Runs:       f.Runs,
+			Failures:   f.Failures,
+			StableRuns: stable,
+		}
+
+		if _, ok := quarantined[key]; ok {
+			if stable >= opts.ReleaseAfter {
+				r.Action = ActionRelease
+				r.Reason = fmt.Sprintf("passed the last %d runs", stable)
+				result = append(result, r)
+			}
+		} else if f.Runs >= opts.MinRuns && f.Score >= opts.Threshold {
+			r.Action = ActionQuarantine
+			r.Reason = fmt.Sprintf("flakiness %.2f over the last %d runs, %d failures", f.Score, f.Runs, f.Failures)
+			result = append(result, r)
+		}
+	}
+
+	sort.Slice(result, func(i int, j int) bool {
+		if result[i].Action != result[j].Action {
+			return result[i].Action == ActionQuarantine
+		}
+		if result[i].Flakiness != result[j].Flakiness {
+			return result[i].Flakiness > result[j].Flakiness
+		}
+		return result[i].TestLabel < result[j].TestLabel
+	})
+
+	return result
+}
+
+// SkipPattern returns a go test -skip pattern matching the quarantined tests.
+// go test matches -skip level by level, so a single pattern can't select
+// subtests of different parents; quarantined subtests are skipped through
+// their top level test.
+func SkipPattern(entries []ingestion.QuarantineEntry) string {
+	names := make([]string, 0, len(entries))
+	seen := make(map[string]bool)
+	for _, e := range entries {
+		name := strings.SplitN(e.Name, "/", 2)[0]
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, regexp.QuoteMeta(name))
+		}
+	}
+	if len(names) == 0 {
+		return ""
+	}
+	sort.Strings(names)
+
+	return "^(" + strings.Join(names, "|") + ")$"
+}
+
+// WriteQuarantineYAML writes the quarantined tests as a YAML document for CI
+// to consume, along with the combined -skip pattern.
+func WriteQuarantineYAML(w io.Writer, entries []ingestion.QuarantineEntry) error {
+	var sb strings.Builder
+	sb.WriteString("# Quarantined tests, generated by test-analyzer\n")
+	sb.WriteString("skip: " + strconv.Quote(SkipPattern(entries)) + "\n")
+	if len(entries) == 0 {
+		sb.WriteString("tests: []\n")
+	} else {
+		sb.WriteString("tests:\n")
+	}
+	for _, e := range entries {
+		sb.WriteString("  - package: " + strconv.Quote(e.Package) + "\n")
+		sb.WriteString("    name: " + strconv.Quote(e.Name) + "\n")
+		sb.WriteString("    reason: " + strconv.Quote(e.Reason) + "\n")
+		sb.WriteString("    quarantined_by: " + strconv.Quote(e.QuarantinedBy) + "\n")
+		sb.WriteString("    quarantined_at: " + strconv.Quote(time.UnixMilli(e.QuarantinedAt).UTC().Format(time.RFC3339)) + "\n")
+	}
+
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
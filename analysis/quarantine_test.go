@@ -0,0 +1,109 @@
+package analysis
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"test-analyzer/ingestion"
+	"testing"
+)
+
+func TestRecommendQuarantine(t *testing.T) {
+	opts := QuarantineOptions{Window: 10, Threshold: 0.5, MinRuns: 4, ReleaseAfter: 3}
+	score := ScoreFlakiness(history(1, "ppfpp"), DefaultFlakinessOptions()).Score
+	quarantined := []ingestion.QuarantineEntry{{TestCaseID: 1, Name: "TestX"}}
+
+	tests := []struct {
+		name     string
+		outcomes []ingestion.TestOutcome
+		active   []ingestion.QuarantineEntry
+		opts     func(o *QuarantineOptions)
+		want     string
+	}{
+		{name: "below min runs", outcomes: history(1, "pfp"), want: ""},
+		{name: "at min runs", outcomes: history(1, "pfpf"), want: "quarantine 1"},
+		{name: "at threshold", outcomes: history(1, "ppfpp"), opts: func(o *QuarantineOptions) { o.Threshold = score }, want: "quarantine 1"},
+		{name: "below threshold", outcomes: history(1, "ppfpp"), opts: func(o *QuarantineOptions) { o.Threshold = score + 0.01 }, want: ""},
+		{name: "flaky before the window", outcomes: history(1, "pfpfpfpppppppppp"), want: ""},
+		{name: "flaky without a window", outcomes: history(1, "pfpfpfpfpfpppp"), opts: func(o *QuarantineOptions) { o.Window = 0 }, want: "quarantine 1"},
+		{name: "already quarantined", outcomes: history(1, "pfpf"), active: quarantined, want: ""},
+		{name: "not passing long enough", outcomes: history(1, "pfpfpp"), active: quarantined, want: ""},
+		{name: "passing long enough", outcomes: history(1, "pfpfppp"), active: quarantined, want: "release 1"},
+		{name: "release after quarantine", outcomes: append(history(1, "pppp"), history(2, "pfpf")...), active: quarantined, want: "quarantine 2, release 1"},
+	}
+	for _, tt := range tests {
+		o := opts
+		if tt.opts != nil {
+			tt.opts(&o)
+		}
+		recommendations := RecommendQuarantine(tt.outcomes, tt.active, o)
+		got := make([]string, 0, len(recommendations))
+		for _, r := range recommendations {
+			got = append(got, fmt.Sprintf("%s %d", r.Action, r.TestCaseID))
+		}
+		if strings.Join(got, ", ") != tt.want {
+			t.Errorf("%s: got %v, want %q", tt.name, got, tt.want)
+		}
+	}
+
+	r := RecommendQuarantine(history(1, "ffpppp"), quarantined, opts)
+	if len(r) != 1 || r[0].StableRuns != 4 || r[0].Failures != 2 || r[0].Runs != 6 {
+		t.Errorf("got %+v", r)
+	}
+}
+
+func TestSkipPattern(t *testing.T) {
+	tests := []struct {
+		names []string
+		want  string
+	}{
+		{names: nil, want: ""},
+		{names: []string{"TestB", "TestA"}, want: "^(TestA|TestB)$"},
+		{names: []string{"TestA/sub", "TestA/other", "TestA"}, want: "^(TestA)$"},
+		{names: []string{"TestA/with space", "TestB/x"}, want: "^(TestA|TestB)$"},
+		{names: []string{"TestA[1]", "TestA.x"}, want: `^(TestA\.x|TestA\[1\])$`},
+	}
+	for _, tt := range tests {
+		entries := make([]ingestion.QuarantineEntry, 0, len(tt.names))
+		for _, name := range tt.names {
+			entries = append(entries, ingestion.QuarantineEntry{Name: name})
+		}
+		if got := SkipPattern(entries); got != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.names, got, tt.want)
+		}
+	}
+}
+
+func TestWriteQuarantineYAML(t *testing.T) {
+	var b bytes.Buffer
+	if err := WriteQuarantineYAML(&b, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := "# Quarantined tests, generated by test-analyzer\nskip: \"\"\ntests: []\n"; b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+
+	b.Reset()
+	err := WriteQuarantineYAML(&b, []ingestion.QuarantineEntry{{
+		Package:       "pkg",
+		Name:          "TestA/sub",
+		Reason:        `flaky: "timeout"`,
+		QuarantinedBy: "alice",
+		QuarantinedAt: 86400000,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `# Quarantined tests, generated by test-analyzer
+skip: "^(TestA)$"
+tests:
+  - package: "pkg"
+    name: "TestA/sub"
+    reason: "flaky: \"timeout\""
+    quarantined_by: "alice"
+    quarantined_at: "1970-01-02T00:00:00Z"
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
@@ -0,0 +1,338 @@
+package analysis
+
+import (
+	"sort"
+	"test-analyzer/ingestion"
+)
+
+// ResultCounts counts test results by status. Start and End span the results,
+// in ms since the epoch, and are 0 without any.
+type ResultCounts struct {
+	Pass  int
+	Fail  int
+	Skip  int
+	Start int64
+	End   int64
+}
+
+func (c *ResultCounts) add(t ingestion.Test) {
+	switch t.Status {
+	case "pass":
+		c.Pass += 1
+	case "fail":
+		c.Fail += 1
+	case "skip":
+		c.Skip += 1
+	}
+	if t.Start > 0 && (c.Start == 0 || t.Start < c.Start) {
+		c.Start = t.Start
+	}
+	if t.End > c.End {
+		c.End = t.End
+	}
+}
+
+func (c *ResultCounts) merge(o ResultCounts) {
+	c.Pass += o.Pass
+	c.Fail += o.Fail
+	c.Skip += o.Skip
+	if o.Start > 0 && (c.Start == 0 || o.Start < c.Start) {
+		c.Start = o.Start
+	}
+	if o.End > c.End {
+		c.End = o.End
+	}
+}
+
+// PackageCounts holds the result counts of a package within a report.
+type PackageCounts struct {
+	Package string
+	ResultCounts
+}
+
+// RunReport is a report (an artifact) of a run with its result counts.
+type RunReport struct {
+	ReportID uint
+	Label    string
+	ResultCounts
+	Packages []PackageCounts
+}
+
+// RunFailure is a failed test of a run.
+type RunFailure struct {
+	TestID          uint
+	TestCaseID      uint
+	Report          string
+	Package         string
+	TestLabel       string
+	Duration        int64
+	FailureMessage  string
+	FailureCategory string
+}
+
+// RunTestChange is a test whose result differs from the previous run.
+type RunTestChange struct {
+	TestCaseID     uint
+	Package        string
+	TestLabel      string
+	FailureMessage string
+}
+
+// RunDetail summarizes a single workflow run. Previous is the run of the same
+// branch before it, which NewlyFailing and NewlyPassing compare against:
+// newly failing tests failed in this run but not in the previous one (where
+// they may not have run), newly passing tests failed in the previous run and
+// passed in this one.
+type RunDetail struct {
+	ReportGroupID uint
+	RunID         int64
+	Label         string
+	HeadSHA       string
+	HeadBranch    string
+	Event         string
+	CreatedAt     int64
+	ResultCounts
+	Reports      []RunReport
+	Failures     []RunFailure
+	Previous     *ingestion.ReportGroup `json:",omitempty"`
+	NewlyFailing []RunTestChange
+	NewlyPassing []RunTestChange
+}
+
+// runResults loads the reports of a run with their results, without logs.
+func runResults(db *ingestion.RecordDB, reportGroupId uint) []ingestion.Report {
+	reports := make([]ingestion.Report, 0)
+	for _, r := range db.GetReports(reportGroupId) {
+		if report := db.LoadReport(r.ID, false); report != nil {
+			reports = append(reports, *report)
+		}
+	}
+	sort.Slice(reports, func(i int, j int) bool {
+		return reports[i].Label < reports[j].Label
+	})
+
+	return reports
+}
+
+// ComputeRunDetail summarizes a run and compares it with the previous run of
+// its branch.
+func ComputeRunDetail(db *ingestion.RecordDB, rg *ingestion.ReportGroup) RunDetail {
+	detail := RunDetail{
+		ReportGroupID: rg.ID,
+		RunID:         rg.RunID,
+		Label:         rg.Label,
+		HeadSHA:       rg.HeadSHA,
+		HeadBranch:    rg.HeadBranch,
+		Event:         rg.Event,
+		CreatedAt:     rg.RunCreatedAt,
+		Reports:       make([]RunReport, 0),
+		Failures:      make([]RunFailure, 0),
+		NewlyFailing:  make([]RunTestChange, 0),
+		NewlyPassing:  make([]RunTestChange, 0),
+	}
+	if detail.CreatedAt == 0 {
+		detail.CreatedAt = rg.CreatedAt.UnixMilli()
+	}
+
+	current := make(map[string]RunTestChange)
+	failed := make(map[string]bool)
+	passed := make(map[string]bool)
+	for _, report := range runResults(db, rg.ID) {
+		rr := RunReport{ReportID: report.ID, Label: report.Label, Packages: make([]PackageCounts, 0)}
+		for _, group := range report.TestGroups {
+			pc := PackageCounts{Package: group.Label}
+			for _, t := range group.Tests {
+				pc.add(t)
+
+				key := TestKey(t.TestCaseID, t.Label)
+				current[key] = RunTestChange{TestCaseID: t.TestCaseID, Package: group.Label, TestLabel: t.Label, FailureMessage: t.FailureMessage}
+				if t.Status == "fail" {
+					failed[key] = true
+					detail.Failures = append(detail.Failures, RunFailure{
+						TestID:          t.ID,
+						TestCaseID:      t.TestCaseID,
+						Report:          report.Label,
+						Package:         group.Label,
+						TestLabel:       t.Label,
+						Duration:        t.End - t.Start,
+						FailureMessage:  t.FailureMessage,
+						FailureCategory: FailureCategory(t.FailureCategory),
+					})
+				} else if t.Status == "pass" {
+					passed[key] = true
+				}
+			}
+			rr.ResultCounts.merge(pc.ResultCounts)
+			rr.Packages = append(rr.Packages, pc)
+		}
+		sort.Slice(rr.Packages, func(i int, j int) bool {
+			return rr.Packages[i].Package < rr.Packages[j].Package
+		})
+		detail.ResultCounts.merge(rr.ResultCounts)
+		detail.Reports = append(detail.Reports, rr)
+	}
+	sort.Slice(detail.Failures, func(i int, j int) bool {
+		if detail.Failures[i].Package != detail.Failures[j].Package {
+			return detail.Failures[i].Package < detail.Failures[j].Package
+		}
+		return detail.Failures[i].TestLabel < detail.Failures[j].TestLabel
+	})
+
+	detail.Previous = db.GetPreviousReportGroup(rg)
+	if detail.Previous == nil {
+		return detail
+	}
+
+	previousFailed := make(map[string]bool)
+	for _, report := range runResults(db, detail.Previous.ID) {
+		for _, group := range report.TestGroups {
+			for _, t := range group.Tests {
+				if t.Status == "fail" {
+					previousFailed[TestKey(t.TestCaseID, t.Label)] = true
+				}
+			}
+		}
+	}
+	for key := range failed {
+		if !previousFailed[key] {
+			detail.NewlyFailing = append(detail.NewlyFailing, current[key])
+		}
+	}
+	for key := range previousFailed {
+		if passed[key] && !failed[key] {
+			detail.NewlyPassing = append(detail.NewlyPassing, current[key])
+		}
+	}
+	sortTestChanges(detail.NewlyFailing)
+	sortTestChanges(detail.NewlyPassing)
+
+	return detail
+}
+
+func sortTestChanges(changes []RunTestChange) {
+	sort.Slice(changes, func(i int, j int) bool {
+		if changes[i].Package != changes[j].Package {
+			return changes[i].Package < changes[j].Package
+		}
+		return changes[i].TestLabel < changes[j].TestLabel
+	})
+}
+
+// RunMetrics sums the pass / fail results of a workflow run, a row of the run
+// table. Start and End span its results, in ms since the epoch.
+type RunMetrics struct {
+	ReportGroupID uint
+	RunID         int64
+	RunLabel      string
+	HeadSHA       string
+	HeadBranch    string
+	Event         string
+	Start         int64
+	End           int64
+	PassCount     int
+	FailCount     int
+	// FailureCategories counts the failed tests of the run by category
+	FailureCategories map[string]int
+}
+
+// ComputeRunMetrics aggregates outcomes by run, newest first.
+func ComputeRunMetrics(outcomes []ingestion.TestOutcome) []RunMetrics {
+	runs := make(map[uint]*RunMetrics)
+	for _, o := range outcomes {
+		run, ok := runs[o.ReportGroupID]
+		if !ok {
+			run = &RunMetrics{
+				ReportGroupID:     o.ReportGroupID,
+				RunID:             o.RunID,
+				RunLabel:          o.RunLabel,
+				HeadSHA:           o.HeadSHA,
+				HeadBranch:        o.HeadBranch,
+				Event:             o.Event,
+				Start:             o.Start,
+				End:               o.End,
+				FailureCategories: make(map[string]int),
+			}
+			runs[o.ReportGroupID] = run
+		}
+		if o.Start > 0 && (run.Start == 0 || o.Start < run.Start) {
+			run.Start = o.Start
+		}
+		if o.End > run.End {
+			run.End = o.End
+		}
+		if o.Passed() {
+			run.PassCount += 1
+		} else {
+			run.FailCount += 1
+			run.FailureCategories[FailureCategory(o.FailureCategory)] += 1
+		}
+	}
+
+	result := make([]RunMetrics, 0, len(runs))
+	for _, run := range runs {
+		result = append(result, *run)
+	}
+	sort.Slice(result, func(i int, j int) bool {
+		if result[i].Start != result[j].Start {
+			return result[i].Start > result[j].Start
+		}
+		return result[i].ReportGroupID > result[j].ReportGroupID
+	})
+	return result
+}
+
+// PackagePassRate is the share of passing results of the tests of a package
+// over a number of runs.
+type PackagePassRate struct {
+	Package   string
+	Runs      int
+	PassCount int
+	FailCount int
+	PassRate  float64
+}
+
+// ComputePackagePassRates aggregates the results of the last runs runs of a
+// branch, all branches if empty, by package, ordered by package. All runs are
+// included if runs is 0.
+func ComputePackagePassRates(outcomes []ingestion.TestOutcome, branch string, runs int) []PackagePassRate {
+	outcomes = filterBranch(outcomes, branch)
+	recent := ComputeRunMetrics(outcomes)
+	if runs > 0 && len(recent) > runs {
+		recent = recent[:runs]
+	}
+	included := make(map[uint]bool)
+	for _, run := range recent {
+		included[run.ReportGroupID] = true
+	}
+
+	packages := make(map[string]*PackagePassRate)
+	packageRuns := make(map[string]map[uint]bool)
+	for _, o := range outcomes {
+		if !included[o.ReportGroupID] {
+			continue
+		}
+		p, ok := packages[o.Package]
+		if !ok {
+			p = &PackagePassRate{Package: o.Package}
+			packages[o.Package] = p
+			packageRuns[o.Package] = make(map[uint]bool)
+		}
+		packageRuns[o.Package][o.ReportGroupID] = true
+		if o.Passed() {
+			p.PassCount += 1
+		} else {
+			p.FailCount += 1
+		}
+	}
+
+	result := make([]PackagePassRate, 0, len(packages))
+	for name, p := range packages {
+		p.Runs = len(packageRuns[name])
+		p.PassRate = float64(p.PassCount) / float64(p.PassCount+p.FailCount)
+		result = append(result, *p)
+	}
+	sort.Slice(result, func(i int, j int) bool {
+		return result[i].Package < result[j].Package
+	})
+	return result
+}
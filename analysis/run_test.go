@@ -0,0 +1,183 @@
+package analysis
+
+import (
+	"fmt"
+	"path/filepath"
+	"test-analyzer/ingestion"
+	"testing"
+)
+
+// runResult is a test of package pkg in a stored run.
+type runResult struct {
+	pkg    string
+	label  string
+	status string
+}
+
+// storeRun stores a run of branch with a report per label holding its results.
+func storeRun(t *testing.T, db *ingestion.RecordDB, project *ingestion.Project, label string, branch string, createdAt int64, reports map[string][]runResult) *ingestion.ReportGroup {
+	t.Helper()
+	group := db.FindOrCreateReportGroupByLabel(project.ID, label)
+	if err := db.UpdateRunMetadata(group, ingestion.RunMetadata{RunID: createdAt, HeadBranch: branch, CreatedAt: createdAt}); err != nil {
+		t.Fatal(err)
+	}
+
+	for reportLabel, results := range reports {
+		report := ingestion.Report{ReportGroupID: group.ID, Label: reportLabel}
+		groups := make(map[string]int)
+		for _, r := range results {
+			i, ok := groups[r.pkg]
+			if !ok {
+				i = len(report.TestGroups)
+				groups[r.pkg] = i
+				report.TestGroups = append(report.TestGroups, ingestion.TestGroup{Label: r.pkg})
+			}
+			test := ingestion.Test{Label: r.label, Status: r.status, Start: createdAt, End: createdAt + 100}
+			if r.status == "fail" {
+				test.FailureMessage = fmt.Sprintf("%s failed", r.label)
+			}
+			report.TestGroups[i].Tests = append(report.TestGroups[i].Tests, test)
+		}
+		db.AssignTestCases(&report)
+		if err := db.StoreReportWithStages(&report, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return db.GetReportGroup(group.ID)
+}
+
+func changedTests(changes []RunTestChange) string {
+	labels := make([]string, 0, len(changes))
+	for _, c := range changes {
+		labels = append(labels, c.Package+"."+c.TestLabel)
+	}
+	return fmt.Sprint(labels)
+}
+
+func TestComputeRunDetail(t *testing.T) {
+	db := ingestion.OpenRecordDB(filepath.Join(t.TempDir(), "run.db"))
+	if db == nil {
+		t.Fatal("unable to open the database")
+	}
+	project := db.FindOrCreateProjectByLabel("dapr")
+
+	first := storeRun(t, db, project, "run-1", "master", 1000, map[string][]runResult{
+		"unit": {
+			{"pkg/a", "TestA", "fail"},
+			{"pkg/a", "TestB", "pass"},
+			{"pkg/a", "TestC", "fail"},
+			{"pkg/a", "TestF", "fail"},
+		},
+	})
+	// runs of other branches are never compared against
+	storeRun(t, db, project, "run-2", "feature", 1500, map[string][]runResult{
+		"unit": {
+			{"pkg/a", "TestA", "pass"},
+			{"pkg/a", "TestB", "fail"},
+		},
+	})
+	run := storeRun(t, db, project, "run-3", "master", 2000, map[string][]runResult{
+		"unit": {
+			{"pkg/a", "TestA", "pass"},
+			{"pkg/a", "TestB", "fail"},
+			// failed before and passed on a retry
+			{"pkg/a", "TestC", "fail"},
+			{"pkg/a", "TestC", "pass"},
+			{"pkg/a", "TestD", "skip"},
+			{"pkg/a", "TestF", "fail"},
+		},
+		"e2e": {
+			// new, failed and passed on a retry
+			{"pkg/b", "TestE", "fail"},
+			{"pkg/b", "TestE", "pass"},
+		},
+	})
+
+	detail := ComputeRunDetail(db, run)
+	if detail.ReportGroupID != run.ID || detail.Label != "run-3" || detail.HeadBranch != "master" || detail.CreatedAt != 2000 {
+		t.Errorf("got run %+v", detail)
+	}
+	if detail.Previous == nil || detail.Previous.ID != first.ID {
+		t.Fatalf("got previous run %+v, want run-1", detail.Previous)
+	}
+	if detail.Pass != 3 || detail.Fail != 4 || detail.Skip != 1 || detail.Start != 2000 || detail.End != 2100 {
+		t.Errorf("got counts %+v", detail.ResultCounts)
+	}
+	if len(detail.Reports) != 2 || detail.Reports[0].Label != "e2e" || detail.Reports[1].Label != "unit" {
+		t.Fatalf("got reports %+v, want e2e and unit", detail.Reports)
+	}
+	if r := detail.Reports[1]; r.Pass != 2 || r.Fail != 3 || r.Skip != 1 || len(r.Packages) != 1 || r.Packages[0].Package != "pkg/a" {
+		t.Errorf("got unit report %+v", r)
+	}
+
+	failures := make([]string, 0, len(detail.Failures))
+	for _, f := range detail.Failures {
+		failures = append(failures, f.Report+":"+f.Package+"."+f.TestLabel)
+		if f.FailureCategory != CategoryUnknown || f.FailureMessage != f.TestLabel+" failed" || f.Duration != 100 {
+			t.Errorf("got failure %+v", f)
+		}
+	}
+	if got := fmt.Sprint(failures); got != "[unit:pkg/a.TestB unit:pkg/a.TestC unit:pkg/a.TestF e2e:pkg/b.TestE]" {
+		t.Errorf("got failures %s", got)
+	}
+
+	if got := changedTests(detail.NewlyFailing); got != "[pkg/a.TestB pkg/b.TestE]" {
+		t.Errorf("got newly failing tests %s", got)
+	}
+	if got := changedTests(detail.NewlyPassing); got != "[pkg/a.TestA]" {
+		t.Errorf("got newly passing tests %s", got)
+	}
+
+	detail = ComputeRunDetail(db, first)
+	if detail.Previous != nil || len(detail.NewlyFailing) != 0 || detail.NewlyPassing == nil {
+		t.Errorf("got first run %+v", detail)
+	}
+}
+
+func TestComputePackagePassRates(t *testing.T) {
+	result := func(run uint, branch string, pkg string, status string) ingestion.TestOutcome {
+		return ingestion.TestOutcome{
+			Label:         "TestA",
+			Package:       pkg,
+			Status:        status,
+			ReportGroupID: run,
+			HeadBranch:    branch,
+			Start:         int64(run * 1000),
+		}
+	}
+	outcomes := []ingestion.TestOutcome{
+		result(1, "master", "pkg/a", "fail"),
+		result(1, "master", "pkg/b", "pass"),
+		result(2, "master", "pkg/a", "pass"),
+		result(2, "master", "pkg/a", "fail"),
+		result(3, "feature", "pkg/a", "fail"),
+		result(3, "feature", "pkg/c", "pass"),
+		result(4, "master", "pkg/a", "pass"),
+	}
+
+	tests := []struct {
+		name   string
+		branch string
+		runs   int
+		want   string
+	}{
+		{name: "all", want: "[pkg/a:4/3/5 pkg/b:1/0/1 pkg/c:1/0/1]"},
+		{name: "branch", branch: "master", want: "[pkg/a:3/2/4 pkg/b:1/0/1]"},
+		{name: "last runs of branch", branch: "master", runs: 2, want: "[pkg/a:2/1/3]"},
+		{name: "last run", runs: 1, want: "[pkg/a:1/0/1]"},
+		{name: "unknown branch", branch: "release", want: "[]"},
+	}
+	for _, tt := range tests {
+		rates := ComputePackagePassRates(outcomes, tt.branch, tt.runs)
+		got := make([]string, 0, len(rates))
+		for _, r := range rates {
+			got = append(got, fmt.Sprintf("%s:%d/%d/%d", r.Package, r.Runs, r.FailCount, r.PassCount+r.FailCount))
+			if r.PassRate != float64(r.PassCount)/float64(r.PassCount+r.FailCount) {
+				t.Errorf("%s: got pass rate %v for %+v", tt.name, r.PassRate, r)
+			}
+		}
+		if fmt.Sprint(got) != tt.want {
+			t.Errorf("%s: got %v, want %s", tt.name, got, tt.want)
+		}
+	}
+}
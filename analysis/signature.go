@@ -0,0 +1,311 @@
+package analysis
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+	"test-analyzer/ingestion"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultClusterSimilarity is the token Jaccard similarity above which two
+	// failure signatures are considered the same failure
+	DefaultClusterSimilarity = 0.8
+
+	maxFailureMessageLength   = 500
+	maxFailureSignatureLength = 300
+)
+
+var (
+	ansiPattern        = regexp.MustCompile(`\x1b\[[0-9;]*[a-zA-Z]`)
+	testifyErrorLine   = regexp.MustCompile(`^\s*Error:\s*(.*)$`)
+	ignoredLinePattern = regexp.MustCompile(`^\s*(=== (RUN|PAUSE|CONT|NAME)|--- (FAIL|PASS|SKIP)|Error Trace:|Test:|FAIL\b|PASS\b|ok\s)`)
+	errorLinePattern   = regexp.MustCompile(`(?i)(panic:|error|fail|timed? ?out|deadline|refused|not found|unexpected|unable|cannot|could not)`)
+
+	// signatureReplacements strip the parts of a failure message that differ
+	// between occurrences of the same failure, most specific first.
+	signatureReplacements = []struct {
+		pattern     *regexp.Regexp
+		replacement string
+	}{
+		{regexp.MustCompile(`(\w+\.go):\d+`), "$1:<L>"},
+		{regexp.MustCompile(`\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(\.\d+)?(Z|[+-]\d{2}:?\d{2})?`), "<TIME>"},
+		{regexp.MustCompile(`\b\d{2}:\d{2}:\d{2}(\.\d+)?\b`), "<TIME>"},
+		{regexp.MustCompile(`(?i)\b[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}\b`), "<ID>"},
+		{regexp.MustCompile(`\b\d{1,3}(\.\d{1,3}){3}(:\d+)?\b`), "<ADDR>"},
+		{regexp.MustCompile(`\[[0-9a-fA-F]*:[0-9a-fA-F:]+\](:\d+)?`), "<ADDR>"},
+		{regexp.MustCompile(`localhost:\d+`), "localhost:<PORT>"},
+		{regexp.MustCompile(`\b([a-z0-9]+(-[a-z0-9]+)*)-[a-z0-9]{8,10}-[a-z0-9]{5}\b`), "$1-<POD>"},
+		{regexp.MustCompile(`\b(0x[0-9a-fA-F]+|[0-9a-f]{8,})\b`), "<HEX>"},
+		{regexp.MustCompile(`\b\d+(\.\d+)?(ns|µs|us|ms|s|m|h)\b`), "<DUR>"},
+		{regexp.MustCompile(`\b\d+(\.\d+)?\b`), "<N>"},
+		{regexp.MustCompile(`\s+`), " "},
+	}
+)
+
+// ExtractFailureMessage picks the line of a failed test's output that best
+// describes the failure: a testify "Error:" (with its continuation line when
+// the message itself ends in a colon), a panic, or else the first line that
+// looks like an error.
+func ExtractFailureMessage(logs []ingestion.TestLog) string {
+	lines := make([]string, 0)
+	for _, l := range logs {
+		for _, line := range strings.Split(StripANSI(l.Text), "\n") {
+			if strings.TrimSpace(line) != "" {
+				lines = append(lines, line)
+			}
+		}
+	}
+
+	candidate := ""
+	fallback := ""
+	for i, line := range lines {
+		if m := testifyErrorLine.FindStringSubmatch(line); m != nil {
+			message := strings.TrimSpace(m[1])
+			if (message == "" || strings.HasSuffix(message, ":")) && i+1 < len(lines) {
+				message = strings.TrimSpace(message + " " + strings.TrimSpace(lines[i+1]))
+			}
+			return truncate(message, maxFailureMessageLength)
+		}
+
+		trimmed := strings.TrimSpace(line)
+		if strings.HasPrefix(trimmed, "panic:") {
+			return truncate(trimmed, maxFailureMessageLength)
+		}
+		if ignoredLinePattern.MatchString(line) {
+			continue
+		}
+		if candidate == "" && errorLinePattern.MatchString(line) {
+			candidate = trimmed
+		}
+		fallback = trimmed
+	}
+
+	if candidate != "" {
+		return truncate(candidate, maxFailureMessageLength)
+	}
+	return truncate(fallback, maxFailureMessageLength)
+}
+
+// StripANSI removes terminal color and cursor escape sequences from a log line.
+func StripANSI(line string) string {
+	return ansiPattern.ReplaceAllString(line, "")
+}
+
+// IsErrorLine reports whether a log line looks like it describes an error: a
+// testify "Error:", a panic or a line mentioning a failure.
+func IsErrorLine(line string) bool {
+	return testifyErrorLine.MatchString(line) || (!ignoredLinePattern.MatchString(line) && errorLinePattern.MatchString(line))
+}
+
+// NormalizeSignature reduces a failure message to a signature that is equal
+// for repeated occurrences of the same failure, by replacing timestamps, IDs,
+// addresses, pod name suffixes, hex strings, durations and numbers with
+// placeholders.
+func NormalizeSignature(message string) string {
+	signature := message
+	for _, r := range signatureReplacements {
+		signature = r.pattern.ReplaceAllString(signature, r.replacement)
+	}
+
+	return truncate(strings.TrimSpace(signature), maxFailureSignatureLength)
+}
+
+// truncate cuts s to at most max bytes without splitting a UTF-8 sequence.
+func truncate(s string, max int) string {
+	if len(s) <= max {
+		return s
+	}
+	for max > 0 && !utf8.RuneStart(s[max]) {
+		max -= 1
+	}
+	return s[:max]
+}
+
+// FailureSignatureStage stores the failure message and signature of every
+// failed test of a newly ingested report.
+type FailureSignatureStage struct{}
+
+func (FailureSignatureStage) Name() string {
+	return "failure-signatures"
+}
+
+func (FailureSignatureStage) Process(db *ingestion.RecordDB, report *ingestion.Report) error {
+	for _, group := range report.TestGroups {
+		for _, test := range group.Tests {
+			if test.Status != "fail" {
+				continue
+			}
+
+			message := ExtractFailureMessage(test.Logs)
+			if err := db.SetTestFailure(test.ID, message, NormalizeSignature(message)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+type ClusterTest struct {
+	TestCaseID uint
+	TestLabel  string
+	Package    string
+	Failures   int
+}
+
+type ClusterRun struct {
+	ReportGroupID uint
+	RunID         int64
+	RunLabel      string
+	Failures      int
+}
+
+// FailureCluster is a group of failures with identical or similar
+// signatures, most likely sharing a root cause.
+type FailureCluster struct {
+	Signature  string
+	Example    string
+	Signatures []string
+	Failures   int
+	Tests      []ClusterTest
+	Runs       []ClusterRun
+	FirstSeen  int64
+	LastSeen   int64
+	FirstRun   string
+	LastRun    string
+}
+
+func signatureTokens(signature string) map[string]bool {
+	tokens := make(map[string]bool)
+	for _, t := range strings.Fields(strings.ToLower(signature)) {
+		tokens[t] = true
+	}
+	return tokens
+}
+
+func jaccard(a map[string]bool, b map[string]bool) float64 {
+	if len(a) == 0 && len(b) == 0 {
+		return 1
+	}
+	intersection := 0
+	for t := range a {
+		if b[t] {
+			intersection += 1
+		}
+	}
+	return float64(intersection) / float64(len(a)+len(b)-intersection)
+}
+
+// ClusterFailures groups failed outcomes by signature, then merges signatures
+// whose token Jaccard similarity to a cluster's representative signature is
+// at least similarity. Clusters are returned largest first.
+func ClusterFailures(outcomes []ingestion.TestOutcome, similarity float64) []FailureCluster {
+	bySignature := make(map[string][]ingestion.TestOutcome)
+	for _, o := range outcomes {
+		if o.Failed() && o.FailureSignature != "" {
+			bySignature[o.FailureSignature] = append(bySignature[o.FailureSignature], o)
+		}
+	}
+
+	signatures := make([]string, 0, len(bySignature))
+	for s := range bySignature {
+		signatures = append(signatures, s)
+	}
+	sort.Slice(signatures, func(i int, j int) bool {
+		a, b := len(bySignature[signatures[i]]), len(bySignature[signatures[j]])
+		if a != b {
+			return a > b
+		}
+		return signatures[i] < signatures[j]
+	})
+
+	type cluster struct {
+		tokens   map[string]bool
+		members  []string
+		outcomes []ingestion.TestOutcome
+	}
+	clusters := make([]*cluster, 0)
+	for _, s := range signatures {
+		tokens := signatureTokens(s)
+		var target *cluster
+		for _, c := range clusters {
+			if jaccard(tokens, c.tokens) >= similarity {
+				target = c
+				break
+			}
+		}
+		if target == nil {
+			target = &cluster{tokens: tokens}
+			clusters = append(clusters, target)
+		}
+		target.members = append(target.members, s)
+		target.outcomes = append(target.outcomes, bySignature[s]...)
+	}
+
+	result := make([]FailureCluster, 0, len(clusters))
+	for _, c := range clusters {
+		result = append(result, summarizeCluster(c.members, c.outcomes))
+	}
+
+	sort.SliceStable(result, func(i int, j int) bool {
+		return result[i].Failures > result[j].Failures
+	})
+
+	return result
+}
+
+func summarizeCluster(signatures []string, outcomes []ingestion.TestOutcome) FailureCluster {
+	fc := FailureCluster{
+		Signature:  signatures[0],
+		Signatures: signatures,
+		Failures:   len(outcomes),
+	}
+
+	tests := make(map[string]*ClusterTest)
+	runs := make(map[uint]*ClusterRun)
+	for _, o := range outcomes {
+		if fc.Example == "" && o.FailureSignature == fc.Signature {
+			fc.Example = o.FailureMessage
+		}
+		if fc.FirstSeen == 0 || o.Start < fc.FirstSeen {
+			fc.FirstSeen = o.Start
+			fc.FirstRun = o.RunLabel
+		}
+		if o.Start > fc.LastSeen {
+			fc.LastSeen = o.Start
+			fc.LastRun = o.RunLabel
+		}
+
+		key := TestKey(o.TestCaseID, o.Label)
+		if _, ok := tests[key]; !ok {
+			tests[key] = &ClusterTest{TestCaseID: o.TestCaseID, TestLabel: o.Label, Package: o.Package}
+		}
+		tests[key].Failures += 1
+
+		if _, ok := runs[o.ReportGroupID]; !ok {
+			runs[o.ReportGroupID] = &ClusterRun{ReportGroupID: o.ReportGroupID, RunID: o.RunID, RunLabel: o.RunLabel}
+		}
+		runs[o.ReportGroupID].Failures += 1
+	}
+
+	for _, t := range tests {
+		fc.Tests = append(fc.Tests, *t)
+	}
+	sort.Slice(fc.Tests, func(i int, j int) bool {
+		if fc.Tests[i].Failures != fc.Tests[j].Failures {
+			return fc.Tests[i].Failures > fc.Tests[j].Failures
+		}
+		return fc.Tests[i].TestLabel < fc.Tests[j].TestLabel
+	})
+
+	for _, r := range runs {
+		fc.Runs = append(fc.Runs, *r)
+	}
+	sort.Slice(fc.Runs, func(i int, j int) bool {
+		return fc.Runs[i].ReportGroupID > fc.Runs[j].ReportGroupID
+	})
+
+	return fc
+}
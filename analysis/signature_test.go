@@ -0,0 +1,176 @@
+package analysis
+
+import (
+	"strings"
+	"test-analyzer/ingestion"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestNormalizeSignature(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{
+			message: "server_test.go:42: request failed after 1.5s",
+			want:    "server_test.go:<L>: request failed after <DUR>",
+		},
+		{
+			message: "2023-04-01T12:30:45.123Z connection to 10.0.0.12:5432 refused",
+			want:    "<TIME> connection to <ADDR> refused",
+		},
+		{
+			message: "pod web-7d9f8b6c4d-x2k9p not ready",
+			want:    "pod web-<POD> not ready",
+		},
+		{
+			message: "object 123e4567-e89b-12d3-a456-426614174000 at 0xc000123456 has 3   items",
+			want:    "object <ID> at <HEX> has <N> items",
+		},
+		{
+			message: "  dial tcp localhost:8080: timeout  ",
+			want:    "dial tcp localhost:<PORT>: timeout",
+		},
+	}
+	for _, tt := range tests {
+		if got := NormalizeSignature(tt.message); got != tt.want {
+			t.Errorf("NormalizeSignature(%q) = %q, want %q", tt.message, got, tt.want)
+		}
+	}
+
+	a := NormalizeSignature("timed out waiting for job 17 after 30s")
+	b := NormalizeSignature("timed out waiting for job 912 after 45s")
+	if a != b {
+		t.Errorf("signatures differ: %q and %q", a, b)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		s    string
+		max  int
+		want string
+	}{
+		{s: "short", max: 10, want: "short"},
+		{s: "abcdef", max: 3, want: "abc"},
+		{s: "aé", max: 2, want: "a"},
+		{s: "日本語", max: 4, want: "日"},
+		{s: "日本語", max: 6, want: "日本"},
+		{s: "日本語", max: 2, want: ""},
+	}
+	for _, tt := range tests {
+		got := truncate(tt.s, tt.max)
+		if got != tt.want {
+			t.Errorf("truncate(%q, %d) = %q, want %q", tt.s, tt.max, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("truncate(%q, %d) returned invalid UTF-8", tt.s, tt.max)
+		}
+	}
+
+	long := strings.Repeat("ü", maxFailureSignatureLength)
+	if got := NormalizeSignature(long); !utf8.ValidString(got) || len(got) > maxFailureSignatureLength {
+		t.Errorf("signature of %d bytes is not valid UTF-8 within the limit", len(got))
+	}
+}
+
+func TestExtractFailureMessage(t *testing.T) {
+	logs := func(lines ...string) []ingestion.TestLog {
+		return []ingestion.TestLog{{Text: strings.Join(lines, "\n")}}
+	}
+	tests := []struct {
+		name string
+		logs []ingestion.TestLog
+		want string
+	}{
+		{
+			name: "testify error",
+			logs: logs(
+				"=== RUN   TestA",
+				"    Error Trace:	a_test.go:12",
+				"    Error:      	Not equal:",
+				"    expected: 1",
+				"--- FAIL: TestA (0.00s)",
+			),
+			want: "Not equal: expected: 1",
+		},
+		{
+			name: "panic",
+			logs: logs("=== RUN   TestA", "panic: runtime error: index out of range", "goroutine 1 [running]:"),
+			want: "panic: runtime error: index out of range",
+		},
+		{
+			name: "error line",
+			logs: logs("=== RUN   TestA", "setting up", "\x1b[31mcould not connect\x1b[0m", "cleanup", "--- FAIL: TestA"),
+			want: "could not connect",
+		},
+		{
+			name: "last line",
+			logs: logs("=== RUN   TestA", "first", "last", "--- FAIL: TestA"),
+			want: "last",
+		},
+		{
+			name: "no logs",
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		if got := ExtractFailureMessage(tt.logs); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func failure(testCaseId uint, runId int64, signature string) ingestion.TestOutcome {
+	return ingestion.TestOutcome{
+		TestCaseID:       testCaseId,
+		Status:           "fail",
+		Start:            runId * 100,
+		ReportGroupID:    uint(runId),
+		RunID:            runId,
+		FailureMessage:   signature + " (raw)",
+		FailureSignature: signature,
+	}
+}
+
+func TestClusterFailures(t *testing.T) {
+	outcomes := []ingestion.TestOutcome{
+		failure(1, 1, "dial tcp <ADDR>: connection refused by the remote host"),
+		failure(2, 1, "dial tcp <ADDR>: connection refused by the remote host"),
+		failure(1, 2, "dial tcp <ADDR>: connection refused by the remote server"),
+		failure(3, 3, "expected <N> items, got <N>"),
+		{TestCaseID: 4, Status: "pass", FailureSignature: "expected <N> items, got <N>"},
+		{TestCaseID: 5, Status: "fail"},
+	}
+
+	clusters := ClusterFailures(outcomes, 0.7)
+	if len(clusters) != 2 {
+		t.Fatalf("got %d clusters, want 2", len(clusters))
+	}
+
+	c := clusters[0]
+	if c.Failures != 3 || len(c.Signatures) != 2 || len(c.Tests) != 2 || len(c.Runs) != 2 {
+		t.Errorf("got %d failures, %d signatures, %d tests and %d runs, want 3, 2, 2 and 2",
+			c.Failures, len(c.Signatures), len(c.Tests), len(c.Runs))
+	}
+	if c.Signature != "dial tcp <ADDR>: connection refused by the remote host" {
+		t.Errorf("got representative signature %q", c.Signature)
+	}
+	if c.Example != c.Signature+" (raw)" {
+		t.Errorf("got example %q", c.Example)
+	}
+	if c.Tests[0].TestCaseID != 1 || c.Tests[0].Failures != 2 {
+		t.Errorf("got top test %+v, want #1 with 2 failures", c.Tests[0])
+	}
+	if c.FirstSeen != 100 || c.LastSeen != 200 || c.Runs[0].RunID != 2 {
+		t.Errorf("got first seen %d, last seen %d, latest run %d", c.FirstSeen, c.LastSeen, c.Runs[0].RunID)
+	}
+	if clusters[1].Failures != 1 {
+		t.Errorf("got %d failures in the second cluster, want 1", clusters[1].Failures)
+	}
+
+	if strict := ClusterFailures(outcomes, 1); len(strict) != 3 {
+		t.Errorf("got %d clusters at similarity 1, want 3", len(strict))
+	}
+}
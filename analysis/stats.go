@@ -1,6 +1,7 @@
 package analysis
 
 import (
+	"fmt"
 	"test-analyzer/ingestion"
 )
 
@@ -12,13 +13,25 @@ type ReportMetrics struct {
 }
 
 type TestMetrics struct {
-	TestLabel string
-	PassCount uint
-	FailCount uint
+	TestCaseID uint
+	TestLabel  string
+	PassCount  uint
+	FailCount  uint
 }
 
+// TestKey identifies a test for aggregation; the canonical test case when one
+// has been assigned, otherwise its label.
+func TestKey(testCaseId uint, label string) string {
+	if testCaseId != 0 {
+		return fmt.Sprintf("#%d", testCaseId)
+	}
+	return label
+}
+
+// PassRate is the fraction of passing results, 0 if the test has none; see
+// PassRateInterval for how much to trust it.
 func (t TestMetrics) PassRate() float64 {
-	return float64(t.PassCount) / float64(t.PassCount+t.FailCount)
+	return t.PassRateInterval().Rate
 }
 
 func (t TestMetrics) Add(o TestMetrics) TestMetrics {
@@ -28,33 +41,27 @@ func (t TestMetrics) Add(o TestMetrics) TestMetrics {
 }
 
 func (m ReportMetrics) PassRate() float64 {
-	return float64(m.PassCount) / float64(m.PassCount+m.FailCount)
+	return m.PassRateInterval().Rate
 }
 
 func GenerateTestMetrics(report ingestion.Report) []TestMetrics {
 	dataMap := make(map[string]TestMetrics)
 	for _, group := range report.TestGroups {
 		for _, test := range group.Tests {
-			if _, ok := dataMap[test.Label]; !ok {
-				dataMap[test.Label] = TestMetrics{
-					TestLabel: test.Label,
-					PassCount: 0,
-					FailCount: 0,
+			key := TestKey(test.TestCaseID, test.Label)
+			if _, ok := dataMap[key]; !ok {
+				dataMap[key] = TestMetrics{
+					TestCaseID: test.TestCaseID,
+					TestLabel:  test.Label,
+					PassCount:  0,
+					FailCount:  0,
 				}
 			}
 
 			if test.Status == "pass" {
-				dataMap[test.Label] = TestMetrics{
-					TestLabel: test.Label,
-					PassCount: dataMap[test.Label].PassCount + 1,
-					FailCount: dataMap[test.Label].FailCount,
-				}
+				dataMap[key] = dataMap[key].Add(TestMetrics{PassCount: 1})
 			} else if test.Status == "fail" {
-				dataMap[test.Label] = TestMetrics{
-					TestLabel: test.Label,
-					PassCount: dataMap[test.Label].PassCount,
-					FailCount: dataMap[test.Label].FailCount + 1,
-				}
+				dataMap[key] = dataMap[key].Add(TestMetrics{FailCount: 1})
 			}
 		}
 	}
@@ -66,6 +73,40 @@ func GenerateTestMetrics(report ingestion.Report) []TestMetrics {
 	return results
 }
 
+// AggregateTestMetrics sums the stored per-report metrics of every test.
+func AggregateTestMetrics(db *ingestion.RecordDB) []TestMetrics {
+	testMetrics := make([]TestMetrics, 0)
+	reportIds := db.GetReportIDsWithTestMetrics()
+	testMetricsMap := make(map[string]TestMetrics)
+
+	for i, rid := range reportIds {
+		fmt.Fprintf(db.Progress, "[%d/%d] Processing report %d\r", i, len(reportIds), rid)
+		for _, tm := range db.GetReportTestMetrics(rid) {
+			key := TestKey(tm.TestCaseID, tm.TestLabel)
+			if _, ok := testMetricsMap[key]; !ok {
+				testMetricsMap[key] = TestMetrics{
+					TestCaseID: tm.TestCaseID,
+					TestLabel:  tm.TestLabel,
+				}
+			}
+			testMetricsMap[key] = testMetricsMap[key].Add(TestMetrics{
+				PassCount: tm.PassCount,
+				FailCount: tm.FailCount,
+			})
+		}
+	}
+
+	if len(reportIds) > 0 {
+		fmt.Fprintln(db.Progress)
+	}
+
+	for _, tm := range testMetricsMap {
+		testMetrics = append(testMetrics, tm)
+	}
+
+	return testMetrics
+}
+
 func GenerateMetrics(reportGroup ingestion.ReportGroup) map[uint]ReportMetrics {
 	result := make(map[uint]ReportMetrics)
 	for _, report := range reportGroup.Reports {
@@ -98,23 +139,36 @@ func GenerateMetrics(reportGroup ingestion.ReportGroup) map[uint]ReportMetrics {
 }
 
 type TestHistory struct {
-	Label    string
-	Group    string
-	Subgroup string
-	Passed   bool
+	TestCaseID    uint
+	ReportGroupID uint
+	Label         string
+	Group         string
+	Subgroup      string
+	Passed        bool
+	// Category is the failure category of a failed test
+	Category string `json:",omitempty"`
 }
 
 func GenerateTestHistory(reportGroup *ingestion.ReportGroup) []TestHistory {
 	result := make([]TestHistory, 0)
 	for _, report := range reportGroup.Reports {
+		if !report.MetricsGenerated {
+			continue
+		}
 		for _, group := range report.TestGroups {
 			for _, test := range group.Tests {
-				result = append(result, TestHistory{
-					Label:    test.Label,
-					Group:    reportGroup.Label,
-					Subgroup: report.Label,
-					Passed:   test.Status == "pass",
-				})
+				h := TestHistory{
+					TestCaseID:    test.TestCaseID,
+					ReportGroupID: reportGroup.ID,
+					Label:         test.Label,
+					Group:         reportGroup.Label,
+					Subgroup:      report.Label,
+					Passed:        test.Status == "pass",
+				}
+				if test.Status == "fail" {
+					h.Category = FailureCategory(test.FailureCategory)
+				}
+				result = append(result, h)
 			}
 		}
 	}
@@ -0,0 +1,9 @@
+package main
+
+import "embed"
+
+// assets are the web UI's templates and static files, embedded so the binary
+// can be run from anywhere.
+//
+//go:embed templates static
+var assets embed.FS
@@ -0,0 +1,333 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultMaxEntries and DefaultMaxBytes bound the memory tier of a new
+	// cache
+	DefaultMaxEntries = 512
+	DefaultMaxBytes   = 256 << 20
+)
+
+// Cache stores JSON-encoded views keyed by name and the data version they
+// were built from. Entries live in memory and are written through to disk so
+// that they survive restarts; an entry built from an older data version is
+// never served. Concurrent requests for the same stale key share one rebuild.
+// The memory tier holds at most MaxEntries entries and MaxBytes bytes, the
+// least recently used entries are evicted from both tiers to stay below them.
+type Cache struct {
+	Dir        string
+	MaxEntries int
+	MaxBytes   int
+
+	mu       sync.Mutex
+	entries  map[string]*list.Element
+	lru      *list.List
+	bytes    int
+	inflight map[string]*call
+}
+
+type entry struct {
+	key     string
+	version uint64
+	data    []byte
+	builtAt time.Time
+	hits    uint64
+	tier    string
+}
+
+type call struct {
+	wg   sync.WaitGroup
+	data []byte
+	err  error
+}
+
+type EntryStats struct {
+	Key     string    `json:"key"`
+	Version uint64    `json:"version"`
+	Bytes   int       `json:"bytes"`
+	BuiltAt time.Time `json:"built_at"`
+	Hits    uint64    `json:"hits"`
+	Tier    string    `json:"tier"`
+}
+
+func New(dir string) *Cache {
+	if dir != "" {
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			fmt.Printf("Unable to create cache dir %s, caching in memory only: %v\n", dir, err)
+			dir = ""
+		}
+	}
+
+	return &Cache{
+		Dir:        dir,
+		MaxEntries: DefaultMaxEntries,
+		MaxBytes:   DefaultMaxBytes,
+		entries:    make(map[string]*list.Element),
+		lru:        list.New(),
+		inflight:   make(map[string]*call),
+	}
+}
+
+// Get returns the JSON encoding of key at the given data version, calling
+// build to regenerate it if neither the memory nor the disk tier has it.
+func (c *Cache) Get(key string, version uint64, build func() (interface{}, error)) ([]byte, error) {
+	c.mu.Lock()
+	if el, ok := c.entries[key]; ok && el.Value.(*entry).version == version {
+		e := el.Value.(*entry)
+		e.hits += 1
+		c.lru.MoveToFront(el)
+		c.mu.Unlock()
+		return e.data, nil
+	}
+
+	flightKey := fmt.Sprintf("%s@%d", key, version)
+	if cl, ok := c.inflight[flightKey]; ok {
+		c.mu.Unlock()
+		cl.wg.Wait()
+		return cl.data, cl.err
+	}
+
+	cl := &call{}
+	cl.wg.Add(1)
+	c.inflight[flightKey] = cl
+	c.mu.Unlock()
+	// Waiters are released however the build ends, a panic included
+	defer func() {
+		c.mu.Lock()
+		delete(c.inflight, flightKey)
+		c.mu.Unlock()
+		cl.wg.Done()
+	}()
+
+	var tier string
+	if cl.data, tier, cl.err = c.load(key, version, build); cl.err != nil {
+		return nil, cl.err
+	}
+
+	c.mu.Lock()
+	evicted := c.store(&entry{
+		key:     key,
+		version: version,
+		data:    cl.data,
+		builtAt: time.Now(),
+		tier:    tier,
+	})
+	c.mu.Unlock()
+
+	for _, k := range evicted {
+		c.removeDisk(k)
+	}
+
+	return cl.data, nil
+}
+
+// load reads key from the disk tier, or else builds it and writes it there,
+// returning the tier it came from. A panic in build is returned as an error.
+func (c *Cache) load(key string, version uint64, build func() (interface{}, error)) (data []byte, tier string, err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			data, err = nil, fmt.Errorf("failed to build %s: %v", key, p)
+		}
+	}()
+
+	if data, err := c.readDisk(key, version); err == nil {
+		return data, "disk", nil
+	}
+
+	v, err := build()
+	if err != nil {
+		return nil, "", err
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to marshal %s: %w", key, err)
+	}
+	c.writeDisk(key, version, b)
+	return b, "memory", nil
+}
+
+// store adds e to the memory tier unless it holds a newer version of the key,
+// and evicts the least recently used entries beyond the limits, returning
+// their keys. It must be called with mu held.
+func (c *Cache) store(e *entry) []string {
+	if el, ok := c.entries[e.key]; ok {
+		old := el.Value.(*entry)
+		if old.version > e.version {
+			return nil
+		}
+		c.bytes -= len(old.data)
+		el.Value = e
+		c.lru.MoveToFront(el)
+	} else {
+		c.entries[e.key] = c.lru.PushFront(e)
+	}
+	c.bytes += len(e.data)
+
+	var evicted []string
+	for c.lru.Len() > 1 && ((c.MaxEntries > 0 && c.lru.Len() > c.MaxEntries) || (c.MaxBytes > 0 && c.bytes > c.MaxBytes)) {
+		oldest := c.lru.Remove(c.lru.Back()).(*entry)
+		delete(c.entries, oldest.key)
+		c.bytes -= len(oldest.data)
+		evicted = append(evicted, oldest.key)
+	}
+	return evicted
+}
+
+// GetInto is Get followed by decoding the cached JSON into out.
+func (c *Cache) GetInto(key string, version uint64, out interface{}, build func() (interface{}, error)) error {
+	if b, err := c.Get(key, version, build); err != nil {
+		return err
+	} else {
+		return json.Unmarshal(b, out)
+	}
+}
+
+func (c *Cache) Stats() []EntryStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	stats := make([]EntryStats, 0, len(c.entries))
+	for el := c.lru.Front(); el != nil; el = el.Next() {
+		e := el.Value.(*entry)
+		stats = append(stats, EntryStats{
+			Key:     e.key,
+			Version: e.version,
+			Bytes:   len(e.data),
+			BuiltAt: e.builtAt,
+			Hits:    e.hits,
+			Tier:    e.tier,
+		})
+	}
+	sort.Slice(stats, func(i int, j int) bool {
+		return stats[i].Key < stats[j].Key
+	})
+
+	return stats
+}
+
+// Flush drops every entry from both tiers. Rebuilds already in flight still
+// complete and store their results in both tiers afterwards.
+func (c *Cache) Flush() error {
+	c.mu.Lock()
+	c.entries = make(map[string]*list.Element)
+	c.lru = list.New()
+	c.bytes = 0
+	c.mu.Unlock()
+
+	if c.Dir == "" {
+		return nil
+	}
+
+	if files, err := filepath.Glob(filepath.Join(c.Dir, "*.json")); err != nil {
+		return err
+	} else {
+		for _, f := range files {
+			if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// diskName is the base name of the files of key, a hash of the raw key so
+// that distinct keys never share a file whatever characters they contain.
+func diskName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+func (c *Cache) diskPath(key string, version uint64) string {
+	return filepath.Join(c.Dir, fmt.Sprintf("%s.v%d.json", diskName(key), version))
+}
+
+// diskEntry is the content of a cache file. The raw key is stored along with
+// the view, so a read can tell the file really belongs to the key.
+type diskEntry struct {
+	Key  string          `json:"key"`
+	Data json.RawMessage `json:"data"`
+}
+
+func (c *Cache) readDisk(key string, version uint64) ([]byte, error) {
+	if c.Dir == "" {
+		return nil, os.ErrNotExist
+	}
+
+	var de diskEntry
+	if b, err := os.ReadFile(c.diskPath(key, version)); err != nil {
+		return nil, err
+	} else if err := json.Unmarshal(b, &de); err != nil || len(de.Data) == 0 {
+		return nil, fmt.Errorf("corrupt cache file for %s", key)
+	} else if de.Key != key {
+		return nil, fmt.Errorf("cache file for %s holds %s", key, de.Key)
+	} else {
+		return de.Data, nil
+	}
+}
+
+// writeDisk writes via a temporary file and rename so readers never see a
+// partially written entry, then removes files for older versions of the key.
+func (c *Cache) writeDisk(key string, version uint64, data []byte) {
+	if c.Dir == "" {
+		return
+	}
+
+	b, err := json.Marshal(diskEntry{Key: key, Data: data})
+	if err != nil {
+		fmt.Printf("Failed to encode cache file for %s: %v\n", key, err)
+		return
+	}
+
+	path := c.diskPath(key, version)
+	if f, err := os.CreateTemp(c.Dir, diskName(key)+".*.tmp"); err != nil {
+		fmt.Printf("Failed to create cache file for %s: %v\n", key, err)
+		return
+	} else {
+		_, werr := f.Write(b)
+		cerr := f.Close()
+		if werr != nil || cerr != nil {
+			fmt.Printf("Failed to write cache file for %s: %v %v\n", key, werr, cerr)
+			_ = os.Remove(f.Name())
+			return
+		}
+		if err := os.Rename(f.Name(), path); err != nil {
+			fmt.Printf("Failed to move cache file into place for %s: %v\n", key, err)
+			_ = os.Remove(f.Name())
+			return
+		}
+	}
+
+	if old, err := filepath.Glob(filepath.Join(c.Dir, diskName(key)+".v*.json")); err == nil {
+		for _, f := range old {
+			if f != path {
+				_ = os.Remove(f)
+			}
+		}
+	}
+}
+
+// removeDisk removes every version of key from the disk tier.
+func (c *Cache) removeDisk(key string) {
+	if c.Dir == "" {
+		return
+	}
+
+	if files, err := filepath.Glob(filepath.Join(c.Dir, diskName(key)+".v*.json")); err == nil {
+		for _, f := range files {
+			_ = os.Remove(f)
+		}
+	}
+}
@@ -0,0 +1,226 @@
+package cache
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// counter returns a build function that counts its calls and builds value.
+func counter(builds *int32, value interface{}) func() (interface{}, error) {
+	return func() (interface{}, error) {
+		atomic.AddInt32(builds, 1)
+		return value, nil
+	}
+}
+
+func TestGetVersions(t *testing.T) {
+	c := New("")
+	var builds int32
+
+	for i := 0; i < 3; i++ {
+		if b, err := c.Get("view", 1, counter(&builds, "v1")); err != nil || string(b) != `"v1"` {
+			t.Fatalf("got %s, %v", b, err)
+		}
+	}
+	if builds != 1 {
+		t.Errorf("got %d builds for one version, want 1", builds)
+	}
+
+	if b, err := c.Get("view", 2, counter(&builds, "v2")); err != nil || string(b) != `"v2"` {
+		t.Fatalf("got %s, %v after a version bump", b, err)
+	}
+	if builds != 2 {
+		t.Errorf("got %d builds after a version bump, want 2", builds)
+	}
+
+	// a rebuild of an older version is served but doesn't replace the newer
+	if b, _ := c.Get("view", 1, counter(&builds, "old")); string(b) != `"old"` {
+		t.Errorf("got %s for the older version", b)
+	}
+	if b, _ := c.Get("view", 2, counter(&builds, "v2 again")); string(b) != `"v2"` {
+		t.Errorf("got %s, the older version replaced the newer one", b)
+	}
+
+	stats := c.Stats()
+	if len(stats) != 1 || stats[0].Version != 2 || stats[0].Hits != 1 {
+		t.Errorf("got stats %+v", stats)
+	}
+}
+
+func TestGetError(t *testing.T) {
+	c := New("")
+	failure := errors.New("build failed")
+	if _, err := c.Get("view", 1, func() (interface{}, error) { return nil, failure }); !errors.Is(err, failure) {
+		t.Fatalf("got error %v, want %v", err, failure)
+	}
+	if len(c.Stats()) != 0 {
+		t.Error("a failed build was cached")
+	}
+
+	var builds int32
+	if _, err := c.Get("view", 1, counter(&builds, 1)); err != nil || builds != 1 {
+		t.Errorf("got %v and %d builds after a failed build", err, builds)
+	}
+}
+
+func TestGetPanic(t *testing.T) {
+	c := New("")
+	if _, err := c.Get("view", 1, func() (interface{}, error) { panic("boom") }); err == nil || !strings.Contains(err.Error(), "boom") {
+		t.Fatalf("got error %v for a panicking build", err)
+	}
+
+	// the next request builds again instead of waiting on the failed one
+	done := make(chan error)
+	var builds int32
+	go func() {
+		_, err := c.Get("view", 1, counter(&builds, 1))
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if err != nil || builds != 1 {
+			t.Errorf("got %v and %d builds after a panicking build", err, builds)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("the request after a panicking build never returned")
+	}
+}
+
+func TestGetSingleFlight(t *testing.T) {
+	c := New("")
+	var builds int32
+	release := make(chan struct{})
+	build := func() (interface{}, error) {
+		atomic.AddInt32(&builds, 1)
+		<-release
+		return "slow", nil
+	}
+
+	const callers = 10
+	var wg sync.WaitGroup
+	results := make([]string, callers)
+	for i := 0; i < callers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			b, _ := c.Get("view", 1, build)
+			results[i] = string(b)
+		}(i)
+	}
+
+	// wait for the first build to start before letting it finish
+	for atomic.LoadInt32(&builds) == 0 {
+		time.Sleep(time.Millisecond)
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if builds != 1 {
+		t.Errorf("got %d builds for concurrent requests, want 1", builds)
+	}
+	for i, r := range results {
+		if r != `"slow"` {
+			t.Errorf("caller %d got %s", i, r)
+		}
+	}
+}
+
+func TestDiskTier(t *testing.T) {
+	dir := t.TempDir()
+	var builds int32
+
+	// keys that would share a file if they were only sanitized
+	keys := []string{"heatmap:a/b", "heatmap:a_b", "heatmap:a?b"}
+	c := New(dir)
+	for _, k := range keys {
+		if _, err := c.Get(k, 1, counter(&builds, k)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	reopened := New(dir)
+	for _, k := range keys {
+		if b, err := reopened.Get(k, 1, counter(&builds, "rebuilt")); err != nil || string(b) != fmt.Sprintf("%q", k) {
+			t.Errorf("got %s, %v for %s from disk", b, err, k)
+		}
+	}
+	if builds != int32(len(keys)) {
+		t.Errorf("got %d builds, want %d", builds, len(keys))
+	}
+	if stats := reopened.Stats(); stats[0].Tier != "disk" {
+		t.Errorf("got tier %s, want disk", stats[0].Tier)
+	}
+
+	// a newer version replaces the file of the older one
+	if _, err := reopened.Get(keys[0], 2, counter(&builds, "v2")); err != nil {
+		t.Fatal(err)
+	}
+	files, _ := filepath.Glob(filepath.Join(dir, diskName(keys[0])+".*"))
+	if len(files) != 1 || filepath.Base(files[0]) != diskName(keys[0])+".v2.json" {
+		t.Errorf("got files %v", files)
+	}
+
+	// a file holding another key is not served
+	if err := os.Rename(c.diskPath(keys[1], 1), c.diskPath("other", 1)); err != nil {
+		t.Fatal(err)
+	}
+	if b, _ := New(dir).Get("other", 1, counter(&builds, "other")); string(b) != `"other"` {
+		t.Errorf("got %s from a file of another key", b)
+	}
+
+	if err := reopened.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.json")); len(files) != 0 || len(reopened.Stats()) != 0 {
+		t.Errorf("got %d files and %d entries after a flush", len(files), len(reopened.Stats()))
+	}
+}
+
+func TestEviction(t *testing.T) {
+	dir := t.TempDir()
+	c := New(dir)
+	c.MaxEntries = 2
+	var builds int32
+
+	for _, k := range []string{"a", "b"} {
+		_, _ = c.Get(k, 1, counter(&builds, k))
+	}
+	// using a makes b the least recently used
+	_, _ = c.Get("a", 1, counter(&builds, "a"))
+	_, _ = c.Get("c", 1, counter(&builds, "c"))
+
+	stats := c.Stats()
+	if len(stats) != 2 || stats[0].Key != "a" || stats[1].Key != "c" {
+		t.Fatalf("got entries %+v, want a and c", stats)
+	}
+	if _, err := os.Stat(c.diskPath("b", 1)); !os.IsNotExist(err) {
+		t.Errorf("the evicted entry is still on disk: %v", err)
+	}
+
+	bytes := New("")
+	bytes.MaxBytes = 10
+	_, _ = bytes.Get("small", 1, counter(&builds, "1234"))
+	_, _ = bytes.Get("large", 1, counter(&builds, "12345678"))
+	if stats := bytes.Stats(); len(stats) != 1 || stats[0].Key != "large" {
+		t.Errorf("got entries %+v over the byte limit, want large", stats)
+	}
+}
+
+func TestGetInto(t *testing.T) {
+	c := New("")
+	var out struct{ Name string }
+	err := c.GetInto("view", 1, &out, func() (interface{}, error) {
+		return struct{ Name string }{Name: "x"}, nil
+	})
+	if err != nil || out.Name != "x" {
+		t.Errorf("got %+v, %v", out, err)
+	}
+}
@@ -0,0 +1,40 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+)
+
+func aliasCommand() *Command {
+	return &Command{
+		Name:    "alias",
+		Args:    "<test case id> <previous package> <previous name>",
+		Summary: "Record that a test was renamed or moved, merging the results of its previous name into it",
+		Setup: func(fs *flag.FlagSet) func(ctx *Context, args []string) error {
+			return func(ctx *Context, args []string) error {
+				if len(args) != 3 {
+					return usageErrorf("expected a test case id, see query cases, and the previous package and name of the test")
+				}
+				id, err := strconv.ParseUint(args[0], 10, 64)
+				if err != nil {
+					return usageErrorf("invalid test case id %q", args[0])
+				}
+
+				db, err := ctx.DB()
+				if err != nil {
+					return err
+				}
+
+				if err := db.AddTestCaseAlias(uint(id), args[1], args[2]); err != nil {
+					return err
+				}
+				testCase := db.GetTestCase(uint(id))
+				return ctx.Output(testCase, func(w io.Writer) {
+					fmt.Fprintf(w, "%s.%s is now %s\n", args[1], args[2], testCase.FullName())
+				})
+			}
+		},
+	}
+}
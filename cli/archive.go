@@ -0,0 +1,168 @@
+package cli
+
+import (
+	"compress/gzip"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"test-analyzer/ingestion"
+	"time"
+)
+
+func exportCommand() *Command {
+	return &Command{
+		Name:    "export",
+		Args:    "<file>",
+		Summary: "Export the database to a portable NDJSON archive, gzip compressed if the file ends with .gz, - for stdout",
+		Setup: func(fs *flag.FlagSet) func(ctx *Context, args []string) error {
+			project := fs.String("project", "", "only export this project")
+			since := fs.String("since", "", "only export runs ingested on or after this date (YYYY-MM-DD)")
+			until := fs.String("until", "", "only export runs ingested before this date (YYYY-MM-DD)")
+			logs := fs.Bool("logs", false, "include test log output")
+
+			return func(ctx *Context, args []string) error {
+				if len(args) != 1 {
+					return usageErrorf("expected the archive file to write")
+				}
+				out := args[0]
+
+				opts := ingestion.ExportOptions{
+					Project:     *project,
+					IncludeLogs: *logs,
+				}
+				var err error
+				if opts.Since, err = parseDate(*since); err != nil {
+					return usageErrorf("invalid -since: %v", err)
+				}
+				if opts.Until, err = parseDate(*until); err != nil {
+					return usageErrorf("invalid -until: %v", err)
+				}
+				if out == "-" && ctx.JSON {
+					return usageErrorf("cannot write the archive and --json output to stdout")
+				}
+
+				db, err := ctx.DB()
+				if err != nil {
+					return err
+				}
+
+				var w io.Writer = ctx.Stdout
+				var f *os.File
+				if out != "-" {
+					if f, err = os.Create(out); err != nil {
+						return err
+					}
+					w = f
+				}
+				var gz *gzip.Writer
+				if strings.HasSuffix(out, ".gz") {
+					gz = gzip.NewWriter(w)
+					w = gz
+				}
+
+				// Closing flushes the end of the archive, so its errors count
+				stats, err := db.Export(w, opts)
+				if gz != nil {
+					if closeErr := gz.Close(); err == nil {
+						err = closeErr
+					}
+				}
+				if f != nil {
+					if closeErr := f.Close(); err == nil {
+						err = closeErr
+					}
+				}
+				if err != nil {
+					return err
+				}
+
+				return ctx.Output(stats.Counts, func(_ io.Writer) {
+					printCounts(ctx.Stderr, "Exported", stats.Counts, nil)
+				})
+			}
+		},
+	}
+}
+
+func importCommand() *Command {
+	return &Command{
+		Name:    "import",
+		Args:    "<file>",
+		Summary: "Import an archive written by export, gzip compressed if the file ends with .gz, - for stdin",
+		Setup: func(fs *flag.FlagSet) func(ctx *Context, args []string) error {
+			return func(ctx *Context, args []string) error {
+				if len(args) != 1 {
+					return usageErrorf("expected the archive file to read")
+				}
+				in := args[0]
+
+				db, err := ctx.DB()
+				if err != nil {
+					return err
+				}
+
+				var r io.Reader = os.Stdin
+				if in != "-" {
+					f, err := os.Open(in)
+					if err != nil {
+						return err
+					}
+					defer f.Close()
+					r = f
+				}
+				var gz *gzip.Reader
+				if strings.HasSuffix(in, ".gz") {
+					if gz, err = gzip.NewReader(r); err != nil {
+						return fmt.Errorf("unable to decompress %s: %w", in, err)
+					}
+					r = gz
+				}
+
+				stats, err := db.Import(r)
+				if gz != nil {
+					if closeErr := gz.Close(); err == nil {
+						err = closeErr
+					}
+				}
+				if err != nil {
+					return err
+				}
+
+				return ctx.Output(stats, func(w io.Writer) {
+					printCounts(w, "Imported", stats.Counts, stats.Skipped)
+				})
+			}
+		},
+	}
+}
+
+func parseDate(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse("2006-01-02", s)
+}
+
+func printCounts(w io.Writer, verb string, counts map[string]int64, skipped map[string]int64) {
+	tables := make([]string, 0, len(counts))
+	for table := range counts {
+		tables = append(tables, table)
+	}
+	for table := range skipped {
+		if _, ok := counts[table]; !ok {
+			tables = append(tables, table)
+		}
+	}
+	sort.Strings(tables)
+
+	for _, table := range tables {
+		if skipped != nil {
+			fmt.Fprintf(w, "%s %d %s (%d already present)\n", verb, counts[table], table, skipped[table])
+		} else {
+			fmt.Fprintf(w, "%s %d %s\n", verb, counts[table], table)
+		}
+	}
+}
@@ -0,0 +1,57 @@
+package cli
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"strconv"
+	"test-analyzer/analysis"
+)
+
+func blameCommand() *Command {
+	return &Command{
+		Name:    "blame",
+		Args:    "<test case id>",
+		Summary: "List the commits between the last passing and first failing run of a failing test",
+		Setup: func(fs *flag.FlagSet) func(ctx *Context, args []string) error {
+			branch := fs.String("branch", "", "branch to look at (default $"+envMainBranch+" or master)")
+
+			return func(ctx *Context, args []string) error {
+				if len(args) != 1 {
+					return usageErrorf("expected a test case id, see query cases")
+				}
+				id, err := strconv.ParseUint(args[0], 10, 64)
+				if err != nil {
+					return usageErrorf("invalid test case id %q", args[0])
+				}
+				if *branch == "" {
+					*branch = ctx.Config.MainBranch
+				}
+
+				db, err := ctx.DB()
+				if err != nil {
+					return err
+				}
+
+				blame, err := analysis.BlameTest(context.Background(), db.GetTestCaseOutcomes(uint(id)), *branch, ctx.Config.CommitComparer())
+				if err != nil {
+					return err
+				}
+
+				return ctx.Output(blame, func(w io.Writer) {
+					fmt.Fprintf(w, "%s (%s) has failed %d times in a row on %s\n", blame.TestLabel, blame.Package, blame.FailStreak, blame.Branch)
+					if blame.LastGoodSHA == "" {
+						fmt.Fprintf(w, "No passing run before the first failure at %s\n", blame.FirstBadSHA)
+						return
+					}
+					fmt.Fprintf(w, "Last good %s (run %d), first bad %s (run %d), %d commits in between:\n\n",
+						blame.LastGoodSHA, blame.LastGoodRunID, blame.FirstBadSHA, blame.FirstBadRunID, len(blame.Commits))
+					for _, c := range blame.Commits {
+						fmt.Fprintf(w, "%.10s  %-20s  %s\n", c.SHA, c.Author, c.Message)
+					}
+				})
+			}
+		},
+	}
+}
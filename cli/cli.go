@@ -0,0 +1,242 @@
+package cli
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"sort"
+	"test-analyzer/ingestion"
+	"test-analyzer/tables"
+)
+
+// Exit codes returned by Run.
+const (
+	ExitOK    = 0
+	ExitError = 1
+	ExitUsage = 2
+)
+
+// Command is a single test-analyzer subcommand. Setup registers the
+// command's flags and returns the function that runs it once they are parsed.
+type Command struct {
+	Name    string
+	Args    string
+	Summary string
+	Setup   func(fs *flag.FlagSet) func(ctx *Context, args []string) error
+}
+
+// Context is handed to a running command.
+type Context struct {
+	Config *Config
+	// JSON is set by --json; commands then write a single JSON document to
+	// Stdout and all progress output goes to stderr
+	JSON bool
+	// Format is the table format given by --format, csv or xlsx, or empty for
+	// text or JSON output
+	Format string
+	Stdout io.Writer
+	Stderr io.Writer
+	// Assets holds the web UI's templates and static directories
+	Assets fs.FS
+
+	db *ingestion.RecordDB
+}
+
+func (ctx *Context) DB() (*ingestion.RecordDB, error) {
+	if ctx.db == nil {
+		if ctx.db = ingestion.OpenRecordDBWithProgress(ctx.Config.DBPath, ctx.Progress()); ctx.db == nil {
+			return nil, fmt.Errorf("unable to open database %s", ctx.Config.DBPath)
+		}
+	}
+	return ctx.db, nil
+}
+
+// Progress is where ingestion and analysis report their progress, stderr
+// when stdout is kept for a JSON document or table.
+func (ctx *Context) Progress() io.Writer {
+	if ctx.JSON || ctx.Format != "" {
+		return ctx.Stderr
+	}
+	return ctx.Stdout
+}
+
+// Output writes v as JSON when --json was given, otherwise calls text.
+func (ctx *Context) Output(v interface{}, text func(w io.Writer)) error {
+	if ctx.Format != "" {
+		return usageErrorf("this command has no %s output", ctx.Format)
+	}
+	if ctx.JSON {
+		enc := json.NewEncoder(ctx.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+
+	text(ctx.Stdout)
+	return nil
+}
+
+// OutputTable is Output for commands listing a table, which can also be
+// written as CSV or XLSX with --format.
+func (ctx *Context) OutputTable(v interface{}, table func() tables.Table, text func(w io.Writer)) error {
+	if ctx.Format != "" {
+		return table().Write(ctx.Stdout, ctx.Format)
+	}
+	return ctx.Output(v, text)
+}
+
+type usageError struct {
+	msg string
+}
+
+func (e usageError) Error() string {
+	return e.msg
+}
+
+func usageErrorf(format string, args ...interface{}) error {
+	return usageError{msg: fmt.Sprintf(format, args...)}
+}
+
+func commands() []*Command {
+	cmds := []*Command{
+		fetchCommand(),
+		extractCommand(),
+		analyzeCommand(),
+		rebuildMetricsCommand(),
+		serveCommand(),
+		exportCommand(),
+		importCommand(),
+		queryCommand(),
+		aliasCommand(),
+		reportCommand(),
+		flakyCommand(),
+		slowestCommand(),
+		regressionsCommand(),
+		blameCommand(),
+		failuresCommand(),
+		rebuildFailuresCommand(),
+		correlationsCommand(),
+		healthCommand(),
+		quarantineCommand(),
+		compareCommand(),
+	}
+	return cmds
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintf(w, "Usage: test-analyzer <command> [flags]\n\nCommands:\n")
+	cmds := commands()
+	sort.Slice(cmds, func(i int, j int) bool {
+		return cmds[i].Name < cmds[j].Name
+	})
+	for _, c := range cmds {
+		fmt.Fprintf(w, "  %-16s %s\n", c.Name, c.Summary)
+	}
+	fmt.Fprintf(w, "\nRun 'test-analyzer <command> -h' for the flags of a command.\n")
+}
+
+// Run executes the subcommand named by args[0] and returns the process exit
+// code. assets holds the web UI's templates and static directories.
+func Run(args []string, assets fs.FS) int {
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		usage(os.Stderr)
+		if len(args) == 0 {
+			return ExitUsage
+		}
+		return ExitOK
+	}
+
+	var cmd *Command
+	for _, c := range commands() {
+		if c.Name == args[0] {
+			cmd = c
+		}
+	}
+	if cmd == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", args[0])
+		usage(os.Stderr)
+		return ExitUsage
+	}
+
+	fs := flag.NewFlagSet(cmd.Name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: test-analyzer %s [flags] %s\n\n%s\n\nFlags:\n", cmd.Name, cmd.Args, cmd.Summary)
+		fs.PrintDefaults()
+	}
+
+	var flags configFlags
+	var jsonOutput bool
+	var format string
+	fs.StringVar(&flags.configFile, "config", "", "config file in KEY=value form (default $"+envConfigFile+" or .env)")
+	fs.StringVar(&flags.dbPath, "db", "", "database file (default $"+envDBPath+" or gorm.db)")
+	fs.StringVar(&flags.cacheDir, "cache-dir", "", "artifact and view cache directory (default $"+envCacheDir+" or ~/.cache/dapr-test-analyzer)")
+	fs.StringVar(&flags.repository, "repo", "", "GitHub repository as owner/repo (default $"+envRepository+" or dapr/dapr)")
+	fs.BoolVar(&jsonOutput, "json", false, "write machine readable JSON to stdout")
+	run := cmd.Setup(fs)
+	// Commands with a format flag of their own, such as quarantine export,
+	// don't write tables
+	if fs.Lookup("format") == nil {
+		fs.StringVar(&format, "format", "", "write tables to stdout as text, json, csv or xlsx (default text)")
+	}
+
+	// Allow flags after positional arguments, e.g. query runs --json
+	positional := make([]string, 0)
+	rest := args[1:]
+	for {
+		if err := fs.Parse(rest); err != nil {
+			if errors.Is(err, flag.ErrHelp) {
+				return ExitOK
+			}
+			return ExitUsage
+		}
+		if rest = fs.Args(); len(rest) == 0 {
+			break
+		}
+		positional = append(positional, rest[0])
+		rest = rest[1:]
+	}
+
+	switch format {
+	case "", "text":
+		format = ""
+	case "json":
+		jsonOutput = true
+		format = ""
+	default:
+		if !tables.IsFormat(format) {
+			fmt.Fprintf(os.Stderr, "Error: unknown format %q, expected text, json, %s or %s\n", format, tables.FormatCSV, tables.FormatXLSX)
+			return ExitUsage
+		}
+	}
+
+	cfg, err := loadConfig(flags)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return ExitUsage
+	}
+
+	ctx := &Context{
+		Config: cfg,
+		JSON:   jsonOutput,
+		Format: format,
+		Stdout: os.Stdout,
+		Stderr: os.Stderr,
+		Assets: assets,
+	}
+
+	if err := run(ctx, positional); err != nil {
+		var ue usageError
+		if errors.As(err, &ue) {
+			fmt.Fprintf(os.Stderr, "Error: %v\n\n", err)
+			fs.Usage()
+			return ExitUsage
+		}
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		return ExitError
+	}
+
+	return ExitOK
+}
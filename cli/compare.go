@@ -0,0 +1,70 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"test-analyzer/analysis"
+	"text/tabwriter"
+)
+
+func compareCommand() *Command {
+	return &Command{
+		Name:    "compare",
+		Summary: "Compare test pass rates between two branches or events",
+		Setup: func(fs *flag.FlagSet) func(ctx *Context, args []string) error {
+			defaults := analysis.DefaultCompareOptions()
+			var a, b analysis.Slice
+			fs.StringVar(&a.Branch, "a-branch", "", "branch (or pattern such as release-*) of slice A (default $"+envMainBranch+" or master)")
+			fs.StringVar(&a.Event, "a-event", "", "event of slice A, e.g. push, pull_request or schedule")
+			fs.StringVar(&b.Branch, "b-branch", "", "branch (or pattern) of slice B")
+			fs.StringVar(&b.Event, "b-event", "", "event of slice B")
+			window := fs.Int("window", defaults.Window, "number of most recent results per test and slice to compare, 0 for all")
+			minRuns := fs.Int("min-runs", defaults.MinRuns, "minimum number of results a test needs in both slices")
+			alpha := fs.Float64("alpha", defaults.Alpha, "significance level")
+			all := fs.Bool("all", false, "list every compared test, not just the significant ones")
+
+			return func(ctx *Context, args []string) error {
+				if a == (analysis.Slice{}) {
+					a.Branch = ctx.Config.MainBranch
+				}
+				if a == b {
+					return usageErrorf("slices A and B are the same, set --b-branch or --b-event")
+				}
+
+				db, err := ctx.DB()
+				if err != nil {
+					return err
+				}
+
+				comparison := analysis.CompareSlices(db.GetTestOutcomes(), a, b, analysis.CompareOptions{
+					Window:  *window,
+					MinRuns: *minRuns,
+					Alpha:   *alpha,
+				})
+
+				return ctx.Output(comparison, func(w io.Writer) {
+					for _, s := range []struct {
+						name    string
+						summary analysis.SliceSummary
+					}{{"A", comparison.A}, {"B", comparison.B}} {
+						fmt.Fprintf(w, "%s, %s: %d runs, %.1f%% fully green, %.1f%% of %d results passed\n", s.name, s.summary.Slice,
+							s.summary.Runs, s.summary.GreenRate*100, s.summary.PassRate*100, s.summary.Results)
+					}
+					fmt.Fprintln(w)
+
+					tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+					fmt.Fprintln(tw, "TEST\tPACKAGE\tA\tB\tDELTA\tP-VALUE")
+					for _, t := range comparison.Tests {
+						if !*all && !t.Significant {
+							break
+						}
+						fmt.Fprintf(tw, "%s\t%s\t%.1f%% (%d)\t%.1f%% (%d)\t%+.1f\t%.2g\n", t.TestLabel, t.Package,
+							t.A.Rate*100, t.A.Samples, t.B.Rate*100, t.B.Samples, t.Delta*100, t.PValue)
+					}
+					_ = tw.Flush()
+				})
+			}
+		},
+	}
+}
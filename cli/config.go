@@ -0,0 +1,133 @@
+package cli
+
+import (
+	"fmt"
+	"github.com/joho/godotenv"
+	"os"
+	"path/filepath"
+	"strings"
+	"test-analyzer/analysis"
+	"test-analyzer/ingestion"
+)
+
+// Config is shared by every subcommand. Each setting is resolved from, in
+// order of precedence, a command line flag, an environment variable, the
+// config file and finally a default. The config file uses the same
+// KEY=value syntax and key names as the environment, so an existing .env
+// file works unchanged.
+type Config struct {
+	DBPath      string
+	CacheDir    string
+	GitHubToken string
+	// Repository is the GitHub owner/repo whose runs are analyzed, it doubles
+	// as the label of the project the runs are stored under
+	Repository string
+	// MainBranch is the branch regressions are blamed on
+	MainBranch string
+	Listen     string
+	// CategoryRules is the JSON file of failure category rules, the built in
+	// rules are used when empty
+	CategoryRules string
+}
+
+const (
+	envConfigFile  = "TEST_ANALYZER_CONFIG"
+	envDBPath      = "DB_PATH"
+	envCacheDir    = "CACHE_DIR"
+	envGitHubToken = "GITHUB_TOKEN"
+	envRepository  = "GITHUB_REPOSITORY"
+	envMainBranch  = "MAIN_BRANCH"
+	envListen      = "LISTEN_ADDR"
+	envCategories  = "CATEGORY_RULES"
+)
+
+// configFlags are the flags every subcommand accepts to override Config.
+type configFlags struct {
+	configFile string
+	dbPath     string
+	cacheDir   string
+	repository string
+}
+
+func loadConfig(flags configFlags) (*Config, error) {
+	fileValues := map[string]string{}
+
+	path := flags.configFile
+	if path == "" {
+		path = os.Getenv(envConfigFile)
+	}
+	if path != "" {
+		if values, err := godotenv.Read(path); err != nil {
+			return nil, fmt.Errorf("unable to read config file %s: %w", path, err)
+		} else {
+			fileValues = values
+		}
+	} else if values, err := godotenv.Read(".env"); err == nil {
+		fileValues = values
+	}
+
+	lookup := func(flagValue string, key string, def string) string {
+		if flagValue != "" {
+			return flagValue
+		}
+		if v := os.Getenv(key); v != "" {
+			return v
+		}
+		if v := fileValues[key]; v != "" {
+			return v
+		}
+		return def
+	}
+
+	cfg := &Config{
+		DBPath:      lookup(flags.dbPath, envDBPath, "gorm.db"),
+		CacheDir:    lookup(flags.cacheDir, envCacheDir, ""),
+		GitHubToken: lookup("", envGitHubToken, ""),
+		Repository:  lookup(flags.repository, envRepository, "dapr/dapr"),
+		MainBranch:  lookup("", envMainBranch, "master"),
+		Listen:      lookup("", envListen, ":5000"),
+
+		CategoryRules: lookup("", envCategories, ""),
+	}
+
+	if cfg.CacheDir == "" {
+		if dir, err := ingestion.CacheDir(); err != nil {
+			return nil, err
+		} else {
+			cfg.CacheDir = dir
+		}
+	}
+
+	if _, _, err := cfg.OwnerRepo(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+func (c *Config) OwnerRepo() (string, string, error) {
+	parts := strings.Split(c.Repository, "/")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return "", "", fmt.Errorf("repository must be in owner/repo form, got %q", c.Repository)
+	}
+	return parts[0], parts[1], nil
+}
+
+// ViewCacheDir is where the web server keeps its on-disk view cache.
+func (c *Config) ViewCacheDir() string {
+	return filepath.Join(c.CacheDir, "views")
+}
+
+func (c *Config) CommitComparer() analysis.CommitComparer {
+	owner, repo, _ := c.OwnerRepo()
+	return analysis.GitHubCommitComparer{
+		Client: ingestion.NewGitHubClient(c.GitHubToken),
+		Owner:  owner,
+		Repo:   repo,
+	}
+}
+
+// Classifier categorizes the failures of the configured repository.
+func (c *Config) Classifier() (*analysis.Classifier, error) {
+	return analysis.LoadClassifier(c.CategoryRules, c.Repository)
+}
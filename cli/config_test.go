@@ -0,0 +1,102 @@
+package cli
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// inDir runs the test from dir, where loadConfig looks for .env.
+func inDir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+}
+
+func writeConfig(t *testing.T, path string, lines ...string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	dir := t.TempDir()
+	inDir(t, dir)
+	for _, key := range []string{envConfigFile, envDBPath, envCacheDir, envGitHubToken, envRepository, envMainBranch, envListen, envCategories} {
+		t.Setenv(key, "")
+	}
+	writeConfig(t, "config.env", "DB_PATH=file.db", "CACHE_DIR=/file/cache", "GITHUB_REPOSITORY=file/repo", "MAIN_BRANCH=file-main")
+	writeConfig(t, ".env", "DB_PATH=dotenv.db", "MAIN_BRANCH=dotenv-main", "LISTEN_ADDR=:6000")
+
+	tests := []struct {
+		name  string
+		flags configFlags
+		env   map[string]string
+		want  Config
+	}{
+		{
+			name: ".env",
+			want: Config{DBPath: "dotenv.db", Repository: "dapr/dapr", MainBranch: "dotenv-main", Listen: ":6000"},
+		},
+		{
+			name:  "config flag over .env",
+			flags: configFlags{configFile: "config.env"},
+			want:  Config{DBPath: "file.db", CacheDir: "/file/cache", Repository: "file/repo", MainBranch: "file-main", Listen: ":5000"},
+		},
+		{
+			name: "config environment over .env",
+			env:  map[string]string{envConfigFile: "config.env"},
+			want: Config{DBPath: "file.db", CacheDir: "/file/cache", Repository: "file/repo", MainBranch: "file-main", Listen: ":5000"},
+		},
+		{
+			name: "environment over config file",
+			env:  map[string]string{envConfigFile: "config.env", envDBPath: "env.db", envRepository: "env/repo", envGitHubToken: "token"},
+			want: Config{DBPath: "env.db", CacheDir: "/file/cache", GitHubToken: "token", Repository: "env/repo", MainBranch: "file-main", Listen: ":5000"},
+		},
+		{
+			name:  "flags over environment",
+			flags: configFlags{configFile: "config.env", dbPath: "flag.db", cacheDir: "/flag/cache", repository: "flag/repo"},
+			env:   map[string]string{envDBPath: "env.db", envCacheDir: "/env/cache", envRepository: "env/repo"},
+			want:  Config{DBPath: "flag.db", CacheDir: "/flag/cache", Repository: "flag/repo", MainBranch: "file-main", Listen: ":5000"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for key, value := range tt.env {
+				t.Setenv(key, value)
+			}
+			cfg, err := loadConfig(tt.flags)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.want.CacheDir == "" {
+				// the default depends on the user running the test
+				tt.want.CacheDir = cfg.CacheDir
+			}
+			if *cfg != tt.want {
+				t.Errorf("got %+v, want %+v", *cfg, tt.want)
+			}
+		})
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	inDir(t, t.TempDir())
+	t.Setenv(envConfigFile, "")
+	t.Setenv(envRepository, "")
+
+	if _, err := loadConfig(configFlags{configFile: filepath.Join(t.TempDir(), "missing.env")}); err == nil || !strings.Contains(err.Error(), "unable to read config file") {
+		t.Errorf("got error %v for a missing config file", err)
+	}
+	if _, err := loadConfig(configFlags{repository: "dapr"}); err == nil || !strings.Contains(err.Error(), "owner/repo") {
+		t.Errorf("got error %v for a repository without an owner", err)
+	}
+}
@@ -0,0 +1,69 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"test-analyzer/analysis"
+	"text/tabwriter"
+)
+
+func correlationsCommand() *Command {
+	return &Command{
+		Name:    "correlations",
+		Summary: "List tests that tend to fail in the same runs",
+		Setup: func(fs *flag.FlagSet) func(ctx *Context, args []string) error {
+			defaults := analysis.DefaultCorrelationOptions()
+			branch := fs.String("branch", "", "only look at runs of this branch")
+			window := fs.Int("window", defaults.Window, "number of most recent runs to look at, 0 for all")
+			maxRunFailures := fs.Int("max-run-failures", defaults.MaxRunFailures, "skip runs with more failed tests than this, 0 for no limit")
+			minCoFailures := fs.Int("min-co-failures", defaults.MinCoFailures, "minimum number of runs two tests failed together in")
+			minJaccard := fs.Float64("min-jaccard", defaults.MinJaccard, "minimum Jaccard similarity of a correlated pair")
+			minLift := fs.Float64("min-lift", defaults.MinLift, "minimum lift of a correlated pair")
+			limit := fs.Int("limit", 30, "maximum number of pairs to list, 0 for all")
+
+			return func(ctx *Context, args []string) error {
+				db, err := ctx.DB()
+				if err != nil {
+					return err
+				}
+
+				report := analysis.ComputeCorrelations(db.GetTestOutcomes(), analysis.CorrelationOptions{
+					Branch:         *branch,
+					Window:         *window,
+					MaxRunFailures: *maxRunFailures,
+					MinCoFailures:  *minCoFailures,
+					MinJaccard:     *minJaccard,
+					MinLift:        *minLift,
+				})
+
+				labels := make(map[string]string)
+				for _, t := range report.Tests {
+					labels[t.Key] = t.TestLabel
+				}
+				pairs := report.Pairs
+				if *limit > 0 && len(pairs) > *limit {
+					pairs = pairs[:*limit]
+				}
+
+				return ctx.Output(report, func(w io.Writer) {
+					fmt.Fprintf(w, "%d runs analyzed, %d skipped with more than %d failures\n\n", report.Runs, report.SkippedRuns, *maxRunFailures)
+
+					tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+					fmt.Fprintln(tw, "JACCARD\tLIFT\tTOGETHER\tTEST\tTEST")
+					for _, p := range pairs {
+						fmt.Fprintf(tw, "%.2f\t%.1f\t%d\t%s\t%s\n", p.Jaccard, p.Lift, p.CoFailures, labels[p.Source], labels[p.Target])
+					}
+					_ = tw.Flush()
+
+					for i, g := range report.Groups {
+						fmt.Fprintf(w, "\nGroup %d: %d tests, %d pairs, mean Jaccard %.2f\n", i+1, len(g.Tests), g.Pairs, g.MeanJaccard)
+						for _, t := range g.Tests {
+							fmt.Fprintf(w, "  %s (%s), %d failed runs\n", t.TestLabel, t.Package, t.Failures)
+						}
+					}
+				})
+			}
+		},
+	}
+}
@@ -0,0 +1,76 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"test-analyzer/analysis"
+	"text/tabwriter"
+	"time"
+)
+
+func failuresCommand() *Command {
+	return &Command{
+		Name:    "failures",
+		Summary: "List clusters of similar test failures, largest first",
+		Setup: func(fs *flag.FlagSet) func(ctx *Context, args []string) error {
+			limit := fs.Int("limit", 20, "maximum number of clusters to list, 0 for all")
+			similarity := fs.Float64("similarity", analysis.DefaultClusterSimilarity, "token similarity above which signatures are merged")
+
+			return func(ctx *Context, args []string) error {
+				if *similarity <= 0 || *similarity > 1 {
+					return usageErrorf("similarity must be in (0, 1], got %v", *similarity)
+				}
+
+				db, err := ctx.DB()
+				if err != nil {
+					return err
+				}
+
+				clusters := analysis.ClusterFailures(db.GetTestOutcomes(), *similarity)
+				if *limit > 0 && len(clusters) > *limit {
+					clusters = clusters[:*limit]
+				}
+
+				return ctx.Output(clusters, func(w io.Writer) {
+					tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+					fmt.Fprintf(tw, "FAILURES\tTESTS\tRUNS\tLAST SEEN\tSIGNATURE\n")
+					for _, c := range clusters {
+						fmt.Fprintf(tw, "%d\t%d\t%d\t%s\t%.100s\n", c.Failures, len(c.Tests), len(c.Runs),
+							time.UnixMilli(c.LastSeen).UTC().Format("2006-01-02 15:04"), c.Signature)
+					}
+					tw.Flush()
+				})
+			}
+		},
+	}
+}
+
+func rebuildFailuresCommand() *Command {
+	return &Command{
+		Name:    "rebuild-failures",
+		Summary: "Re-extract the failure message, signature and category of every failed test",
+		Setup: func(fs *flag.FlagSet) func(ctx *Context, args []string) error {
+			return func(ctx *Context, args []string) error {
+				classifier, err := ctx.Config.Classifier()
+				if err != nil {
+					return err
+				}
+
+				db, err := ctx.DB()
+				if err != nil {
+					return err
+				}
+
+				count, err := analysis.RebuildFailures(db, classifier)
+				if err != nil {
+					return err
+				}
+
+				return ctx.Output(map[string]int{"reports": count}, func(w io.Writer) {
+					fmt.Fprintf(w, "Rebuilt failure signatures and categories for %d reports\n", count)
+				})
+			}
+		},
+	}
+}
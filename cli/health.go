@@ -0,0 +1,78 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"test-analyzer/analysis"
+	"text/tabwriter"
+	"time"
+)
+
+func healthCommand() *Command {
+	return &Command{
+		Name:    "health",
+		Summary: "Show the share of green runs, red streaks, time to recovery and time between failures",
+		Setup: func(fs *flag.FlagSet) func(ctx *Context, args []string) error {
+			branch := fs.String("branch", "", "only look at runs of this branch")
+			days := fs.Int("days", 30, "number of days to look back, 0 for all history")
+			top := fs.Int("top", 20, "number of tests with the most failure episodes to list")
+
+			return func(ctx *Context, args []string) error {
+				if *days < 0 {
+					return usageErrorf("days must not be negative")
+				}
+
+				db, err := ctx.DB()
+				if err != nil {
+					return err
+				}
+
+				opts := analysis.HealthOptions{Branch: *branch}
+				if *days > 0 {
+					opts.Since = time.Now().AddDate(0, 0, -*days).UnixMilli()
+				}
+				health := analysis.ComputeRunHealth(db.GetTestOutcomes(), opts)
+
+				return ctx.Output(health, func(w io.Writer) {
+					if health.Runs == 0 {
+						fmt.Fprintln(w, "No runs in the window")
+						return
+					}
+
+					fmt.Fprintf(w, "%d runs from %s to %s\n", health.Runs, formatTime(health.From), formatTime(health.To))
+					fmt.Fprintf(w, "Green runs:         %d (%.1f%%)\n", health.GreenRuns, health.GreenRate*100)
+					fmt.Fprintf(w, "Longest red streak: %d runs", health.LongestRedStreak)
+					if health.LongestRedStreak > 0 {
+						fmt.Fprintf(w, " (%s to %s)", health.LongestRedStreakStart, health.LongestRedStreakEnd)
+					}
+					fmt.Fprintf(w, "\nCurrent red streak: %d runs\n", health.CurrentRedStreak)
+					fmt.Fprintf(w, "Mean time to green: %s\n", formatMillis(health.TimeToGreen))
+					fmt.Fprintf(w, "Mean test recovery: %s\n\n", formatMillis(health.TestMTTR))
+
+					tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+					fmt.Fprintln(tw, "TEST\tRUNS\tFAILURES\tEPISODES\tMTTR\tMTBF\tFAILING")
+					for i, t := range health.Tests {
+						if i >= *top || t.Episodes == 0 {
+							break
+						}
+						fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%s\t%s\t%v\n", t.TestLabel, t.Runs, t.Failures, t.Episodes, formatMillis(t.MTTR), formatMillis(t.MTBF), t.Failing)
+					}
+					_ = tw.Flush()
+				})
+			}
+		},
+	}
+}
+
+func formatTime(ms int64) string {
+	return time.UnixMilli(ms).UTC().Format("2006-01-02 15:04")
+}
+
+// formatMillis renders a duration in ms rounded to the minute, "-" for 0.
+func formatMillis(ms int64) string {
+	if ms == 0 {
+		return "-"
+	}
+	return (time.Duration(ms) * time.Millisecond).Round(time.Minute).String()
+}
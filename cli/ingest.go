@@ -0,0 +1,142 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"test-analyzer/analysis"
+	"test-analyzer/ingestion"
+)
+
+func fetchCommand() *Command {
+	return &Command{
+		Name:    "fetch",
+		Summary: "Download new e2e test artifacts from GitHub into the cache dir",
+		Setup: func(fs *flag.FlagSet) func(ctx *Context, args []string) error {
+			limit := fs.Int("limit", 900, "maximum number of e2e artifacts to look at")
+			pages := fs.Int("pages", 50, "maximum number of artifact pages to walk through")
+
+			return func(ctx *Context, args []string) error {
+				if ctx.Config.GitHubToken == "" {
+					return fmt.Errorf("no GitHub token, set %s in the environment or config file", envGitHubToken)
+				}
+				owner, repo, _ := ctx.Config.OwnerRepo()
+
+				db, err := ctx.DB()
+				if err != nil {
+					return err
+				}
+
+				fetch := db.StartIngestion("fetch")
+				count, err := ingestion.FetchResults(ingestion.FetchOptions{
+					Token:    ctx.Config.GitHubToken,
+					CacheDir: ctx.Config.CacheDir,
+					Owner:    owner,
+					Repo:     repo,
+					Limit:    *limit,
+					MaxPages: *pages,
+					Progress: ctx.Progress(),
+				})
+				fetch.Stored = count
+				db.FinishIngestion(fetch, err)
+				if err != nil {
+					return err
+				}
+
+				return ctx.Output(map[string]int{"downloaded": count}, func(w io.Writer) {
+					fmt.Fprintf(w, "Downloaded %d artifacts\n", count)
+				})
+			}
+		},
+	}
+}
+
+func extractCommand() *Command {
+	return &Command{
+		Name:    "extract",
+		Summary: "Store the results of downloaded artifacts that have not been extracted yet",
+		Setup: func(fs *flag.FlagSet) func(ctx *Context, args []string) error {
+			return func(ctx *Context, args []string) error {
+				db, err := ctx.DB()
+				if err != nil {
+					return err
+				}
+
+				classifier, err := ctx.Config.Classifier()
+				if err != nil {
+					return err
+				}
+
+				count, err := ingestion.ExtractResults(db, ingestion.ExtractOptions{
+					CacheDir: ctx.Config.CacheDir,
+					Project:  ctx.Config.Repository,
+				}, analysis.IngestionStages(classifier)...)
+				if err != nil {
+					return err
+				}
+
+				return ctx.Output(map[string]int{"stored": count}, func(w io.Writer) {
+					fmt.Fprintf(w, "Stored %d reports\n", count)
+				})
+			}
+		},
+	}
+}
+
+func analyzeCommand() *Command {
+	return &Command{
+		Name:    "analyze",
+		Summary: "Generate test metrics for reports that do not have them yet",
+		Setup: func(fs *flag.FlagSet) func(ctx *Context, args []string) error {
+			rebuild := fs.Bool("rebuild", false, "regenerate metrics for every report, same as rebuild-metrics")
+
+			return func(ctx *Context, args []string) error {
+				if *rebuild {
+					return rebuildMetrics(ctx)
+				}
+
+				db, err := ctx.DB()
+				if err != nil {
+					return err
+				}
+
+				count, err := analysis.GenerateMissingTestMetrics(db)
+				if err != nil {
+					return err
+				}
+
+				return ctx.Output(map[string]int{"reports": count}, func(w io.Writer) {
+					fmt.Fprintf(w, "Generated test metrics for %d reports\n", count)
+				})
+			}
+		},
+	}
+}
+
+func rebuildMetricsCommand() *Command {
+	return &Command{
+		Name:    "rebuild-metrics",
+		Summary: "Regenerate the test metrics of every stored report",
+		Setup: func(fs *flag.FlagSet) func(ctx *Context, args []string) error {
+			return func(ctx *Context, args []string) error {
+				return rebuildMetrics(ctx)
+			}
+		},
+	}
+}
+
+func rebuildMetrics(ctx *Context) error {
+	db, err := ctx.DB()
+	if err != nil {
+		return err
+	}
+
+	count, err := analysis.RebuildTestMetrics(db)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Output(map[string]int{"reports": count}, func(w io.Writer) {
+		fmt.Fprintf(w, "Rebuilt test metrics for %d reports\n", count)
+	})
+}
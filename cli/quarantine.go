@@ -0,0 +1,123 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"test-analyzer/analysis"
+	"test-analyzer/ingestion"
+	"text/tabwriter"
+)
+
+func quarantineCommand() *Command {
+	return &Command{
+		Name:    "quarantine",
+		Args:    "<list|add|release|recommend|export> [test case id]",
+		Summary: "Manage the quarantine list and get quarantine and release recommendations",
+		Setup: func(fs *flag.FlagSet) func(ctx *Context, args []string) error {
+			defaults := analysis.DefaultQuarantineOptions()
+			all := fs.Bool("all", false, "include released entries (list only)")
+			by := fs.String("by", os.Getenv("USER"), "who is quarantining or releasing the test (add and release)")
+			reason := fs.String("reason", "", "why the test is quarantined or released (add and release)")
+			format := fs.String("format", "yaml", "export format, yaml or skip (export only)")
+			window := fs.Int("window", defaults.Window, "number of most recent results per test to score (recommend only)")
+			threshold := fs.Float64("threshold", defaults.Threshold, "flakiness at or above which to recommend quarantine (recommend only)")
+			minRuns := fs.Int("min-runs", defaults.MinRuns, "minimum number of results to recommend quarantine (recommend only)")
+			releaseAfter := fs.Int("release-after", defaults.ReleaseAfter, "consecutive passes after which to recommend release (recommend only)")
+
+			return func(ctx *Context, args []string) error {
+				if len(args) == 0 {
+					return usageErrorf("expected one of list, add, release, recommend or export")
+				}
+
+				var testCaseId uint
+				if args[0] == "add" || args[0] == "release" {
+					if len(args) != 2 {
+						return usageErrorf("%s expects a test case id, see query cases", args[0])
+					}
+					id, err := strconv.ParseUint(args[1], 10, 64)
+					if err != nil {
+						return usageErrorf("invalid test case id %q", args[1])
+					}
+					testCaseId = uint(id)
+					if *by == "" || *reason == "" {
+						return usageErrorf("%s requires --by and --reason", args[0])
+					}
+				} else if len(args) != 1 {
+					return usageErrorf("unexpected arguments %v", args[1:])
+				}
+
+				db, err := ctx.DB()
+				if err != nil {
+					return err
+				}
+
+				switch args[0] {
+				case "list":
+					return listQuarantine(ctx, db.GetQuarantineEntries(*all))
+				case "add":
+					entry, err := db.QuarantineTestCase(testCaseId, *by, *reason)
+					if err != nil {
+						return err
+					}
+					return ctx.Output(entry, func(w io.Writer) {
+						fmt.Fprintf(w, "Quarantined %s (%s)\n", entry.Name, entry.Package)
+					})
+				case "release":
+					entry, err := db.ReleaseTestCase(testCaseId, *by, *reason)
+					if err != nil {
+						return err
+					}
+					return ctx.Output(entry, func(w io.Writer) {
+						fmt.Fprintf(w, "Released %s (%s)\n", entry.Name, entry.Package)
+					})
+				case "recommend":
+					recommendations := analysis.RecommendQuarantine(db.GetTestOutcomes(), db.GetQuarantineEntries(false), analysis.QuarantineOptions{
+						Window:       *window,
+						Threshold:    *threshold,
+						MinRuns:      *minRuns,
+						ReleaseAfter: *releaseAfter,
+					})
+					return ctx.Output(recommendations, func(w io.Writer) {
+						tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+						fmt.Fprintln(tw, "ACTION\tID\tTEST\tPACKAGE\tREASON")
+						for _, r := range recommendations {
+							fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", r.Action, r.TestCaseID, r.TestLabel, r.Package, r.Reason)
+						}
+						_ = tw.Flush()
+					})
+				case "export":
+					entries := db.GetQuarantineEntries(false)
+					switch *format {
+					case "yaml":
+						return analysis.WriteQuarantineYAML(ctx.Stdout, entries)
+					case "skip":
+						_, err := fmt.Fprintln(ctx.Stdout, analysis.SkipPattern(entries))
+						return err
+					default:
+						return usageErrorf("format must be yaml or skip, got %q", *format)
+					}
+				default:
+					return usageErrorf("unknown quarantine command %q", args[0])
+				}
+			}
+		},
+	}
+}
+
+func listQuarantine(ctx *Context, entries []ingestion.QuarantineEntry) error {
+	return ctx.Output(entries, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tTEST\tPACKAGE\tBY\tSINCE\tREASON\tRELEASED")
+		for _, e := range entries {
+			released := ""
+			if !e.Active() {
+				released = fmt.Sprintf("%s by %s: %s", formatTime(e.ReleasedAt), e.ReleasedBy, e.ReleaseReason)
+			}
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", e.TestCaseID, e.Name, e.Package, e.QuarantinedBy, formatTime(e.QuarantinedAt), e.Reason, released)
+		}
+		_ = tw.Flush()
+	})
+}
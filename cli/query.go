@@ -0,0 +1,220 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"test-analyzer/analysis"
+	"test-analyzer/ingestion"
+	"test-analyzer/tables"
+	"text/tabwriter"
+	"time"
+)
+
+func queryCommand() *Command {
+	return &Command{
+		Name:    "query",
+		Args:    "<tests|runs|cases>",
+		Summary: "List per-test metrics, workflow runs or test cases",
+		Setup: func(fs *flag.FlagSet) func(ctx *Context, args []string) error {
+			label := fs.String("label", "", "only include tests whose label starts with this prefix")
+			pkg := fs.String("package", "", "only include test cases in this package (cases only)")
+			limit := fs.Int("limit", 0, "maximum number of rows, 0 for all")
+
+			return func(ctx *Context, args []string) error {
+				if len(args) != 1 {
+					return usageErrorf("expected one of tests, runs or cases")
+				}
+
+				db, err := ctx.DB()
+				if err != nil {
+					return err
+				}
+
+				switch args[0] {
+				case "tests":
+					return queryTests(ctx, db, *label, *limit)
+				case "runs":
+					return queryRuns(ctx, db, *limit)
+				case "cases":
+					return queryCases(ctx, db, *pkg, *label, *limit)
+				default:
+					return usageErrorf("unknown query %q", args[0])
+				}
+			}
+		},
+	}
+}
+
+func queryTests(ctx *Context, db *ingestion.RecordDB, label string, limit int) error {
+	metrics := make([]analysis.TestMetrics, 0)
+	for _, tm := range analysis.AggregateTestMetrics(db) {
+		if strings.HasPrefix(tm.TestLabel, label) {
+			metrics = append(metrics, tm)
+		}
+	}
+	sort.Slice(metrics, func(i int, j int) bool {
+		return metrics[i].TestLabel < metrics[j].TestLabel
+	})
+	if limit > 0 && len(metrics) > limit {
+		metrics = metrics[:limit]
+	}
+
+	table := func() tables.Table {
+		return tables.TestMetrics(metrics, analysis.ComputeFlakiness(db.GetTestOutcomes(), analysis.DefaultFlakinessOptions()))
+	}
+	return ctx.OutputTable(metrics, table, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "TEST\tPASS\tFAIL\tPASS RATE\t95% INTERVAL")
+		for _, tm := range metrics {
+			interval := tm.PassRateInterval()
+			fmt.Fprintf(tw, "%s\t%d\t%d\t%.2f%%\t%.2f%% - %.2f%%\n", tm.TestLabel, tm.PassCount, tm.FailCount, interval.Rate*100, interval.Low*100, interval.High*100)
+		}
+		_ = tw.Flush()
+	})
+}
+
+type runSummary struct {
+	ID        uint      `json:"id"`
+	Label     string    `json:"label"`
+	CreatedAt time.Time `json:"created_at"`
+	Reports   int       `json:"reports"`
+	PassCount int       `json:"pass_count"`
+	FailCount int       `json:"fail_count"`
+	// FailureCategories counts the failed tests of the run by category
+	FailureCategories map[string]int `json:"failure_categories"`
+}
+
+func queryRuns(ctx *Context, db *ingestion.RecordDB, limit int) error {
+	categories := analysis.CountRunFailureCategories(db)
+	metrics := make(map[uint]analysis.RunMetrics)
+	for _, rm := range analysis.ComputeRunMetrics(db.GetTestOutcomes()) {
+		metrics[rm.ReportGroupID] = rm
+	}
+
+	runs := make([]runSummary, 0)
+	for _, rg := range db.AllReportGroups() {
+		runs = append(runs, runSummary{
+			ID:                rg.ID,
+			Label:             rg.Label,
+			CreatedAt:         rg.CreatedAt,
+			Reports:           len(db.GetReports(rg.ID)),
+			PassCount:         metrics[rg.ID].PassCount,
+			FailCount:         metrics[rg.ID].FailCount,
+			FailureCategories: categories[rg.ID],
+		})
+	}
+	sort.Slice(runs, func(i int, j int) bool {
+		return runs[i].ID > runs[j].ID
+	})
+	if limit > 0 && len(runs) > limit {
+		runs = runs[:limit]
+	}
+
+	// Runs without analyzed results still get a row, with their label only
+	table := func() tables.Table {
+		rows := make([]analysis.RunMetrics, 0, len(runs))
+		for _, r := range runs {
+			rm, ok := metrics[r.ID]
+			if !ok {
+				rm = analysis.RunMetrics{ReportGroupID: r.ID, RunLabel: r.Label}
+			}
+			rows = append(rows, rm)
+		}
+		return tables.Runs(rows)
+	}
+	return ctx.OutputTable(runs, table, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tRUN\tINGESTED\tREPORTS\tPASS\tFAIL\tFAILURES")
+		for _, r := range runs {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%d\t%d\t%d\t%s\n", r.ID, r.Label, r.CreatedAt.Format(time.RFC3339), r.Reports, r.PassCount, r.FailCount, tables.FormatCounts(r.FailureCategories))
+		}
+		_ = tw.Flush()
+	})
+}
+
+func queryCases(ctx *Context, db *ingestion.RecordDB, pkg string, label string, limit int) error {
+	cases := make([]ingestion.TestCase, 0)
+	for _, tc := range db.AllTestCases() {
+		if (pkg == "" || tc.Package == pkg) && strings.HasPrefix(tc.Name, label) {
+			cases = append(cases, tc)
+		}
+	}
+	if limit > 0 && len(cases) > limit {
+		cases = cases[:limit]
+	}
+
+	return ctx.Output(cases, func(w io.Writer) {
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "ID\tPACKAGE\tNAME\tFIRST SEEN\tLAST SEEN")
+		for _, tc := range cases {
+			fmt.Fprintf(tw, "%d\t%s\t%s\t%s\t%s\n", tc.ID, tc.Package, tc.Name,
+				time.UnixMilli(tc.FirstSeen).UTC().Format(time.RFC3339),
+				time.UnixMilli(tc.LastSeen).UTC().Format(time.RFC3339))
+		}
+		_ = tw.Flush()
+	})
+}
+
+type reliabilityReport struct {
+	Tests      int                    `json:"tests"`
+	PassCount  uint                   `json:"pass_count"`
+	FailCount  uint                   `json:"fail_count"`
+	PassRate   float64                `json:"pass_rate"`
+	Unreliable []analysis.TestMetrics `json:"unreliable"`
+}
+
+func reportCommand() *Command {
+	return &Command{
+		Name:    "report",
+		Summary: "Summarize overall reliability and list the least reliable tests",
+		Setup: func(fs *flag.FlagSet) func(ctx *Context, args []string) error {
+			top := fs.Int("top", 20, "number of least reliable tests to list")
+			minRuns := fs.Uint("min-runs", 5, "ignore tests with fewer pass/fail results than this")
+
+			return func(ctx *Context, args []string) error {
+				db, err := ctx.DB()
+				if err != nil {
+					return err
+				}
+
+				metrics := analysis.AggregateTestMetrics(db)
+				report := reliabilityReport{
+					Tests:      len(metrics),
+					Unreliable: make([]analysis.TestMetrics, 0),
+				}
+				for _, tm := range metrics {
+					report.PassCount += tm.PassCount
+					report.FailCount += tm.FailCount
+					if tm.PassCount+tm.FailCount >= *minRuns && tm.FailCount > 0 {
+						report.Unreliable = append(report.Unreliable, tm)
+					}
+				}
+				if report.PassCount+report.FailCount > 0 {
+					report.PassRate = float64(report.PassCount) / float64(report.PassCount+report.FailCount)
+				}
+
+				analysis.SortByUnreliability(report.Unreliable)
+				if len(report.Unreliable) > *top {
+					report.Unreliable = report.Unreliable[:*top]
+				}
+
+				table := func() tables.Table {
+					return tables.TestMetrics(report.Unreliable, analysis.ComputeFlakiness(db.GetTestOutcomes(), analysis.DefaultFlakinessOptions()))
+				}
+				return ctx.OutputTable(report, table, func(w io.Writer) {
+					fmt.Fprintf(w, "%d tests, %d passed, %d failed, %.2f%% pass rate\n\n", report.Tests, report.PassCount, report.FailCount, report.PassRate*100)
+					tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+					fmt.Fprintln(tw, "TEST\tPASS\tFAIL\tPASS RATE\t95% INTERVAL")
+					for _, tm := range report.Unreliable {
+						interval := tm.PassRateInterval()
+						fmt.Fprintf(tw, "%s\t%d\t%d\t%.2f%%\t%.2f%% - %.2f%%\n", tm.TestLabel, tm.PassCount, tm.FailCount, interval.Rate*100, interval.Low*100, interval.High*100)
+					}
+					_ = tw.Flush()
+				})
+			}
+		},
+	}
+}
@@ -0,0 +1,47 @@
+package cli
+
+import (
+	"flag"
+	"os"
+	"test-analyzer/web"
+)
+
+func serveCommand() *Command {
+	return &Command{
+		Name:    "serve",
+		Summary: "Serve the web UI",
+		Setup: func(fs *flag.FlagSet) func(ctx *Context, args []string) error {
+			listen := fs.String("listen", "", "address to listen on (default $"+envListen+" or :5000)")
+			dev := fs.Bool("dev", false, "serve templates and static assets from ./templates and ./static, reloading templates on every request")
+
+			return func(ctx *Context, args []string) error {
+				db, err := ctx.DB()
+				if err != nil {
+					return err
+				}
+
+				addr := ctx.Config.Listen
+				if *listen != "" {
+					addr = *listen
+				}
+
+				// The binary embeds the assets, development reads the files
+				// being edited
+				assets := ctx.Assets
+				if *dev {
+					assets = os.DirFS(".")
+				}
+
+				return web.ServeHTTP(db, web.Options{
+					Addr:       addr,
+					CacheDir:   ctx.Config.ViewCacheDir(),
+					Repository: ctx.Config.Repository,
+					MainBranch: ctx.Config.MainBranch,
+					Comparer:   ctx.Config.CommitComparer(),
+					Assets:     assets,
+					Dev:        *dev,
+				})
+			}
+		},
+	}
+}
@@ -0,0 +1,118 @@
+package cli
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"test-analyzer/analysis"
+	"test-analyzer/tables"
+	"text/tabwriter"
+	"time"
+)
+
+func flakyCommand() *Command {
+	return &Command{
+		Name:    "flaky",
+		Summary: "List tests by how often their result flips between pass and fail",
+		Setup: func(fs *flag.FlagSet) func(ctx *Context, args []string) error {
+			classification := fs.String("classification", "", "only list tests classified as stable, flaky or broken")
+			limit := fs.Int("limit", 20, "maximum number of tests to list, 0 for all")
+
+			return func(ctx *Context, args []string) error {
+				db, err := ctx.DB()
+				if err != nil {
+					return err
+				}
+
+				tests := make([]analysis.TestFlakiness, 0)
+				for _, f := range analysis.ComputeFlakiness(db.GetTestOutcomes(), analysis.DefaultFlakinessOptions()) {
+					if *classification == "" || f.Classification == *classification {
+						tests = append(tests, f)
+					}
+				}
+				if *limit > 0 && len(tests) > *limit {
+					tests = tests[:*limit]
+				}
+
+				table := func() tables.Table { return tables.Flakiness(tests) }
+				return ctx.OutputTable(tests, table, func(w io.Writer) {
+					tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+					fmt.Fprintln(tw, "TEST\tRUNS\tFAILURES\tTRANSITIONS\tSCORE\tCLASSIFICATION")
+					for _, f := range tests {
+						fmt.Fprintf(tw, "%s\t%d\t%d\t%d\t%.3f\t%s\n", f.TestLabel, f.Runs, f.Failures, f.Transitions, f.Score, f.Classification)
+					}
+					_ = tw.Flush()
+				})
+			}
+		},
+	}
+}
+
+func slowestCommand() *Command {
+	return &Command{
+		Name:    "slowest",
+		Summary: "List tests by the mean duration of their passing results, slowest first",
+		Setup: func(fs *flag.FlagSet) func(ctx *Context, args []string) error {
+			limit := fs.Int("limit", 20, "maximum number of tests to list, 0 for all")
+
+			return func(ctx *Context, args []string) error {
+				db, err := ctx.DB()
+				if err != nil {
+					return err
+				}
+
+				tests := analysis.SlowestTests(db.GetTestOutcomes())
+				if *limit > 0 && len(tests) > *limit {
+					tests = tests[:*limit]
+				}
+
+				table := func() tables.Table { return tables.SlowestTests(tests) }
+				return ctx.OutputTable(tests, table, func(w io.Writer) {
+					tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+					fmt.Fprintln(tw, "TEST\tRUNS\tMEAN\tMEDIAN\tMAX")
+					for _, d := range tests {
+						fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\n", d.TestLabel, d.Runs,
+							time.Duration(d.MeanDuration)*time.Millisecond,
+							time.Duration(d.MedianDuration)*time.Millisecond,
+							time.Duration(d.MaxDuration)*time.Millisecond)
+					}
+					_ = tw.Flush()
+				})
+			}
+		},
+	}
+}
+
+func regressionsCommand() *Command {
+	return &Command{
+		Name:    "regressions",
+		Summary: "List tests whose failure rate went up abruptly and the run it happened in",
+		Setup: func(fs *flag.FlagSet) func(ctx *Context, args []string) error {
+			branch := fs.String("branch", "", "only look at runs of this branch, all if empty")
+			opts := analysis.DefaultChangepointOptions()
+			fs.IntVar(&opts.Window, "window", opts.Window, "number of most recent results per test to look at")
+			fs.Float64Var(&opts.MinDelta, "min-delta", opts.MinDelta, "minimum change in failure rate to report")
+
+			return func(ctx *Context, args []string) error {
+				db, err := ctx.DB()
+				if err != nil {
+					return err
+				}
+
+				opts.Branch = *branch
+				regressions := analysis.DetectRegressions(db.GetTestOutcomes(), opts)
+
+				table := func() tables.Table { return tables.Regressions(regressions) }
+				return ctx.OutputTable(regressions, table, func(w io.Writer) {
+					tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+					fmt.Fprintln(tw, "TEST\tBRANCH\tFAIL RATE\tFIRST BAD RUN\tFIRST BAD SHA")
+					for _, r := range regressions {
+						fmt.Fprintf(tw, "%s\t%s\t%.0f%% -> %.0f%%\t%s\t%.10s\n", r.TestLabel, r.HeadBranch,
+							r.PreviousFailRate*100, r.NewFailRate*100, r.FirstBadRunLabel, r.FirstBadSHA)
+					}
+					_ = tw.Flush()
+				})
+			}
+		},
+	}
+}
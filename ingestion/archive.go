@@ -0,0 +1,540 @@
+package ingestion
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"io"
+	"time"
+)
+
+// ArchiveFormat and ArchiveVersion identify the NDJSON snapshot format written
+// by Export. Bump the version whenever the meaning of an existing table or
+// field changes; adding fields is backwards compatible.
+const (
+	ArchiveFormat  = "test-analyzer-archive"
+	ArchiveVersion = 2
+)
+
+const (
+	archiveTableProjects          = "projects"
+	archiveTableTestCases         = "test_cases"
+	archiveTableTestCaseAliases   = "test_case_aliases"
+	archiveTableReportGroups      = "report_groups"
+	archiveTableReports           = "reports"
+	archiveTableTestGroups        = "test_groups"
+	archiveTableTests             = "tests"
+	archiveTableTestLogs          = "test_logs"
+	archiveTableReportTestMetrics = "report_test_metrics"
+	// archiveTableQuarantine was added in version 2
+	archiveTableQuarantine = "quarantine"
+)
+
+type ArchiveHeader struct {
+	Format      string     `json:"format"`
+	Version     int        `json:"version"`
+	CreatedAt   time.Time  `json:"created_at"`
+	Project     string     `json:"project,omitempty"`
+	Since       *time.Time `json:"since,omitempty"`
+	Until       *time.Time `json:"until,omitempty"`
+	IncludeLogs bool       `json:"include_logs"`
+}
+
+// archiveLine is a single line of an archive. The first line carries the
+// header, the last one the per-table row counts, everything in between a row.
+type archiveLine struct {
+	Header *ArchiveHeader  `json:"header,omitempty"`
+	Table  string          `json:"table,omitempty"`
+	Row    json.RawMessage `json:"row,omitempty"`
+	// Counts is a pointer so that the footer of an empty archive is kept
+	Counts *map[string]int64 `json:"counts,omitempty"`
+}
+
+type ExportOptions struct {
+	// Project restricts the export to a single project label
+	Project string
+	// Since and Until restrict the export to report groups created in the window
+	Since time.Time
+	Until time.Time
+	// IncludeLogs exports test log output, which is by far the largest table
+	IncludeLogs bool
+}
+
+type ArchiveStats struct {
+	Counts  map[string]int64
+	Skipped map[string]int64
+}
+
+type archiveWriter struct {
+	enc    *json.Encoder
+	counts map[string]int64
+}
+
+func (aw *archiveWriter) write(table string, row interface{}) error {
+	if b, err := json.Marshal(row); err != nil {
+		return fmt.Errorf("failed to marshal %s row: %w", table, err)
+	} else {
+		aw.counts[table] += 1
+		return aw.enc.Encode(archiveLine{Table: table, Row: b})
+	}
+}
+
+// Export streams a snapshot of the database to w as NDJSON, one report group
+// at a time so memory use is bounded by the size of a single workflow run.
+// It reads within a single transaction, so an export taken during ingestion
+// never holds a report without its tests or metrics.
+func (r *RecordDB) Export(w io.Writer, opts ExportOptions) (*ArchiveStats, error) {
+	var stats *ArchiveStats
+	err := r.Transaction(func(tx *RecordDB) error {
+		var err error
+		stats, err = tx.export(w, opts)
+		return err
+	})
+	return stats, err
+}
+
+func (r *RecordDB) export(w io.Writer, opts ExportOptions) (*ArchiveStats, error) {
+	bw := bufio.NewWriter(w)
+	aw := &archiveWriter{enc: json.NewEncoder(bw), counts: make(map[string]int64)}
+
+	header := ArchiveHeader{
+		Format:      ArchiveFormat,
+		Version:     ArchiveVersion,
+		CreatedAt:   time.Now().UTC(),
+		Project:     opts.Project,
+		IncludeLogs: opts.IncludeLogs,
+	}
+	if !opts.Since.IsZero() {
+		header.Since = &opts.Since
+	}
+	if !opts.Until.IsZero() {
+		header.Until = &opts.Until
+	}
+	if err := aw.enc.Encode(archiveLine{Header: &header}); err != nil {
+		return nil, err
+	}
+
+	var projects []Project
+	projectQuery := r.db.Order("id")
+	if opts.Project != "" {
+		projectQuery = projectQuery.Where("label = ?", opts.Project)
+	}
+	if tx := projectQuery.Find(&projects); tx.Error != nil {
+		return nil, fmt.Errorf("failed to load projects: %w", tx.Error)
+	}
+	if opts.Project != "" && len(projects) == 0 {
+		return nil, fmt.Errorf("no project with label %q", opts.Project)
+	}
+
+	for _, p := range projects {
+		if err := aw.write(archiveTableProjects, p); err != nil {
+			return nil, err
+		}
+	}
+
+	var testCases []TestCase
+	r.db.Order("id").Find(&testCases)
+	for _, tc := range testCases {
+		if err := aw.write(archiveTableTestCases, tc); err != nil {
+			return nil, err
+		}
+	}
+
+	var aliases []TestCaseAlias
+	r.db.Order("id").Find(&aliases)
+	for _, a := range aliases {
+		if err := aw.write(archiveTableTestCaseAliases, a); err != nil {
+			return nil, err
+		}
+	}
+
+	var quarantine []QuarantineEntry
+	r.db.Order("id").Find(&quarantine)
+	for _, e := range quarantine {
+		if err := aw.write(archiveTableQuarantine, e); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, p := range projects {
+		var reportGroups []ReportGroup
+		groupQuery := r.db.Where("project_id = ?", p.ID).Order("id")
+		if !opts.Since.IsZero() {
+			groupQuery = groupQuery.Where("created_at >= ?", opts.Since)
+		}
+		if !opts.Until.IsZero() {
+			groupQuery = groupQuery.Where("created_at < ?", opts.Until)
+		}
+		if tx := groupQuery.Find(&reportGroups); tx.Error != nil {
+			return nil, fmt.Errorf("failed to load report groups: %w", tx.Error)
+		}
+
+		for _, rg := range reportGroups {
+			if err := r.exportReportGroup(aw, rg, opts); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	if err := aw.enc.Encode(archiveLine{Counts: &aw.counts}); err != nil {
+		return nil, err
+	}
+
+	return &ArchiveStats{Counts: aw.counts}, bw.Flush()
+}
+
+func (r *RecordDB) exportReportGroup(aw *archiveWriter, rg ReportGroup, opts ExportOptions) error {
+	if err := aw.write(archiveTableReportGroups, rg); err != nil {
+		return err
+	}
+
+	for _, report := range r.GetReports(rg.ID) {
+		if err := aw.write(archiveTableReports, report); err != nil {
+			return err
+		}
+
+		for _, m := range r.GetReportTestMetrics(report.ID) {
+			if err := aw.write(archiveTableReportTestMetrics, m); err != nil {
+				return err
+			}
+		}
+
+		for _, testGroup := range r.GetTestGroups(report.ID) {
+			if err := aw.write(archiveTableTestGroups, testGroup); err != nil {
+				return err
+			}
+
+			for _, test := range r.GetTests(testGroup.ID) {
+				if err := aw.write(archiveTableTests, test); err != nil {
+					return err
+				}
+
+				if opts.IncludeLogs {
+					for _, l := range r.GetTestLogs(test.ID) {
+						if err := aw.write(archiveTableTestLogs, l); err != nil {
+							return err
+						}
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// archiveImporter remaps the primary keys of an archive onto the target
+// database. Report groups that already exist are skipped along with all of
+// their children, so importing the same archive twice is a no-op.
+type archiveImporter struct {
+	tx    *gorm.DB
+	stats *ArchiveStats
+
+	projects  map[uint]uint
+	testCases map[uint]uint
+
+	// the following are reset for every report group
+	skipGroup  bool
+	groupID    uint
+	reports    map[uint]uint
+	testGroups map[uint]uint
+	tests      map[uint]uint
+	logs       []TestLog
+}
+
+// Import reads an archive produced by Export and inserts its contents,
+// assigning new primary keys and preserving everything else.
+func (r *RecordDB) Import(rd io.Reader) (*ArchiveStats, error) {
+	scanner := bufio.NewScanner(rd)
+	scanner.Buffer(make([]byte, 0, 1024*1024), 256*1024*1024)
+
+	if !scanner.Scan() {
+		if err := scanner.Err(); err != nil {
+			return nil, err
+		}
+		return nil, fmt.Errorf("empty archive")
+	}
+
+	var first archiveLine
+	if err := json.Unmarshal(scanner.Bytes(), &first); err != nil || first.Header == nil {
+		return nil, fmt.Errorf("missing archive header")
+	}
+	if first.Header.Format != ArchiveFormat {
+		return nil, fmt.Errorf("unknown archive format %q", first.Header.Format)
+	}
+	if first.Header.Version > ArchiveVersion {
+		return nil, fmt.Errorf("archive version %d is newer than supported version %d", first.Header.Version, ArchiveVersion)
+	}
+
+	stats := &ArchiveStats{Counts: make(map[string]int64), Skipped: make(map[string]int64)}
+	var footer map[string]int64
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		imp := &archiveImporter{
+			tx:        tx,
+			stats:     stats,
+			projects:  make(map[uint]uint),
+			testCases: make(map[uint]uint),
+		}
+
+		line := 1
+		for scanner.Scan() {
+			line += 1
+			var l archiveLine
+			if err := json.Unmarshal(scanner.Bytes(), &l); err != nil {
+				return fmt.Errorf("line %d: %w", line, err)
+			}
+			if l.Counts != nil {
+				footer = *l.Counts
+				continue
+			}
+			if err := imp.importRow(l.Table, l.Row); err != nil {
+				return fmt.Errorf("line %d (%s): %w", line, l.Table, err)
+			}
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+		if err := imp.flushLogs(); err != nil {
+			return err
+		}
+
+		// A truncated or inconsistent archive rolls back, otherwise its report
+		// groups would be skipped as present when it is imported again
+		if footer == nil {
+			return fmt.Errorf("archive is truncated: missing footer")
+		}
+		for table, count := range footer {
+			if got := stats.Counts[table] + stats.Skipped[table]; got != count {
+				return fmt.Errorf("archive declares %d %s rows but %d were read", count, table, got)
+			}
+		}
+		return nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	r.BumpDataVersion()
+
+	return stats, nil
+}
+
+func (imp *archiveImporter) importRow(table string, raw json.RawMessage) error {
+	switch table {
+	case archiveTableProjects:
+		var p Project
+		if err := json.Unmarshal(raw, &p); err != nil {
+			return err
+		}
+		oldID := p.ID
+		var existing Project
+		if imp.tx.First(&existing, "label = ?", p.Label).Error == nil {
+			imp.projects[oldID] = existing.ID
+			return imp.skip(table)
+		}
+		p.ID = 0
+		p.ReportGroups = nil
+		if err := imp.tx.Create(&p).Error; err != nil {
+			return err
+		}
+		imp.projects[oldID] = p.ID
+
+	case archiveTableTestCases:
+		var tc TestCase
+		if err := json.Unmarshal(raw, &tc); err != nil {
+			return err
+		}
+		oldID := tc.ID
+		var existing TestCase
+		if imp.tx.First(&existing, "package = ? AND name = ?", tc.Package, tc.Name).Error == nil {
+			if tc.FirstSeen != 0 && (existing.FirstSeen == 0 || tc.FirstSeen < existing.FirstSeen) {
+				existing.FirstSeen = tc.FirstSeen
+			}
+			if tc.LastSeen > existing.LastSeen {
+				existing.LastSeen = tc.LastSeen
+			}
+			imp.tx.Save(&existing)
+			imp.testCases[oldID] = existing.ID
+			return imp.skip(table)
+		}
+		tc.ID = 0
+		tc.Aliases = nil
+		if err := imp.tx.Create(&tc).Error; err != nil {
+			return err
+		}
+		imp.testCases[oldID] = tc.ID
+
+	case archiveTableTestCaseAliases:
+		var a TestCaseAlias
+		if err := json.Unmarshal(raw, &a); err != nil {
+			return err
+		}
+		a.ID = 0
+		a.TestCaseID = imp.testCases[a.TestCaseID]
+		if res := imp.tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&a); res.Error != nil {
+			return res.Error
+		} else if res.RowsAffected == 0 {
+			return imp.skip(table)
+		}
+
+	case archiveTableQuarantine:
+		var e QuarantineEntry
+		if err := json.Unmarshal(raw, &e); err != nil {
+			return err
+		}
+		testCaseID, ok := imp.testCases[e.TestCaseID]
+		if !ok {
+			return fmt.Errorf("quarantine entry %d references unknown test case %d", e.ID, e.TestCaseID)
+		}
+		// The same entry from an earlier import, or an active entry that
+		// would be a second one for the test case
+		var existing QuarantineEntry
+		if imp.tx.First(&existing, "test_case_id = ? AND quarantined_at = ?", testCaseID, e.QuarantinedAt).Error == nil ||
+			(e.ReleasedAt == 0 && imp.tx.First(&existing, "test_case_id = ? AND released_at = ?", testCaseID, 0).Error == nil) {
+			return imp.skip(table)
+		}
+		e.ID = 0
+		e.TestCaseID = testCaseID
+		if err := imp.tx.Create(&e).Error; err != nil {
+			return err
+		}
+
+	case archiveTableReportGroups:
+		if err := imp.flushLogs(); err != nil {
+			return err
+		}
+		var rg ReportGroup
+		if err := json.Unmarshal(raw, &rg); err != nil {
+			return err
+		}
+		projectID, ok := imp.projects[rg.ProjectID]
+		if !ok {
+			return fmt.Errorf("report group %d references unknown project %d", rg.ID, rg.ProjectID)
+		}
+		imp.reports = make(map[uint]uint)
+		imp.testGroups = make(map[uint]uint)
+		imp.tests = make(map[uint]uint)
+
+		var existing ReportGroup
+		if imp.tx.First(&existing, "project_id = ? AND label = ?", projectID, rg.Label).Error == nil {
+			imp.skipGroup = true
+			return imp.skip(table)
+		}
+		imp.skipGroup = false
+		rg.ID = 0
+		rg.ProjectID = projectID
+		rg.Reports = nil
+		if err := imp.tx.Create(&rg).Error; err != nil {
+			return err
+		}
+		imp.groupID = rg.ID
+
+	case archiveTableReports:
+		if imp.skipGroup {
+			return imp.skip(table)
+		}
+		var report Report
+		if err := json.Unmarshal(raw, &report); err != nil {
+			return err
+		}
+		oldID := report.ID
+		report.ID = 0
+		report.ReportGroupID = imp.groupID
+		report.TestGroups = nil
+		if err := imp.tx.Create(&report).Error; err != nil {
+			return err
+		}
+		imp.reports[oldID] = report.ID
+
+	case archiveTableReportTestMetrics:
+		if imp.skipGroup {
+			return imp.skip(table)
+		}
+		var m ReportTestMetrics
+		if err := json.Unmarshal(raw, &m); err != nil {
+			return err
+		}
+		m.ID = 0
+		m.ReportID = imp.reports[m.ReportID]
+		m.TestCaseID = imp.testCases[m.TestCaseID]
+		if err := imp.tx.Create(&m).Error; err != nil {
+			return err
+		}
+
+	case archiveTableTestGroups:
+		if imp.skipGroup {
+			return imp.skip(table)
+		}
+		var tg TestGroup
+		if err := json.Unmarshal(raw, &tg); err != nil {
+			return err
+		}
+		oldID := tg.ID
+		tg.ID = 0
+		tg.ReportID = imp.reports[tg.ReportID]
+		tg.Tests = nil
+		if err := imp.tx.Create(&tg).Error; err != nil {
+			return err
+		}
+		imp.testGroups[oldID] = tg.ID
+
+	case archiveTableTests:
+		if imp.skipGroup {
+			return imp.skip(table)
+		}
+		var t Test
+		if err := json.Unmarshal(raw, &t); err != nil {
+			return err
+		}
+		oldID := t.ID
+		t.ID = 0
+		t.TestGroupID = imp.testGroups[t.TestGroupID]
+		t.TestCaseID = imp.testCases[t.TestCaseID]
+		t.Logs = nil
+		if err := imp.tx.Create(&t).Error; err != nil {
+			return err
+		}
+		imp.tests[oldID] = t.ID
+
+	case archiveTableTestLogs:
+		if imp.skipGroup {
+			return imp.skip(table)
+		}
+		var l TestLog
+		if err := json.Unmarshal(raw, &l); err != nil {
+			return err
+		}
+		l.ID = 0
+		l.TestID = imp.tests[l.TestID]
+		imp.logs = append(imp.logs, l)
+		if len(imp.logs) >= 500 {
+			if err := imp.flushLogs(); err != nil {
+				return err
+			}
+		}
+
+	default:
+		return fmt.Errorf("unknown table %q", table)
+	}
+
+	imp.stats.Counts[table] += 1
+	return nil
+}
+
+func (imp *archiveImporter) skip(table string) error {
+	imp.stats.Skipped[table] += 1
+	return nil
+}
+
+func (imp *archiveImporter) flushLogs() error {
+	if len(imp.logs) == 0 {
+		return nil
+	}
+	err := imp.tx.CreateInBatches(imp.logs, 500).Error
+	imp.logs = imp.logs[:0]
+	return err
+}
@@ -0,0 +1,239 @@
+package ingestion
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func openTestDB(t *testing.T, name string) *RecordDB {
+	t.Helper()
+	db := OpenRecordDB(filepath.Join(t.TempDir(), name))
+	if db == nil {
+		t.Fatalf("unable to open %s", name)
+	}
+	return db
+}
+
+// seedArchiveDB stores a run with a passing and a failing test, their logs
+// and metrics, an alias and a quarantine entry.
+func seedArchiveDB(t *testing.T, db *RecordDB) {
+	t.Helper()
+	project := db.FindOrCreateProjectByLabel("dapr")
+	group := db.FindOrCreateReportGroupByLabel(project.ID, "run-1")
+	if err := db.UpdateRunMetadata(group, RunMetadata{RunID: 1, HeadSHA: "abc", HeadBranch: "master", Event: "push"}); err != nil {
+		t.Fatal(err)
+	}
+
+	report := Report{
+		ReportGroupID: group.ID,
+		Label:         "e2e",
+		TestGroups: []TestGroup{{
+			Label: "pkg",
+			Tests: []Test{
+				{Label: "TestA", Status: "pass", Start: 100, End: 200, Logs: []TestLog{{Timestamp: 100, Text: "ok"}}},
+				{Label: "TestB", Status: "fail", Start: 100, End: 300, FailureMessage: "boom", Logs: []TestLog{
+					{Timestamp: 100, Text: "=== RUN TestB"},
+					{Timestamp: 300, Text: "panic: boom"},
+				}},
+			},
+		}},
+	}
+	db.AssignTestCases(&report)
+	if err := db.StoreReportWithStages(&report, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	a, b := db.FindTestCase("pkg", "TestA"), db.FindTestCase("pkg", "TestB")
+	if err := db.ReplaceReportTestMetrics(report.ID, []ReportTestMetrics{
+		{TestCaseID: a.ID, TestLabel: "TestA", PassCount: 1},
+		{TestCaseID: b.ID, TestLabel: "TestB", FailCount: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.AddTestCaseAlias(a.ID, "oldpkg", "TestOld"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.QuarantineTestCase(b.ID, "tester", "flaky"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// outcomeSummary describes outcomes independently of primary keys.
+func outcomeSummary(db *RecordDB) string {
+	lines := make([]string, 0)
+	for _, o := range db.GetTestOutcomes() {
+		tc := db.GetTestCase(o.TestCaseID)
+		lines = append(lines, fmt.Sprintf("%s %s %s %s %d %s %s %s", o.RunLabel, o.ReportLabel, tc.FullName(), o.Status,
+			o.Start, o.HeadSHA, o.HeadBranch, o.FailureMessage))
+	}
+	sort.Strings(lines)
+	return strings.Join(lines, "\n")
+}
+
+func TestArchiveRoundTrip(t *testing.T) {
+	src := openTestDB(t, "src.db")
+	seedArchiveDB(t, src)
+
+	var archive bytes.Buffer
+	exported, err := src.Export(&archive, ExportOptions{IncludeLogs: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{
+		archiveTableProjects:          1,
+		archiveTableTestCases:         2,
+		archiveTableTestCaseAliases:   1,
+		archiveTableReportGroups:      1,
+		archiveTableReports:           1,
+		archiveTableTestGroups:        1,
+		archiveTableTests:             2,
+		archiveTableTestLogs:          3,
+		archiveTableReportTestMetrics: 2,
+		archiveTableQuarantine:        1,
+	}
+	if fmt.Sprint(exported.Counts) != fmt.Sprint(want) {
+		t.Errorf("exported %v, want %v", exported.Counts, want)
+	}
+
+	// a test case that only exists in the target shifts the primary keys
+	dst := openTestDB(t, "dst.db")
+	dst.FindOrCreateTestCase("other", "TestZ", 1)
+	version := dst.DataVersion()
+
+	imported, err := dst.Import(bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fmt.Sprint(imported.Counts) != fmt.Sprint(want) {
+		t.Errorf("imported %v, want %v", imported.Counts, want)
+	}
+	if dst.DataVersion() == version {
+		t.Error("the import did not bump the data version")
+	}
+
+	if got, want := outcomeSummary(dst), outcomeSummary(src); got != want {
+		t.Errorf("got outcomes\n%s\nwant\n%s", got, want)
+	}
+
+	a, b := dst.FindTestCase("pkg", "TestA"), dst.FindTestCase("pkg", "TestB")
+	if alias := dst.FindTestCase("oldpkg", "TestOld"); alias == nil || alias.ID != a.ID {
+		t.Errorf("got %+v for the alias, want test case %d", alias, a.ID)
+	}
+	if entries := dst.GetQuarantineEntries(false); len(entries) != 1 || entries[0].TestCaseID != b.ID || entries[0].Reason != "flaky" {
+		t.Errorf("got quarantine entries %+v, want one for test case %d", entries, b.ID)
+	}
+
+	reports := dst.GetReportIDsWithTestMetrics()
+	if len(reports) != 1 {
+		t.Fatalf("got %d reports with metrics, want 1", len(reports))
+	}
+	for _, m := range dst.GetReportTestMetrics(reports[0]) {
+		if tc := dst.GetTestCase(m.TestCaseID); tc == nil || tc.Name != m.TestLabel {
+			t.Errorf("metrics of %s reference test case %+v", m.TestLabel, tc)
+		}
+	}
+
+	logs := 0
+	for _, o := range dst.GetTestCaseOutcomes(b.ID) {
+		_ = dst.EachTestLog(o.TestID, func(l TestLog) error {
+			logs += 1
+			return nil
+		})
+	}
+	if logs != 2 {
+		t.Errorf("got %d logs for TestB, want 2", logs)
+	}
+
+	// importing the same archive again changes nothing
+	again, err := dst.Import(bytes.NewReader(archive.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for table, count := range again.Counts {
+		if count != 0 && table != archiveTableProjects && table != archiveTableTestCases {
+			t.Errorf("imported %d %s rows again", count, table)
+		}
+	}
+	if entries := dst.GetQuarantineEntries(true); len(entries) != 1 {
+		t.Errorf("got %d quarantine entries after a second import, want 1", len(entries))
+	}
+}
+
+func TestArchiveExportOptions(t *testing.T) {
+	db := openTestDB(t, "src.db")
+	seedArchiveDB(t, db)
+
+	var archive bytes.Buffer
+	stats, err := db.Export(&archive, ExportOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.Counts[archiveTableTestLogs] != 0 || stats.Counts[archiveTableTests] != 2 {
+		t.Errorf("got %v without logs", stats.Counts)
+	}
+
+	if _, err := db.Export(&bytes.Buffer{}, ExportOptions{Project: "missing"}); err == nil {
+		t.Error("expected an error for an unknown project")
+	}
+}
+
+func TestArchiveEmpty(t *testing.T) {
+	var archive bytes.Buffer
+	if _, err := openTestDB(t, "src.db").Export(&archive, ExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	stats, err := openTestDB(t, "dst.db").Import(&archive)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for table, count := range stats.Counts {
+		if count != 0 {
+			t.Errorf("imported %d %s rows from an empty archive", count, table)
+		}
+	}
+}
+
+func TestArchiveImportErrors(t *testing.T) {
+	src := openTestDB(t, "src.db")
+	seedArchiveDB(t, src)
+	var archive bytes.Buffer
+	if _, err := src.Export(&archive, ExportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(archive.String()), "\n")
+
+	tests := []struct {
+		name    string
+		archive string
+		err     string
+	}{
+		{name: "empty", archive: "", err: "empty archive"},
+		{name: "no header", archive: lines[1], err: "missing archive header"},
+		{name: "other format", archive: `{"header":{"format":"other","version":1}}`, err: "unknown archive format"},
+		{name: "newer version", archive: fmt.Sprintf(`{"header":{"format":%q,"version":%d}}`, ArchiveFormat, ArchiveVersion+1), err: "newer than supported"},
+		{name: "truncated", archive: strings.Join(lines[:len(lines)-3], "\n"), err: "missing footer"},
+		{name: "missing row", archive: strings.Join(append(lines[:len(lines)-2:len(lines)-2], lines[len(lines)-1]), "\n"), err: "rows but"},
+	}
+	for _, tt := range tests {
+		dst := openTestDB(t, "dst.db")
+		if _, err := dst.Import(strings.NewReader(tt.archive)); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: got error %v, want %q", tt.name, err, tt.err)
+		}
+		// nothing of a bad archive is kept
+		if outcomes, groups := dst.GetTestOutcomes(), dst.AllReportGroups(); len(outcomes) != 0 || len(groups) != 0 {
+			t.Errorf("%s: kept %d outcomes and %d runs", tt.name, len(outcomes), len(groups))
+		}
+
+		// so the complete archive still imports in full afterwards
+		if _, err := dst.Import(bytes.NewReader(archive.Bytes())); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got, want := outcomeSummary(dst), outcomeSummary(src); got != want {
+			t.Errorf("%s: got outcomes\n%s\nafter importing the complete archive, want\n%s", tt.name, got, want)
+		}
+	}
+}
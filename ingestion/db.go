@@ -1,23 +1,42 @@
 package ingestion
 
 import (
+	"errors"
 	"fmt"
 	"gorm.io/driver/sqlite"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
+	"io"
 	"log"
 	"os"
 	"time"
 )
 
+var (
+	ErrAlreadyQuarantined = errors.New("test case is already quarantined")
+	ErrNotQuarantined     = errors.New("test case is not quarantined")
+)
+
 type RecordDB struct {
 	Path string
-	db   *gorm.DB
+	// Progress receives progress and error messages, see
+	// OpenRecordDBWithProgress
+	Progress io.Writer
+	db       *gorm.DB
 }
 
 func NewRecordDB() *RecordDB {
+	return OpenRecordDB("gorm.db")
+}
+
+func OpenRecordDB(path string) *RecordDB {
+	return OpenRecordDBWithProgress(path, os.Stdout)
+}
 
+// OpenRecordDBWithProgress opens the database at path, migrating it if
+// needed, and reports progress to progress rather than stdout.
+func OpenRecordDBWithProgress(path string, progress io.Writer) *RecordDB {
 	newLogger := logger.New(
 		log.New(os.Stdout, "\r\n", log.LstdFlags), // io writer
 		logger.Config{
@@ -28,23 +47,54 @@ func NewRecordDB() *RecordDB {
 		},
 	)
 
-	if db, err := gorm.Open(sqlite.Open("gorm.db"), &gorm.Config{
+	if db, err := gorm.Open(sqlite.Open(path), &gorm.Config{
 		Logger: newLogger,
 	}); err != nil {
 		panic("failed to connect database")
 	} else {
-		if err := db.AutoMigrate(&Project{}, &ReportGroup{}, &Report{}, &TestGroup{}, &Test{}, &TestLog{}, &ReportTestMetrics{}); err != nil {
-			fmt.Printf("Error migrating: %v\n", err)
+		// Reports stored before metrics generation was tracked predate the
+		// column, see backfillMetricsGenerated
+		tracked := db.Migrator().HasColumn(&Report{}, "MetricsGenerated")
+		if err := db.AutoMigrate(&Project{}, &ReportGroup{}, &Report{}, &TestGroup{}, &Test{}, &TestLog{}, &ReportTestMetrics{}, &TestCase{}, &TestCaseAlias{}, &DataVersion{}, &QuarantineEntry{}, &Ingestion{}); err != nil {
+			fmt.Fprintf(progress, "Error migrating: %v\n", err)
 			return nil
 		} else {
-			return &RecordDB{
-				Path: "gorm.db",
-				db:   db,
+			r := &RecordDB{
+				Path:     path,
+				Progress: progress,
+				db:       db,
+			}
+			if !tracked {
+				r.backfillMetricsGenerated()
 			}
+			return r
 		}
 	}
 }
 
+// backfillMetricsGenerated marks the reports that already have test metrics
+// as generated, so that an upgraded database keeps its history in the views
+// rather than waiting for rebuild-metrics.
+func (r *RecordDB) backfillMetricsGenerated() {
+	tx := r.db.Model(&Report{}).
+		Where("id IN (?)", r.db.Model(&ReportTestMetrics{}).Distinct("report_id")).
+		Update("metrics_generated", true)
+	if tx.Error != nil {
+		fmt.Fprintf(r.Progress, "Error marking reports with test metrics as generated: %v\n", tx.Error)
+	} else if tx.RowsAffected > 0 {
+		fmt.Fprintf(r.Progress, "Marked %d reports with test metrics as generated\n", tx.RowsAffected)
+		r.BumpDataVersion()
+	}
+}
+
+// Transaction runs fn against a RecordDB bound to a single transaction, which
+// is committed if fn returns nil and rolled back otherwise.
+func (r *RecordDB) Transaction(fn func(tx *RecordDB) error) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		return fn(&RecordDB{Path: r.Path, Progress: r.Progress, db: tx})
+	})
+}
+
 func (r *RecordDB) FindProjectByLabel(label string) *Project {
 	var project Project
 	if tx := r.db.First(&project, "label = ?", label); tx.Error != nil {
@@ -54,6 +104,22 @@ func (r *RecordDB) FindProjectByLabel(label string) *Project {
 	}
 }
 
+func (r *RecordDB) FindOrCreateProjectByLabel(label string) *Project {
+	if project := r.FindProjectByLabel(label); project != nil {
+		return project
+	} else {
+		p := Project{
+			Label: label,
+		}
+		if tx := r.db.Create(&p); tx.Error != nil {
+			fmt.Fprintf(r.Progress, "Error creating project: %v\n", tx.Error)
+			return nil
+		} else {
+			return &p
+		}
+	}
+}
+
 func (r *RecordDB) FindReportGroupByLabel(projectId uint, label string) *ReportGroup {
 	var reportGroup ReportGroup
 	if tx := r.db.First(&reportGroup, "project_id = ? AND label = ?", projectId, label); tx.Error != nil {
@@ -120,10 +186,74 @@ func (r *RecordDB) AllReportGroups() []ReportGroup {
 	return reportGroups
 }
 
-func (r *RecordDB) GetReportGroup() ReportGroup {
+func (r *RecordDB) GetReportGroup(reportGroupId uint) *ReportGroup {
 	var reportGroup ReportGroup
-	r.db.First(&reportGroup)
-	return reportGroup
+	if tx := r.db.First(&reportGroup, reportGroupId); tx.Error != nil {
+		return nil
+	}
+	return &reportGroup
+}
+
+// hasStoredReport matches the report groups with at least one stored report,
+// extraction creates the group before it stores the first one.
+const hasStoredReport = "EXISTS (SELECT 1 FROM reports WHERE reports.report_group_id = report_groups.id AND reports.deleted_at IS NULL)"
+
+// GetLatestReportGroup returns the most recently stored report group that has
+// a stored report, nil if there are none.
+func (r *RecordDB) GetLatestReportGroup() *ReportGroup {
+	var reportGroup ReportGroup
+	if tx := r.db.Where(hasStoredReport).Order("id DESC").First(&reportGroup); tx.Error != nil {
+		return nil
+	}
+	return &reportGroup
+}
+
+// GetReportGroupsAfter returns the report groups stored after the one with
+// the given ID that have a stored report, oldest first.
+func (r *RecordDB) GetReportGroupsAfter(reportGroupId uint) []ReportGroup {
+	var reportGroups []ReportGroup
+	r.db.Where("id > ?", reportGroupId).Where(hasStoredReport).Order("id").Find(&reportGroups)
+	return reportGroups
+}
+
+// GetPreviousReportGroup returns the run of the same project and branch that
+// came before reportGroup, nil if there is none or the branch is unknown. Runs
+// are ordered by when they were created, then by ID.
+func (r *RecordDB) GetPreviousReportGroup(reportGroup *ReportGroup) *ReportGroup {
+	if reportGroup.HeadBranch == "" {
+		return nil
+	}
+
+	var previous ReportGroup
+	if tx := r.db.Where("project_id = ? AND head_branch = ?", reportGroup.ProjectID, reportGroup.HeadBranch).
+		Where("run_created_at < ? OR (run_created_at = ? AND id < ?)", reportGroup.RunCreatedAt, reportGroup.RunCreatedAt, reportGroup.ID).
+		Order("run_created_at DESC, id DESC").
+		First(&previous); tx.Error != nil {
+		return nil
+	}
+	return &previous
+}
+
+func (r *RecordDB) AllProjects() []Project {
+	var projects []Project
+	r.db.Order("id").Find(&projects)
+	return projects
+}
+
+func (r *RecordDB) GetProject(projectId uint) *Project {
+	var project Project
+	if tx := r.db.First(&project, projectId); tx.Error != nil {
+		return nil
+	}
+	return &project
+}
+
+func (r *RecordDB) GetReport(reportId uint) *Report {
+	var report Report
+	if tx := r.db.First(&report, reportId); tx.Error != nil {
+		return nil
+	}
+	return &report
 }
 
 func (r *RecordDB) GetReports(reportGroupId uint) []Report {
@@ -152,7 +282,9 @@ func (r *RecordDB) GetTestLogs(testId uint) []TestLog {
 
 func (r *RecordDB) LoadReportGroup(reportGroupId uint) *ReportGroup {
 	var reportGroup ReportGroup
-	r.db.Where("id = ?", reportGroupId).First(&reportGroup)
+	if tx := r.db.Where("id = ?", reportGroupId).First(&reportGroup); tx.Error != nil {
+		return nil
+	}
 	reportGroup.Reports = r.GetReports(reportGroupId)
 	for l, report := range reportGroup.Reports {
 		report.TestGroups = r.GetTestGroups(report.ID)
@@ -167,10 +299,52 @@ func (r *RecordDB) LoadReportGroup(reportGroupId uint) *ReportGroup {
 	return &reportGroup
 }
 
+// LoadReport loads a report with its test groups and tests, and their logs
+// only if withLogs is set since they make up most of the data.
+func (r *RecordDB) LoadReport(reportId uint, withLogs bool) *Report {
+	var report Report
+	if tx := r.db.First(&report, reportId); tx.Error != nil {
+		return nil
+	}
+
+	report.TestGroups = r.GetTestGroups(report.ID)
+	for i, testGroup := range report.TestGroups {
+		report.TestGroups[i].Tests = r.GetTests(testGroup.ID)
+		if withLogs {
+			for j, test := range report.TestGroups[i].Tests {
+				report.TestGroups[i].Tests[j].Logs = r.GetTestLogs(test.ID)
+			}
+		}
+	}
+
+	return &report
+}
+
 func (r *RecordDB) StoreReportTestMetrics(metrics ReportTestMetrics) {
 	r.db.Create(&metrics).Clauses(clause.OnConflict{DoNothing: true})
 }
 
+// ReplaceReportTestMetrics swaps the stored metrics of a report for the given
+// ones and marks the report's metrics as generated.
+func (r *RecordDB) ReplaceReportTestMetrics(reportId uint, metrics []ReportTestMetrics) error {
+	return r.Transaction(func(tx *RecordDB) error {
+		if err := tx.db.Unscoped().Where("report_id = ?", reportId).Delete(&ReportTestMetrics{}).Error; err != nil {
+			return err
+		}
+
+		for i := range metrics {
+			metrics[i].ReportID = reportId
+		}
+		if len(metrics) > 0 {
+			if err := tx.db.CreateInBatches(metrics, 500).Error; err != nil {
+				return err
+			}
+		}
+
+		return tx.db.Model(&Report{}).Where("id = ?", reportId).Update("metrics_generated", true).Error
+	})
+}
+
 func (r *RecordDB) GetReportTestMetrics(reportId uint) []ReportTestMetrics {
 	var metrics []ReportTestMetrics
 	r.db.Where("report_id = ?", reportId).Find(&metrics)
@@ -185,7 +359,13 @@ func (r *RecordDB) GetReportIDsWithTestMetrics() []uint {
 
 func (r *RecordDB) GetReportIDsWithoutTestMetrics() []uint {
 	var ids []uint
-	r.db.Model(&Report{}).Where("id NOT IN (?)", r.GetReportIDsWithTestMetrics()).Pluck("id", &ids)
+	r.db.Model(&Report{}).Where("metrics_generated = ?", false).Pluck("id", &ids)
+	return ids
+}
+
+func (r *RecordDB) AllReportIDs() []uint {
+	var ids []uint
+	r.db.Model(&Report{}).Order("id").Pluck("id", &ids)
 	return ids
 }
 
@@ -198,7 +378,7 @@ func (r *RecordDB) FindOrCreateReportGroupByLabel(projectId uint, label string)
 			Label:     label,
 		}
 		if id, err := r.StoreReportGroup(rg); err != nil {
-			fmt.Printf("Error creating report group: %v\n", err)
+			fmt.Fprintf(r.Progress, "Error creating report group: %v\n", err)
 			return nil
 		} else {
 			rg.ID = id
@@ -206,3 +386,543 @@ func (r *RecordDB) FindOrCreateReportGroupByLabel(projectId uint, label string)
 		}
 	}
 }
+
+func (r *RecordDB) GetTestCase(testCaseId uint) *TestCase {
+	var testCase TestCase
+	if tx := r.db.Preload("Aliases").First(&testCase, testCaseId); tx.Error != nil {
+		return nil
+	} else {
+		return &testCase
+	}
+}
+
+func (r *RecordDB) AllTestCases() []TestCase {
+	var testCases []TestCase
+	r.db.Find(&testCases)
+	return testCases
+}
+
+// FindTestCase resolves a package and test name to its canonical TestCase,
+// following rename aliases if there is no direct match.
+func (r *RecordDB) FindTestCase(pkg string, name string) *TestCase {
+	var testCase TestCase
+	if tx := r.db.First(&testCase, "package = ? AND name = ?", pkg, name); tx.Error == nil {
+		return &testCase
+	}
+
+	var alias TestCaseAlias
+	if tx := r.db.First(&alias, "package = ? AND name = ?", pkg, name); tx.Error != nil {
+		return nil
+	} else {
+		return r.GetTestCase(alias.TestCaseID)
+	}
+}
+
+func (r *RecordDB) FindOrCreateTestCase(pkg string, name string, seen int64) *TestCase {
+	if testCase := r.FindTestCase(pkg, name); testCase != nil {
+		updated := false
+		if seen > 0 && (testCase.FirstSeen == 0 || seen < testCase.FirstSeen) {
+			testCase.FirstSeen = seen
+			updated = true
+		}
+		if seen > testCase.LastSeen {
+			testCase.LastSeen = seen
+			updated = true
+		}
+		if updated {
+			r.db.Model(testCase).Updates(map[string]interface{}{
+				"first_seen": testCase.FirstSeen,
+				"last_seen":  testCase.LastSeen,
+			})
+		}
+		return testCase
+	} else {
+		tc := TestCase{
+			Package:   pkg,
+			Name:      name,
+			FirstSeen: seen,
+			LastSeen:  seen,
+		}
+		if tx := r.db.Create(&tc); tx.Error != nil {
+			fmt.Fprintf(r.Progress, "Error creating test case: %v\n", tx.Error)
+			return nil
+		} else {
+			return &tc
+		}
+	}
+}
+
+// AssignTestCases resolves the TestCase of every test in the report so that
+// the rows reference it when the report is stored.
+func (r *RecordDB) AssignTestCases(report *Report) {
+	for i, group := range report.TestGroups {
+		for j, test := range group.Tests {
+			if testCase := r.FindOrCreateTestCase(group.Label, test.Label, test.Start); testCase != nil {
+				report.TestGroups[i].Tests[j].TestCaseID = testCase.ID
+			}
+		}
+	}
+}
+
+// AddTestCaseAlias records that a test previously known as pkg / name is now
+// the test case testCaseId. If a separate test case already exists under the
+// old name its results, metrics and quarantine entries are moved to
+// testCaseId and it is removed.
+func (r *RecordDB) AddTestCaseAlias(testCaseId uint, pkg string, name string) error {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var target TestCase
+		if err := tx.First(&target, testCaseId).Error; err != nil {
+			return fmt.Errorf("unable to find test case %d: %w", testCaseId, err)
+		}
+		if target.Package == pkg && target.Name == name {
+			return fmt.Errorf("test case %d is named %s", testCaseId, target.FullName())
+		}
+
+		var previous TestCase
+		if err := tx.First(&previous, "package = ? AND name = ?", pkg, name).Error; err == nil && previous.ID != target.ID {
+			if err := tx.Model(&Test{}).Where("test_case_id = ?", previous.ID).Update("test_case_id", target.ID).Error; err != nil {
+				return fmt.Errorf("unable to move tests to test case %d: %w", target.ID, err)
+			}
+			if err := tx.Model(&ReportTestMetrics{}).Where("test_case_id = ?", previous.ID).Update("test_case_id", target.ID).Error; err != nil {
+				return fmt.Errorf("unable to move metrics to test case %d: %w", target.ID, err)
+			}
+			if err := tx.Model(&TestCaseAlias{}).Where("test_case_id = ?", previous.ID).Update("test_case_id", target.ID).Error; err != nil {
+				return fmt.Errorf("unable to move aliases to test case %d: %w", target.ID, err)
+			}
+			if r.findActiveQuarantineEntry(tx, target.ID) != nil {
+				// Keep a single active entry, the one of the current name
+				if err := tx.Model(&QuarantineEntry{}).Where("test_case_id = ? AND released_at = ?", previous.ID, 0).Updates(map[string]interface{}{
+					"released_at":    time.Now().UnixMilli(),
+					"release_reason": fmt.Sprintf("merged into test case %d", target.ID),
+				}).Error; err != nil {
+					return fmt.Errorf("unable to release quarantine entries of test case %d: %w", previous.ID, err)
+				}
+			}
+			if err := tx.Model(&QuarantineEntry{}).Where("test_case_id = ?", previous.ID).Update("test_case_id", target.ID).Error; err != nil {
+				return fmt.Errorf("unable to move quarantine entries to test case %d: %w", target.ID, err)
+			}
+			// An active entry skips the test under its current name
+			if err := tx.Model(&QuarantineEntry{}).Where("test_case_id = ? AND released_at = ?", target.ID, 0).Updates(map[string]interface{}{
+				"package": target.Package,
+				"name":    target.Name,
+			}).Error; err != nil {
+				return fmt.Errorf("unable to rename quarantine entries of test case %d: %w", target.ID, err)
+			}
+			if previous.FirstSeen != 0 && (target.FirstSeen == 0 || previous.FirstSeen < target.FirstSeen) {
+				target.FirstSeen = previous.FirstSeen
+			}
+			if previous.LastSeen > target.LastSeen {
+				target.LastSeen = previous.LastSeen
+			}
+			if err := tx.Save(&target).Error; err != nil {
+				return err
+			}
+			if err := tx.Unscoped().Delete(&previous).Error; err != nil {
+				return fmt.Errorf("unable to remove test case %d: %w", previous.ID, err)
+			}
+		}
+
+		alias := TestCaseAlias{
+			TestCaseID: target.ID,
+			Package:    pkg,
+			Name:       name,
+		}
+		return tx.Clauses(clause.OnConflict{
+			Columns:   []clause.Column{{Name: "package"}, {Name: "name"}},
+			DoUpdates: clause.AssignmentColumns([]string{"test_case_id", "updated_at"}),
+		}).Create(&alias).Error
+	})
+
+	if err == nil {
+		r.BumpDataVersion()
+	}
+
+	return err
+}
+
+// BackfillTestCases assigns a TestCase to every stored test that was ingested
+// before test cases existed, then to the metrics rows of those tests so that
+// old and new metrics aggregate into the same test. It returns the number of
+// tests and of metrics rows assigned.
+func (r *RecordDB) BackfillTestCases() (int, int) {
+	type row struct {
+		ID      uint
+		Label   string
+		Start   int64
+		Package string
+	}
+
+	var rows []row
+	r.db.Table("tests").
+		Select("tests.id, tests.label, tests.start, test_groups.label AS package").
+		Joins("JOIN test_groups ON test_groups.id = tests.test_group_id").
+		Where("tests.test_case_id = 0 OR tests.test_case_id IS NULL").
+		Where("tests.deleted_at IS NULL").
+		Scan(&rows)
+
+	for i, t := range rows {
+		fmt.Fprintf(r.Progress, "[%d/%d] Assigning test case for test %d\r", i+1, len(rows), t.ID)
+		if testCase := r.FindOrCreateTestCase(t.Package, t.Label, t.Start); testCase != nil {
+			r.db.Model(&Test{}).Where("id = ?", t.ID).Update("test_case_id", testCase.ID)
+		}
+	}
+
+	if len(rows) > 0 {
+		fmt.Fprintln(r.Progress)
+	}
+
+	// Metrics rows name the test by label only, within their report
+	tests := `FROM tests
+		JOIN test_groups ON test_groups.id = tests.test_group_id
+		WHERE test_groups.report_id = report_test_metrics.report_id AND tests.label = report_test_metrics.test_label
+		AND tests.test_case_id > 0 AND tests.deleted_at IS NULL`
+	tx := r.db.Model(&ReportTestMetrics{}).
+		Where("test_case_id = 0 OR test_case_id IS NULL").
+		Where("EXISTS (SELECT 1 "+tests+")").
+		Update("test_case_id", gorm.Expr("(SELECT MIN(tests.test_case_id) "+tests+")"))
+	if tx.Error != nil {
+		fmt.Fprintf(r.Progress, "Error assigning test cases to metrics: %v\n", tx.Error)
+	}
+
+	return len(rows), int(tx.RowsAffected)
+}
+
+// DataVersion returns the current data version, zero if nothing has been
+// ingested since the counter was introduced.
+func (r *RecordDB) DataVersion() uint64 {
+	var version DataVersion
+	if tx := r.db.First(&version, 1); tx.Error != nil {
+		return 0
+	} else {
+		return version.Version
+	}
+}
+
+func (r *RecordDB) BumpDataVersion() uint64 {
+	version := DataVersion{ID: 1, Version: 1, UpdatedAt: time.Now()}
+	r.db.Clauses(clause.OnConflict{
+		Columns: []clause.Column{{Name: "id"}},
+		DoUpdates: clause.Assignments(map[string]interface{}{
+			"version":    gorm.Expr("version + 1"),
+			"updated_at": version.UpdatedAt,
+		}),
+	}).Create(&version)
+	return r.DataVersion()
+}
+
+// StartIngestion records the start of an ingestion by command.
+func (r *RecordDB) StartIngestion(command string) *Ingestion {
+	ingestion := Ingestion{Command: command, StartedAt: time.Now()}
+	if tx := r.db.Create(&ingestion); tx.Error != nil {
+		fmt.Fprintf(r.Progress, "Unable to record ingestion: %v\n", tx.Error)
+	}
+	return &ingestion
+}
+
+// UpdateIngestion stores the progress of a running ingestion.
+func (r *RecordDB) UpdateIngestion(ingestion *Ingestion) {
+	r.db.Save(ingestion)
+}
+
+// FinishIngestion records the end of an ingestion, which failed if err is
+// not nil.
+func (r *RecordDB) FinishIngestion(ingestion *Ingestion, err error) {
+	if err != nil {
+		ingestion.Errors += 1
+		ingestion.LastError = err.Error()
+	}
+	ingestion.FinishedAt = time.Now()
+	r.UpdateIngestion(ingestion)
+}
+
+// GetLatestIngestion returns the most recently started ingestion, nil if
+// there are none.
+func (r *RecordDB) GetLatestIngestion() *Ingestion {
+	var ingestion Ingestion
+	if tx := r.db.Order("id DESC").First(&ingestion); tx.Error != nil {
+		return nil
+	}
+	return &ingestion
+}
+
+// GetIngestionErrorCounts sums the errors and unparseable records of every
+// ingestion.
+func (r *RecordDB) GetIngestionErrorCounts() (errors int, recordErrors int) {
+	var totals struct {
+		Errors       int
+		RecordErrors int
+	}
+	r.db.Model(&Ingestion{}).Select("COALESCE(SUM(errors), 0) AS errors, COALESCE(SUM(record_errors), 0) AS record_errors").Scan(&totals)
+	return totals.Errors, totals.RecordErrors
+}
+
+// GetTestOutcomes returns every pass or fail result of reports whose metrics
+// have been generated, ordered by start time.
+func (r *RecordDB) GetTestOutcomes() []TestOutcome {
+	var outcomes []TestOutcome
+	r.outcomeQuery().Scan(&outcomes)
+	return outcomes
+}
+
+// GetTestCaseOutcomes is GetTestOutcomes for a single test case.
+func (r *RecordDB) GetTestCaseOutcomes(testCaseId uint) []TestOutcome {
+	var outcomes []TestOutcome
+	r.outcomeQuery().Where("tests.test_case_id = ?", testCaseId).Scan(&outcomes)
+	return outcomes
+}
+
+// GetTestResult returns a single result of any status flattened together
+// with its report and run, nil if there is no such result.
+func (r *RecordDB) GetTestResult(testId uint) *TestOutcome {
+	var outcomes []TestOutcome
+	r.resultQuery().Where("tests.id = ?", testId).Scan(&outcomes)
+	if len(outcomes) == 0 {
+		return nil
+	}
+	return &outcomes[0]
+}
+
+// EachTestLog calls fn with the log lines of a test in order, reading them
+// from the database as they are consumed, and stops at the first error.
+func (r *RecordDB) EachTestLog(testId uint, fn func(log TestLog) error) error {
+	rows, err := r.db.Model(&TestLog{}).Where("test_id = ?", testId).Order("timestamp, id").Rows()
+	if err != nil {
+		return err
+	}
+	defer func() {
+		_ = rows.Close()
+	}()
+
+	for rows.Next() {
+		var log TestLog
+		if err := r.db.ScanRows(rows, &log); err != nil {
+			return err
+		}
+		if err := fn(log); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (r *RecordDB) outcomeQuery() *gorm.DB {
+	return r.resultQuery().
+		Where("tests.status IN ?", []string{"pass", "fail"}).
+		Where("reports.metrics_generated = ?", true).
+		Order("tests.start, tests.id")
+}
+
+func (r *RecordDB) resultQuery() *gorm.DB {
+	return r.db.Table("tests").
+		Select("tests.id AS test_id, tests.test_case_id, tests.label, test_groups.label AS package, tests.status, tests.start, tests.end, " +
+			"reports.id AS report_id, reports.label AS report_label, report_groups.id AS report_group_id, report_groups.label AS run_label, " +
+			"report_groups.run_id, report_groups.head_sha, report_groups.head_branch, report_groups.event, tests.failure_message, tests.failure_signature, tests.failure_category").
+		Joins("JOIN test_groups ON test_groups.id = tests.test_group_id").
+		Joins("JOIN reports ON reports.id = test_groups.report_id").
+		Joins("JOIN report_groups ON report_groups.id = reports.report_group_id").
+		Where("tests.deleted_at IS NULL AND reports.deleted_at IS NULL AND report_groups.deleted_at IS NULL")
+}
+
+// UpdateRunMetadata fills in the workflow run details of a report group that
+// was stored without them, or corrects them. A known event is kept if the
+// metadata has none. If the report group changes, the data version is bumped
+// in the same transaction, since views built from the old details are stale.
+func (r *RecordDB) UpdateRunMetadata(reportGroup *ReportGroup, metadata RunMetadata) error {
+	event := reportGroup.Event
+	if metadata.Event != "" {
+		event = metadata.Event
+	}
+	createdAt := reportGroup.RunCreatedAt
+	if createdAt == 0 || (metadata.CreatedAt != 0 && metadata.CreatedAt < createdAt) {
+		createdAt = metadata.CreatedAt
+	}
+	if reportGroup.RunID == metadata.RunID && reportGroup.HeadSHA == metadata.HeadSHA && reportGroup.HeadBranch == metadata.HeadBranch &&
+		reportGroup.Event == event && reportGroup.RunCreatedAt == createdAt {
+		return nil
+	}
+
+	updated := *reportGroup
+	updated.RunID = metadata.RunID
+	updated.HeadSHA = metadata.HeadSHA
+	updated.HeadBranch = metadata.HeadBranch
+	updated.Event = event
+	updated.RunCreatedAt = createdAt
+
+	err := r.Transaction(func(tx *RecordDB) error {
+		if err := tx.db.Model(&updated).Updates(map[string]interface{}{
+			"run_id":         updated.RunID,
+			"head_sha":       updated.HeadSHA,
+			"head_branch":    updated.HeadBranch,
+			"event":          updated.Event,
+			"run_created_at": updated.RunCreatedAt,
+		}).Error; err != nil {
+			return err
+		}
+		tx.BumpDataVersion()
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("unable to update run of report group %d: %w", reportGroup.ID, err)
+	}
+
+	*reportGroup = updated
+	return nil
+}
+
+func (r *RecordDB) SetTestFailure(testId uint, message string, signature string) error {
+	return r.db.Model(&Test{}).Where("id = ?", testId).Updates(map[string]interface{}{
+		"failure_message":   message,
+		"failure_signature": signature,
+	}).Error
+}
+
+// GetReportIDsWithFailures returns the reports containing at least one
+// failed test.
+func (r *RecordDB) GetReportIDsWithFailures() []uint {
+	var ids []uint
+	r.db.Table("test_groups").
+		Joins("JOIN tests ON tests.test_group_id = test_groups.id").
+		Where("tests.status = ? AND tests.deleted_at IS NULL", "fail").
+		Distinct("test_groups.report_id").
+		Order("test_groups.report_id").
+		Pluck("test_groups.report_id", &ids)
+	return ids
+}
+
+func (r *RecordDB) SetTestFailureCategory(testId uint, category string) error {
+	return r.db.Model(&Test{}).Where("id = ?", testId).Update("failure_category", category).Error
+}
+
+// GetFailureCategoryCounts counts the failed tests of every run by failure
+// category, keyed on report group ID.
+func (r *RecordDB) GetFailureCategoryCounts() map[uint]map[string]int {
+	var rows []struct {
+		ReportGroupID   uint
+		FailureCategory string
+		Count           int
+	}
+	r.db.Table("tests").
+		Select("reports.report_group_id, tests.failure_category, COUNT(*) AS count").
+		Joins("JOIN test_groups ON test_groups.id = tests.test_group_id").
+		Joins("JOIN reports ON reports.id = test_groups.report_id").
+		Where("tests.status = ? AND tests.deleted_at IS NULL AND reports.deleted_at IS NULL", "fail").
+		Group("reports.report_group_id, tests.failure_category").
+		Scan(&rows)
+
+	result := make(map[uint]map[string]int)
+	for _, row := range rows {
+		if _, ok := result[row.ReportGroupID]; !ok {
+			result[row.ReportGroupID] = make(map[string]int)
+		}
+		result[row.ReportGroupID][row.FailureCategory] += row.Count
+	}
+	return result
+}
+
+// GetQuarantineEntries returns the active quarantine entries, or every entry
+// ever made when includeReleased is set, newest first.
+func (r *RecordDB) GetQuarantineEntries(includeReleased bool) []QuarantineEntry {
+	var entries []QuarantineEntry
+	tx := r.db.Order("id DESC")
+	if !includeReleased {
+		tx = tx.Where("released_at = ?", 0)
+	}
+	tx.Find(&entries)
+	return entries
+}
+
+func (r *RecordDB) findActiveQuarantineEntry(tx *gorm.DB, testCaseId uint) *QuarantineEntry {
+	var entry QuarantineEntry
+	if err := tx.Where("test_case_id = ? AND released_at = ?", testCaseId, 0).First(&entry).Error; err != nil {
+		return nil
+	}
+	return &entry
+}
+
+// QuarantineTestCase adds an active quarantine entry for a test case.
+func (r *RecordDB) QuarantineTestCase(testCaseId uint, by string, reason string) (*QuarantineEntry, error) {
+	var entry QuarantineEntry
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var testCase TestCase
+		if err := tx.First(&testCase, testCaseId).Error; err != nil {
+			return fmt.Errorf("unable to find test case %d: %w", testCaseId, err)
+		}
+		if r.findActiveQuarantineEntry(tx, testCaseId) != nil {
+			return ErrAlreadyQuarantined
+		}
+
+		entry = QuarantineEntry{
+			TestCaseID:    testCase.ID,
+			Package:       testCase.Package,
+			Name:          testCase.Name,
+			Reason:        reason,
+			QuarantinedBy: by,
+			QuarantinedAt: time.Now().UnixMilli(),
+		}
+		return tx.Create(&entry).Error
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	r.BumpDataVersion()
+	return &entry, nil
+}
+
+// ReleaseTestCase releases the active quarantine entry of a test case.
+func (r *RecordDB) ReleaseTestCase(testCaseId uint, by string, reason string) (*QuarantineEntry, error) {
+	var entry *QuarantineEntry
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if entry = r.findActiveQuarantineEntry(tx, testCaseId); entry == nil {
+			return ErrNotQuarantined
+		}
+
+		entry.ReleasedAt = time.Now().UnixMilli()
+		entry.ReleasedBy = by
+		entry.ReleaseReason = reason
+		return tx.Save(entry).Error
+	})
+
+	if err != nil {
+		return nil, err
+	}
+	r.BumpDataVersion()
+	return entry, nil
+}
+
+// GetRunBranches returns the distinct head branches of the stored runs.
+func (r *RecordDB) GetRunBranches() []string {
+	var branches []string
+	r.db.Model(&ReportGroup{}).Where("head_branch <> ?", "").Distinct("head_branch").Order("head_branch").Pluck("head_branch", &branches)
+	return branches
+}
+
+// GetRunEvents returns the distinct events that triggered the stored runs.
+func (r *RecordDB) GetRunEvents() []string {
+	var events []string
+	r.db.Model(&ReportGroup{}).Where("event <> ?", "").Distinct("event").Order("event").Pluck("event", &events)
+	return events
+}
+
+// GetTestCasePackages returns the distinct packages of the test cases.
+func (r *RecordDB) GetTestCasePackages() []string {
+	var packages []string
+	r.db.Model(&TestCase{}).Distinct("package").Order("package").Pluck("package", &packages)
+	return packages
+}
+
+// HasTestCaseGroup reports whether there is a test case named name or a
+// subtest of it.
+func (r *RecordDB) HasTestCaseGroup(name string) bool {
+	var count int64
+	r.db.Model(&TestCase{}).Where("name = ? OR instr(name, ?) = 1", name, name+"/").Count(&count)
+	return count > 0
+}
+
+// GetFailureCategories returns the distinct categories assigned to failed
+// tests.
+func (r *RecordDB) GetFailureCategories() []string {
+	var categories []string
+	r.db.Model(&Test{}).Where("status = ? AND failure_category <> ?", "fail", "").Distinct("failure_category").Order("failure_category").Pluck("failure_category", &categories)
+	return categories
+}
@@ -0,0 +1,203 @@
+package ingestion
+
+import (
+	"strings"
+	"testing"
+)
+
+// storeTestReport stores a report of run label with the given tests of
+// package pkg, resolving their test cases if assign is set.
+func storeTestReport(t *testing.T, db *RecordDB, label string, pkg string, tests []Test, assign bool) *Report {
+	t.Helper()
+	project := db.FindOrCreateProjectByLabel("dapr")
+	group := db.FindOrCreateReportGroupByLabel(project.ID, label)
+	report := &Report{
+		ReportGroupID: group.ID,
+		Label:         "unit",
+		TestGroups:    []TestGroup{{Label: pkg, Tests: tests}},
+		// as if the metrics stage had run, so the results are outcomes
+		MetricsGenerated: true,
+	}
+	if assign {
+		db.AssignTestCases(report)
+	}
+	if err := db.StoreReportWithStages(report, nil); err != nil {
+		t.Fatal(err)
+	}
+	return report
+}
+
+func TestFindOrCreateTestCase(t *testing.T) {
+	db := openTestDB(t, "test.db")
+
+	tc := db.FindOrCreateTestCase("pkg", "TestA", 200)
+	if tc == nil || tc.FirstSeen != 200 || tc.LastSeen != 200 {
+		t.Fatalf("got %+v", tc)
+	}
+
+	again := db.FindOrCreateTestCase("pkg", "TestA", 100)
+	if again.ID != tc.ID || again.FirstSeen != 100 || again.LastSeen != 200 {
+		t.Errorf("got %+v after an earlier result", again)
+	}
+	again = db.FindOrCreateTestCase("pkg", "TestA", 300)
+	if again.ID != tc.ID || again.FirstSeen != 100 || again.LastSeen != 300 {
+		t.Errorf("got %+v after a later result", again)
+	}
+	if stored := db.GetTestCase(tc.ID); stored.FirstSeen != 100 || stored.LastSeen != 300 {
+		t.Errorf("stored %+v", stored)
+	}
+
+	if other := db.FindOrCreateTestCase("other", "TestA", 100); other.ID == tc.ID {
+		t.Error("the same name in another package got the same test case")
+	}
+	if len(db.AllTestCases()) != 2 {
+		t.Errorf("got %d test cases, want 2", len(db.AllTestCases()))
+	}
+}
+
+func TestAssignTestCases(t *testing.T) {
+	db := openTestDB(t, "test.db")
+
+	first := storeTestReport(t, db, "run-1", "pkg", []Test{
+		{Label: "TestA", Status: "pass", Start: 100},
+		{Label: "TestA/sub", Status: "pass", Start: 100},
+	}, true)
+	second := storeTestReport(t, db, "run-2", "pkg", []Test{{Label: "TestA", Status: "fail", Start: 200}}, true)
+
+	a := db.FindTestCase("pkg", "TestA")
+	if a == nil || a.FirstSeen != 100 || a.LastSeen != 200 {
+		t.Fatalf("got %+v", a)
+	}
+	if first.TestGroups[0].Tests[0].TestCaseID != a.ID || second.TestGroups[0].Tests[0].TestCaseID != a.ID {
+		t.Error("results of the same test got different test cases")
+	}
+	if sub := first.TestGroups[0].Tests[1].TestCaseID; sub == 0 || sub == a.ID {
+		t.Errorf("got test case %d for the subtest", sub)
+	}
+	if outcomes := db.GetTestCaseOutcomes(a.ID); len(outcomes) != 2 {
+		t.Errorf("got %d outcomes for TestA, want 2", len(outcomes))
+	}
+}
+
+func TestAddTestCaseAlias(t *testing.T) {
+	db := openTestDB(t, "test.db")
+
+	storeTestReport(t, db, "run-1", "pkg/old", []Test{{Label: "TestA", Status: "fail", Start: 100}}, true)
+	report := storeTestReport(t, db, "run-2", "pkg/new", []Test{{Label: "TestA", Status: "pass", Start: 200}}, true)
+	previous, current := db.FindTestCase("pkg/old", "TestA"), db.FindTestCase("pkg/new", "TestA")
+	if err := db.ReplaceReportTestMetrics(report.ID, []ReportTestMetrics{{TestCaseID: previous.ID, TestLabel: "TestA", PassCount: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	version := db.DataVersion()
+
+	if err := db.AddTestCaseAlias(current.ID, "pkg/old", "TestA"); err != nil {
+		t.Fatal(err)
+	}
+
+	if db.GetTestCase(previous.ID) != nil {
+		t.Error("the previous test case was kept")
+	}
+	if tc := db.FindTestCase("pkg/old", "TestA"); tc == nil || tc.ID != current.ID {
+		t.Errorf("got %+v for the old name, want test case %d", tc, current.ID)
+	}
+	if merged := db.GetTestCase(current.ID); merged.FirstSeen != 100 || merged.LastSeen != 200 {
+		t.Errorf("got %+v, want the history of both", merged)
+	}
+	if outcomes := db.GetTestCaseOutcomes(current.ID); len(outcomes) != 2 {
+		t.Errorf("got %d outcomes, want the results of both", len(outcomes))
+	}
+	if metrics := db.GetReportTestMetrics(report.ID); len(metrics) != 1 || metrics[0].TestCaseID != current.ID {
+		t.Errorf("got metrics %+v, want them moved to test case %d", metrics, current.ID)
+	}
+	if db.DataVersion() == version {
+		t.Error("the merge did not bump the data version")
+	}
+
+	// aliasing again is idempotent
+	if err := db.AddTestCaseAlias(current.ID, "pkg/old", "TestA"); err != nil {
+		t.Error(err)
+	}
+
+	for _, tt := range []struct {
+		id   uint
+		pkg  string
+		name string
+		err  string
+	}{
+		{id: current.ID, pkg: "pkg/new", name: "TestA", err: "is named"},
+		{id: 999, pkg: "pkg", name: "TestB", err: "unable to find"},
+	} {
+		if err := db.AddTestCaseAlias(tt.id, tt.pkg, tt.name); err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("alias %s.%s of %d: got error %v, want %q", tt.pkg, tt.name, tt.id, err, tt.err)
+		}
+	}
+}
+
+func TestAddTestCaseAliasQuarantine(t *testing.T) {
+	db := openTestDB(t, "test.db")
+
+	storeTestReport(t, db, "run-1", "pkg/old", []Test{{Label: "TestA", Status: "fail", Start: 100}}, true)
+	storeTestReport(t, db, "run-2", "pkg/new", []Test{{Label: "TestA", Status: "pass", Start: 200}}, true)
+	previous, current := db.FindTestCase("pkg/old", "TestA"), db.FindTestCase("pkg/new", "TestA")
+	if _, err := db.QuarantineTestCase(previous.ID, "tester", "old"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := db.QuarantineTestCase(current.ID, "tester", "new"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := db.AddTestCaseAlias(current.ID, "pkg/old", "TestA"); err != nil {
+		t.Fatal(err)
+	}
+
+	active := db.GetQuarantineEntries(false)
+	if len(active) != 1 || active[0].TestCaseID != current.ID || active[0].Reason != "new" {
+		t.Fatalf("got active entries %+v, want the one of the current name", active)
+	}
+	all := db.GetQuarantineEntries(true)
+	if len(all) != 2 {
+		t.Fatalf("got %d entries, want 2", len(all))
+	}
+	for _, e := range all {
+		if e.Reason == "old" && (e.Active() || e.TestCaseID != current.ID || !strings.Contains(e.ReleaseReason, "merged")) {
+			t.Errorf("got %+v for the entry of the previous name", e)
+		}
+	}
+}
+
+func TestBackfillTestCases(t *testing.T) {
+	db := openTestDB(t, "test.db")
+
+	// stored before test cases existed
+	report := storeTestReport(t, db, "run-1", "pkg", []Test{
+		{Label: "TestA", Status: "pass", Start: 100},
+		{Label: "TestB", Status: "fail", Start: 100},
+	}, false)
+	if err := db.ReplaceReportTestMetrics(report.ID, []ReportTestMetrics{
+		{TestLabel: "TestA", PassCount: 1},
+		{TestLabel: "TestB", FailCount: 1},
+		{TestLabel: "TestGone", FailCount: 1},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests, metrics := db.BackfillTestCases()
+	if tests != 2 || metrics != 2 {
+		t.Fatalf("assigned %d tests and %d metrics rows, want 2 and 2", tests, metrics)
+	}
+
+	a, b := db.FindTestCase("pkg", "TestA"), db.FindTestCase("pkg", "TestB")
+	if a == nil || b == nil {
+		t.Fatal("no test cases were created")
+	}
+	want := map[string]uint{"TestA": a.ID, "TestB": b.ID, "TestGone": 0}
+	for _, m := range db.GetReportTestMetrics(report.ID) {
+		if m.TestCaseID != want[m.TestLabel] {
+			t.Errorf("got test case %d for the metrics of %s, want %d", m.TestCaseID, m.TestLabel, want[m.TestLabel])
+		}
+	}
+
+	if tests, metrics := db.BackfillTestCases(); tests != 0 || metrics != 0 {
+		t.Errorf("assigned %d tests and %d metrics rows again", tests, metrics)
+	}
+}
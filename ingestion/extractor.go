@@ -4,13 +4,12 @@ import (
 	"archive/zip"
 	"encoding/json"
 	"fmt"
+	"github.com/google/go-github/v48/github"
 	"io"
-	"os"
-	"path"
 	"strings"
 )
 
-func extractReport(zipfilePath string) ([]byte, error) {
+func extractReport(zipfilePath string, progress io.Writer) ([]byte, error) {
 	if r, err := zip.OpenReader(zipfilePath); err != nil {
 		return nil, err
 	} else {
@@ -18,7 +17,7 @@ func extractReport(zipfilePath string) ([]byte, error) {
 
 		for _, f := range r.File {
 			if strings.Contains(f.Name, "e2e") {
-				fmt.Printf("Found report file %s\n", f.Name)
+				fmt.Fprintf(progress, "Found report file %s\n", f.Name)
 				if rc, err := f.Open(); err != nil {
 					return nil, err
 				} else {
@@ -33,6 +32,33 @@ func extractReport(zipfilePath string) ([]byte, error) {
 	}
 }
 
+func updateRunMetadata(db *RecordDB, store ArtifactStore, reportGroup *ReportGroup, artifact *github.Artifact) error {
+	var metadata RunMetadata
+	if run := artifact.WorkflowRunMetadata; run != nil {
+		if run.ID != nil {
+			metadata.RunID = *run.ID
+		}
+		if run.HeadSHA != nil {
+			metadata.HeadSHA = *run.HeadSHA
+		}
+		if run.HeadBranch != nil {
+			metadata.HeadBranch = *run.HeadBranch
+		}
+	}
+
+	if artifact.CreatedAt != nil {
+		metadata.CreatedAt = artifact.CreatedAt.UnixMilli()
+	}
+
+	if run, err := store.LoadWorkflowRun(metadata.RunID); err != nil {
+		fmt.Fprintf(db.Progress, "Unable to load workflow run %d: %v\n", metadata.RunID, err)
+	} else if run != nil {
+		metadata.Event = run.GetEvent()
+	}
+
+	return db.UpdateRunMetadata(reportGroup, metadata)
+}
+
 func GenerateReport(reportLabel string, db *RecordDB, records []TestRecord) Report {
 	report := Report{
 		Label: reportLabel,
@@ -91,35 +117,84 @@ func GenerateReport(reportLabel string, db *RecordDB, records []TestRecord) Repo
 	return report
 }
 
-func ExtractResults(db *RecordDB) error {
-	reportPath := os.Getenv("CACHE_DIR")
+type ExtractOptions struct {
+	CacheDir string
+	// Project is the label of the project the artifacts belong to
+	Project string
+}
+
+// ExtractResults stores every downloaded artifact that has not been extracted
+// yet, running the given stages over each newly stored report. It returns the
+// number of reports stored. Its progress and errors are recorded as an
+// Ingestion.
+func ExtractResults(db *RecordDB, opts ExtractOptions, stages ...Stage) (int, error) {
+	ingestion := db.StartIngestion("extract")
+	count, err := extractResults(db, opts, ingestion, stages)
+	db.FinishIngestion(ingestion, err)
+	return count, err
+}
+
+func extractResults(db *RecordDB, opts ExtractOptions, ingestion *Ingestion, stages []Stage) (int, error) {
+	reportPath := opts.CacheDir
 	if reportPath == "" {
-		if userDir, err := os.UserHomeDir(); err != nil {
-			return fmt.Errorf("unable to determine cache dir via environment or default ~/.cache/dapr-test-analyzer")
+		if dir, err := CacheDir(); err != nil {
+			return 0, err
 		} else {
-			reportPath = path.Join(userDir, ".cache", "dapr-test-analyzer")
+			reportPath = dir
 		}
 	}
 
-	store := ArtifactStore{RootPath: reportPath}
-	projectId := uint(1)
+	store := ArtifactStore{RootPath: reportPath, Progress: db.Progress}
+	project := db.FindOrCreateProjectByLabel(opts.Project)
+	if project == nil {
+		return 0, fmt.Errorf("unable to find or create project")
+	}
+	projectId := project.ID
+	storedCount := 0
 
 	if artifacts, err := store.ListArtifacts(); err != nil {
-		return fmt.Errorf("failed to list artifacts: %w", err)
+		return 0, fmt.Errorf("failed to list artifacts: %w", err)
 	} else {
-		fmt.Printf("Found %d artifacts\n", len(artifacts))
+		fmt.Fprintf(db.Progress, "Found %d artifacts\n", len(artifacts))
+		ingestion.Total = len(artifacts)
+		db.UpdateIngestion(ingestion)
 
 		for _, artifact := range artifacts {
-			fmt.Printf("Extracting %v\n", *artifact.Name)
-			if b, err := extractReport(store.PathToArtifact(artifact)); err != nil {
-				return fmt.Errorf("failed to extract report: %w", err)
+			ingestion.Processed += 1
+			runId := fmt.Sprintf("%d", *artifact.WorkflowRunMetadata.ID)
+			groupLabel := "Workflow Run " + runId
+
+			reportGroup := db.FindOrCreateReportGroupByLabel(projectId, groupLabel)
+			if reportGroup == nil {
+				return storedCount, fmt.Errorf("unable to find or create report group %s", groupLabel)
+			}
+
+			if err := updateRunMetadata(db, store, reportGroup, artifact); err != nil {
+				fmt.Fprintf(db.Progress, "%v\n", err)
+				ingestion.Errors += 1
+				ingestion.LastError = err.Error()
+			}
+
+			if db.FindReportByLabel(reportGroup.ID, *artifact.Name) != nil {
+				fmt.Fprintf(db.Progress, "Report %s already extracted, skipping\n", *artifact.Name)
+				db.UpdateIngestion(ingestion)
+				continue
+			}
+
+			fmt.Fprintf(db.Progress, "Extracting %v\n", *artifact.Name)
+			if b, err := extractReport(store.PathToArtifact(artifact), db.Progress); err != nil {
+				return storedCount, fmt.Errorf("failed to extract report: %w", err)
 			} else {
-				fmt.Printf("Extracted %d bytes from %s\n", len(b), *artifact.Name)
+				fmt.Fprintf(db.Progress, "Extracted %d bytes from %s\n", len(b), *artifact.Name)
 				fData := string(b[:])
 				lines := strings.Split(fData, "\n")
 				errorCount := 0
 				records := make([]TestRecord, 0)
 				for _, line := range lines {
+					// The output ends with a newline, blank lines are not errors
+					if strings.TrimSpace(line) == "" {
+						continue
+					}
 					var rec TestRecord
 					if err := json.Unmarshal([]byte(line), &rec); err != nil {
 						errorCount += 1
@@ -127,39 +202,32 @@ func ExtractResults(db *RecordDB) error {
 						records = append(records, rec)
 					}
 				}
-				fmt.Printf("Extracted %d records from %s with %d errors\n", len(records), *artifact.Name, errorCount)
-				runId := fmt.Sprintf("%d", *artifact.WorkflowRunMetadata.ID)
+				fmt.Fprintf(db.Progress, "Extracted %d records from %s with %d errors\n", len(records), *artifact.Name, errorCount)
+				ingestion.RecordErrors += errorCount
 				report := GenerateReport(*artifact.Name, db, records)
-				groupLabel := "Workflow Run " + runId
-
-				reportGroup := db.FindOrCreateReportGroupByLabel(projectId, groupLabel)
 
 				report.ReportGroupID = reportGroup.ID
+				db.AssignTestCases(&report)
 
-				//fmt.Printf("Report: %v\n", report)
-				if reportId, err := db.StoreReport(report); err == nil {
-					fmt.Printf("Stored report %d\n", reportId)
-					/*for _, g := range report.TestGroups {
-						fmt.Printf("TestGroup: %d\n", g.ReportID)
-						g.ReportID = reportId
-						fmt.Printf("TestGroup: %d\n", g.ReportID)
-						if groupId, err := db.StoreTestGroup(g); err == nil {
-							fmt.Printf("Stored test group %d\n", groupId)
-							for _, t := range g.Tests {
-								t.TestGroupID = groupId
-								if testId, err := db.StoreTest(t); err == nil {
-									for _, l := range t.Logs {
-										l.TestID = testId
-										db.StoreTestLog(l)
-									}
-								}
-							}
-						}
-					}*/
+				if err := db.StoreReportWithStages(&report, stages); err != nil {
+					fmt.Fprintf(db.Progress, "Failed to store report %s: %v\n", *artifact.Name, err)
+					ingestion.Errors += 1
+					ingestion.LastError = fmt.Sprintf("failed to store report %s: %v", *artifact.Name, err)
+				} else {
+					fmt.Fprintf(db.Progress, "Stored report %d\n", report.ID)
+					db.BumpDataVersion()
+					storedCount += 1
+					ingestion.Stored = storedCount
 				}
 			}
+			db.UpdateIngestion(ingestion)
 		}
 	}
 
-	return nil
+	if tests, metrics := db.BackfillTestCases(); tests > 0 || metrics > 0 {
+		fmt.Fprintf(db.Progress, "Assigned test cases to %d previously stored tests and %d metrics rows\n", tests, metrics)
+		db.BumpDataVersion()
+	}
+
+	return storedCount, nil
 }
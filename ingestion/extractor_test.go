@@ -0,0 +1,87 @@
+package ingestion
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"github.com/google/go-github/v48/github"
+	"io"
+	"testing"
+)
+
+// storeArtifact stores an artifact of workflow run runId holding a go test
+// JSON report.
+func storeArtifact(t *testing.T, store ArtifactStore, runId int64, name string, report string) {
+	t.Helper()
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	f, err := zw.Create("e2e-report.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := io.WriteString(f, report); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	artifact := &github.Artifact{
+		Name:                github.String(name),
+		WorkflowRunMetadata: &github.ArtifactWorkflowMetadata{ID: github.Int64(runId), HeadBranch: github.String("master")},
+	}
+	if err := store.Store(artifact, b.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// progressStage records the progress of the ingestion stored when each report
+// is processed.
+type progressStage struct {
+	processed []int
+}
+
+func (s *progressStage) Name() string {
+	return "progress"
+}
+
+func (s *progressStage) Process(db *RecordDB, report *Report) error {
+	s.processed = append(s.processed, db.GetLatestIngestion().Processed)
+	return nil
+}
+
+func TestExtractResults(t *testing.T) {
+	db := openTestDB(t, "test.db")
+	db.Progress = io.Discard
+	opts := ExtractOptions{CacheDir: t.TempDir(), Project: "dapr/dapr"}
+	store := ArtifactStore{RootPath: opts.CacheDir, Progress: io.Discard}
+	storeArtifact(t, store, 1, "e2e-a", `{"Action":"run","Package":"pkg","Test":"TestA"}
+{"Action":"pass","Package":"pkg","Test":"TestA","Elapsed":0.5}
+`)
+
+	if count, err := ExtractResults(db, opts); err != nil || count != 1 {
+		t.Fatalf("stored %d reports, %v", count, err)
+	}
+	if latest := db.GetLatestIngestion(); latest.Total != 1 || latest.Processed != 1 || latest.Stored != 1 || !latest.Finished() {
+		t.Errorf("got ingestion %+v", latest)
+	}
+
+	// the already extracted report still counts as processed while the
+	// new one is stored
+	storeArtifact(t, store, 2, "e2e-b", `{"Action":"run","Package":"pkg","Test":"TestA"}
+{"Action":"fail","Package":"pkg","Test":"TestA","Elapsed":0.5}
+`)
+	stage := &progressStage{}
+	if count, err := ExtractResults(db, opts, stage); err != nil || count != 1 {
+		t.Fatalf("stored %d reports, %v", count, err)
+	}
+	if fmt.Sprint(stage.processed) != "[1]" {
+		t.Errorf("got %v processed artifacts when storing the new report, want [1]", stage.processed)
+	}
+	if latest := db.GetLatestIngestion(); latest.Total != 2 || latest.Processed != 2 || latest.Stored != 1 {
+		t.Errorf("got ingestion %+v", latest)
+	}
+	if groups := db.AllReportGroups(); len(groups) != 2 {
+		t.Errorf("got %d runs, want 2", len(groups))
+	}
+}
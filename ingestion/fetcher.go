@@ -8,73 +8,122 @@ import (
 	"io"
 	"log"
 	http "net/http"
-	"os"
-	"path"
 	"strings"
 )
 
-func FetchResults(limit int, maxPages int) error {
-	token := os.Getenv("GITHUB_TOKEN")
-	outPath := os.Getenv("CACHE_DIR")
+// NewGitHubClient returns a client authenticated with token, or an anonymous
+// one if token is empty.
+func NewGitHubClient(token string) *github.Client {
+	if token == "" {
+		return github.NewClient(nil)
+	}
+
+	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
+	return github.NewClient(oauth2.NewClient(context.Background(), ts))
+}
+
+type FetchOptions struct {
+	Token    string
+	CacheDir string
+	// Owner and Repo of the GitHub repository to fetch artifacts from
+	Owner string
+	Repo  string
+	// Limit is the maximum number of e2e artifacts to look at, MaxPages the
+	// maximum number of artifact list pages to walk through
+	Limit    int
+	MaxPages int
+	// Progress receives progress messages, stdout if nil
+	Progress io.Writer
+}
+
+// FetchResults downloads e2e test artifacts that are not in the local
+// artifact store yet, returning the number of artifacts downloaded.
+func FetchResults(opts FetchOptions) (int, error) {
+	outPath := opts.CacheDir
 	if outPath == "" {
-		if userDir, err := os.UserHomeDir(); err != nil {
-			return fmt.Errorf("unable to determine cache dir via environment or default ~/.cache/dapr-test-analyzer")
+		if dir, err := CacheDir(); err != nil {
+			return 0, err
 		} else {
-			outPath = path.Join(userDir, ".cache", "dapr-test-analyzer")
+			outPath = dir
 		}
 	}
 
 	log.Printf("Writing data files to %s", outPath)
-	ts := oauth2.StaticTokenSource(&oauth2.Token{AccessToken: token})
-	ctx := context.Background()
-	tc := oauth2.NewClient(ctx, ts)
-	client := github.NewClient(tc)
+	client := NewGitHubClient(opts.Token)
 
-	pages := maxPages
+	limit := opts.Limit
+	pages := opts.MaxPages
 	artifactCount := 0
+	downloadCount := 0
 
-	store := ArtifactStore{RootPath: outPath}
+	store := ArtifactStore{RootPath: outPath, Progress: opts.Progress}
+	progress := store.progress()
 
 	for i := 1; i <= pages; i++ {
 		if artifactCount >= limit {
-			fmt.Printf("Reached limit of %d artifacts\n", limit)
+			fmt.Fprintf(progress, "Reached limit of %d artifacts\n", limit)
 			break
 		}
 
-		if artifacts, _, err := client.Actions.ListArtifacts(context.TODO(), "dapr", "dapr", &github.ListOptions{
+		if artifacts, _, err := client.Actions.ListArtifacts(context.TODO(), opts.Owner, opts.Repo, &github.ListOptions{
 			Page: i,
 		}); err != nil {
-			return err
+			return downloadCount, err
 		} else {
 			for _, a := range artifacts.Artifacts {
 				if strings.Contains(a.GetName(), "e2e") {
 					artifactCount += 1
 					//fmt.Printf("ID: %d\n", *workflow_run.ID)
-					fmt.Printf("*  %s\n", a.GetName())
+					fmt.Fprintf(progress, "*  %s\n", a.GetName())
 					if !store.ArtifactExists(a) {
-						fmt.Printf("Downloading artifact %s\n", a.GetName())
-						artifactUrl, _, err := client.Actions.DownloadArtifact(context.TODO(), "dapr", "dapr", *a.ID, true)
+						fmt.Fprintf(progress, "Downloading artifact %s\n", a.GetName())
+						artifactUrl, _, err := client.Actions.DownloadArtifact(context.TODO(), opts.Owner, opts.Repo, *a.ID, true)
 						if err != nil {
-							return err
+							return downloadCount, err
 						}
 
 						if artifactData, err := downloadArtifact(artifactUrl.String()); err != nil {
-							return err
+							return downloadCount, err
 						} else {
 							if err := store.Store(a, artifactData); err != nil {
-								return err
+								return downloadCount, err
 							}
+							downloadCount += 1
 						}
 
 					} else {
-						fmt.Printf("Artifact %s already exists, skipping\n", a.GetName())
+						fmt.Fprintf(progress, "Artifact %s already exists, skipping\n", a.GetName())
+					}
+
+					if err := fetchWorkflowRun(client, store, opts.Owner, opts.Repo, a); err != nil {
+						return downloadCount, err
 					}
 				}
 			}
 		}
 	}
 
-	return nil
+	return downloadCount, nil
+}
+
+// fetchWorkflowRun stores the metadata of the workflow run that produced an
+// artifact, unless it is already stored.
+func fetchWorkflowRun(client *github.Client, store ArtifactStore, owner string, repo string, artifact *github.Artifact) error {
+	if artifact.WorkflowRunMetadata == nil || artifact.WorkflowRunMetadata.ID == nil {
+		return nil
+	}
+	runId := *artifact.WorkflowRunMetadata.ID
+	if store.WorkflowRunExists(runId) {
+		return nil
+	}
+
+	fmt.Fprintf(store.progress(), "Fetching workflow run %d\n", runId)
+	run, _, err := client.Actions.GetWorkflowRunByID(context.TODO(), owner, repo, runId)
+	if err != nil {
+		return fmt.Errorf("failed to fetch workflow run %d: %w", runId, err)
+	}
+
+	return store.StoreWorkflowRun(run)
 }
 
 func downloadArtifact(url string) ([]byte, error) {
@@ -0,0 +1,36 @@
+package ingestion
+
+import (
+	"fmt"
+)
+
+// Stage is a step of ingestion that derives data from a newly stored report.
+// Stages run in the same transaction that stores the report, so a report is
+// never visible without the data its stages produce; a failing stage rolls
+// the report back and it is retried on the next extraction.
+type Stage interface {
+	Name() string
+	Process(db *RecordDB, report *Report) error
+}
+
+func RunStages(db *RecordDB, report *Report, stages []Stage) error {
+	for _, stage := range stages {
+		if err := stage.Process(db, report); err != nil {
+			return fmt.Errorf("stage %s failed for report %d: %w", stage.Name(), report.ID, err)
+		}
+	}
+
+	return nil
+}
+
+// StoreReportWithStages stores a report together with its test groups, tests
+// and logs, then runs the stages over it within a single transaction.
+func (r *RecordDB) StoreReportWithStages(report *Report, stages []Stage) error {
+	return r.Transaction(func(tx *RecordDB) error {
+		if err := tx.db.Create(report).Error; err != nil {
+			return err
+		}
+
+		return RunStages(tx, report, stages)
+	})
+}
@@ -9,8 +9,35 @@ import (
 	"path/filepath"
 )
 
+// CacheDir is where downloaded artifacts and other local state are kept,
+// $CACHE_DIR or ~/.cache/dapr-test-analyzer by default.
+func CacheDir() (string, error) {
+	if dir := os.Getenv("CACHE_DIR"); dir != "" {
+		return dir, nil
+	}
+
+	if userDir, err := os.UserHomeDir(); err != nil {
+		return "", fmt.Errorf("unable to determine cache dir via environment or default ~/.cache/dapr-test-analyzer")
+	} else {
+		return filepath.Join(userDir, ".cache", "dapr-test-analyzer"), nil
+	}
+}
+
+// workflowRunFile holds the metadata of the workflow run in each run's
+// directory, next to the artifact metadata files
+const workflowRunFile = "_workflow_run.json"
+
 type ArtifactStore struct {
 	RootPath string
+	// Progress receives progress messages, stdout if nil
+	Progress io.Writer
+}
+
+func (as ArtifactStore) progress() io.Writer {
+	if as.Progress == nil {
+		return os.Stdout
+	}
+	return as.Progress
 }
 
 func (as ArtifactStore) PathToArtifact(artifact *github.Artifact) string {
@@ -30,11 +57,14 @@ func (as ArtifactStore) ListArtifacts() ([]*github.Artifact, error) {
 		artifacts := make([]*github.Artifact, 0)
 
 		for _, metadataFile := range metadataFiles {
-			fmt.Printf("Loading metadata file %s\n", metadataFile)
+			if filepath.Base(metadataFile) == workflowRunFile {
+				continue
+			}
+			fmt.Fprintf(as.progress(), "Loading metadata file %s\n", metadataFile)
 			if artifact, err := loadArtifact(metadataFile); err != nil {
 				return nil, fmt.Errorf("failed to load artifact from %s: %w", metadataFile, err)
 			} else {
-				fmt.Printf("Loaded artifact %s\n", *artifact.Name)
+				fmt.Fprintf(as.progress(), "Loaded artifact %s\n", *artifact.Name)
 				artifacts = append(artifacts, artifact)
 			}
 		}
@@ -51,7 +81,7 @@ func (as ArtifactStore) Store(artifact *github.Artifact, filedata []byte) error
 	artifactPath := filepath.Join(workflowDir, zipfileName)
 	metadataFilePath := filepath.Join(workflowDir, metadataFileName)
 
-	fmt.Printf("Storing artifact %s to %s\n", *artifact.Name, artifactPath)
+	fmt.Fprintf(as.progress(), "Storing artifact %s to %s\n", *artifact.Name, artifactPath)
 
 	if err := os.MkdirAll(workflowDir, os.ModePerm); err != nil {
 		return err
@@ -72,6 +102,49 @@ func (as ArtifactStore) Store(artifact *github.Artifact, filedata []byte) error
 	return nil
 }
 
+func (as ArtifactStore) pathToWorkflowRun(runId int64) string {
+	return filepath.Join(as.RootPath, fmt.Sprintf("%d", runId), workflowRunFile)
+}
+
+func (as ArtifactStore) WorkflowRunExists(runId int64) bool {
+	_, err := os.Stat(as.pathToWorkflowRun(runId))
+	return !os.IsNotExist(err)
+}
+
+// StoreWorkflowRun keeps the metadata of a workflow run, which carries details
+// such as the triggering event that artifacts lack.
+func (as ArtifactStore) StoreWorkflowRun(run *github.WorkflowRun) error {
+	path := as.pathToWorkflowRun(run.GetID())
+	if err := os.MkdirAll(filepath.Dir(path), os.ModePerm); err != nil {
+		return err
+	}
+
+	if b, err := json.Marshal(run); err != nil {
+		return fmt.Errorf("failed to marshal workflow run metadata: %w", err)
+	} else if err := writeFile(path, b); err != nil {
+		return fmt.Errorf("failed to write workflow run file %s: %w", path, err)
+	}
+
+	return nil
+}
+
+// LoadWorkflowRun returns the stored metadata of a workflow run, nil if it
+// was never fetched.
+func (as ArtifactStore) LoadWorkflowRun(runId int64) (*github.WorkflowRun, error) {
+	b, err := os.ReadFile(as.pathToWorkflowRun(runId))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	run := &github.WorkflowRun{}
+	if err := json.Unmarshal(b, run); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal workflow run %d: %w", runId, err)
+	}
+	return run, nil
+}
+
 func loadArtifact(path string) (*github.Artifact, error) {
 
 	if f, err := os.Open(path); err != nil {
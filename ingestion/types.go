@@ -18,7 +18,26 @@ type ReportGroup struct {
 
 	ProjectID uint   `gorm:"index:idx_project_id_label"`
 	Label     string `gorm:"index:idx_project_id_label"`
-	Reports   []Report
+	// RunID, HeadSHA, HeadBranch and Event describe the GitHub workflow run
+	// the reports were produced by, RunCreatedAt is when its artifacts were
+	// created
+	RunID        int64
+	HeadSHA      string
+	HeadBranch   string `gorm:"index:idx_report_group_head_branch"`
+	Event        string `gorm:"index:idx_report_group_event"`
+	RunCreatedAt int64
+	Reports      []Report
+}
+
+// RunMetadata describes the GitHub workflow run a report group was produced
+// by. Event is the event that triggered it (push, pull_request, schedule...)
+// and empty when unknown.
+type RunMetadata struct {
+	RunID      int64
+	HeadSHA    string
+	HeadBranch string
+	Event      string
+	CreatedAt  int64
 }
 
 func (rg ReportGroup) TimeWindow() TimeWindow {
@@ -46,7 +65,9 @@ type Report struct {
 
 	ReportGroupID uint   `gorm:"index:idx_report_group_id_label"`
 	Label         string `gorm:"index:idx_report_group_id_label"`
-	TestGroups    []TestGroup
+	// MetricsGenerated is set once the ReportTestMetrics of the report exist
+	MetricsGenerated bool `gorm:"index:idx_report_metrics_generated"`
+	TestGroups       []TestGroup
 }
 
 func (r Report) TimeWindow() TimeWindow {
@@ -72,10 +93,11 @@ func (r Report) TimeWindow() TimeWindow {
 type ReportTestMetrics struct {
 	gorm.Model
 
-	ReportID  uint
-	TestLabel string
-	PassCount uint
-	FailCount uint
+	ReportID   uint
+	TestCaseID uint
+	TestLabel  string
+	PassCount  uint
+	FailCount  uint
 }
 
 func (r ReportTestMetrics) PassRate() float64 {
@@ -118,15 +140,81 @@ type TimeWindow struct {
 	End   int64
 }
 
+// TestCase is the canonical identity of a test across runs. Each Test result
+// row references one, so history attaches to the test rather than to its label.
+type TestCase struct {
+	gorm.Model
+
+	Package   string `gorm:"uniqueIndex:idx_test_case_package_name"`
+	Name      string `gorm:"uniqueIndex:idx_test_case_package_name"`
+	FirstSeen int64
+	LastSeen  int64
+	Aliases   []TestCaseAlias
+}
+
+func (tc TestCase) FullName() string {
+	return tc.Package + "." + tc.Name
+}
+
+// TestCaseAlias maps a previous package / name of a test (after a rename or a
+// package move) onto its canonical TestCase.
+type TestCaseAlias struct {
+	gorm.Model
+
+	TestCaseID uint   `gorm:"index:idx_test_case_alias_test_case_id"`
+	Package    string `gorm:"uniqueIndex:idx_test_case_alias_package_name"`
+	Name       string `gorm:"uniqueIndex:idx_test_case_alias_package_name"`
+}
+
 type Test struct {
 	gorm.Model
 
 	TestGroupID uint `gorm:"index:idx_test_test_group_id"`
+	TestCaseID  uint `gorm:"index:idx_test_test_case_id"`
 	Label       string
 	Status      string
 	Start       int64
 	End         int64
-	Logs        []TestLog
+	// FailureMessage is the most relevant error line of a failed test's output
+	// and FailureSignature its normalized form, shared by similar failures
+	FailureMessage   string
+	FailureSignature string `gorm:"index:idx_test_failure_signature"`
+	// FailureCategory tells infrastructure trouble apart from broken tests,
+	// see analysis.Classifier
+	FailureCategory string `gorm:"index:idx_test_failure_category"`
+	Logs            []TestLog
+}
+
+// TestOutcome is a single test result flattened together with the report and
+// workflow run it belongs to, the basic unit of history based analysis.
+type TestOutcome struct {
+	TestID        uint
+	TestCaseID    uint
+	Label         string
+	Package       string
+	Status        string
+	Start         int64
+	End           int64
+	ReportID      uint
+	ReportLabel   string
+	ReportGroupID uint
+	RunLabel      string
+	RunID         int64
+	HeadSHA       string
+	HeadBranch    string
+	Event         string
+
+	FailureMessage   string
+	FailureSignature string
+	FailureCategory  string
+}
+
+func (o TestOutcome) Passed() bool {
+	return o.Status == "pass"
+}
+
+func (o TestOutcome) Failed() bool {
+	return o.Status == "fail"
 }
 
 type TestLog struct {
@@ -137,6 +225,36 @@ type TestLog struct {
 	Text      string
 }
 
+// DataVersion is a single-row counter bumped whenever ingestion changes the
+// stored results, so derived views know when they are stale.
+type DataVersion struct {
+	ID        uint `gorm:"primarykey"`
+	Version   uint64
+	UpdatedAt time.Time
+}
+
+// Ingestion records a fetch or extract, so the server can report on
+// ingestion done by other processes. Total and Processed count the artifacts
+// to go through and gone through so far, Errors those that failed and
+// RecordErrors the lines of test output that could not be parsed. FinishedAt
+// is zero while it is running.
+type Ingestion struct {
+	ID           uint `gorm:"primarykey"`
+	Command      string
+	StartedAt    time.Time
+	FinishedAt   time.Time
+	Total        int
+	Processed    int
+	Stored       int
+	Errors       int
+	RecordErrors int
+	LastError    string
+}
+
+func (i Ingestion) Finished() bool {
+	return !i.FinishedAt.IsZero()
+}
+
 type TestRecord struct {
 	Time    string  `json:"time,omitempty"`
 	Action  string  `json:"action,omitempty"`
@@ -153,3 +271,24 @@ func (t TestRecord) Timestamp() int64 {
 		return tt.UnixMilli()
 	}
 }
+
+// QuarantineEntry records that a test case was quarantined, by whom and why,
+// and once released, when, by whom and why. A test case has at most one
+// active entry, one with ReleasedAt 0. Times are in ms since the epoch.
+type QuarantineEntry struct {
+	gorm.Model
+
+	TestCaseID    uint `gorm:"index:idx_quarantine_entry_test_case_id"`
+	Package       string
+	Name          string
+	Reason        string
+	QuarantinedBy string
+	QuarantinedAt int64
+	ReleasedAt    int64 `gorm:"index:idx_quarantine_entry_released_at"`
+	ReleasedBy    string
+	ReleaseReason string
+}
+
+func (q QuarantineEntry) Active() bool {
+	return q.ReleasedAt == 0
+}
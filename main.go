@@ -1,16 +1,10 @@
 package main
 
 import (
-	"github.com/joho/godotenv"
-	"log"
-	"test-analyzer/web"
+	"os"
+	"test-analyzer/cli"
 )
 
 func main() {
-	err := godotenv.Load()
-	if err != nil {
-		log.Printf("Error loading .env file")
-	}
-
-	web.ServeHTTP()
+	os.Exit(cli.Run(os.Args[1:], assets))
 }
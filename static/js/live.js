@@ -0,0 +1,56 @@
+// Subscribes to the live updates of /api/events. onData is called with the
+// new data version whenever the stored results change after the page was
+// loaded, onRun with each newly ingested run. A change that came with new
+// runs is left to onRun, if given, so pages can add the runs rather than
+// reload everything. The progress of fetches and extractions is shown in the
+// element with id "live-status", if any.
+function subscribeLiveUpdates(onData, onRun) {
+    if (!window.EventSource) {
+        return;
+    }
+
+    let version = null;
+    // Runs are announced ahead of the data change they belong to
+    let runs = 0;
+    const source = new EventSource("/api/events");
+
+    source.addEventListener("data", (e) => {
+        const data = JSON.parse(e.data);
+        // The first event is the version the page was built from, or a
+        // change missed while reconnecting
+        if (version !== null && data.Version !== version && onData && !(onRun && runs > 0)) {
+            onData(data.Version);
+        }
+        version = data.Version;
+        runs = 0;
+    });
+
+    source.addEventListener("run", (e) => {
+        runs += 1;
+        if (onRun) {
+            onRun(JSON.parse(e.data));
+        }
+    });
+
+    source.addEventListener("ingestion", (e) => {
+        const ingestion = JSON.parse(e.data);
+        const status = document.getElementById("live-status");
+        if (!status) {
+            return;
+        }
+
+        const finished = !ingestion.FinishedAt.startsWith("0001-");
+        let text = ingestion.Command === "fetch" ? "Fetch" : "Extraction";
+        if (!finished) {
+            text += " running" + (ingestion.Total ? ": " + ingestion.Processed + " / " + ingestion.Total + " artifacts" : "");
+        } else {
+            text += " finished " + new Date(ingestion.FinishedAt).toLocaleString();
+        }
+        text += ", " + ingestion.Stored + (ingestion.Command === "fetch" ? " downloaded" : " reports stored");
+        if (ingestion.Errors) {
+            text += ", " + ingestion.Errors + " errors (last: " + ingestion.LastError + ")";
+        }
+        status.textContent = text;
+        status.className = ingestion.Errors ? "text-danger" : "text-muted";
+    });
+}
@@ -0,0 +1,117 @@
+package tables
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Export formats of a table.
+const (
+	FormatCSV  = "csv"
+	FormatXLSX = "xlsx"
+)
+
+// Table is a tabular view of analysis results, with a fixed column order so
+// that spreadsheets built from it keep working as columns are added at the
+// end. Values are strings, integers, floats or times.
+type Table struct {
+	// Name is used for the sheet and the file name of downloads
+	Name    string
+	Columns []string
+	Rows    [][]interface{}
+}
+
+// IsFormat reports whether format is one a table can be written in.
+func IsFormat(format string) bool {
+	return format == FormatCSV || format == FormatXLSX
+}
+
+// ContentType is the MIME type of a table written in format.
+func ContentType(format string) string {
+	if format == FormatXLSX {
+		return "application/vnd.openxmlformats-officedocument.spreadsheetml.sheet"
+	}
+	return "text/csv; charset=utf-8"
+}
+
+// FileName is the name to download t as in format.
+func (t Table) FileName(format string) string {
+	return t.Name + "." + format
+}
+
+// Write writes t to w in format, one of FormatCSV or FormatXLSX.
+func (t Table) Write(w io.Writer, format string) error {
+	switch format {
+	case FormatCSV:
+		return t.WriteCSV(w)
+	case FormatXLSX:
+		return t.WriteXLSX(w)
+	default:
+		return fmt.Errorf("unknown table format %q, expected %s or %s", format, FormatCSV, FormatXLSX)
+	}
+}
+
+// WriteCSV writes t as RFC 4180 CSV with a header row; values holding commas,
+// quotes or line breaks are quoted.
+func (t Table) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(t.Columns); err != nil {
+		return err
+	}
+	record := make([]string, len(t.Columns))
+	for _, row := range t.Rows {
+		for i := range record {
+			record[i] = ""
+			if i < len(row) {
+				record[i] = formatValue(row[i])
+			}
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func formatValue(v interface{}) string {
+	switch v := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return escapeFormula(v)
+	case int, int64, uint, uint64:
+		// negative numbers stay numbers
+		return fmt.Sprint(v)
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case time.Time:
+		if v.IsZero() {
+			return ""
+		}
+		return v.UTC().Format(time.RFC3339)
+	default:
+		return escapeFormula(fmt.Sprint(v))
+	}
+}
+
+// escapeFormula quotes text that a spreadsheet would evaluate as a formula,
+// such as a failure message starting with =, so opening a table can't run it.
+func escapeFormula(s string) string {
+	if s != "" && strings.ContainsRune("=+-@", rune(s[0])) {
+		return "'" + s
+	}
+	return s
+}
+
+// millisToTime converts a timestamp in ms since the epoch, 0 if unknown.
+func millisToTime(ms int64) time.Time {
+	if ms == 0 {
+		return time.Time{}
+	}
+	return time.UnixMilli(ms).UTC()
+}
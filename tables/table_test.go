@@ -0,0 +1,216 @@
+package tables
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/csv"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testTable() Table {
+	return Table{
+		Name:    "tests",
+		Columns: []string{"Name", "Runs", "Pass rate", "Last seen"},
+		Rows: [][]interface{}{
+			{"TestA", 10, 0.95, time.Date(2023, 4, 1, 12, 0, 0, 0, time.UTC)},
+			{"Test, \"quoted\"\nmultiline", uint(3), 1.0, time.Time{}},
+			{"TestC <&>", int64(0)},
+			{`=HYPERLINK("http://x")`, -2, -0.5},
+		},
+	}
+}
+
+func TestWriteCSV(t *testing.T) {
+	var b bytes.Buffer
+	if err := testTable().Write(&b, FormatCSV); err != nil {
+		t.Fatal(err)
+	}
+
+	records, err := csv.NewReader(&b).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := [][]string{
+		{"Name", "Runs", "Pass rate", "Last seen"},
+		{"TestA", "10", "0.95", "2023-04-01T12:00:00Z"},
+		{"Test, \"quoted\"\nmultiline", "3", "1", ""},
+		{"TestC <&>", "0", "", ""},
+		{`'=HYPERLINK("http://x")`, "-2", "-0.5", ""},
+	}
+	if len(records) != len(want) {
+		t.Fatalf("got %d records, want %d", len(records), len(want))
+	}
+	for i := range want {
+		if strings.Join(records[i], "|") != strings.Join(want[i], "|") {
+			t.Errorf("record %d: got %q, want %q", i, records[i], want[i])
+		}
+	}
+}
+
+// sheet is the part of a worksheet the tests look at.
+type sheet struct {
+	Rows []struct {
+		R     int `xml:"r,attr"`
+		Cells []struct {
+			R      string `xml:"r,attr"`
+			T      string `xml:"t,attr"`
+			S      string `xml:"s,attr"`
+			Value  string `xml:"v"`
+			Inline string `xml:"is>t"`
+		} `xml:"c"`
+	} `xml:"sheetData>row"`
+}
+
+func readXLSX(t *testing.T, b []byte) map[string]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := io.ReadAll(rc)
+		_ = rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		parts[f.Name] = string(content)
+	}
+	return parts
+}
+
+func TestWriteXLSX(t *testing.T) {
+	var b bytes.Buffer
+	if err := testTable().Write(&b, FormatXLSX); err != nil {
+		t.Fatal(err)
+	}
+	parts := readXLSX(t, b.Bytes())
+
+	for _, name := range []string{"[Content_Types].xml", "_rels/.rels", "xl/workbook.xml", "xl/_rels/workbook.xml.rels", "xl/styles.xml"} {
+		if _, ok := parts[name]; !ok {
+			t.Errorf("missing part %s", name)
+		}
+	}
+	if !strings.Contains(parts["xl/workbook.xml"], `<sheet name="tests"`) {
+		t.Errorf("sheet is not named after the table: %s", parts["xl/workbook.xml"])
+	}
+
+	var s sheet
+	if err := xml.Unmarshal([]byte(parts["xl/worksheets/sheet1.xml"]), &s); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.Rows) != 5 {
+		t.Fatalf("got %d rows, want 5", len(s.Rows))
+	}
+
+	header := s.Rows[0].Cells
+	if len(header) != 4 || header[3].R != "D1" || header[3].Inline != "Last seen" || header[3].S != "1" {
+		t.Errorf("got header %+v", header)
+	}
+
+	cells := make(map[string]string)
+	types := make(map[string]string)
+	for _, row := range s.Rows[1:] {
+		for _, c := range row.Cells {
+			cells[c.R] = c.Value + c.Inline
+			types[c.R] = c.T
+		}
+	}
+	want := map[string]string{
+		"A2": "TestA",
+		"B2": "10",
+		"C2": "0.95",
+		"D2": "2023-04-01T12:00:00Z",
+		"A3": "Test, \"quoted\"\nmultiline",
+		"B3": "3",
+		"C3": "1",
+		"A4": "TestC <&>",
+		"B4": "0",
+		"A5": `'=HYPERLINK("http://x")`,
+		"B5": "-2",
+		"C5": "-0.5",
+	}
+	if len(cells) != len(want) {
+		t.Errorf("got cells %v, want %v", cells, want)
+	}
+	for ref, value := range want {
+		if cells[ref] != value {
+			t.Errorf("%s: got %q, want %q", ref, cells[ref], value)
+		}
+	}
+	if types["B2"] != "" || types["C2"] != "" || types["A2"] != "inlineStr" {
+		t.Errorf("got cell types %v, want numbers stored as numbers", types)
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		want  string
+	}{
+		{value: "TestA", want: "TestA"},
+		{value: "", want: ""},
+		{value: "=1+1", want: "'=1+1"},
+		{value: "+1", want: "'+1"},
+		{value: "-cmd", want: "'-cmd"},
+		{value: "@SUM(A1)", want: "'@SUM(A1)"},
+		{value: "a=b", want: "a=b"},
+		{value: -1, want: "-1"},
+		{value: -1.5, want: "-1.5"},
+		{value: []string{"=x"}, want: "[=x]"},
+	}
+	for _, tt := range tests {
+		if got := formatValue(tt.value); got != tt.want {
+			t.Errorf("formatValue(%#v) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestFormatCounts(t *testing.T) {
+	if got := FormatCounts(map[string]int{"timeout": 2, "assertion": 1}); got != "assertion=1 timeout=2" {
+		t.Errorf("got %q", got)
+	}
+	if got := FormatCounts(nil); got != "" {
+		t.Errorf("got %q for no counts", got)
+	}
+}
+
+func TestWriteUnknownFormat(t *testing.T) {
+	if err := testTable().Write(io.Discard, "pdf"); err == nil {
+		t.Error("expected an error for an unknown format")
+	}
+	if IsFormat("pdf") || !IsFormat(FormatCSV) || !IsFormat(FormatXLSX) {
+		t.Error("IsFormat disagrees with Write")
+	}
+}
+
+func TestColumnName(t *testing.T) {
+	tests := map[int]string{0: "A", 25: "Z", 26: "AA", 27: "AB", 51: "AZ", 52: "BA", 701: "ZZ", 702: "AAA"}
+	for i, want := range tests {
+		if got := columnName(i); got != want {
+			t.Errorf("columnName(%d) = %s, want %s", i, got, want)
+		}
+	}
+}
+
+func TestSheetName(t *testing.T) {
+	tests := map[string]string{
+		"":                                   "Sheet1",
+		"runs":                               "runs",
+		"a/b:c?":                             "a_b_c_",
+		"a very long table name that is cut": "a very long table name that is ",
+	}
+	for name, want := range tests {
+		if got := sheetName(name); got != want {
+			t.Errorf("sheetName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,125 @@
+package tables
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"test-analyzer/analysis"
+)
+
+// TestMetrics is the test table: the aggregated pass / fail counts of each
+// test along with its flakiness, in the order of metrics.
+func TestMetrics(metrics []analysis.TestMetrics, flakiness []analysis.TestFlakiness) Table {
+	flaky := make(map[string]analysis.TestFlakiness)
+	for _, f := range flakiness {
+		flaky[analysis.TestKey(f.TestCaseID, f.TestLabel)] = f
+	}
+
+	t := Table{
+		Name:    "test-metrics",
+		Columns: []string{"Test Case ID", "Test", "Package", "Passed", "Failed", "Pass Rate", "Pass Rate Low", "Pass Rate High", "Flakiness", "Classification"},
+		Rows:    make([][]interface{}, 0, len(metrics)),
+	}
+	for _, tm := range metrics {
+		interval := tm.PassRateInterval()
+		f, ok := flaky[analysis.TestKey(tm.TestCaseID, tm.TestLabel)]
+		if !ok {
+			f.Classification = analysis.ClassificationStable
+		}
+		t.Rows = append(t.Rows, []interface{}{
+			tm.TestCaseID, tm.TestLabel, f.Package, tm.PassCount, tm.FailCount,
+			interval.Rate, interval.Low, interval.High, f.Score, f.Classification,
+		})
+	}
+	return t
+}
+
+// Runs is the run table, in the order of runs.
+func Runs(runs []analysis.RunMetrics) Table {
+	t := Table{
+		Name:    "runs",
+		Columns: []string{"Run ID", "Workflow Run ID", "Run", "Branch", "Event", "Commit", "Start", "Duration (s)", "Passed", "Failed", "Pass Rate", "Failure Categories"},
+		Rows:    make([][]interface{}, 0, len(runs)),
+	}
+	for _, r := range runs {
+		passRate := 0.0
+		if r.PassCount+r.FailCount > 0 {
+			passRate = float64(r.PassCount) / float64(r.PassCount+r.FailCount)
+		}
+		t.Rows = append(t.Rows, []interface{}{
+			r.ReportGroupID, r.RunID, r.RunLabel, r.HeadBranch, r.Event, r.HeadSHA,
+			millisToTime(r.Start), seconds(r.End - r.Start), r.PassCount, r.FailCount, passRate,
+			FormatCounts(r.FailureCategories),
+		})
+	}
+	return t
+}
+
+// Flakiness is the flaky test list, in the order of tests.
+func Flakiness(tests []analysis.TestFlakiness) Table {
+	t := Table{
+		Name:    "flaky-tests",
+		Columns: []string{"Test Case ID", "Test", "Package", "Results", "Failures", "Transitions", "Flakiness", "Fail Streak", "Last Status", "Classification"},
+		Rows:    make([][]interface{}, 0, len(tests)),
+	}
+	for _, f := range tests {
+		t.Rows = append(t.Rows, []interface{}{
+			f.TestCaseID, f.TestLabel, f.Package, f.Runs, f.Failures, f.Transitions,
+			f.Score, f.FailStreak, f.LastStatus, f.Classification,
+		})
+	}
+	return t
+}
+
+// SlowestTests is the slowest test list, in the order of tests.
+func SlowestTests(tests []analysis.TestDuration) Table {
+	t := Table{
+		Name:    "slowest-tests",
+		Columns: []string{"Test Case ID", "Test", "Package", "Passing Results", "Mean Duration (s)", "Median Duration (s)", "Max Duration (s)", "Total Duration (s)"},
+		Rows:    make([][]interface{}, 0, len(tests)),
+	}
+	for _, d := range tests {
+		t.Rows = append(t.Rows, []interface{}{
+			d.TestCaseID, d.TestLabel, d.Package, d.Runs,
+			seconds(d.MeanDuration), seconds(d.MedianDuration), seconds(d.MaxDuration), seconds(d.TotalDuration),
+		})
+	}
+	return t
+}
+
+// Regressions is the regression list, in the order of regressions.
+func Regressions(regressions []analysis.Regression) Table {
+	t := Table{
+		Name: "regressions",
+		Columns: []string{"Test Case ID", "Test", "Package", "Branch", "Previous Fail Rate", "New Fail Rate", "Previous Results", "New Results", "Statistic",
+			"First Bad Run ID", "First Bad Workflow Run ID", "First Bad Run", "First Bad Commit", "First Bad Start", "Last Good Workflow Run ID", "Last Good Commit"},
+		Rows: make([][]interface{}, 0, len(regressions)),
+	}
+	for _, r := range regressions {
+		t.Rows = append(t.Rows, []interface{}{
+			r.TestCaseID, r.TestLabel, r.Package, r.HeadBranch, r.PreviousFailRate, r.NewFailRate, r.PreviousRuns, r.NewRuns, r.Statistic,
+			r.FirstBadReportGroupID, r.FirstBadRunID, r.FirstBadRunLabel, r.FirstBadSHA, millisToTime(r.FirstBadStart), r.LastGoodRunID, r.LastGoodSHA,
+		})
+	}
+	return t
+}
+
+// seconds converts a duration in ms to seconds.
+func seconds(ms int64) float64 {
+	return float64(ms) / 1000
+}
+
+// FormatCounts renders counts as "key=count" pairs ordered by key.
+func FormatCounts(counts map[string]int) string {
+	keys := make([]string, 0, len(counts))
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	parts := make([]string, 0, len(keys))
+	for _, k := range keys {
+		parts = append(parts, fmt.Sprintf("%s=%d", k, counts[k]))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,830 @@
+package web
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"test-analyzer/analysis"
+	"test-analyzer/ingestion"
+	"time"
+)
+
+// The /api/v1 surface. List endpoints return a page of items along with the
+// cursor of the next page, and accept limit, cursor and sort parameters;
+// sort is a field name, prefixed with - to sort descending. Errors are
+// returned as {"error": {"status": 400, "message": "..."}}.
+
+const (
+	defaultPageSize = 50
+	maxPageSize     = 500
+)
+
+type apiError struct {
+	Status  int    `json:"status"`
+	Message string `json:"message"`
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	writeJSON(w, status, map[string]apiError{
+		"error": {Status: status, Message: fmt.Sprintf(format, args...)},
+	})
+}
+
+type apiPage struct {
+	Items      interface{} `json:"items"`
+	Total      int         `json:"total"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+}
+
+// pageCursor is the position of the last item of a page in its list: the
+// sort order, the value of the sort field and the ID of the item. The next
+// page starts after that position rather than after the item itself, so a
+// cursor stays valid when the item is deleted or rebuilt.
+type pageCursor struct {
+	Sort  string      `json:"s"`
+	Value interface{} `json:"v"`
+	ID    uint        `json:"id"`
+}
+
+func encodeCursor(c pageCursor) string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(s string) (pageCursor, error) {
+	var c pageCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, fmt.Errorf("invalid cursor")
+	}
+	if err := json.Unmarshal(b, &c); err != nil {
+		return c, fmt.Errorf("invalid cursor")
+	}
+	switch c.Value.(type) {
+	case string, float64:
+	default:
+		return c, fmt.Errorf("invalid cursor")
+	}
+	return c, nil
+}
+
+// paginate returns the bounds of the page of a list of n items sorted with
+// sortBy on field, and the cursor of the page after it.
+// The page starts at the first item past the position of the cursor, found
+// with a binary search over the sorted items.
+func paginate(r *http.Request, field string, desc bool, n int, key func(i int) interface{}, id func(i int) uint) (int, int, string, error) {
+	order := field
+	if desc {
+		order = "-" + field
+	}
+
+	limit := defaultPageSize
+	if v := r.URL.Query().Get("limit"); v != "" {
+		var err error
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 {
+			return 0, 0, "", fmt.Errorf("invalid limit %q", v)
+		}
+		if limit > maxPageSize {
+			limit = maxPageSize
+		}
+	}
+
+	start := 0
+	if v := r.URL.Query().Get("cursor"); v != "" {
+		c, err := decodeCursor(v)
+		if err != nil {
+			return 0, 0, "", err
+		}
+		if c.Sort != order {
+			return 0, 0, "", fmt.Errorf("cursor is for sort %q, not %q", c.Sort, order)
+		}
+		if n > 0 {
+			if _, ok := c.Value.(string); ok != isString(key(0)) {
+				return 0, 0, "", fmt.Errorf("invalid cursor")
+			}
+		}
+		start = sort.Search(n, func(i int) bool {
+			cmp := compareValues(key(i), c.Value)
+			if desc {
+				cmp = -cmp
+			}
+			return cmp > 0 || (cmp == 0 && id(i) > c.ID)
+		})
+	}
+
+	end := start + limit
+	if end >= n {
+		return start, n, "", nil
+	}
+	return start, end, encodeCursor(pageCursor{Sort: order, Value: key(end - 1), ID: id(end - 1)}), nil
+}
+
+func isString(v interface{}) bool {
+	_, ok := v.(string)
+	return ok
+}
+
+// compareValues compares two values of a sort field, strings or numbers of
+// any type.
+func compareValues(a interface{}, b interface{}) int {
+	if as, ok := a.(string); ok {
+		return strings.Compare(as, b.(string))
+	}
+	return compareFloats(toFloat(a), toFloat(b))
+}
+
+func toFloat(v interface{}) float64 {
+	switch v := v.(type) {
+	case int:
+		return float64(v)
+	case int64:
+		return float64(v)
+	case uint:
+		return float64(v)
+	case float64:
+		return v
+	}
+	return 0
+}
+
+// parseSort reads the sort parameter, which must name one of fields.
+func parseSort(r *http.Request, fields []string, def string) (string, bool, error) {
+	s := r.URL.Query().Get("sort")
+	if s == "" {
+		s = def
+	}
+	desc := strings.HasPrefix(s, "-")
+	field := strings.TrimPrefix(s, "-")
+	for _, f := range fields {
+		if f == field {
+			return field, desc, nil
+		}
+	}
+	return "", false, fmt.Errorf("invalid sort %q, must be one of %s, optionally prefixed with -", s, strings.Join(fields, ", "))
+}
+
+func compareFloats(a float64, b float64) int {
+	if a < b {
+		return -1
+	} else if a > b {
+		return 1
+	}
+	return 0
+}
+
+// sortBy orders n items on the value key returns for them, in reverse if
+// desc, breaking ties by ascending ID so pages are stable.
+func sortBy(n int, swap func(i int, j int), key func(i int) interface{}, id func(i int) uint, desc bool) {
+	sort.Sort(sortable{n: n, swap: swap, less: func(i int, j int) bool {
+		c := compareValues(key(i), key(j))
+		if desc {
+			c = -c
+		}
+		if c != 0 {
+			return c < 0
+		}
+		return id(i) < id(j)
+	}})
+}
+
+type sortable struct {
+	n    int
+	swap func(i int, j int)
+	less func(i int, j int) bool
+}
+
+func (s sortable) Len() int           { return s.n }
+func (s sortable) Swap(i int, j int)  { s.swap(i, j) }
+func (s sortable) Less(i, j int) bool { return s.less(i, j) }
+
+// parseTime accepts RFC 3339 timestamps and YYYY-MM-DD dates.
+func parseTime(r *http.Request, name string) (int64, error) {
+	v := r.URL.Query().Get(name)
+	if v == "" {
+		return 0, nil
+	}
+	if t, err := time.Parse(time.RFC3339, v); err == nil {
+		return t.UnixMilli(), nil
+	}
+	if t, err := time.Parse("2006-01-02", v); err == nil {
+		return t.UnixMilli(), nil
+	}
+	return 0, fmt.Errorf("invalid %s %q, expected RFC 3339 or YYYY-MM-DD", name, v)
+}
+
+// timeRange reads the since and until parameters; until is exclusive and 0
+// when not given.
+func timeRange(r *http.Request) (int64, int64, error) {
+	since, err := parseTime(r, "since")
+	if err != nil {
+		return 0, 0, err
+	}
+	until, err := parseTime(r, "until")
+	return since, until, err
+}
+
+func inRange(ms int64, since int64, until int64) bool {
+	return ms >= since && (until == 0 || ms < until)
+}
+
+// matchFilter reports whether value matches the path.Match pattern of the
+// query parameter name, always if it was not given.
+func matchFilter(r *http.Request, name string, value string) bool {
+	pattern := r.URL.Query().Get(name)
+	if pattern == "" {
+		return true
+	}
+	matched, err := path.Match(pattern, value)
+	return err == nil && matched
+}
+
+func pathID(w http.ResponseWriter, r *http.Request) (uint, bool) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid id %q", mux.Vars(r)["id"])
+		return 0, false
+	}
+	return uint(id), true
+}
+
+func millisToTime(ms int64) *time.Time {
+	if ms == 0 {
+		return nil
+	}
+	t := time.UnixMilli(ms).UTC()
+	return &t
+}
+
+type apiProject struct {
+	ID    uint   `json:"id"`
+	Label string `json:"label"`
+}
+
+func APIListProjects(w http.ResponseWriter, r *http.Request) {
+	items := make([]apiProject, 0)
+	for _, p := range db.AllProjects() {
+		items = append(items, apiProject{ID: p.ID, Label: p.Label})
+	}
+	field, desc, err := parseSort(r, []string{"id"}, "id")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	key := func(i int) interface{} { return items[i].ID }
+	id := func(i int) uint { return items[i].ID }
+	sortBy(len(items), func(i int, j int) { items[i], items[j] = items[j], items[i] }, key, id, desc)
+	start, end, next, err := paginate(r, field, desc, len(items), key, id)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, apiPage{Items: items[start:end], Total: len(items), NextCursor: next})
+}
+
+func APIGetProject(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	p := db.GetProject(id)
+	if p == nil {
+		writeError(w, http.StatusNotFound, "project %d not found", id)
+		return
+	}
+	writeJSON(w, http.StatusOK, apiProject{ID: p.ID, Label: p.Label})
+}
+
+// apiRun is a workflow run, a report group in the database.
+type apiRun struct {
+	ID                uint           `json:"id"`
+	ProjectID         uint           `json:"project_id"`
+	Label             string         `json:"label"`
+	GitHubRunID       int64          `json:"github_run_id,omitempty"`
+	HeadSHA           string         `json:"head_sha,omitempty"`
+	HeadBranch        string         `json:"head_branch,omitempty"`
+	Event             string         `json:"event,omitempty"`
+	CreatedAt         time.Time      `json:"created_at"`
+	Status            string         `json:"status"`
+	Failures          int            `json:"failures"`
+	FailureCategories map[string]int `json:"failure_categories,omitempty"`
+	Reports           []apiReport    `json:"reports,omitempty"`
+}
+
+func newAPIRun(rg ingestion.ReportGroup, categories map[string]int) apiRun {
+	run := apiRun{
+		ID:                rg.ID,
+		ProjectID:         rg.ProjectID,
+		Label:             rg.Label,
+		GitHubRunID:       rg.RunID,
+		HeadSHA:           rg.HeadSHA,
+		HeadBranch:        rg.HeadBranch,
+		Event:             rg.Event,
+		CreatedAt:         rg.CreatedAt.UTC(),
+		Status:            "green",
+		FailureCategories: categories,
+	}
+	if rg.RunCreatedAt != 0 {
+		run.CreatedAt = time.UnixMilli(rg.RunCreatedAt).UTC()
+	}
+	for _, count := range categories {
+		run.Failures += count
+	}
+	if run.Failures > 0 {
+		run.Status = "red"
+	}
+	return run
+}
+
+// APIListRuns lists runs, filtered on project (ID or label), branch and event
+// (path.Match patterns), status (green or red) and a since / until range.
+func APIListRuns(w http.ResponseWriter, r *http.Request) {
+	field, desc, err := parseSort(r, []string{"created_at", "id", "failures", "branch"}, "-created_at")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	since, until, err := timeRange(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	status := r.URL.Query().Get("status")
+	if status != "" && status != "green" && status != "red" {
+		writeError(w, http.StatusBadRequest, "invalid status %q, must be green or red", status)
+		return
+	}
+
+	var projectId uint
+	if project := r.URL.Query().Get("project"); project != "" {
+		if id, err := strconv.ParseUint(project, 10, 64); err == nil {
+			projectId = uint(id)
+		} else if p := db.FindProjectByLabel(project); p != nil {
+			projectId = p.ID
+		} else {
+			writeError(w, http.StatusNotFound, "project %q not found", project)
+			return
+		}
+	}
+
+	counts := analysis.CountRunFailureCategories(db)
+	items := make([]apiRun, 0)
+	for _, rg := range db.AllReportGroups() {
+		run := newAPIRun(rg, counts[rg.ID])
+		if (projectId != 0 && run.ProjectID != projectId) || !matchFilter(r, "branch", run.HeadBranch) || !matchFilter(r, "event", run.Event) ||
+			(status != "" && run.Status != status) || !inRange(run.CreatedAt.UnixMilli(), since, until) {
+			continue
+		}
+		items = append(items, run)
+	}
+
+	key := func(i int) interface{} {
+		switch field {
+		case "created_at":
+			return items[i].CreatedAt.UnixMilli()
+		case "failures":
+			return items[i].Failures
+		case "branch":
+			return items[i].HeadBranch
+		}
+		return items[i].ID
+	}
+	id := func(i int) uint { return items[i].ID }
+	sortBy(len(items), func(i int, j int) { items[i], items[j] = items[j], items[i] }, key, id, desc)
+	start, end, next, err := paginate(r, field, desc, len(items), key, id)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, apiPage{Items: items[start:end], Total: len(items), NextCursor: next})
+}
+
+// APIGetRun returns a run along with its reports.
+func APIGetRun(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	rg := db.GetReportGroup(id)
+	if rg == nil {
+		writeError(w, http.StatusNotFound, "run %d not found", id)
+		return
+	}
+
+	run := newAPIRun(*rg, analysis.CountRunFailureCategories(db)[rg.ID])
+	run.Reports = make([]apiReport, 0)
+	for _, report := range db.GetReports(rg.ID) {
+		run.Reports = append(run.Reports, newAPIReport(report))
+	}
+	writeJSON(w, http.StatusOK, run)
+}
+
+type apiReport struct {
+	ID               uint         `json:"id"`
+	RunID            uint         `json:"run_id"`
+	Label            string       `json:"label"`
+	MetricsGenerated bool         `json:"metrics_generated"`
+	Packages         []apiPackage `json:"packages,omitempty"`
+}
+
+type apiPackage struct {
+	Package string      `json:"package"`
+	Results []apiResult `json:"results"`
+}
+
+// apiResult is a single test result.
+type apiResult struct {
+	ID               uint       `json:"id"`
+	TestCaseID       uint       `json:"test_case_id"`
+	Name             string     `json:"name"`
+	Status           string     `json:"status"`
+	Start            *time.Time `json:"start,omitempty"`
+	DurationMs       int64      `json:"duration_ms"`
+	FailureMessage   string     `json:"failure_message,omitempty"`
+	FailureSignature string     `json:"failure_signature,omitempty"`
+	FailureCategory  string     `json:"failure_category,omitempty"`
+}
+
+func newAPIReport(report ingestion.Report) apiReport {
+	return apiReport{
+		ID:               report.ID,
+		RunID:            report.ReportGroupID,
+		Label:            report.Label,
+		MetricsGenerated: report.MetricsGenerated,
+	}
+}
+
+// APIListReports lists reports, filtered on run and label prefix.
+func APIListReports(w http.ResponseWriter, r *http.Request) {
+	field, desc, err := parseSort(r, []string{"id", "label"}, "-id")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	var groups []ingestion.ReportGroup
+	if run := r.URL.Query().Get("run"); run != "" {
+		id, err := strconv.ParseUint(run, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid run %q", run)
+			return
+		}
+		rg := db.GetReportGroup(uint(id))
+		if rg == nil {
+			writeError(w, http.StatusNotFound, "run %d not found", id)
+			return
+		}
+		groups = []ingestion.ReportGroup{*rg}
+	} else {
+		groups = db.AllReportGroups()
+	}
+
+	label := r.URL.Query().Get("label")
+	items := make([]apiReport, 0)
+	for _, rg := range groups {
+		for _, report := range db.GetReports(rg.ID) {
+			if strings.HasPrefix(report.Label, label) {
+				items = append(items, newAPIReport(report))
+			}
+		}
+	}
+
+	key := func(i int) interface{} {
+		if field == "label" {
+			return items[i].Label
+		}
+		return items[i].ID
+	}
+	id := func(i int) uint { return items[i].ID }
+	sortBy(len(items), func(i int, j int) { items[i], items[j] = items[j], items[i] }, key, id, desc)
+	start, end, next, err := paginate(r, field, desc, len(items), key, id)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, apiPage{Items: items[start:end], Total: len(items), NextCursor: next})
+}
+
+// APIGetReport returns a report with its results by package, optionally
+// filtered on package (a path.Match pattern), status and name prefix.
+func APIGetReport(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	report := db.LoadReport(id, false)
+	if report == nil {
+		writeError(w, http.StatusNotFound, "report %d not found", id)
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	name := r.URL.Query().Get("label")
+	result := newAPIReport(*report)
+	result.Packages = make([]apiPackage, 0)
+	for _, group := range report.TestGroups {
+		if !matchFilter(r, "package", group.Label) {
+			continue
+		}
+		pkg := apiPackage{Package: group.Label, Results: make([]apiResult, 0)}
+		for _, t := range group.Tests {
+			if (status != "" && t.Status != status) || !strings.HasPrefix(t.Label, name) {
+				continue
+			}
+			pkg.Results = append(pkg.Results, apiResult{
+				ID:               t.ID,
+				TestCaseID:       t.TestCaseID,
+				Name:             t.Label,
+				Status:           t.Status,
+				Start:            millisToTime(t.Start),
+				DurationMs:       t.End - t.Start,
+				FailureMessage:   t.FailureMessage,
+				FailureSignature: t.FailureSignature,
+				FailureCategory:  t.FailureCategory,
+			})
+		}
+		sort.Slice(pkg.Results, func(i int, j int) bool { return pkg.Results[i].Name < pkg.Results[j].Name })
+		if len(pkg.Results) > 0 {
+			result.Packages = append(result.Packages, pkg)
+		}
+	}
+	sort.Slice(result.Packages, func(i int, j int) bool { return result.Packages[i].Package < result.Packages[j].Package })
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+// apiTest is a test case, the identity of a test across runs.
+type apiTest struct {
+	ID        uint       `json:"id"`
+	Package   string     `json:"package"`
+	Name      string     `json:"name"`
+	FirstSeen *time.Time `json:"first_seen,omitempty"`
+	LastSeen  *time.Time `json:"last_seen,omitempty"`
+}
+
+func newAPITest(tc ingestion.TestCase) apiTest {
+	return apiTest{
+		ID:        tc.ID,
+		Package:   tc.Package,
+		Name:      tc.Name,
+		FirstSeen: millisToTime(tc.FirstSeen),
+		LastSeen:  millisToTime(tc.LastSeen),
+	}
+}
+
+// APIListTests lists test cases, filtered on package (a path.Match pattern),
+// label (name prefix) and a since / until range of when they last ran.
+func APIListTests(w http.ResponseWriter, r *http.Request) {
+	field, desc, err := parseSort(r, []string{"name", "package", "first_seen", "last_seen", "id"}, "name")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	since, until, err := timeRange(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	label := r.URL.Query().Get("label")
+	cases := make([]ingestion.TestCase, 0)
+	for _, tc := range db.AllTestCases() {
+		if matchFilter(r, "package", tc.Package) && strings.HasPrefix(tc.Name, label) && inRange(tc.LastSeen, since, until) {
+			cases = append(cases, tc)
+		}
+	}
+
+	key := func(i int) interface{} {
+		switch field {
+		case "name":
+			return cases[i].Name
+		case "package":
+			return cases[i].Package
+		case "first_seen":
+			return cases[i].FirstSeen
+		case "last_seen":
+			return cases[i].LastSeen
+		}
+		return cases[i].ID
+	}
+	id := func(i int) uint { return cases[i].ID }
+	sortBy(len(cases), func(i int, j int) { cases[i], cases[j] = cases[j], cases[i] }, key, id, desc)
+	start, end, next, err := paginate(r, field, desc, len(cases), key, id)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	items := make([]apiTest, 0, end-start)
+	for _, tc := range cases[start:end] {
+		items = append(items, newAPITest(tc))
+	}
+	writeJSON(w, http.StatusOK, apiPage{Items: items, Total: len(cases), NextCursor: next})
+}
+
+func APIGetTest(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	tc := db.GetTestCase(id)
+	if tc == nil {
+		writeError(w, http.StatusNotFound, "test %d not found", id)
+		return
+	}
+	writeJSON(w, http.StatusOK, newAPITest(*tc))
+}
+
+// apiHistoryEntry is a result of a test together with the run it was part of.
+type apiHistoryEntry struct {
+	apiResult
+	ReportID    uint   `json:"report_id"`
+	RunID       uint   `json:"run_id"`
+	GitHubRunID int64  `json:"github_run_id,omitempty"`
+	HeadSHA     string `json:"head_sha,omitempty"`
+	HeadBranch  string `json:"head_branch,omitempty"`
+	Event       string `json:"event,omitempty"`
+}
+
+// APIGetTestHistory lists the results of a test, filtered on status (pass or
+// fail), branch and event (path.Match patterns) and a since / until range.
+func APIGetTestHistory(w http.ResponseWriter, r *http.Request) {
+	id, ok := pathID(w, r)
+	if !ok {
+		return
+	}
+	if db.GetTestCase(id) == nil {
+		writeError(w, http.StatusNotFound, "test %d not found", id)
+		return
+	}
+	field, desc, err := parseSort(r, []string{"start"}, "-start")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	since, until, err := timeRange(r)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	status := r.URL.Query().Get("status")
+
+	items := make([]apiHistoryEntry, 0)
+	for _, o := range db.GetTestCaseOutcomes(id) {
+		if (status != "" && o.Status != status) || !matchFilter(r, "branch", o.HeadBranch) || !matchFilter(r, "event", o.Event) ||
+			!inRange(o.Start, since, until) {
+			continue
+		}
+		items = append(items, apiHistoryEntry{
+			apiResult: apiResult{
+				ID:               o.TestID,
+				TestCaseID:       o.TestCaseID,
+				Name:             o.Label,
+				Status:           o.Status,
+				Start:            millisToTime(o.Start),
+				DurationMs:       o.End - o.Start,
+				FailureMessage:   o.FailureMessage,
+				FailureSignature: o.FailureSignature,
+				FailureCategory:  o.FailureCategory,
+			},
+			ReportID:    o.ReportID,
+			RunID:       o.ReportGroupID,
+			GitHubRunID: o.RunID,
+			HeadSHA:     o.HeadSHA,
+			HeadBranch:  o.HeadBranch,
+			Event:       o.Event,
+		})
+	}
+	key := func(i int) interface{} {
+		if items[i].Start == nil {
+			return int64(0)
+		}
+		return items[i].Start.UnixMilli()
+	}
+	resultId := func(i int) uint { return items[i].ID }
+	sortBy(len(items), func(i int, j int) { items[i], items[j] = items[j], items[i] }, key, resultId, desc)
+	start, end, next, err := paginate(r, field, desc, len(items), key, resultId)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, apiPage{Items: items[start:end], Total: len(items), NextCursor: next})
+}
+
+type apiMetrics struct {
+	TestCaseID     uint    `json:"test_case_id"`
+	Package        string  `json:"package,omitempty"`
+	Name           string  `json:"name"`
+	PassCount      uint    `json:"pass_count"`
+	FailCount      uint    `json:"fail_count"`
+	PassRate       float64 `json:"pass_rate"`
+	PassRateLow    float64 `json:"pass_rate_low"`
+	PassRateHigh   float64 `json:"pass_rate_high"`
+	Flakiness      float64 `json:"flakiness"`
+	Classification string  `json:"classification"`
+}
+
+// APIListMetrics lists the aggregated metrics of every test, filtered on
+// package (a path.Match pattern), label (name prefix) and classification.
+// Tests without a test case are left out since they can't be paged through.
+func APIListMetrics(w http.ResponseWriter, r *http.Request) {
+	field, desc, err := parseSort(r, []string{"pass_rate", "pass_rate_high", "fail_count", "pass_count", "flakiness", "name"}, "pass_rate_high")
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	packages := make(map[uint]string)
+	for _, tc := range db.AllTestCases() {
+		packages[tc.ID] = tc.Package
+	}
+	flakiness := make(map[uint]analysis.TestFlakiness)
+	for _, f := range loadFlakiness() {
+		flakiness[f.TestCaseID] = f
+	}
+
+	label := r.URL.Query().Get("label")
+	classification := r.URL.Query().Get("classification")
+	items := make([]apiMetrics, 0)
+	for _, tm := range loadTestMetrics() {
+		if tm.TestCaseID == 0 {
+			continue
+		}
+		interval := tm.PassRateInterval()
+		m := apiMetrics{
+			TestCaseID:     tm.TestCaseID,
+			Package:        packages[tm.TestCaseID],
+			Name:           tm.TestLabel,
+			PassCount:      tm.PassCount,
+			FailCount:      tm.FailCount,
+			PassRate:       interval.Rate,
+			PassRateLow:    interval.Low,
+			PassRateHigh:   interval.High,
+			Classification: analysis.ClassificationStable,
+		}
+		if f, ok := flakiness[tm.TestCaseID]; ok {
+			m.Flakiness = f.Score
+			m.Classification = f.Classification
+		}
+		if !matchFilter(r, "package", m.Package) || !strings.HasPrefix(m.Name, label) || (classification != "" && m.Classification != classification) {
+			continue
+		}
+		items = append(items, m)
+	}
+
+	key := func(i int) interface{} {
+		switch field {
+		case "pass_rate":
+			return items[i].PassRate
+		case "pass_rate_high":
+			return items[i].PassRateHigh
+		case "fail_count":
+			return items[i].FailCount
+		case "pass_count":
+			return items[i].PassCount
+		case "flakiness":
+			return items[i].Flakiness
+		}
+		return items[i].Name
+	}
+	id := func(i int) uint { return items[i].TestCaseID }
+	sortBy(len(items), func(i int, j int) { items[i], items[j] = items[j], items[i] }, key, id, desc)
+	start, end, next, err := paginate(r, field, desc, len(items), key, id)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, apiPage{Items: items[start:end], Total: len(items), NextCursor: next})
+}
+
+func registerAPI(r *mux.Router) {
+	r.HandleFunc("/projects", APIListProjects).Methods("GET")
+	r.HandleFunc("/projects/{id}", APIGetProject).Methods("GET")
+	r.HandleFunc("/runs", APIListRuns).Methods("GET")
+	r.HandleFunc("/runs/{id}", APIGetRun).Methods("GET")
+	r.HandleFunc("/reports", APIListReports).Methods("GET")
+	r.HandleFunc("/reports/{id}", APIGetReport).Methods("GET")
+	r.HandleFunc("/tests", APIListTests).Methods("GET")
+	r.HandleFunc("/tests/{id}", APIGetTest).Methods("GET")
+	r.HandleFunc("/tests/{id}/history", APIGetTestHistory).Methods("GET")
+	r.HandleFunc("/metrics", APIListMetrics).Methods("GET")
+
+	r.NotFoundHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusNotFound, "no such endpoint %s", r.URL.Path)
+	})
+	r.MethodNotAllowedHandler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		writeError(w, http.StatusMethodNotAllowed, "method %s not allowed on %s", r.Method, r.URL.Path)
+	})
+}
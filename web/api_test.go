@@ -0,0 +1,220 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"strings"
+	"test-analyzer/ingestion"
+	"testing"
+)
+
+// openAPITestDB points the package at a database of six runs alternating
+// between master pushes and feature branch schedules. TestFlaky fails in
+// every third run.
+func openAPITestDB(t *testing.T) {
+	t.Helper()
+	previous := db
+	t.Cleanup(func() { db = previous })
+
+	db = ingestion.OpenRecordDB(filepath.Join(t.TempDir(), "api.db"))
+	if db == nil {
+		t.Fatal("unable to open the database")
+	}
+
+	project := db.FindOrCreateProjectByLabel("dapr")
+	for i := 1; i <= 6; i++ {
+		group := db.FindOrCreateReportGroupByLabel(project.ID, fmt.Sprintf("run-%d", i))
+		metadata := ingestion.RunMetadata{RunID: int64(i), HeadBranch: "master", Event: "push", CreatedAt: int64(i) * 1000}
+		if i%2 == 0 {
+			metadata.HeadBranch, metadata.Event = "feature", "schedule"
+		}
+		if err := db.UpdateRunMetadata(group, metadata); err != nil {
+			t.Fatal(err)
+		}
+
+		flaky := ingestion.Test{Label: "TestFlaky", Status: "pass", Start: int64(i) * 1000}
+		if i%3 == 0 {
+			flaky.Status, flaky.FailureCategory = "fail", "timeout"
+		}
+		report := ingestion.Report{
+			ReportGroupID: group.ID,
+			Label:         "unit",
+			// the results count towards the outcomes without running the
+			// metrics stage
+			MetricsGenerated: true,
+			TestGroups: []ingestion.TestGroup{
+				{Label: "pkg/a", Tests: []ingestion.Test{
+					{Label: "TestAlpha", Status: "pass", Start: int64(i) * 1000},
+					{Label: "TestBeta", Status: "pass", Start: int64(i) * 1000},
+					flaky,
+				}},
+				{Label: "pkg/b", Tests: []ingestion.Test{
+					{Label: "TestGamma", Status: "pass", Start: int64(i) * 1000},
+					{Label: "TestDelta", Status: "pass", Start: int64(i) * 1000},
+				}},
+			},
+		}
+		db.AssignTestCases(&report)
+		if err := db.StoreReportWithStages(&report, nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+type testPage struct {
+	Items      []map[string]interface{} `json:"items"`
+	Total      int                      `json:"total"`
+	NextCursor string                   `json:"next_cursor"`
+}
+
+func getPage(t *testing.T, handler http.HandlerFunc, query url.Values) (int, testPage) {
+	t.Helper()
+	rec := httptest.NewRecorder()
+	handler(rec, httptest.NewRequest(http.MethodGet, "/?"+query.Encode(), nil))
+
+	var page testPage
+	if rec.Code == http.StatusOK {
+		if err := json.Unmarshal(rec.Body.Bytes(), &page); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return rec.Code, page
+}
+
+// getAll follows the cursors of a list, returning field of every item.
+func getAll(t *testing.T, handler http.HandlerFunc, query url.Values, field string) []string {
+	t.Helper()
+	result := make([]string, 0)
+	for pages := 0; pages < 20; pages++ {
+		status, page := getPage(t, handler, query)
+		if status != http.StatusOK {
+			t.Fatalf("got status %d for %s", status, query.Encode())
+		}
+		for _, item := range page.Items {
+			result = append(result, fmt.Sprint(item[field]))
+		}
+		if page.NextCursor == "" {
+			return result
+		}
+		query.Set("cursor", page.NextCursor)
+	}
+	t.Fatal("too many pages")
+	return nil
+}
+
+func TestAPIListTests(t *testing.T) {
+	openAPITestDB(t)
+
+	tests := []struct {
+		query url.Values
+		want  string
+	}{
+		{query: url.Values{"limit": {"2"}}, want: "TestAlpha TestBeta TestDelta TestFlaky TestGamma"},
+		{query: url.Values{"limit": {"2"}, "sort": {"-name"}}, want: "TestGamma TestFlaky TestDelta TestBeta TestAlpha"},
+		{query: url.Values{"limit": {"3"}, "package": {"pkg/a"}}, want: "TestAlpha TestBeta TestFlaky"},
+		{query: url.Values{"package": {"pkg/*"}, "label": {"TestG"}}, want: "TestGamma"},
+		{query: url.Values{"package": {"pkg/c"}}, want: ""},
+	}
+	for _, tt := range tests {
+		got := strings.Join(getAll(t, APIListTests, tt.query, "name"), " ")
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.query.Encode(), got, tt.want)
+		}
+	}
+
+	// tests with equal sort values are paged by ID
+	packages := getAll(t, APIListTests, url.Values{"limit": {"1"}, "sort": {"package"}}, "package")
+	if strings.Join(packages, " ") != "pkg/a pkg/a pkg/a pkg/b pkg/b" {
+		t.Errorf("got packages %v", packages)
+	}
+
+	_, page := getPage(t, APIListTests, url.Values{"limit": {"2"}})
+	if page.Total != 5 || len(page.Items) != 2 {
+		t.Errorf("got %d of %d items, want 2 of 5", len(page.Items), page.Total)
+	}
+
+	for _, query := range []url.Values{
+		{"sort": {"color"}},
+		{"limit": {"0"}},
+		{"limit": {"x"}},
+		{"cursor": {"not a cursor"}},
+		{"cursor": {page.NextCursor}, "sort": {"-name"}},
+		{"cursor": {encodeCursor(pageCursor{Sort: "name", Value: 1.0, ID: 1})}},
+		{"since": {"yesterday"}},
+	} {
+		if status, _ := getPage(t, APIListTests, query); status != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", query.Encode(), status, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestAPIListRuns(t *testing.T) {
+	openAPITestDB(t)
+
+	tests := []struct {
+		query url.Values
+		want  string
+	}{
+		{query: url.Values{"limit": {"4"}}, want: "run-6 run-5 run-4 run-3 run-2 run-1"},
+		{query: url.Values{"sort": {"created_at"}, "branch": {"master"}}, want: "run-1 run-3 run-5"},
+		{query: url.Values{"event": {"sched*"}, "limit": {"1"}}, want: "run-6 run-4 run-2"},
+		{query: url.Values{"status": {"red"}}, want: "run-6 run-3"},
+		{query: url.Values{"status": {"green"}, "branch": {"feature"}}, want: "run-4 run-2"},
+		{query: url.Values{"sort": {"-failures"}, "limit": {"1"}}, want: "run-3 run-6 run-1 run-2 run-4 run-5"},
+		{query: url.Values{"project": {"dapr"}, "since": {"1970-01-01T00:00:03Z"}, "until": {"1970-01-01T00:00:05Z"}}, want: "run-4 run-3"},
+	}
+	for _, tt := range tests {
+		got := strings.Join(getAll(t, APIListRuns, tt.query, "label"), " ")
+		if got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.query.Encode(), got, tt.want)
+		}
+	}
+
+	if status, _ := getPage(t, APIListRuns, url.Values{"status": {"amber"}}); status != http.StatusBadRequest {
+		t.Errorf("got status %d for an invalid status, want %d", status, http.StatusBadRequest)
+	}
+	if status, _ := getPage(t, APIListRuns, url.Values{"project": {"missing"}}); status != http.StatusNotFound {
+		t.Errorf("got status %d for an unknown project, want %d", status, http.StatusNotFound)
+	}
+}
+
+// TestPaginateKeyset checks that a cursor continues after the position of the
+// last item it saw, even once that item is gone.
+func TestPaginateKeyset(t *testing.T) {
+	values := []int{10, 20, 20, 30, 40}
+	ids := []uint{1, 2, 3, 4, 5}
+	request := func(cursor string) *http.Request {
+		return httptest.NewRequest(http.MethodGet, "/?limit=2&cursor="+url.QueryEscape(cursor), nil)
+	}
+	page := func(cursor string) (int, int, string) {
+		key := func(i int) interface{} { return values[i] }
+		id := func(i int) uint { return ids[i] }
+		start, end, next, err := paginate(request(cursor), "value", false, len(values), key, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return start, end, next
+	}
+
+	start, end, next := page("")
+	if start != 0 || end != 2 || next == "" {
+		t.Fatalf("got page [%d, %d) and cursor %q", start, end, next)
+	}
+
+	// the last item of the first page is deleted before the second is read
+	values = append(values[:1], values[2:]...)
+	ids = append(ids[:1], ids[2:]...)
+	start, end, next = page(next)
+	if ids[start] != 3 || end != 3 || next == "" {
+		t.Errorf("got page [%d, %d) starting at ID %d, want the page to start at ID 3", start, end, ids[start])
+	}
+
+	start, end, next = page(next)
+	if ids[start] != 5 || end != 4 || next != "" {
+		t.Errorf("got last page [%d, %d) and cursor %q", start, end, next)
+	}
+}
@@ -0,0 +1,237 @@
+package web
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"html/template"
+	"net/http"
+	"path"
+	"strconv"
+	"test-analyzer/analysis"
+	"test-analyzer/ingestion"
+)
+
+const (
+	// defaultBadgeRuns is the number of most recent runs a badge covers
+	defaultBadgeRuns = 10
+
+	badgeMetricPassRate = "pass-rate"
+	badgeMetricFlaky    = "flaky"
+)
+
+// Badge colors, as used by shields.io
+const (
+	colorBrightGreen = "#4c1"
+	colorGreen       = "#97ca00"
+	colorYellow      = "#dfb317"
+	colorOrange      = "#fe7d37"
+	colorRed         = "#e05d44"
+	colorGrey        = "#9f9f9f"
+)
+
+// badgeTemplate is a flat shields style badge; widths are estimated from the
+// number of characters in 11px Verdana.
+var badgeTemplate = template.Must(template.New("badge").Parse(`<svg xmlns="http://www.w3.org/2000/svg" width="{{ .Width }}" height="20" role="img" aria-label="{{ .Label }}: {{ .Message }}">
+<title>{{ .Label }}: {{ .Message }}</title>
+<linearGradient id="s" x2="0" y2="100%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>
+<clipPath id="r"><rect width="{{ .Width }}" height="20" rx="3" fill="#fff"/></clipPath>
+<g clip-path="url(#r)"><rect width="{{ .LabelWidth }}" height="20" fill="#555"/><rect x="{{ .LabelWidth }}" width="{{ .MessageWidth }}" height="20" fill="{{ .Color }}"/><rect width="{{ .Width }}" height="20" fill="url(#s)"/></g>
+<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">
+<text x="{{ .LabelX }}" y="15" fill="#010101" fill-opacity=".3">{{ .Label }}</text><text x="{{ .LabelX }}" y="14">{{ .Label }}</text>
+<text x="{{ .MessageX }}" y="15" fill="#010101" fill-opacity=".3">{{ .Message }}</text><text x="{{ .MessageX }}" y="14">{{ .Message }}</text>
+</g>
+</svg>
+`))
+
+type badge struct {
+	Label   string
+	Message string
+	Color   string
+}
+
+func textWidth(s string) int {
+	return len([]rune(s))*7 + 10
+}
+
+func writeBadge(w http.ResponseWriter, status int, b badge) {
+	labelWidth := textWidth(b.Label)
+	messageWidth := textWidth(b.Message)
+	data := struct {
+		badge
+		Width        int
+		LabelWidth   int
+		MessageWidth int
+		LabelX       float64
+		MessageX     float64
+	}{
+		badge:        b,
+		Width:        labelWidth + messageWidth,
+		LabelWidth:   labelWidth,
+		MessageWidth: messageWidth,
+		LabelX:       float64(labelWidth) / 2,
+		MessageX:     float64(labelWidth) + float64(messageWidth)/2,
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	// README renderers proxy and cache images, keep them reasonably fresh
+	w.Header().Set("Cache-Control", "max-age=300")
+	w.WriteHeader(status)
+	if err := badgeTemplate.Execute(w, &data); err != nil {
+		fmt.Printf("Unable to render badge: %v\n", err)
+	}
+}
+
+// passRateColor grades a pass rate from red to bright green.
+func passRateColor(rate float64) string {
+	switch {
+	case rate >= 0.95:
+		return colorBrightGreen
+	case rate >= 0.9:
+		return colorGreen
+	case rate >= 0.8:
+		return colorYellow
+	case rate >= 0.6:
+		return colorOrange
+	default:
+		return colorRed
+	}
+}
+
+// flakyColor grades a number of flaky tests from bright green to red.
+func flakyColor(count int) string {
+	switch {
+	case count == 0:
+		return colorBrightGreen
+	case count <= 2:
+		return colorYellow
+	case count <= 5:
+		return colorOrange
+	default:
+		return colorRed
+	}
+}
+
+// findProject looks a project up by ID, label or the repository name at the
+// end of its label, so dapr/dapr is found as "dapr".
+func findProject(name string) *ingestion.Project {
+	for _, p := range db.AllProjects() {
+		if strconv.FormatUint(uint64(p.ID), 10) == name || p.Label == name || path.Base(p.Label) == name {
+			project := p
+			return &project
+		}
+	}
+	return nil
+}
+
+// loadBadgeStats summarizes the results of a project, optionally restricted
+// to a package or a test case.
+func loadBadgeStats(project *ingestion.Project, pkg string, testCaseId uint, branch string, runs int) analysis.BadgeStats {
+	var result analysis.BadgeStats
+	build := func() (interface{}, error) {
+		groups := make(map[uint]bool)
+		for _, rg := range db.AllReportGroups() {
+			if rg.ProjectID == project.ID {
+				groups[rg.ID] = true
+			}
+		}
+
+		outcomes := make([]ingestion.TestOutcome, 0)
+		for _, o := range db.GetTestOutcomes() {
+			if groups[o.ReportGroupID] && (pkg == "" || o.Package == pkg) && (testCaseId == 0 || o.TestCaseID == testCaseId) {
+				outcomes = append(outcomes, o)
+			}
+		}
+		return analysis.ComputeBadgeStats(outcomes, branch, runs), nil
+	}
+	key := fmt.Sprintf("badge-%d-%s-%d-%s-%d", project.ID, pkg, testCaseId, branch, runs)
+	if err := viewCache.GetInto(key, db.DataVersion(), &result, build); err != nil {
+		fmt.Printf("Unable to load badge: %v\n", err)
+	}
+
+	return result
+}
+
+// registerBadge adds the badge routes to r; test IDs are tried before
+// packages, which may contain slashes.
+func registerBadge(r *mux.Router) {
+	r.HandleFunc("/badge/{project}.svg", GetBadge).Methods("GET")
+	r.HandleFunc("/badge/{project}/tests/{id:[0-9]+}.svg", GetBadge).Methods("GET")
+	r.HandleFunc("/badge/{project}/{package:.+}.svg", GetBadge).Methods("GET")
+}
+
+// GetBadge renders a status badge of a project, one of its packages or one of
+// its tests at /badge/{project}.svg, /badge/{project}/{package}.svg and
+// /badge/{project}/tests/{id}.svg. The badge shows the pass rate over the last
+// runs (10 by default) of the main branch, or the number of flaky tests with
+// metric=flaky. The label can be overridden with label.
+func GetBadge(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	query := r.URL.Query()
+
+	runs := defaultBadgeRuns
+	if v := query.Get("runs"); v != "" {
+		var err error
+		if runs, err = strconv.Atoi(v); err != nil || runs < 1 || runs > maxRunsParam {
+			writeBadge(w, http.StatusBadRequest, badge{Label: "badge", Message: "invalid runs", Color: colorGrey})
+			return
+		}
+	}
+	metric := query.Get("metric")
+	if metric == "" {
+		metric = badgeMetricPassRate
+	}
+	if metric != badgeMetricPassRate && metric != badgeMetricFlaky {
+		writeBadge(w, http.StatusBadRequest, badge{Label: "badge", Message: "invalid metric", Color: colorGrey})
+		return
+	}
+	branch := query.Get("branch")
+	if branch == "" {
+		branch = mainBranch
+	}
+	if !validBranch(branch) {
+		writeBadge(w, http.StatusBadRequest, badge{Label: "badge", Message: "unknown branch", Color: colorGrey})
+		return
+	}
+
+	project := findProject(vars["project"])
+	if project == nil {
+		writeBadge(w, http.StatusNotFound, badge{Label: vars["project"], Message: "not found", Color: colorGrey})
+		return
+	}
+
+	label := path.Base(project.Label)
+	pkg := vars["package"]
+	var testCaseId uint
+	if pkg != "" {
+		if !validPackage(pkg) {
+			writeBadge(w, http.StatusNotFound, badge{Label: path.Base(pkg), Message: "not found", Color: colorGrey})
+			return
+		}
+		label = path.Base(pkg)
+	}
+	if id := vars["id"]; id != "" {
+		tid, _ := strconv.ParseUint(id, 10, 64)
+		tc := db.GetTestCase(uint(tid))
+		if tc == nil {
+			writeBadge(w, http.StatusNotFound, badge{Label: "test " + id, Message: "not found", Color: colorGrey})
+			return
+		}
+		testCaseId = tc.ID
+		label = tc.Name
+	}
+	if v := query.Get("label"); v != "" {
+		label = v
+	}
+
+	stats := loadBadgeStats(project, pkg, testCaseId, branch, runs)
+	b := badge{Label: label}
+	switch {
+	case stats.Runs == 0:
+		b.Message, b.Color = "no runs", colorGrey
+	case metric == badgeMetricFlaky:
+		b.Message, b.Color = fmt.Sprintf("%d flaky", stats.FlakyTests), flakyColor(stats.FlakyTests)
+	default:
+		b.Message, b.Color = fmt.Sprintf("%.1f%% passing", stats.PassRate*100), passRateColor(stats.PassRate)
+	}
+	writeBadge(w, http.StatusOK, b)
+}
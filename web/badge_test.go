@@ -0,0 +1,112 @@
+package web
+
+import (
+	"fmt"
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"test-analyzer/cache"
+	"testing"
+)
+
+var (
+	badgeTextPattern  = regexp.MustCompile(`aria-label="([^"]*): ([^"]*)"`)
+	badgeColorPattern = regexp.MustCompile(`<rect x="\d+" width="\d+" height="20" fill="([^"]+)"/>`)
+)
+
+func TestGetBadge(t *testing.T) {
+	openAPITestDB(t)
+	previousCache, previousBranch := viewCache, mainBranch
+	t.Cleanup(func() { viewCache, mainBranch = previousCache, previousBranch })
+	viewCache = cache.New(t.TempDir())
+	mainBranch = "master"
+	db.FindOrCreateProjectByLabel("dapr/empty")
+	flaky := db.FindOrCreateTestCase("pkg/a", "TestFlaky", 0)
+
+	r := mux.NewRouter()
+	registerBadge(r)
+
+	// the master runs are 1, 3 and 5, TestFlaky fails in run 3
+	tests := []struct {
+		target  string
+		status  int
+		label   string
+		message string
+		color   string
+	}{
+		{target: "/badge/dapr.svg", status: http.StatusOK, label: "dapr", message: "93.3% passing", color: colorGreen},
+		{target: "/badge/1.svg", status: http.StatusOK, label: "dapr", message: "93.3% passing", color: colorGreen},
+		{target: "/badge/dapr.svg?runs=1", status: http.StatusOK, label: "dapr", message: "100.0% passing", color: colorBrightGreen},
+		{target: "/badge/dapr.svg?runs=2", status: http.StatusOK, label: "dapr", message: "90.0% passing", color: colorGreen},
+		{target: "/badge/dapr.svg?branch=feature&label=nightly", status: http.StatusOK, label: "nightly", message: "93.3% passing", color: colorGreen},
+		{target: "/badge/dapr/pkg/a.svg", status: http.StatusOK, label: "a", message: "88.9% passing", color: colorYellow},
+		{target: "/badge/dapr/pkg/b.svg", status: http.StatusOK, label: "b", message: "100.0% passing", color: colorBrightGreen},
+		{target: fmt.Sprintf("/badge/dapr/tests/%d.svg", flaky.ID), status: http.StatusOK, label: "TestFlaky", message: "66.7% passing", color: colorOrange},
+		{target: "/badge/dapr.svg?metric=flaky", status: http.StatusOK, label: "dapr", message: "0 flaky", color: colorBrightGreen},
+		{target: "/badge/empty.svg", status: http.StatusOK, label: "empty", message: "no runs", color: colorGrey},
+		{target: "/badge/dapr.svg?runs=0", status: http.StatusBadRequest, label: "badge", message: "invalid runs", color: colorGrey},
+		{target: "/badge/dapr.svg?runs=x", status: http.StatusBadRequest, label: "badge", message: "invalid runs", color: colorGrey},
+		{target: "/badge/dapr.svg?metric=coverage", status: http.StatusBadRequest, label: "badge", message: "invalid metric", color: colorGrey},
+		{target: "/badge/dapr.svg?branch=release", status: http.StatusBadRequest, label: "badge", message: "unknown branch", color: colorGrey},
+		{target: "/badge/missing.svg", status: http.StatusNotFound, label: "missing", message: "not found", color: colorGrey},
+		{target: "/badge/dapr/pkg/c.svg", status: http.StatusNotFound, label: "c", message: "not found", color: colorGrey},
+		{target: "/badge/dapr/tests/999.svg", status: http.StatusNotFound, label: "test 999", message: "not found", color: colorGrey},
+	}
+	for _, tt := range tests {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, tt.target, nil))
+		if rec.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.target, rec.Code, tt.status)
+		}
+		if rec.Header().Get("Content-Type") != "image/svg+xml" {
+			t.Errorf("%s: got content type %s", tt.target, rec.Header().Get("Content-Type"))
+			continue
+		}
+		text := badgeTextPattern.FindStringSubmatch(rec.Body.String())
+		color := badgeColorPattern.FindStringSubmatch(rec.Body.String())
+		if text == nil || color == nil {
+			t.Errorf("%s: got badge %s", tt.target, rec.Body.String())
+			continue
+		}
+		if text[1] != tt.label || text[2] != tt.message || color[1] != tt.color {
+			t.Errorf("%s: got %s: %s in %s, want %s: %s in %s", tt.target, text[1], text[2], color[1], tt.label, tt.message, tt.color)
+		}
+	}
+
+	// a test ID that isn't a number is taken as a package
+	rec := httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/badge/dapr/tests/x.svg", nil))
+	if rec.Code != http.StatusNotFound || !strings.Contains(rec.Body.String(), `aria-label="x: not found"`) {
+		t.Errorf("got status %d and badge %s", rec.Code, rec.Body.String())
+	}
+}
+
+func TestBadgeColors(t *testing.T) {
+	for rate, want := range map[float64]string{1: colorBrightGreen, 0.95: colorBrightGreen, 0.949: colorGreen, 0.9: colorGreen, 0.8: colorYellow, 0.79: colorOrange, 0.6: colorOrange, 0.59: colorRed, 0: colorRed} {
+		if got := passRateColor(rate); got != want {
+			t.Errorf("got %s for a pass rate of %v, want %s", got, rate, want)
+		}
+	}
+	for count, want := range map[int]string{0: colorBrightGreen, 1: colorYellow, 2: colorYellow, 3: colorOrange, 5: colorOrange, 6: colorRed} {
+		if got := flakyColor(count); got != want {
+			t.Errorf("got %s for %d flaky tests, want %s", got, count, want)
+		}
+	}
+}
+
+func TestFindProject(t *testing.T) {
+	openAPITestDB(t)
+	other := db.FindOrCreateProjectByLabel("dapr/components-contrib")
+
+	for name, want := range map[string]uint{"dapr": 1, "1": 1, "components-contrib": other.ID, "dapr/components-contrib": other.ID, fmt.Sprint(other.ID): other.ID, "missing": 0, "dapr/missing": 0} {
+		got := uint(0)
+		if p := findProject(name); p != nil {
+			got = p.ID
+		}
+		if got != want {
+			t.Errorf("%s: got project %d, want %d", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+	"test-analyzer/ingestion"
+	"time"
+)
+
+const (
+	// eventPollInterval is how often the database is checked for ingestion
+	// done by other processes
+	eventPollInterval = 2 * time.Second
+	// eventKeepAlive is how often idle streams get a comment, so proxies
+	// don't close them
+	eventKeepAlive = 30 * time.Second
+)
+
+var events *eventBroker
+
+// event is a server-sent event, Data is sent as JSON.
+type event struct {
+	Name string
+	Data interface{}
+}
+
+// DataEvent tells clients the stored results changed, so views built before
+// Version are stale.
+type DataEvent struct {
+	Version uint64
+}
+
+// RunEvent announces a newly ingested workflow run.
+type RunEvent struct {
+	ReportGroupID uint
+	RunID         int64
+	Label         string
+	HeadSHA       string
+	HeadBranch    string
+	Event         string
+}
+
+// eventBroker watches the database for ingestion, which is done by the fetch
+// and extract commands in other processes, and fans the changes out to the
+// subscribed clients.
+type eventBroker struct {
+	mu        sync.Mutex
+	clients   map[chan event]bool
+	version   uint64
+	ingestion *ingestion.Ingestion
+	lastRun   uint
+}
+
+func newEventBroker() *eventBroker {
+	b := &eventBroker{
+		clients:   make(map[chan event]bool),
+		version:   db.DataVersion(),
+		ingestion: db.GetLatestIngestion(),
+	}
+	if latest := db.GetLatestReportGroup(); latest != nil {
+		b.lastRun = latest.ID
+	}
+	return b
+}
+
+func (b *eventBroker) subscribe() chan event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	ch := make(chan event, 16)
+	b.clients[ch] = true
+	return ch
+}
+
+func (b *eventBroker) unsubscribe(ch chan event) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	delete(b.clients, ch)
+}
+
+// current returns the data version and latest ingestion last seen.
+func (b *eventBroker) current() (uint64, *ingestion.Ingestion) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.version, b.ingestion
+}
+
+// publish sends e to every client. Clients too slow to keep up miss events
+// rather than holding up the others.
+func (b *eventBroker) publish(e event) {
+	for ch := range b.clients {
+		select {
+		case ch <- e:
+		default:
+		}
+	}
+}
+
+func (b *eventBroker) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		b.poll()
+	}
+}
+
+func (b *eventBroker) poll() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if latest := db.GetLatestIngestion(); latest != nil && ingestionChanged(b.ingestion, latest) {
+		b.ingestion = latest
+		b.publish(event{Name: "ingestion", Data: latest})
+	}
+
+	if version := db.DataVersion(); version != b.version {
+		b.version = version
+		for _, rg := range db.GetReportGroupsAfter(b.lastRun) {
+			b.lastRun = rg.ID
+			b.publish(event{Name: "run", Data: RunEvent{
+				ReportGroupID: rg.ID,
+				RunID:         rg.RunID,
+				Label:         rg.Label,
+				HeadSHA:       rg.HeadSHA,
+				HeadBranch:    rg.HeadBranch,
+				Event:         rg.Event,
+			}})
+		}
+		b.publish(event{Name: "data", Data: DataEvent{Version: version}})
+	}
+}
+
+func ingestionChanged(previous *ingestion.Ingestion, latest *ingestion.Ingestion) bool {
+	return previous == nil ||
+		previous.ID != latest.ID ||
+		previous.Total != latest.Total ||
+		previous.Processed != latest.Processed ||
+		previous.Stored != latest.Stored ||
+		previous.Errors != latest.Errors ||
+		previous.Finished() != latest.Finished()
+}
+
+func writeEvent(w io.Writer, e event) error {
+	data, err := json.Marshal(e.Data)
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", e.Name, data)
+	return err
+}
+
+// GetEvents streams server-sent events: "ingestion" with the progress of the
+// latest fetch or extraction, "run" for each newly ingested run and "data"
+// once the stored results changed. The current data version and ingestion are
+// sent on connect, so clients can tell whether they missed a change.
+func GetEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keep reverse proxies such as nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+
+	ch := events.subscribe()
+	defer events.unsubscribe(ch)
+
+	version, latest := events.current()
+	_, _ = io.WriteString(w, "retry: 5000\n\n")
+	_ = writeEvent(w, event{Name: "data", Data: DataEvent{Version: version}})
+	if latest != nil {
+		_ = writeEvent(w, event{Name: "ingestion", Data: latest})
+	}
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case e := <-ch:
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+		case <-keepAlive.C:
+			if _, err := io.WriteString(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		}
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,130 @@
+package web
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"test-analyzer/ingestion"
+	"testing"
+	"time"
+)
+
+// received describes the events waiting on ch, such as "run 1" or "data".
+func received(ch chan event) string {
+	names := make([]string, 0)
+	for {
+		select {
+		case e := <-ch:
+			if run, ok := e.Data.(RunEvent); ok {
+				names = append(names, fmt.Sprintf("%s %d", e.Name, run.ReportGroupID))
+			} else {
+				names = append(names, e.Name)
+			}
+		default:
+			return strings.Join(names, ", ")
+		}
+	}
+}
+
+func TestEventBrokerPoll(t *testing.T) {
+	previous := db
+	t.Cleanup(func() { db = previous })
+	db = ingestion.OpenRecordDB(filepath.Join(t.TempDir(), "events.db"))
+	if db == nil {
+		t.Fatal("unable to open the database")
+	}
+	project := db.FindOrCreateProjectByLabel("dapr")
+	storeRun := func(label string) *ingestion.ReportGroup {
+		group := db.FindOrCreateReportGroupByLabel(project.ID, label)
+		report := ingestion.Report{ReportGroupID: group.ID, Label: "unit"}
+		if err := db.StoreReportWithStages(&report, nil); err != nil {
+			t.Fatal(err)
+		}
+		db.BumpDataVersion()
+		return group
+	}
+	stored := storeRun("run-1")
+
+	b := newEventBroker()
+	if b.lastRun != stored.ID {
+		t.Errorf("started after run %d, want %d", b.lastRun, stored.ID)
+	}
+	ch := b.subscribe()
+	defer b.unsubscribe(ch)
+
+	b.poll()
+	if got := received(ch); got != "" {
+		t.Errorf("got %q without changes", got)
+	}
+
+	// extraction creates the run before it stores its first report
+	pending := db.FindOrCreateReportGroupByLabel(project.ID, "run-2")
+	db.BumpDataVersion()
+	b.poll()
+	if got := received(ch); got != "data" {
+		t.Errorf("got %q for a run without reports, want data", got)
+	}
+
+	storeRun("run-2")
+	b.poll()
+	if got, want := received(ch), fmt.Sprintf("run %d, data", pending.ID); got != want {
+		t.Errorf("got %q once the run has a report, want %q", got, want)
+	}
+	b.poll()
+	if got := received(ch); got != "" {
+		t.Errorf("got %q after the run was announced", got)
+	}
+
+	extraction := db.StartIngestion("extract")
+	b.poll()
+	if got := received(ch); got != "ingestion" {
+		t.Errorf("got %q for a started ingestion, want ingestion", got)
+	}
+	b.poll()
+	if got := received(ch); got != "" {
+		t.Errorf("got %q for an unchanged ingestion", got)
+	}
+	extraction.Processed += 1
+	db.UpdateIngestion(extraction)
+	db.FinishIngestion(extraction, nil)
+	b.poll()
+	if got := received(ch); got != "ingestion" {
+		t.Errorf("got %q for a finished ingestion, want ingestion", got)
+	}
+	if version, latest := b.current(); version != db.DataVersion() || latest == nil || !latest.Finished() {
+		t.Errorf("got version %d and ingestion %+v", version, latest)
+	}
+}
+
+func TestIngestionChanged(t *testing.T) {
+	base := ingestion.Ingestion{ID: 1, Command: "extract", Total: 10, Processed: 2, Stored: 1}
+	with := func(change func(i *ingestion.Ingestion)) *ingestion.Ingestion {
+		i := base
+		change(&i)
+		return &i
+	}
+
+	tests := []struct {
+		name     string
+		previous *ingestion.Ingestion
+		latest   *ingestion.Ingestion
+		want     bool
+	}{
+		{name: "first", previous: nil, latest: &base, want: true},
+		{name: "unchanged", previous: &base, latest: with(func(i *ingestion.Ingestion) {}), want: false},
+		{name: "another ingestion", previous: &base, latest: with(func(i *ingestion.Ingestion) { i.ID = 2 }), want: true},
+		{name: "total", previous: &base, latest: with(func(i *ingestion.Ingestion) { i.Total = 11 }), want: true},
+		{name: "processed", previous: &base, latest: with(func(i *ingestion.Ingestion) { i.Processed = 3 }), want: true},
+		{name: "stored", previous: &base, latest: with(func(i *ingestion.Ingestion) { i.Stored = 2 }), want: true},
+		{name: "errors", previous: &base, latest: with(func(i *ingestion.Ingestion) { i.Errors = 1 }), want: true},
+		{name: "finished", previous: &base, latest: with(func(i *ingestion.Ingestion) { i.FinishedAt = time.Now() }), want: true},
+		// record errors and the message alone come with a processed artifact
+		{name: "record errors", previous: &base, latest: with(func(i *ingestion.Ingestion) { i.RecordErrors = 5 }), want: false},
+		{name: "last error", previous: &base, latest: with(func(i *ingestion.Ingestion) { i.LastError = "boom" }), want: false},
+	}
+	for _, tt := range tests {
+		if got := ingestionChanged(tt.previous, tt.latest); got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
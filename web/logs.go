@@ -0,0 +1,228 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/gorilla/mux"
+	"html/template"
+	"io"
+	"net/http"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"test-analyzer/analysis"
+	"test-analyzer/ingestion"
+)
+
+var (
+	runLinePattern  = regexp.MustCompile(`^\s*=== RUN\s+(\S+)`)
+	endLinePattern  = regexp.MustCompile(`^\s*--- (PASS|FAIL|SKIP): (\S+)`)
+	failLinePattern = regexp.MustCompile(`^\s*--- FAIL`)
+)
+
+// logBlock holds the rendered lines of a subtest until it ends.
+type logBlock struct {
+	name  string
+	lines int
+	buf   bytes.Buffer
+}
+
+// logRenderer writes test output as HTML while it is read. The output of a
+// subtest, from its "=== RUN" line to its "--- PASS" line, is held back until
+// the subtest ends, then collapsed if it passed or was skipped so failures
+// stand out.
+type logRenderer struct {
+	w io.Writer
+	// test is the label of the test the log belongs to, whose own output is
+	// never collapsed
+	test    string
+	link    func(file string, line string) string
+	stack   []*logBlock
+	partial string
+}
+
+func (lr *logRenderer) out() io.Writer {
+	if len(lr.stack) == 0 {
+		return lr.w
+	}
+	return &lr.stack[len(lr.stack)-1].buf
+}
+
+// Write renders text, which may hold several lines or end halfway through
+// one.
+func (lr *logRenderer) Write(text string) {
+	lines := strings.Split(lr.partial+text, "\n")
+	lr.partial = lines[len(lines)-1]
+	for _, line := range lines[:len(lines)-1] {
+		lr.writeLine(line)
+	}
+
+	if f, ok := lr.w.(http.Flusher); ok && len(lr.stack) == 0 {
+		f.Flush()
+	}
+}
+
+// Close renders the last partial line and any subtests that never ended.
+func (lr *logRenderer) Close() {
+	if lr.partial != "" {
+		lr.writeLine(lr.partial)
+		lr.partial = ""
+	}
+	for len(lr.stack) > 0 {
+		lr.endBlock("unfinished")
+	}
+}
+
+func (lr *logRenderer) writeLine(line string) {
+	line = analysis.StripANSI(line)
+
+	if m := runLinePattern.FindStringSubmatch(line); m != nil && m[1] != lr.test {
+		lr.stack = append(lr.stack, &logBlock{name: m[1]})
+	}
+
+	class := "line"
+	if failLinePattern.MatchString(line) {
+		class += " fail"
+	} else if analysis.IsErrorLine(line) {
+		class += " error"
+	}
+	_, _ = fmt.Fprintf(lr.out(), "<div class=\"%s\">%s</div>\n", class, toHTML(line, lr.link))
+	if len(lr.stack) > 0 {
+		lr.stack[len(lr.stack)-1].lines += 1
+	}
+
+	if m := endLinePattern.FindStringSubmatch(line); m != nil {
+		for i := len(lr.stack) - 1; i >= 0; i-- {
+			if lr.stack[i].name != m[2] {
+				continue
+			}
+			// Subtests that never reported their end are left open in their parent
+			for len(lr.stack) > i+1 {
+				lr.endBlock("unfinished")
+			}
+			lr.endBlock(strings.ToLower(m[1]))
+			break
+		}
+	}
+}
+
+// endBlock renders the innermost subtest into its parent, collapsed unless it
+// failed or never ended.
+func (lr *logRenderer) endBlock(status string) {
+	block := lr.stack[len(lr.stack)-1]
+	lr.stack = lr.stack[:len(lr.stack)-1]
+	if len(lr.stack) > 0 {
+		lr.stack[len(lr.stack)-1].lines += block.lines
+	}
+
+	open := ""
+	if status == "fail" || status == "unfinished" {
+		open = " open"
+	}
+	_, _ = fmt.Fprintf(lr.out(), "<details class=\"subtest %s\"%s><summary>%s, %s (%d lines)</summary>\n",
+		status, open, template.HTMLEscapeString(block.name), status, block.lines)
+	_, _ = lr.out().Write(block.buf.Bytes())
+	_, _ = io.WriteString(lr.out(), "</details>\n")
+}
+
+// sourceLinker returns a function linking the file:line references in the
+// output of a result to its source at the run's SHA on GitHub, nil if the SHA
+// is unknown. Bare file names are resolved against the package of the test
+// and absolute paths against the workflow's checkout directory.
+func sourceLinker(result *ingestion.TestOutcome) func(file string, line string) string {
+	if result.HeadSHA == "" || repository == "" {
+		return nil
+	}
+
+	module := "github.com/" + repository
+	checkout := "/" + path.Base(repository) + "/" + path.Base(repository) + "/"
+	return func(file string, line string) string {
+		var source string
+		switch {
+		case !strings.Contains(file, "/") && result.Package == module:
+			source = file
+		case !strings.Contains(file, "/") && strings.HasPrefix(result.Package, module+"/"):
+			source = strings.TrimPrefix(result.Package, module+"/") + "/" + file
+		case strings.Contains(file, checkout):
+			source = file[strings.LastIndex(file, checkout)+len(checkout):]
+		case strings.HasPrefix(file, module+"/"):
+			source = strings.TrimPrefix(file, module+"/")
+		default:
+			return ""
+		}
+		return fmt.Sprintf("https://github.com/%s/blob/%s/%s#L%s", repository, result.HeadSHA, source, line)
+	}
+}
+
+func loadTestResult(w http.ResponseWriter, r *http.Request) *ingestion.TestOutcome {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "invalid test result id", http.StatusBadRequest)
+		return nil
+	}
+	result := db.GetTestResult(uint(id))
+	if result == nil {
+		http.Error(w, "test result not found", http.StatusNotFound)
+	}
+	return result
+}
+
+// GetTestLog renders the output of a single test result, highlighting errors
+// and --- FAIL lines, collapsing passed subtests and linking file:line
+// references to GitHub.
+func GetTestLog(w http.ResponseWriter, r *http.Request) {
+	result := loadTestResult(w, r)
+	if result == nil {
+		return
+	}
+
+	t, err := templates.lookup("log.html")
+	if err != nil {
+		fmt.Printf("Unable to render log of test %d: %v\n", result.TestID, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	data := struct {
+		Result     *ingestion.TestOutcome
+		Repository string
+	}{
+		Result:     result,
+		Repository: repository,
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := t.ExecuteTemplate(w, "header", &data); err != nil {
+		fmt.Printf("Unable to render log of test %d: %v\n", result.TestID, err)
+		return
+	}
+
+	lr := &logRenderer{w: w, test: result.Label, link: sourceLinker(result)}
+	if err := db.EachTestLog(result.TestID, func(log ingestion.TestLog) error {
+		lr.Write(log.Text)
+		return nil
+	}); err != nil {
+		fmt.Printf("Unable to read log of test %d: %v\n", result.TestID, err)
+	}
+	lr.Close()
+
+	_ = t.ExecuteTemplate(w, "footer", &data)
+}
+
+// GetRawTestLog downloads the output of a single test result as it was
+// recorded.
+func GetRawTestLog(w http.ResponseWriter, r *http.Request) {
+	result := loadTestResult(w, r)
+	if result == nil {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"test-%d.log\"", result.TestID))
+	if err := db.EachTestLog(result.TestID, func(log ingestion.TestLog) error {
+		_, err := io.WriteString(w, log.Text)
+		return err
+	}); err != nil {
+		fmt.Printf("Unable to read log of test %d: %v\n", result.TestID, err)
+	}
+}
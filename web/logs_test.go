@@ -0,0 +1,105 @@
+package web
+
+import (
+	"bytes"
+	"strings"
+	"test-analyzer/ingestion"
+	"testing"
+)
+
+const testOutput = `=== RUN   TestA
+=== RUN   TestA/ok
+    a_test.go:10: fine
+--- PASS: TestA/ok (0.00s)
+=== RUN   TestA/bad
+=== RUN   TestA/bad/inner
+    a_test.go:20: Error: got <nil>
+--- FAIL: TestA/bad/inner (0.00s)
+--- FAIL: TestA/bad (0.00s)
+=== RUN   TestA/hang
+` + "\x1b[31mstill running\x1b[0m"
+
+func renderLog(t *testing.T, chunks []string, link func(file string, line string) string) string {
+	t.Helper()
+	var b bytes.Buffer
+	lr := &logRenderer{w: &b, test: "TestA", link: link}
+	for _, c := range chunks {
+		lr.Write(c)
+	}
+	lr.Close()
+	return b.String()
+}
+
+func TestLogRenderer(t *testing.T) {
+	html := renderLog(t, []string{testOutput}, nil)
+
+	for _, want := range []string{
+		`<details class="subtest pass"><summary>TestA/ok, pass (3 lines)</summary>`,
+		`<details class="subtest fail" open><summary>TestA/bad, fail (5 lines)</summary>`,
+		`<details class="subtest fail" open><summary>TestA/bad/inner, fail (3 lines)</summary>`,
+		`<details class="subtest unfinished" open><summary>TestA/hang, unfinished (2 lines)</summary>`,
+		`<div class="line error">    a_test.go:20: Error: got &lt;nil&gt;</div>`,
+		`<div class="line fail">--- FAIL: TestA/bad (0.00s)</div>`,
+		`<div class="line">still running</div>`,
+	} {
+		if !strings.Contains(html, want) {
+			t.Errorf("missing %s in\n%s", want, html)
+		}
+	}
+
+	// the test's own output is never collapsed
+	if !strings.HasPrefix(html, `<div class="line">=== RUN   TestA</div>`) {
+		t.Errorf("the log does not start with the test's own output:\n%s", html)
+	}
+	if strings.Count(html, "<details") != strings.Count(html, "</details>") {
+		t.Errorf("unbalanced details in\n%s", html)
+	}
+	// the inner subtest is rendered within its parent
+	if strings.Index(html, "TestA/bad, fail") > strings.Index(html, "TestA/bad/inner, fail") {
+		t.Errorf("the inner subtest is not nested in its parent:\n%s", html)
+	}
+
+	// output read in arbitrary pieces renders the same
+	chunks := make([]string, 0)
+	for i := 0; i < len(testOutput); i += 7 {
+		end := i + 7
+		if end > len(testOutput) {
+			end = len(testOutput)
+		}
+		chunks = append(chunks, testOutput[i:end])
+	}
+	if chunked := renderLog(t, chunks, nil); chunked != html {
+		t.Errorf("got\n%s\nfrom pieces, want\n%s", chunked, html)
+	}
+}
+
+func TestSourceLinker(t *testing.T) {
+	defer func(r string) { repository = r }(repository)
+	repository = "dapr/dapr"
+	result := &ingestion.TestOutcome{Package: "github.com/dapr/dapr/pkg/runtime", HeadSHA: "abc"}
+
+	link := sourceLinker(result)
+	tests := []struct {
+		file string
+		want string
+	}{
+		{file: "runtime_test.go", want: "https://github.com/dapr/dapr/blob/abc/pkg/runtime/runtime_test.go#L12"},
+		{file: "/home/runner/work/dapr/dapr/tests/e2e/a_test.go", want: "https://github.com/dapr/dapr/blob/abc/tests/e2e/a_test.go#L12"},
+		{file: "github.com/dapr/dapr/utils/utils.go", want: "https://github.com/dapr/dapr/blob/abc/utils/utils.go#L12"},
+		{file: "/usr/local/go/src/testing/testing.go", want: ""},
+	}
+	for _, tt := range tests {
+		if got := link(tt.file, "12"); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.file, got, tt.want)
+		}
+	}
+
+	html := renderLog(t, []string{"    runtime_test.go:12: failed\n"}, link)
+	if !strings.Contains(html, `<a target='_blank' href='https://github.com/dapr/dapr/blob/abc/pkg/runtime/runtime_test.go#L12'>runtime_test.go:12</a>`) {
+		t.Errorf("missing source link in\n%s", html)
+	}
+
+	if sourceLinker(&ingestion.TestOutcome{Package: result.Package}) != nil {
+		t.Error("got a linker without a SHA")
+	}
+}
@@ -0,0 +1,291 @@
+package web
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"strings"
+	"test-analyzer/analysis"
+	"time"
+)
+
+const (
+	// defaultMetricsRuns is the number of most recent runs of the main branch
+	// per-run and per-package metrics cover
+	defaultMetricsRuns = 10
+	// defaultMaxSeries caps the number of series of each metric, so a project
+	// with thousands of flaky subtests doesn't flood the monitoring system
+	defaultMaxSeries = 200
+)
+
+var startTime = time.Now()
+
+// metricsWriter writes metrics in the Prometheus text exposition format, or
+// OpenMetrics when the scraper asked for it. Families whose labels come from
+// the data are capped at maxSeries series; callers write the most important
+// series first and the rest are counted as dropped.
+type metricsWriter struct {
+	w           io.Writer
+	openMetrics bool
+	maxSeries   int
+
+	name    string
+	capped  bool
+	series  int
+	dropped map[string]int
+}
+
+// family starts a metric family. Counter names end in _total, which
+// OpenMetrics leaves out of the family name.
+func (mw *metricsWriter) family(name string, kind string, help string) {
+	mw.name = name
+	mw.capped = false
+	mw.series = 0
+
+	family := name
+	if mw.openMetrics && kind == "counter" {
+		family = strings.TrimSuffix(name, "_total")
+	}
+	help = strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+	_, _ = fmt.Fprintf(mw.w, "# HELP %s %s\n# TYPE %s %s\n", family, help, family, kind)
+}
+
+// cappedFamily starts a metric family with at most maxSeries series.
+func (mw *metricsWriter) cappedFamily(name string, kind string, help string) {
+	mw.family(name, kind, help)
+	mw.capped = true
+}
+
+// sample writes a series of the current family, labels are name, value
+// pairs.
+func (mw *metricsWriter) sample(value float64, labels ...string) {
+	if mw.capped && mw.series >= mw.maxSeries {
+		mw.dropped[mw.name] += 1
+		return
+	}
+	mw.series += 1
+
+	var b strings.Builder
+	b.WriteString(mw.name)
+	if len(labels) > 0 {
+		b.WriteString("{")
+		for i := 0; i+1 < len(labels); i += 2 {
+			if i > 0 {
+				b.WriteString(",")
+			}
+			b.WriteString(labels[i] + `="` + escapeLabelValue(labels[i+1]) + `"`)
+		}
+		b.WriteString("}")
+	}
+	b.WriteString(" " + formatSampleValue(value) + "\n")
+	_, _ = io.WriteString(mw.w, b.String())
+}
+
+func (mw *metricsWriter) close() {
+	if len(mw.dropped) > 0 {
+		names := make([]string, 0, len(mw.dropped))
+		for name := range mw.dropped {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+
+		mw.family("test_analyzer_dropped_series", "gauge", "Series left out of a metric by the series cap.")
+		for _, name := range names {
+			mw.sample(float64(mw.dropped[name]), "metric", name)
+		}
+	}
+	if mw.openMetrics {
+		_, _ = io.WriteString(mw.w, "# EOF\n")
+	}
+}
+
+func escapeLabelValue(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func formatSampleValue(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+func loadPackagePassRates(branch string, runs int) []analysis.PackagePassRate {
+	var result []analysis.PackagePassRate
+	build := func() (interface{}, error) {
+		return analysis.ComputePackagePassRates(db.GetTestOutcomes(), branch, runs), nil
+	}
+	if err := viewCache.GetInto(fmt.Sprintf("package-pass-rates-%s-%d", branch, runs), db.DataVersion(), &result, build); err != nil {
+		fmt.Printf("Unable to load package pass rates: %v\n", err)
+	}
+
+	return result
+}
+
+// GetMetricsExposition exposes the reliability of the tests and the state of
+// ingestion to Prometheus. Per-run and per-package metrics cover the last runs
+// runs of the main branch, and metrics labelled by package, test or run are
+// capped at max_series series.
+func GetMetricsExposition(w http.ResponseWriter, r *http.Request) {
+	runs := defaultMetricsRuns
+	if v := r.URL.Query().Get("runs"); v != "" {
+		var err error
+		if runs, err = strconv.Atoi(v); err != nil || runs < 1 || runs > maxRunsParam {
+			http.Error(w, fmt.Sprintf("runs must be a number from 1 to %d", maxRunsParam), http.StatusBadRequest)
+			return
+		}
+	}
+	maxSeries := defaultMaxSeries
+	if v := r.URL.Query().Get("max_series"); v != "" {
+		var err error
+		if maxSeries, err = strconv.Atoi(v); err != nil || maxSeries < 1 {
+			http.Error(w, "max_series must be a positive number", http.StatusBadRequest)
+			return
+		}
+	}
+
+	mw := &metricsWriter{
+		w:           w,
+		openMetrics: strings.Contains(r.Header.Get("Accept"), "application/openmetrics-text"),
+		maxSeries:   maxSeries,
+		dropped:     make(map[string]int),
+	}
+	if mw.openMetrics {
+		w.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	}
+
+	writeTestMetrics(mw, runs)
+	writeIngestionMetrics(mw)
+	writeProcessMetrics(mw)
+	mw.close()
+}
+
+func writeTestMetrics(mw *metricsWriter, runs int) {
+	// Worst packages first, so they survive the cap
+	packages := loadPackagePassRates(mainBranch, runs)
+	sort.SliceStable(packages, func(i int, j int) bool {
+		return packages[i].PassRate < packages[j].PassRate
+	})
+	mw.cappedFamily("test_analyzer_package_pass_rate", "gauge", fmt.Sprintf("Share of passing test results of a package over the last %d runs of the main branch.", runs))
+	for _, p := range packages {
+		mw.sample(p.PassRate, "branch", mainBranch, "package", p.Package)
+	}
+	mw.cappedFamily("test_analyzer_package_failures", "gauge", fmt.Sprintf("Failed test results of a package over the last %d runs of the main branch.", runs))
+	for _, p := range packages {
+		mw.sample(float64(p.FailCount), "branch", mainBranch, "package", p.Package)
+	}
+
+	flakiness := loadFlakiness()
+	classifications := map[string]int{
+		analysis.ClassificationStable: 0,
+		analysis.ClassificationFlaky:  0,
+		analysis.ClassificationBroken: 0,
+	}
+	unstable := make([]analysis.TestFlakiness, 0)
+	for _, f := range flakiness {
+		classifications[f.Classification] += 1
+		if f.Classification != analysis.ClassificationStable {
+			unstable = append(unstable, f)
+		}
+	}
+	mw.family("test_analyzer_tests", "gauge", "Tests by flakiness classification.")
+	for _, c := range []string{analysis.ClassificationStable, analysis.ClassificationFlaky, analysis.ClassificationBroken} {
+		mw.sample(float64(classifications[c]), "classification", c)
+	}
+
+	// Most flaky first, so they survive the cap
+	sort.SliceStable(unstable, func(i int, j int) bool {
+		return unstable[i].Score > unstable[j].Score
+	})
+	mw.cappedFamily("test_analyzer_test_flakiness", "gauge", "Flakiness score of the tests classified as flaky or broken.")
+	for _, f := range unstable {
+		mw.sample(f.Score, "package", f.Package, "test", f.TestLabel, "classification", f.Classification)
+	}
+
+	recent := make([]analysis.RunMetrics, 0, runs)
+	for _, run := range loadRunMetrics() {
+		if run.HeadBranch == mainBranch && len(recent) < runs {
+			recent = append(recent, run)
+		}
+	}
+	mw.cappedFamily("test_analyzer_run_duration_seconds", "gauge", fmt.Sprintf("Time from the first test start to the last test end of the last %d runs of the main branch.", runs))
+	for _, run := range recent {
+		mw.sample(float64(run.End-run.Start)/1000, "branch", run.HeadBranch, "run_id", strconv.FormatInt(run.RunID, 10))
+	}
+	mw.cappedFamily("test_analyzer_run_failures", "gauge", fmt.Sprintf("Failed test results of the last %d runs of the main branch.", runs))
+	for _, run := range recent {
+		mw.sample(float64(run.FailCount), "branch", run.HeadBranch, "run_id", strconv.FormatInt(run.RunID, 10))
+	}
+
+	if latest := db.GetLatestReportGroup(); latest != nil {
+		mw.family("test_analyzer_last_run_timestamp_seconds", "gauge", "When the artifacts of the most recently ingested run were created, or it was ingested if unknown.")
+		created := latest.CreatedAt.Unix()
+		if latest.RunCreatedAt != 0 {
+			created = latest.RunCreatedAt / 1000
+		}
+		mw.sample(float64(created))
+	}
+}
+
+func writeIngestionMetrics(mw *metricsWriter) {
+	mw.family("test_analyzer_data_version", "gauge", "Counter bumped whenever ingestion changes the stored results.")
+	mw.sample(float64(db.DataVersion()))
+
+	errors, recordErrors := db.GetIngestionErrorCounts()
+	mw.family("test_analyzer_ingestion_errors_total", "counter", "Fetches, extractions and artifacts that failed.")
+	mw.sample(float64(errors))
+	mw.family("test_analyzer_ingestion_record_errors_total", "counter", "Lines of test output that could not be parsed.")
+	mw.sample(float64(recordErrors))
+
+	if ingestion := db.GetLatestIngestion(); ingestion != nil {
+		running := 0.0
+		if !ingestion.Finished() {
+			running = 1
+		}
+		mw.family("test_analyzer_ingestion_running", "gauge", "Whether the most recent fetch or extraction is still running.")
+		mw.sample(running, "command", ingestion.Command)
+		mw.family("test_analyzer_last_ingestion_timestamp_seconds", "gauge", "When the most recent fetch or extraction started.")
+		mw.sample(float64(ingestion.StartedAt.Unix()), "command", ingestion.Command)
+	}
+}
+
+func writeProcessMetrics(mw *metricsWriter) {
+	var mem runtime.MemStats
+	runtime.ReadMemStats(&mem)
+
+	mw.family("process_start_time_seconds", "gauge", "Start time of the process since unix epoch in seconds.")
+	mw.sample(float64(startTime.Unix()))
+	if fds, err := os.ReadDir("/proc/self/fd"); err == nil {
+		mw.family("process_open_fds", "gauge", "Number of open file descriptors.")
+		mw.sample(float64(len(fds)))
+	}
+
+	mw.family("go_info", "gauge", "Information about the Go environment.")
+	mw.sample(1, "version", runtime.Version())
+	mw.family("go_goroutines", "gauge", "Number of goroutines that currently exist.")
+	mw.sample(float64(runtime.NumGoroutine()))
+	mw.family("go_threads", "gauge", "Number of OS threads created.")
+	mw.sample(float64(pprof.Lookup("threadcreate").Count()))
+	mw.family("go_memstats_alloc_bytes", "gauge", "Number of bytes allocated and still in use.")
+	mw.sample(float64(mem.Alloc))
+	mw.family("go_memstats_sys_bytes", "gauge", "Number of bytes obtained from system.")
+	mw.sample(float64(mem.Sys))
+	mw.family("go_memstats_heap_objects", "gauge", "Number of allocated objects.")
+	mw.sample(float64(mem.HeapObjects))
+	mw.family("go_memstats_last_gc_time_seconds", "gauge", "Number of seconds since 1970 of last garbage collection.")
+	mw.sample(float64(mem.LastGC) / 1e9)
+}
@@ -0,0 +1,176 @@
+package web
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strconv"
+	"strings"
+	"test-analyzer/cache"
+	"testing"
+)
+
+func TestMetricsWriter(t *testing.T) {
+	var b bytes.Buffer
+	mw := &metricsWriter{w: &b, maxSeries: 2, dropped: make(map[string]int)}
+	mw.cappedFamily("test_flakiness", "gauge", "Flakiness\nof a test.")
+	mw.sample(0.5, "test", `TestA/"quoted"`)
+	mw.sample(0.25, "test", `C:\path`, "package", "line\nbreak")
+	mw.sample(0.125, "test", "TestC")
+	mw.sample(0.0625, "test", "TestD")
+	// uncapped families keep every series
+	mw.family("test_runs_total", "counter", "Runs.")
+	mw.sample(1, "branch", "a")
+	mw.sample(2, "branch", "b")
+	mw.sample(3, "branch", "c")
+	mw.close()
+
+	want := `# HELP test_flakiness Flakiness\nof a test.
+# TYPE test_flakiness gauge
+test_flakiness{test="TestA/\"quoted\""} 0.5
+test_flakiness{test="C:\\path",package="line\nbreak"} 0.25
+# HELP test_runs_total Runs.
+# TYPE test_runs_total counter
+test_runs_total{branch="a"} 1
+test_runs_total{branch="b"} 2
+test_runs_total{branch="c"} 3
+# HELP test_analyzer_dropped_series Series left out of a metric by the series cap.
+# TYPE test_analyzer_dropped_series gauge
+test_analyzer_dropped_series{metric="test_flakiness"} 2
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestMetricsWriterOpenMetrics(t *testing.T) {
+	var b bytes.Buffer
+	mw := &metricsWriter{w: &b, openMetrics: true, maxSeries: 10, dropped: make(map[string]int)}
+	mw.family("test_errors_total", "counter", "Errors.")
+	mw.sample(3)
+	mw.family("test_up_total", "gauge", "Not a counter.")
+	mw.sample(1)
+	mw.close()
+
+	want := `# HELP test_errors Errors.
+# TYPE test_errors counter
+test_errors_total 3
+# HELP test_up_total Not a counter.
+# TYPE test_up_total gauge
+test_up_total 1
+# EOF
+`
+	if b.String() != want {
+		t.Errorf("got\n%s\nwant\n%s", b.String(), want)
+	}
+}
+
+func TestFormatSampleValue(t *testing.T) {
+	for value, want := range map[float64]string{0: "0", 0.5: "0.5", 1e21: "1e+21", -3: "-3"} {
+		if got := formatSampleValue(value); got != want {
+			t.Errorf("got %s for %v, want %s", got, value, want)
+		}
+	}
+}
+
+var samplePattern = regexp.MustCompile(`^([a-z_]+)(\{[a-z_]+="(?:[^"\\]|\\.)*"(?:,[a-z_]+="(?:[^"\\]|\\.)*")*\})? (\S+)$`)
+
+// parseExposition counts the series of every metric in a text exposition and
+// reads the dropped series, failing on lines that aren't valid.
+func parseExposition(t *testing.T, body string) (map[string]int, map[string]float64) {
+	t.Helper()
+	series := make(map[string]int)
+	dropped := make(map[string]float64)
+	typed := make(map[string]bool)
+	for _, line := range strings.Split(strings.TrimSuffix(body, "\n"), "\n") {
+		if strings.HasPrefix(line, "# TYPE ") {
+			typed[strings.Fields(line)[2]] = true
+			continue
+		}
+		if strings.HasPrefix(line, "# HELP ") || line == "# EOF" {
+			continue
+		}
+		m := samplePattern.FindStringSubmatch(line)
+		if m == nil {
+			t.Fatalf("invalid sample %q", line)
+		}
+		if !typed[m[1]] && !typed[strings.TrimSuffix(m[1], "_total")] {
+			t.Errorf("sample %q without a type", line)
+		}
+		value, err := strconv.ParseFloat(m[3], 64)
+		if err != nil {
+			t.Fatalf("invalid value of %q: %v", line, err)
+		}
+		series[m[1]] += 1
+		if m[1] == "test_analyzer_dropped_series" {
+			dropped[strings.TrimSuffix(strings.TrimPrefix(m[2], `{metric="`), `"}`)] = value
+		}
+	}
+	return series, dropped
+}
+
+func TestGetMetricsExposition(t *testing.T) {
+	openAPITestDB(t)
+	previousCache, previousBranch := viewCache, mainBranch
+	t.Cleanup(func() { viewCache, mainBranch = previousCache, previousBranch })
+	viewCache = cache.New(t.TempDir())
+	mainBranch = "master"
+
+	get := func(query string, accept string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r := httptest.NewRequest(http.MethodGet, "/metrics"+query, nil)
+		if accept != "" {
+			r.Header.Set("Accept", accept)
+		}
+		GetMetricsExposition(rec, r)
+		return rec
+	}
+
+	rec := get("", "")
+	if rec.Code != http.StatusOK || !strings.HasPrefix(rec.Header().Get("Content-Type"), "text/plain; version=0.0.4") {
+		t.Fatalf("got status %d and content type %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	series, dropped := parseExposition(t, rec.Body.String())
+	if series["test_analyzer_package_pass_rate"] != 2 || series["test_analyzer_run_failures"] != 3 || len(dropped) != 0 {
+		t.Errorf("got series %v, dropped %v", series, dropped)
+	}
+	if !strings.Contains(rec.Body.String(), `test_analyzer_package_failures{branch="master",package="pkg/a"} 1`) {
+		t.Errorf("got no failures of pkg/a in\n%s", rec.Body.String())
+	}
+
+	// the master runs are 1, 3 and 5, and only run 3 failed
+	series, dropped = parseExposition(t, get("?max_series=1", "").Body.String())
+	for _, name := range []string{"test_analyzer_package_pass_rate", "test_analyzer_package_failures", "test_analyzer_run_duration_seconds", "test_analyzer_run_failures"} {
+		if series[name] != 1 {
+			t.Errorf("got %d series of %s with a cap of 1", series[name], name)
+		}
+	}
+	if dropped["test_analyzer_package_pass_rate"] != 1 || dropped["test_analyzer_run_failures"] != 2 {
+		t.Errorf("got dropped series %v", dropped)
+	}
+	if series["test_analyzer_tests"] != 3 {
+		t.Errorf("got %d series of the uncapped test_analyzer_tests", series["test_analyzer_tests"])
+	}
+
+	series, _ = parseExposition(t, get("?runs=1", "").Body.String())
+	if series["test_analyzer_run_failures"] != 1 {
+		t.Errorf("got %d runs, want 1", series["test_analyzer_run_failures"])
+	}
+
+	rec = get("", "application/openmetrics-text; version=1.0.0")
+	body := rec.Body.String()
+	if !strings.HasPrefix(rec.Header().Get("Content-Type"), "application/openmetrics-text") || !strings.HasSuffix(body, "# EOF\n") {
+		t.Errorf("got content type %s and body ending %q", rec.Header().Get("Content-Type"), body[len(body)-10:])
+	}
+	if !strings.Contains(body, "# TYPE test_analyzer_ingestion_errors counter\ntest_analyzer_ingestion_errors_total 0\n") {
+		t.Errorf("got no ingestion_errors family in\n%s", body)
+	}
+	parseExposition(t, body)
+
+	for _, query := range []string{"?runs=0", "?runs=x", "?max_series=0", "?max_series=-1"} {
+		if rec := get(query, ""); rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: got status %d, want %d", query, rec.Code, http.StatusBadRequest)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package web
+
+import (
+	"path"
+	"test-analyzer/analysis"
+)
+
+// Request parameters end up in view cache keys, so they are checked against
+// the stored data before they are used; otherwise every made up value would
+// build and cache a view of its own.
+
+const (
+	// maxRunsParam caps the run counts and windows requests can ask for
+	maxRunsParam = 1000
+	// maxDaysParam caps the days of history requests can ask for
+	maxDaysParam = 3650
+)
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// validBranch reports whether branch is empty, the main branch or the head
+// branch of a stored run.
+func validBranch(branch string) bool {
+	return branch == "" || branch == mainBranch || contains(db.GetRunBranches(), branch)
+}
+
+// validPattern reports whether pattern is empty or a path.Match pattern that
+// matches at least one of values.
+func validPattern(pattern string, values []string) bool {
+	if pattern == "" {
+		return true
+	}
+	for _, v := range values {
+		if matched, err := path.Match(pattern, v); err != nil {
+			return false
+		} else if matched {
+			return true
+		}
+	}
+	return false
+}
+
+// validCategory reports whether category is empty or a failure category of
+// the stored results.
+func validCategory(category string) bool {
+	return category == "" || category == analysis.CategoryUnknown || contains(db.GetFailureCategories(), category)
+}
+
+// validPackage reports whether pkg is empty or the package of a test case.
+func validPackage(pkg string) bool {
+	return pkg == "" || contains(db.GetTestCasePackages(), pkg)
+}
+
+// validGroup reports whether group is empty or a test with results.
+func validGroup(group string) bool {
+	return group == "" || db.HasTestCaseGroup(group)
+}
@@ -0,0 +1,159 @@
+package web
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/gorilla/mux"
+	"math"
+	"mime"
+	"net/http"
+	"strconv"
+	"test-analyzer/analysis"
+	"test-analyzer/ingestion"
+)
+
+type quarantineRequest struct {
+	TestCaseID uint   `json:"test_case_id"`
+	By         string `json:"by"`
+	Reason     string `json:"reason"`
+}
+
+// GetQuarantine lists the active quarantine entries, or all of them with
+// ?all=true.
+func GetQuarantine(w http.ResponseWriter, r *http.Request) {
+	all, _ := strconv.ParseBool(r.URL.Query().Get("all"))
+
+	w.Header().Add("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(db.GetQuarantineEntries(all))
+}
+
+// decodeQuarantineRequest reads the JSON body of a quarantine change. Other
+// content types are refused, a form on another site can post those without
+// a preflight.
+func decodeQuarantineRequest(w http.ResponseWriter, r *http.Request) (quarantineRequest, bool) {
+	var req quarantineRequest
+	if mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type")); mediaType != "application/json" {
+		http.Error(w, "the request body must be application/json", http.StatusUnsupportedMediaType)
+		return req, false
+	}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "invalid request body: "+err.Error(), http.StatusBadRequest)
+		return req, false
+	}
+	if req.By == "" || req.Reason == "" {
+		http.Error(w, "by and reason are required", http.StatusBadRequest)
+		return req, false
+	}
+	return req, true
+}
+
+// AddQuarantine quarantines the test case given in the JSON body.
+func AddQuarantine(w http.ResponseWriter, r *http.Request) {
+	req, ok := decodeQuarantineRequest(w, r)
+	if !ok {
+		return
+	}
+	if req.TestCaseID == 0 {
+		http.Error(w, "test_case_id is required", http.StatusBadRequest)
+		return
+	}
+	if db.GetTestCase(req.TestCaseID) == nil {
+		http.Error(w, fmt.Sprintf("test case %d not found", req.TestCaseID), http.StatusNotFound)
+		return
+	}
+
+	entry, err := db.QuarantineTestCase(req.TestCaseID, req.By, req.Reason)
+	if errors.Is(err, ingestion.ErrAlreadyQuarantined) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_ = json.NewEncoder(w).Encode(entry)
+}
+
+// ReleaseQuarantine releases the quarantined test case {id}.
+func ReleaseQuarantine(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "invalid test case id", http.StatusBadRequest)
+		return
+	}
+	req, ok := decodeQuarantineRequest(w, r)
+	if !ok {
+		return
+	}
+
+	entry, err := db.ReleaseTestCase(uint(id), req.By, req.Reason)
+	if errors.Is(err, ingestion.ErrNotQuarantined) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(entry)
+}
+
+// GetQuarantineRecommendations lists the tests to quarantine and release,
+// with optional window, threshold, min_runs and release_after overrides.
+func GetQuarantineRecommendations(w http.ResponseWriter, r *http.Request) {
+	opts := analysis.DefaultQuarantineOptions()
+	q := r.URL.Query()
+	for name, target := range map[string]*int{"window": &opts.Window, "min_runs": &opts.MinRuns, "release_after": &opts.ReleaseAfter} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 || n > maxRunsParam {
+				http.Error(w, "invalid "+name, http.StatusBadRequest)
+				return
+			}
+			*target = n
+		}
+	}
+	if v := q.Get("threshold"); v != "" {
+		t, err := strconv.ParseFloat(v, 64)
+		if err != nil || t < 0 || t > 1 {
+			http.Error(w, "invalid threshold", http.StatusBadRequest)
+			return
+		}
+		// Keep made up precision out of the cache key
+		opts.Threshold = math.Round(t*1000) / 1000
+	}
+
+	var result []analysis.QuarantineRecommendation
+	key := fmt.Sprintf("quarantine-%d-%v-%d-%d", opts.Window, opts.Threshold, opts.MinRuns, opts.ReleaseAfter)
+	build := func() (interface{}, error) {
+		return analysis.RecommendQuarantine(db.GetTestOutcomes(), db.GetQuarantineEntries(false), opts), nil
+	}
+	if err := viewCache.GetInto(key, db.DataVersion(), &result, build); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+// ExportQuarantine writes the active quarantine list as YAML, or as a go test
+// -skip pattern with ?format=skip.
+func ExportQuarantine(w http.ResponseWriter, r *http.Request) {
+	entries := db.GetQuarantineEntries(false)
+
+	switch r.URL.Query().Get("format") {
+	case "", "yaml":
+		w.Header().Add("Content-Type", "application/yaml")
+		_ = analysis.WriteQuarantineYAML(w, entries)
+	case "skip":
+		w.Header().Add("Content-Type", "text/plain")
+		fmt.Fprintln(w, analysis.SkipPattern(entries))
+	default:
+		http.Error(w, "format must be yaml or skip", http.StatusBadRequest)
+	}
+}
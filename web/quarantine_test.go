@@ -0,0 +1,66 @@
+package web
+
+import (
+	"github.com/gorilla/mux"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"test-analyzer/ingestion"
+	"testing"
+)
+
+func TestQuarantineRequests(t *testing.T) {
+	previous := db
+	t.Cleanup(func() { db = previous })
+	db = ingestion.OpenRecordDB(filepath.Join(t.TempDir(), "quarantine.db"))
+	if db == nil {
+		t.Fatal("unable to open the database")
+	}
+	tc := db.FindOrCreateTestCase("pkg", "TestA", 1)
+
+	post := func(handler http.HandlerFunc, id string, contentType string, body string) int {
+		r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(body))
+		if contentType != "" {
+			r.Header.Set("Content-Type", contentType)
+		}
+		if id != "" {
+			r = mux.SetURLVars(r, map[string]string{"id": id})
+		}
+		rec := httptest.NewRecorder()
+		handler(rec, r)
+		return rec.Code
+	}
+
+	add := `{"test_case_id": 1, "by": "alice", "reason": "flaky"}`
+	release := `{"by": "alice", "reason": "fixed"}`
+	tests := []struct {
+		name        string
+		handler     http.HandlerFunc
+		id          string
+		contentType string
+		body        string
+		status      int
+	}{
+		{name: "add as a form", handler: AddQuarantine, contentType: "application/x-www-form-urlencoded", body: add, status: http.StatusUnsupportedMediaType},
+		{name: "add as text", handler: AddQuarantine, contentType: "text/plain", body: add, status: http.StatusUnsupportedMediaType},
+		{name: "add without a type", handler: AddQuarantine, body: add, status: http.StatusUnsupportedMediaType},
+		{name: "add without a reason", handler: AddQuarantine, contentType: "application/json", body: `{"test_case_id": 1, "by": "alice"}`, status: http.StatusBadRequest},
+		{name: "add an unknown test", handler: AddQuarantine, contentType: "application/json", body: `{"test_case_id": 9, "by": "a", "reason": "r"}`, status: http.StatusNotFound},
+		{name: "add", handler: AddQuarantine, contentType: "application/json; charset=utf-8", body: add, status: http.StatusCreated},
+		{name: "add again", handler: AddQuarantine, contentType: "application/json", body: add, status: http.StatusConflict},
+		{name: "release as text", handler: ReleaseQuarantine, id: "1", contentType: "text/plain", body: release, status: http.StatusUnsupportedMediaType},
+		{name: "release", handler: ReleaseQuarantine, id: "1", contentType: "application/json", body: release, status: http.StatusOK},
+		{name: "release again", handler: ReleaseQuarantine, id: "1", contentType: "application/json", body: release, status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		if status := post(tt.handler, tt.id, tt.contentType, tt.body); status != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.name, status, tt.status)
+		}
+	}
+
+	entries := db.GetQuarantineEntries(true)
+	if len(entries) != 1 || entries[0].TestCaseID != tc.ID || entries[0].Active() || entries[0].ReleaseReason != "fixed" {
+		t.Errorf("got entries %+v", entries)
+	}
+}
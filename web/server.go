@@ -2,37 +2,56 @@ package web
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/gorilla/mux"
 	"html/template"
-	"io"
-	"log"
+	"io/fs"
 	"net/http"
-	"os"
+	"regexp"
 	"sort"
+	"strconv"
 	"strings"
 	"test-analyzer/analysis"
+	"test-analyzer/cache"
 	"test-analyzer/ingestion"
+	"test-analyzer/tables"
+	"time"
 )
 
 var db *ingestion.RecordDB
+var viewCache *cache.Cache
+var repository string
+var mainBranch string
+var comparer analysis.CommitComparer
 
 type ReportResponse struct {
 	Report *ingestion.Report `json:"report"`
 }
 
-func toHTML(s string) template.HTML {
+// sourceRefPattern matches file:line references in test output, such as
+// actor_test.go:42 or /home/runner/work/dapr/dapr/pkg/actors/actors.go:120
+var sourceRefPattern = regexp.MustCompile(`([\w./-]*\w\.go):(\d+)`)
+
+// toHTML escapes s and, if link is set, turns the file:line references in it
+// into links to the URL link returns for them, if any.
+func toHTML(s string, link func(file string, line string) string) template.HTML {
 
 	// Escape everything in the string first to ensure that
 	// special characters ('<' for example) are displayed as
 	// characters and not treated as markup.
 	s = template.HTMLEscapeString(s)
 
-	// Insert the links.
-	//s = linkPat.ReplaceAllStringFunc(s, func(m string) string {
-	//	s = s[1 : len(s)-1]
-	//	return "<a href='/view/" + m + "'>" + m + "</a>"
-	//})
+	// Insert the links. Escaping leaves file paths untouched.
+	if link != nil {
+		s = sourceRefPattern.ReplaceAllStringFunc(s, func(m string) string {
+			ref := sourceRefPattern.FindStringSubmatch(m)
+			if url := link(ref[1], ref[2]); url != "" {
+				return "<a target='_blank' href='" + template.HTMLEscapeString(url) + "'>" + m + "</a>"
+			}
+			return m
+		})
+	}
 
 	return template.HTML(s)
 }
@@ -43,15 +62,37 @@ type Page struct {
 }
 
 func renderTemplate(w http.ResponseWriter, tmpl string, p *Page) {
-	t, _ := template.ParseFiles("templates/" + tmpl + ".html")
-	_ = t.Execute(w, p)
+	renderPage(w, tmpl+".html", p)
 }
 
+// GetReport returns the run {id} (or ?id=) with all of its reports, tests and
+// logs, the latest run if no id is given.
 func GetReport(w http.ResponseWriter, r *http.Request) {
-	//id := mux.Vars(r)["id"]
+	id := mux.Vars(r)["id"]
+	if id == "" {
+		id = r.URL.Query().Get("id")
+	}
 
-	report := db.GetReportGroup()
-	fullReport := db.LoadReportGroup(report.ID)
+	var reportGroupId uint
+	if id == "" {
+		latest := db.GetLatestReportGroup()
+		if latest == nil {
+			writeError(w, http.StatusNotFound, "no runs stored yet")
+			return
+		}
+		reportGroupId = latest.ID
+	} else if parsed, err := strconv.ParseUint(id, 10, 64); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid run id %q", id)
+		return
+	} else {
+		reportGroupId = uint(parsed)
+	}
+
+	fullReport := db.LoadReportGroup(reportGroupId)
+	if fullReport == nil {
+		writeError(w, http.StatusNotFound, "run %d not found", reportGroupId)
+		return
+	}
 
 	w.Header().Set("Content-Type", "application/json")
 	err := json.NewEncoder(w).Encode(fullReport)
@@ -61,119 +102,686 @@ func GetReport(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
-func loadOrGenerateMetrics() []analysis.TestMetrics {
+func buildTestMetrics() (interface{}, error) {
+	fmt.Printf("Rebuilding report data...\n")
+	return analysis.AggregateTestMetrics(db), nil
+}
+
+// loadTestMetrics returns the aggregated metrics of every test with their
+// labels as recorded.
+func loadTestMetrics() []analysis.TestMetrics {
 	var testMetrics []analysis.TestMetrics
-	fname := "report.json"
-	fileExists := true
-	rebuild := false
+	if err := viewCache.GetInto("metrics", db.DataVersion(), &testMetrics, buildTestMetrics); err != nil {
+		fmt.Printf("Unable to load metrics: %v\n", err)
+	}
+
+	return testMetrics
+}
+
+func loadOrGenerateMetrics() []analysis.TestMetrics {
+	tm := make([]analysis.TestMetrics, 0)
+	for _, r := range loadTestMetrics() {
+		r.TestLabel = strings.ReplaceAll(r.TestLabel, ">", "&gt;")
+		r.TestLabel = strings.ReplaceAll(r.TestLabel, "<", "&lt;")
+		tm = append(tm, r)
+	}
+
+	return tm
+}
+
+func buildFlakiness() (interface{}, error) {
+	return analysis.ComputeFlakiness(db.GetTestOutcomes(), analysis.DefaultFlakinessOptions()), nil
+}
+
+func loadFlakiness() []analysis.TestFlakiness {
+	var result []analysis.TestFlakiness
+	if err := viewCache.GetInto("flakiness", db.DataVersion(), &result, buildFlakiness); err != nil {
+		fmt.Printf("Unable to load flakiness: %v\n", err)
+	}
+
+	return result
+}
+
+func buildFailureCategories() (interface{}, error) {
+	return analysis.CountTestFailureCategories(db.GetTestOutcomes()), nil
+}
+
+func loadFailureCategories() map[string]map[string]int {
+	var result map[string]map[string]int
+	if err := viewCache.GetInto("failure-categories", db.DataVersion(), &result, buildFailureCategories); err != nil {
+		fmt.Printf("Unable to load failure categories: %v\n", err)
+	}
+
+	return result
+}
+
+// TestMetricsRow is a row of the test table, the aggregated metrics of a test
+// along with its flakiness.
+type TestMetricsRow struct {
+	analysis.TestMetrics
+	PassRate       float64
+	PassRateLow    float64
+	PassRateHigh   float64
+	Flakiness      float64
+	Classification string
+	// FailureCategories counts the failures of the test by category
+	FailureCategories map[string]int
+}
 
-	if _, err := os.Stat("report.json"); err != nil {
-		if os.IsNotExist(err) {
-			fileExists = false
+// writeTable writes the table built by table as a download when the format
+// parameter asks for csv or xlsx, and reports whether it handled the request.
+// Handlers fall back to JSON, the default, when it didn't.
+func writeTable(w http.ResponseWriter, r *http.Request, table func() tables.Table) bool {
+	format := r.URL.Query().Get("format")
+	if format == "" || format == "json" {
+		return false
+	}
+	if !tables.IsFormat(format) {
+		http.Error(w, fmt.Sprintf("unknown format %q, expected json, %s or %s", format, tables.FormatCSV, tables.FormatXLSX), http.StatusBadRequest)
+		return true
+	}
+
+	t := table()
+	w.Header().Set("Content-Type", tables.ContentType(format))
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", t.FileName(format)))
+	if err := t.Write(w, format); err != nil {
+		fmt.Printf("Unable to write %s: %v\n", t.FileName(format), err)
+	}
+	return true
+}
+
+func GetMetrics(w http.ResponseWriter, r *http.Request) {
+	if writeTable(w, r, func() tables.Table {
+		metrics := loadTestMetrics()
+		sort.Slice(metrics, func(i int, j int) bool {
+			return metrics[i].TestLabel < metrics[j].TestLabel
+		})
+		return tables.TestMetrics(metrics, loadFlakiness())
+	}) {
+		return
+	}
+
+	// A run only updates the rows of the tests that ran in it
+	var run map[string]bool
+	if v := r.URL.Query().Get("run"); v != "" {
+		id, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			http.Error(w, "invalid run", http.StatusBadRequest)
+			return
+		}
+		if run = runTestKeys(uint(id)); run == nil {
+			http.Error(w, "run not found", http.StatusNotFound)
+			return
 		}
 	}
 
-	if fileExists {
-		if f, err := os.Open(fname); err != nil {
-			fmt.Printf("Unable to open report.json: %v\n", err)
-			rebuild = true
-		} else {
-			if b, err := io.ReadAll(f); err != nil {
-				fmt.Printf("Error reading file: %v\n", err)
-				rebuild = true
-			} else {
-				if len(b) == 0 {
-					fmt.Println("Empty file...")
-					rebuild = true
-				} else {
-					fmt.Printf("Read %d bytes\n", len(b))
-					if err := json.Unmarshal(b, &testMetrics); err != nil {
-						fmt.Printf("Failed to parse JSON: %v\n", err)
-						rebuild = true
-					}
+	flakiness := make(map[string]analysis.TestFlakiness)
+	for _, f := range loadFlakiness() {
+		flakiness[analysis.TestKey(f.TestCaseID, f.TestLabel)] = f
+	}
+
+	categories := loadFailureCategories()
+
+	testMetrics := make([]TestMetricsRow, 0)
+	for _, tm := range loadOrGenerateMetrics() {
+		if run != nil && !run[analysis.TestKey(tm.TestCaseID, tm.TestLabel)] {
+			continue
+		}
+		interval := tm.PassRateInterval()
+		row := TestMetricsRow{
+			TestMetrics:    tm,
+			PassRate:       interval.Rate,
+			PassRateLow:    interval.Low,
+			PassRateHigh:   interval.High,
+			Classification: analysis.ClassificationStable,
+
+			FailureCategories: categories[analysis.TestKey(tm.TestCaseID, tm.TestLabel)],
+		}
+		if f, ok := flakiness[analysis.TestKey(tm.TestCaseID, tm.TestLabel)]; ok {
+			row.Flakiness = f.Score
+			row.Classification = f.Classification
+		}
+		testMetrics = append(testMetrics, row)
+	}
+
+	if b, err := json.Marshal(testMetrics); err != nil {
+		w.WriteHeader(500)
+	} else {
+		w.Header().Add("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Write(b)
+	}
+}
+
+// runTestKeys returns the keys of the tests that ran in a run, nil if there
+// is no such run.
+func runTestKeys(reportGroupId uint) map[string]bool {
+	if db.GetReportGroup(reportGroupId) == nil {
+		return nil
+	}
+
+	keys := make(map[string]bool)
+	for _, r := range db.GetReports(reportGroupId) {
+		if report := db.LoadReport(r.ID, false); report != nil {
+			for _, g := range report.TestGroups {
+				for _, t := range g.Tests {
+					keys[analysis.TestKey(t.TestCaseID, t.Label)] = true
 				}
 			}
-			f.Close()
 		}
+	}
+	return keys
+}
+
+// GetFlakyTests lists tests by descending flakiness score, optionally
+// restricted to one classification and a maximum number of entries.
+func GetFlakyTests(w http.ResponseWriter, r *http.Request) {
+	classification := r.URL.Query().Get("classification")
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
 
-		if rebuild == true {
-			fmt.Println("Removing report file")
-			os.Remove(fname)
+	result := make([]analysis.TestFlakiness, 0)
+	for _, f := range loadFlakiness() {
+		if classification == "" || f.Classification == classification {
+			result = append(result, f)
 		}
 	}
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	if writeTable(w, r, func() tables.Table { return tables.Flakiness(result) }) {
+		return
+	}
 
-	if rebuild {
-		testMetrics = make([]analysis.TestMetrics, 0)
-		fmt.Printf("Rebuilding report data...\n")
-		reportIds := db.GetReportIDsWithTestMetrics()
-		testMetricsMap := make(map[string][]analysis.TestMetrics, len(reportIds))
+	w.Header().Add("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(result)
+}
 
-		for i, rid := range reportIds {
-			fmt.Printf("[%d/%d] Processing report %d\r", i, len(reportIds), rid)
-			for _, tm := range db.GetReportTestMetrics(rid) {
-				if _, ok := testMetricsMap[tm.TestLabel]; !ok {
-					testMetricsMap[tm.TestLabel] = make([]analysis.TestMetrics, 0)
-				}
-				testMetricsMap[tm.TestLabel] = append(testMetricsMap[tm.TestLabel], analysis.TestMetrics{
-					TestLabel: tm.TestLabel,
-					PassCount: tm.PassCount,
-					FailCount: tm.FailCount,
-				})
-			}
+func buildSlowestTests() (interface{}, error) {
+	return analysis.SlowestTests(db.GetTestOutcomes()), nil
+}
+
+// GetSlowestTests lists tests by descending mean duration of their passing
+// results, up to limit entries if given.
+func GetSlowestTests(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	var result []analysis.TestDuration
+	if err := viewCache.GetInto("slowest-tests", db.DataVersion(), &result, buildSlowestTests); err != nil {
+		fmt.Printf("Unable to load slowest tests: %v\n", err)
+	}
+	if result == nil {
+		result = make([]analysis.TestDuration, 0)
+	}
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+	if writeTable(w, r, func() tables.Table { return tables.SlowestTests(result) }) {
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+func loadRegressions(branch string) []analysis.Regression {
+	var result []analysis.Regression
+	build := func() (interface{}, error) {
+		opts := analysis.DefaultChangepointOptions()
+		opts.Branch = branch
+		return analysis.DetectRegressions(db.GetTestOutcomes(), opts), nil
+	}
+	if err := viewCache.GetInto("regressions-"+branch, db.DataVersion(), &result, build); err != nil {
+		fmt.Printf("Unable to load regressions: %v\n", err)
+	}
+
+	return result
+}
+
+func GetRegressionData(w http.ResponseWriter, r *http.Request) {
+	branch := r.URL.Query().Get("branch")
+	if !validBranch(branch) {
+		http.Error(w, "unknown branch", http.StatusBadRequest)
+		return
+	}
+
+	regressions := loadRegressions(branch)
+	if writeTable(w, r, func() tables.Table { return tables.Regressions(regressions) }) {
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(regressions)
+}
+
+func GetRegressions(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Repository string
+		Branch     string
+	}{
+		Repository: repository,
+		Branch:     r.URL.Query().Get("branch"),
+	}
+
+	renderPage(w, "regressions.html", &data)
+}
+
+func buildFailureClusters() (interface{}, error) {
+	return analysis.ClusterFailures(db.GetTestOutcomes(), analysis.DefaultClusterSimilarity), nil
+}
+
+func loadFailureClusters() []analysis.FailureCluster {
+	var result []analysis.FailureCluster
+	if err := viewCache.GetInto("failures", db.DataVersion(), &result, buildFailureClusters); err != nil {
+		fmt.Printf("Unable to load failure clusters: %v\n", err)
+	}
+
+	return result
+}
+
+// GetFailureData lists clusters of similar failures, largest first,
+// optionally limited to a maximum number of clusters.
+func GetFailureData(w http.ResponseWriter, r *http.Request) {
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	result := loadFailureClusters()
+	if limit > 0 && len(result) > limit {
+		result = result[:limit]
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+func GetFailures(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Repository string
+	}{
+		Repository: repository,
+	}
+
+	renderPage(w, "failures.html", &data)
+}
+
+func loadCorrelations(branch string) analysis.CorrelationReport {
+	var result analysis.CorrelationReport
+	build := func() (interface{}, error) {
+		opts := analysis.DefaultCorrelationOptions()
+		opts.Branch = branch
+		return analysis.ComputeCorrelations(db.GetTestOutcomes(), opts), nil
+	}
+	if err := viewCache.GetInto("correlations-"+branch, db.DataVersion(), &result, build); err != nil {
+		fmt.Printf("Unable to load correlations: %v\n", err)
+	}
+
+	return result
+}
+
+func GetCorrelationData(w http.ResponseWriter, r *http.Request) {
+	branch := r.URL.Query().Get("branch")
+	if !validBranch(branch) {
+		http.Error(w, "unknown branch", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(loadCorrelations(branch))
+}
+
+func GetCorrelations(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Branch string
+	}{
+		Branch: r.URL.Query().Get("branch"),
+	}
+
+	renderPage(w, "correlations.html", &data)
+}
+
+func loadRunHealth(branch string, days int) analysis.RunHealth {
+	opts := analysis.HealthOptions{Branch: branch}
+	since := ""
+	if days > 0 {
+		cutoff := time.Now().UTC().AddDate(0, 0, -days).Truncate(24 * time.Hour)
+		opts.Since = cutoff.UnixMilli()
+		since = cutoff.Format("20060102")
+	}
+
+	var result analysis.RunHealth
+	build := func() (interface{}, error) {
+		return analysis.ComputeRunHealth(db.GetTestOutcomes(), opts), nil
+	}
+	if err := viewCache.GetInto("health-"+branch+"-"+since, db.DataVersion(), &result, build); err != nil {
+		fmt.Printf("Unable to load run health: %v\n", err)
+	}
+
+	return result
+}
+
+// GetRunHealthData returns the run health metrics of a branch, all runs by
+// default, over the last days, 30 by default and all history if 0.
+func GetRunHealthData(w http.ResponseWriter, r *http.Request) {
+	days := 30
+	if d := r.URL.Query().Get("days"); d != "" {
+		var err error
+		if days, err = strconv.Atoi(d); err != nil || days < 0 || days > maxDaysParam {
+			http.Error(w, "invalid days", http.StatusBadRequest)
+			return
 		}
+	}
+	branch := r.URL.Query().Get("branch")
+	if !validBranch(branch) {
+		http.Error(w, "unknown branch", http.StatusBadRequest)
+		return
+	}
 
-		for _, metrics := range testMetricsMap {
-			tm := analysis.TestMetrics{
-				TestLabel: metrics[0].TestLabel,
-				PassCount: 0,
-				FailCount: 0,
-			}
-			for _, m := range metrics {
-				tm = tm.Add(m)
-			}
-			testMetrics = append(testMetrics, tm)
+	w.Header().Add("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(loadRunHealth(branch, days))
+}
+
+func GetRunHealth(w http.ResponseWriter, r *http.Request) {
+	data := struct {
+		Branch     string
+		MainBranch string
+	}{
+		Branch:     r.URL.Query().Get("branch"),
+		MainBranch: mainBranch,
+	}
+
+	renderPage(w, "health.html", &data)
+}
+
+func sliceParams(r *http.Request, prefix string) analysis.Slice {
+	return analysis.Slice{
+		Branch: r.URL.Query().Get(prefix + "_branch"),
+		Event:  r.URL.Query().Get(prefix + "_event"),
+	}
+}
+
+// GetComparisonData compares the runs of slice a (a_branch, a_event) with
+// those of slice b (b_branch, b_event).
+func GetComparisonData(w http.ResponseWriter, r *http.Request) {
+	a, b := sliceParams(r, "a"), sliceParams(r, "b")
+	branches, events := db.GetRunBranches(), db.GetRunEvents()
+	for _, s := range []analysis.Slice{a, b} {
+		if !validPattern(s.Branch, branches) || !validPattern(s.Event, events) {
+			http.Error(w, fmt.Sprintf("no runs match %s", s), http.StatusBadRequest)
+			return
 		}
+	}
+
+	var result analysis.Comparison
+	build := func() (interface{}, error) {
+		return analysis.CompareSlices(db.GetTestOutcomes(), a, b, analysis.DefaultCompareOptions()), nil
+	}
+	key := fmt.Sprintf("compare-%s-%s-%s-%s", a.Branch, a.Event, b.Branch, b.Event)
+	if err := viewCache.GetInto(key, db.DataVersion(), &result, build); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+func GetComparison(w http.ResponseWriter, r *http.Request) {
+	a, b := sliceParams(r, "a"), sliceParams(r, "b")
+	if a == (analysis.Slice{}) && b == (analysis.Slice{}) {
+		a.Branch = mainBranch
+		b.Branch = "release-*"
+	}
+	data := struct {
+		A        analysis.Slice
+		B        analysis.Slice
+		Branches []string
+		Events   []string
+	}{
+		A:        a,
+		B:        b,
+		Branches: db.GetRunBranches(),
+		Events:   db.GetRunEvents(),
+	}
+
+	renderPage(w, "compare.html", &data)
+}
+
+// GetTestBlame lists the commits between the last passing and first failing
+// run of a test that is currently failing on a branch, the main branch by
+// default.
+func GetTestBlame(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "invalid test id", http.StatusBadRequest)
+		return
+	}
+
+	branch := r.URL.Query().Get("branch")
+	if branch == "" {
+		branch = mainBranch
+	}
 
-		if of, err := os.OpenFile(fname, os.O_RDWR|os.O_CREATE, 0600); err != nil {
-			fmt.Printf("Failed to open %s for writing: %v\n", fname, err)
-		} else {
-			if b, err := json.Marshal(testMetrics); err != nil {
-				fmt.Printf("Failed to marshal metrics to json: %v\n", err)
-			} else {
-				fmt.Printf("Writing data to %s\n", fname)
-				of.Write(b)
+	blame, err := analysis.BlameTest(r.Context(), db.GetTestCaseOutcomes(uint(id)), branch, comparer)
+	if errors.Is(err, analysis.ErrNotFailing) {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(blame)
+}
+
+// maxRecentFailures is the number of most recent failures of a test the test
+// page lists.
+const maxRecentFailures = 20
+
+// RecentFailure is a failed result of a test. Its log output is left to the
+// log viewer, so the cached view of the page stays small.
+type RecentFailure struct {
+	TestID   uint
+	RunID    int64
+	RunLabel string
+	Start    int64
+	Message  string
+}
+
+// TestDetailData is the data behind the test page.
+type TestDetailData struct {
+	analysis.TestDetail
+	RecentFailures []RecentFailure
+}
+
+func loadTestDetail(testCaseId uint) TestDetailData {
+	var result TestDetailData
+	build := func() (interface{}, error) {
+		detail := TestDetailData{
+			TestDetail:     analysis.ComputeTestDetail(db.GetTestCaseOutcomes(testCaseId), analysis.DefaultPassRateWindow),
+			RecentFailures: make([]RecentFailure, 0),
+		}
+		for i := len(detail.History) - 1; i >= 0 && len(detail.RecentFailures) < maxRecentFailures; i-- {
+			h := detail.History[i]
+			if h.Status != "fail" {
+				continue
 			}
-			of.Close()
+			detail.RecentFailures = append(detail.RecentFailures, RecentFailure{TestID: h.TestID, RunID: h.RunID, RunLabel: h.RunLabel, Start: h.Start, Message: h.FailureMessage})
 		}
+		return detail, nil
+	}
+	if err := viewCache.GetInto(fmt.Sprintf("test-%d", testCaseId), db.DataVersion(), &result, build); err != nil {
+		fmt.Printf("Unable to load test %d: %v\n", testCaseId, err)
 	}
 
-	tm := make([]analysis.TestMetrics, 0)
-	for _, r := range testMetrics {
-		r.TestLabel = strings.ReplaceAll(r.TestLabel, ">", "&gt;")
-		r.TestLabel = strings.ReplaceAll(r.TestLabel, "<", "&lt;")
-		tm = append(tm, r)
+	return result
+}
+
+// GetTestDetailData returns the history of a test, its pass rate over time,
+// durations, failure categories and signatures, and its latest failures.
+func GetTestDetailData(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "invalid test id", http.StatusBadRequest)
+		return
+	}
+	if db.GetTestCase(uint(id)) == nil {
+		http.Error(w, "test not found", http.StatusNotFound)
+		return
 	}
 
-	return tm
+	w.Header().Add("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(loadTestDetail(uint(id)))
 }
 
-func GetMetrics(w http.ResponseWriter, r *http.Request) {
-	testMetrics := loadOrGenerateMetrics()
-	if b, err := json.Marshal(testMetrics); err != nil {
-		w.WriteHeader(500)
-	} else {
-		w.Header().Add("Content-Type", "application/json")
-		w.Write(b)
+func GetTestDetail(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "invalid test id", http.StatusBadRequest)
+		return
+	}
+	tc := db.GetTestCase(uint(id))
+	if tc == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := struct {
+		TestCase              *ingestion.TestCase
+		Repository            string
+		MainBranch            string
+		DefaultPassRateWindow int
+	}{
+		TestCase:              tc,
+		Repository:            repository,
+		MainBranch:            mainBranch,
+		DefaultPassRateWindow: analysis.DefaultPassRateWindow,
+	}
+
+	renderPage(w, "test.html", &data)
+}
+
+func loadRunDetail(rg *ingestion.ReportGroup) analysis.RunDetail {
+	var result analysis.RunDetail
+	build := func() (interface{}, error) {
+		return analysis.ComputeRunDetail(db, rg), nil
+	}
+	if err := viewCache.GetInto(fmt.Sprintf("run-%d", rg.ID), db.DataVersion(), &result, build); err != nil {
+		fmt.Printf("Unable to load run %d: %v\n", rg.ID, err)
 	}
+
+	return result
+}
+
+// GetRunDetailData returns the reports of a run with their per package result
+// counts, its failed tests and the tests newly failing or passing since the
+// previous run of its branch.
+func GetRunDetailData(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "invalid run id", http.StatusBadRequest)
+		return
+	}
+	rg := db.GetReportGroup(uint(id))
+	if rg == nil {
+		http.Error(w, "run not found", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(loadRunDetail(rg))
+}
+
+func GetRunDetail(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		http.Error(w, "invalid run id", http.StatusBadRequest)
+		return
+	}
+	rg := db.GetReportGroup(uint(id))
+	if rg == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	data := struct {
+		Run        *ingestion.ReportGroup
+		Repository string
+	}{
+		Run:        rg,
+		Repository: repository,
+	}
+
+	renderPage(w, "run.html", &data)
+}
+
+// RunFailureCategories counts the failed tests of a run by category.
+type RunFailureCategories struct {
+	ReportGroupID uint
+	RunID         int64
+	RunLabel      string
+	HeadBranch    string
+	Categories    map[string]int
+}
+
+// GetRunFailureCategories lists the failure category counts of every run,
+// newest first.
+func GetRunFailureCategories(w http.ResponseWriter, r *http.Request) {
+	counts := analysis.CountRunFailureCategories(db)
+
+	result := make([]RunFailureCategories, 0)
+	for _, rg := range db.AllReportGroups() {
+		result = append(result, RunFailureCategories{
+			ReportGroupID: rg.ID,
+			RunID:         rg.RunID,
+			RunLabel:      rg.Label,
+			HeadBranch:    rg.HeadBranch,
+			Categories:    counts[rg.ID],
+		})
+	}
+	sort.Slice(result, func(i int, j int) bool {
+		return result[i].ReportGroupID > result[j].ReportGroupID
+	})
+
+	w.Header().Add("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+func buildRunMetrics() (interface{}, error) {
+	return analysis.ComputeRunMetrics(db.GetTestOutcomes()), nil
+}
+
+func loadRunMetrics() []analysis.RunMetrics {
+	var result []analysis.RunMetrics
+	if err := viewCache.GetInto("run-metrics", db.DataVersion(), &result, buildRunMetrics); err != nil {
+		fmt.Printf("Unable to load run metrics: %v\n", err)
+	}
+
+	return result
+}
+
+// GetRunMetrics lists the pass / fail counts of every run, newest first,
+// optionally restricted to one branch.
+func GetRunMetrics(w http.ResponseWriter, r *http.Request) {
+	branch := r.URL.Query().Get("branch")
+
+	runs := loadRunMetrics()
+	result := make([]analysis.RunMetrics, 0, len(runs))
+	for _, run := range runs {
+		if branch == "" || run.HeadBranch == branch {
+			result = append(result, run)
+		}
+	}
+	if writeTable(w, r, func() tables.Table { return tables.Runs(result) }) {
+		return
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(result)
 }
 
 func GetIndex(w http.ResponseWriter, r *http.Request) {
-	t, _ := template.ParseFiles("templates/test_report.html")
 	testMetrics := loadOrGenerateMetrics()
 
-	sort.Slice(testMetrics, func(i int, j int) bool {
-		return testMetrics[j].PassRate() > testMetrics[i].PassRate()
-	})
+	analysis.SortByUnreliability(testMetrics)
 
 	fmt.Printf("Rendering template with %d test metrics\n", len(testMetrics))
 	data := struct {
@@ -182,42 +790,32 @@ func GetIndex(w http.ResponseWriter, r *http.Request) {
 		TestMetrics: testMetrics,
 	}
 
-	_ = t.Execute(w, &data)
+	renderPage(w, "test_report.html", &data)
 }
 
-func loadTestHistory() []analysis.TestHistory {
-	fname := "heatmap.json"
-	var result []analysis.TestHistory
-
-	if _, err := os.Stat(fname); os.IsNotExist(err) {
-		result = make([]analysis.TestHistory, 0)
-		for _, g := range db.AllReportGroups() {
-			fmt.Printf("Loading report %d...", g.ID)
-			rg := db.LoadReportGroup(g.ID)
-			reportData := analysis.GenerateTestHistory(rg)
-			for _, h := range reportData {
-				result = append(result, h)
-			}
+func buildTestHistory() (interface{}, error) {
+	result := make([]analysis.TestHistory, 0)
+	for _, g := range db.AllReportGroups() {
+		fmt.Printf("Loading report %d...", g.ID)
+		rg := db.LoadReportGroup(g.ID)
+		if rg == nil {
+			continue
 		}
-		fmt.Println()
-
-		if f, err := os.OpenFile(fname, os.O_RDWR|os.O_CREATE, 0600); err != nil {
-			fmt.Printf("Failed to create file %s: %v\n", fname, err)
-		} else {
-			if b, err := json.Marshal(result); err != nil {
-				fmt.Printf("Failed to marshal: %v\n", err)
-			} else {
-				f.Write(b)
-			}
-			f.Close()
-		}
-	} else {
-		if f, err := os.Open(fname); err == nil {
-			if b, err := io.ReadAll(f); err == nil {
-				json.Unmarshal(b, &result)
-			}
+		reportData := analysis.GenerateTestHistory(rg)
+		for _, h := range reportData {
+			result = append(result, h)
 		}
 	}
+	fmt.Println()
+
+	return result, nil
+}
+
+func loadTestHistory() []analysis.TestHistory {
+	var result []analysis.TestHistory
+	if err := viewCache.GetInto("heatmap", db.DataVersion(), &result, buildTestHistory); err != nil {
+		fmt.Printf("Unable to load test history: %v\n", err)
+	}
 
 	return result
 }
@@ -228,44 +826,196 @@ func GetHeatmapData(w http.ResponseWriter, r *http.Request) {
 		w.WriteHeader(500)
 	} else {
 		w.Header().Add("Content-Type", "application/json")
+		// Changes with every ingestion, browsers must check back
+		w.Header().Set("Cache-Control", "no-cache")
 		w.Write(b)
 	}
 }
 
-func GetHeatmap(w http.ResponseWriter, r *http.Request) {
-	t, _ := template.ParseFiles("templates/heatmap.html")
-	testMetrics := loadOrGenerateMetrics()
+// GetHeatmapMatrix returns the heatmap aggregated for the group (a top level
+// test whose subtests to show), limit (the number of most recent runs),
+// branch, category and sort (pass-rate, failures or name) parameters.
+func GetHeatmapMatrix(w http.ResponseWriter, r *http.Request) {
+	opts := analysis.HeatmapOptions{
+		Group:    r.URL.Query().Get("group"),
+		Branch:   r.URL.Query().Get("branch"),
+		Category: r.URL.Query().Get("category"),
+		Sort:     r.URL.Query().Get("sort"),
+	}
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		if opts.Limit, err = strconv.Atoi(l); err != nil || opts.Limit < 0 || opts.Limit > maxRunsParam {
+			http.Error(w, "invalid limit", http.StatusBadRequest)
+			return
+		}
+	}
+	if v := r.URL.Query().Get("run"); v != "" {
+		run, err := strconv.ParseUint(v, 10, 64)
+		if err != nil || db.GetReportGroup(uint(run)) == nil {
+			http.Error(w, "unknown run", http.StatusBadRequest)
+			return
+		}
+		opts.Run = uint(run)
+	}
+	if !validGroup(opts.Group) {
+		http.Error(w, "unknown group", http.StatusBadRequest)
+		return
+	}
+	if !validBranch(opts.Branch) {
+		http.Error(w, "unknown branch", http.StatusBadRequest)
+		return
+	}
+	if !validCategory(opts.Category) {
+		http.Error(w, "unknown category", http.StatusBadRequest)
+		return
+	}
+	switch opts.Sort {
+	case "":
+		opts.Sort = analysis.HeatmapSortPassRate
+	case analysis.HeatmapSortPassRate, analysis.HeatmapSortFailures, analysis.HeatmapSortName:
+	default:
+		http.Error(w, "invalid sort, must be pass-rate, failures or name", http.StatusBadRequest)
+		return
+	}
 
-	sort.Slice(testMetrics, func(i int, j int) bool {
-		return testMetrics[j].PassRate() > testMetrics[i].PassRate()
-	})
+	var result analysis.Heatmap
+	build := func() (interface{}, error) {
+		return analysis.BuildHeatmap(db.GetTestOutcomes(), opts), nil
+	}
+	key := fmt.Sprintf("heatmap-%s-%d-%s-%s-%s-%d", opts.Group, opts.Limit, opts.Branch, opts.Category, opts.Sort, opts.Run)
+	if err := viewCache.GetInto(key, db.DataVersion(), &result, build); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
 
-	fmt.Printf("Rendering template with %d test metrics\n", len(testMetrics))
+	w.Header().Add("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-cache")
+	_ = json.NewEncoder(w).Encode(result)
+}
+
+func GetHeatmap(w http.ResponseWriter, r *http.Request) {
 	data := struct {
-		TestMetrics []analysis.TestMetrics
+		Repository string
+		Branches   []string
 	}{
-		TestMetrics: testMetrics,
+		Repository: repository,
+		Branches:   db.GetRunBranches(),
 	}
 
-	_ = t.Execute(w, &data)
+	renderPage(w, "heatmap.html", &data)
 }
 
-func ServeHTTP() {
-	db = ingestion.NewRecordDB()
-	if db == nil {
-		log.Fatal("Unable to open DB...")
+func GetCacheStatus(w http.ResponseWriter, r *http.Request) {
+	status := struct {
+		DataVersion uint64             `json:"data_version"`
+		Dir         string             `json:"dir"`
+		Entries     []cache.EntryStats `json:"entries"`
+	}{
+		DataVersion: db.DataVersion(),
+		Dir:         viewCache.Dir,
+		Entries:     viewCache.Stats(),
+	}
+
+	w.Header().Add("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(status)
+}
+
+func FlushCache(w http.ResponseWriter, r *http.Request) {
+	if err := viewCache.Flush(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	fmt.Println("Flushed view cache")
+	GetCacheStatus(w, r)
+}
+
+type Options struct {
+	// Addr is the address to listen on, :5000 by default
+	Addr string
+	// CacheDir holds the on-disk tier of the view cache, memory only if empty
+	CacheDir string
+	// Repository is the GitHub owner/repo used to link to runs and commits
+	Repository string
+	// MainBranch is the default branch to blame failing tests on
+	MainBranch string
+	// Comparer lists the commits between two SHAs for blame
+	Comparer analysis.CommitComparer
+	// Assets holds the templates and static directories
+	Assets fs.FS
+	// Dev reparses the templates on every request, to pick up edits to
+	// Assets without a restart
+	Dev bool
+}
+
+func ServeHTTP(database *ingestion.RecordDB, opts Options) error {
+	var err error
+	if templates, err = newTemplateSet(opts.Assets, opts.Dev); err != nil {
+		return err
+	}
+	static, err := fs.Sub(opts.Assets, "static")
+	if err != nil {
+		return err
+	}
+
+	db = database
+	viewCache = cache.New(opts.CacheDir)
+	repository = opts.Repository
+	mainBranch = opts.MainBranch
+	comparer = opts.Comparer
+	events = newEventBroker()
+	go events.watch(eventPollInterval)
+
+	addr := opts.Addr
+	if addr == "" {
+		addr = ":5000"
 	}
 
 	r := mux.NewRouter()
 
 	r.HandleFunc("/table", GetIndex).Methods("GET")
 	r.HandleFunc("/report", GetReport).Methods("GET")
+	r.HandleFunc("/report/{id}", GetReport).Methods("GET")
 	r.HandleFunc("/", GetHeatmap).Methods("GET")
 	r.HandleFunc("/heatmap", GetHeatmap).Methods("GET")
 	r.HandleFunc("/heatmap.json", GetHeatmapData).Methods("GET")
+	r.HandleFunc("/api/heatmap", GetHeatmapMatrix).Methods("GET")
 	r.HandleFunc("/report.json", GetMetrics).Methods("GET")
-	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.Dir("./static/"))))
-
-	fmt.Println("Listening and serving on http://localhost:5000")
-	log.Fatal(http.ListenAndServe(":5000", r))
+	r.HandleFunc("/api/tests/flaky", GetFlakyTests).Methods("GET")
+	r.HandleFunc("/api/tests/slowest", GetSlowestTests).Methods("GET")
+	r.HandleFunc("/api/regressions", GetRegressionData).Methods("GET")
+	r.HandleFunc("/api/tests/{id}", GetTestDetailData).Methods("GET")
+	r.HandleFunc("/api/tests/{id}/blame", GetTestBlame).Methods("GET")
+	r.HandleFunc("/tests/{id}", GetTestDetail).Methods("GET")
+	r.HandleFunc("/logs/{id}", GetTestLog).Methods("GET")
+	r.HandleFunc("/logs/{id}/raw", GetRawTestLog).Methods("GET")
+	r.HandleFunc("/regressions", GetRegressions).Methods("GET")
+	r.HandleFunc("/api/failures", GetFailureData).Methods("GET")
+	r.HandleFunc("/failures", GetFailures).Methods("GET")
+	r.HandleFunc("/api/runs", GetRunMetrics).Methods("GET")
+	r.HandleFunc("/api/runs/categories", GetRunFailureCategories).Methods("GET")
+	r.HandleFunc("/api/runs/{id}", GetRunDetailData).Methods("GET")
+	r.HandleFunc("/runs/{id}", GetRunDetail).Methods("GET")
+	r.HandleFunc("/api/correlations", GetCorrelationData).Methods("GET")
+	r.HandleFunc("/correlations", GetCorrelations).Methods("GET")
+	r.HandleFunc("/api/health", GetRunHealthData).Methods("GET")
+	r.HandleFunc("/health", GetRunHealth).Methods("GET")
+	r.HandleFunc("/api/compare", GetComparisonData).Methods("GET")
+	r.HandleFunc("/compare", GetComparison).Methods("GET")
+	r.HandleFunc("/api/quarantine", GetQuarantine).Methods("GET")
+	r.HandleFunc("/api/quarantine", AddQuarantine).Methods("POST")
+	r.HandleFunc("/api/quarantine/recommendations", GetQuarantineRecommendations).Methods("GET")
+	r.HandleFunc("/api/quarantine/export", ExportQuarantine).Methods("GET")
+	r.HandleFunc("/api/quarantine/{id}/release", ReleaseQuarantine).Methods("POST")
+	r.HandleFunc("/api/events", GetEvents).Methods("GET")
+	r.HandleFunc("/metrics", GetMetricsExposition).Methods("GET")
+	registerBadge(r)
+	r.HandleFunc("/admin/cache", GetCacheStatus).Methods("GET")
+	// DELETE only, a page on another site can't send one without a preflight
+	r.HandleFunc("/admin/cache", FlushCache).Methods("DELETE")
+	registerAPI(r.PathPrefix("/api/v1").Subrouter())
+	r.PathPrefix("/static/").Handler(http.StripPrefix("/static/", http.FileServer(http.FS(static))))
+
+	fmt.Printf("Listening and serving on %s\n", addr)
+	return http.ListenAndServe(addr, r)
 }
@@ -0,0 +1,96 @@
+package web
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"net/http"
+	"path"
+	"sync"
+)
+
+// templateSet holds the page templates of the templates directory of an
+// assets file system by file name. They are parsed once, so a broken template
+// stops the server from starting, unless dev is set, in which case they are
+// parsed on every use to pick up edits.
+type templateSet struct {
+	assets fs.FS
+	dev    bool
+
+	mu        sync.RWMutex
+	templates map[string]*template.Template
+}
+
+var templates *templateSet
+
+func newTemplateSet(assets fs.FS, dev bool) (*templateSet, error) {
+	ts := &templateSet{assets: assets, dev: dev}
+	if err := ts.parse(); err != nil {
+		return nil, err
+	}
+	return ts, nil
+}
+
+func (ts *templateSet) parse() error {
+	names, err := fs.Glob(ts.assets, "templates/*.html")
+	if err != nil {
+		return err
+	}
+	if len(names) == 0 {
+		return fmt.Errorf("no templates found")
+	}
+
+	parsed := make(map[string]*template.Template)
+	for _, name := range names {
+		t, err := template.ParseFS(ts.assets, name)
+		if err != nil {
+			return fmt.Errorf("unable to parse template %s: %w", name, err)
+		}
+		parsed[path.Base(name)] = t
+	}
+
+	ts.mu.Lock()
+	ts.templates = parsed
+	ts.mu.Unlock()
+	return nil
+}
+
+// lookup returns the template parsed from templates/name.
+func (ts *templateSet) lookup(name string) (*template.Template, error) {
+	if ts.dev {
+		if err := ts.parse(); err != nil {
+			return nil, err
+		}
+	}
+
+	ts.mu.RLock()
+	defer ts.mu.RUnlock()
+	t, ok := ts.templates[name]
+	if !ok {
+		return nil, fmt.Errorf("template %s not found", name)
+	}
+	return t, nil
+}
+
+// renderPage executes the template templates/name with data. The page is
+// rendered in full before it is sent, so a failing template results in an
+// error instead of a partial page.
+func renderPage(w http.ResponseWriter, name string, data interface{}) {
+	t, err := templates.lookup(name)
+	if err != nil {
+		fmt.Printf("Unable to render %s: %v\n", name, err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var buf bytes.Buffer
+	if err := t.Execute(&buf, data); err != nil {
+		fmt.Printf("Unable to render %s: %v\n", name, err)
+		http.Error(w, fmt.Sprintf("unable to render %s", name), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	_, _ = w.Write(buf.Bytes())
+}
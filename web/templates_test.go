@@ -0,0 +1,82 @@
+package web
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+func execute(t *testing.T, ts *templateSet, name string) string {
+	t.Helper()
+	tmpl, err := ts.lookup(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, nil); err != nil {
+		t.Fatal(err)
+	}
+	return b.String()
+}
+
+// TestTemplateSetAssets parses the templates embedded by assets.go.
+func TestTemplateSetAssets(t *testing.T) {
+	ts, err := newTemplateSet(os.DirFS(".."), false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"compare.html", "correlations.html", "failures.html", "health.html", "heatmap.html", "index.html",
+		"log.html", "regressions.html", "run.html", "test.html", "test_report.html"} {
+		if _, err := ts.lookup(name); err != nil {
+			t.Error(err)
+		}
+	}
+	if _, err := ts.lookup("missing.html"); err == nil {
+		t.Error("found a template that doesn't exist")
+	}
+}
+
+func TestTemplateSetReload(t *testing.T) {
+	assets := fstest.MapFS{
+		"templates/page.html": {Data: []byte("first")},
+		"static/js/live.js":   {Data: []byte("")},
+	}
+
+	parsed, err := newTemplateSet(assets, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dev, err := newTemplateSet(assets, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	assets["templates/page.html"] = &fstest.MapFile{Data: []byte("second")}
+	if got := execute(t, parsed, "page.html"); got != "first" {
+		t.Errorf("got %q after an edit, want the template parsed on start", got)
+	}
+	if got := execute(t, dev, "page.html"); got != "second" {
+		t.Errorf("got %q after an edit in dev mode, want the edited template", got)
+	}
+
+	// a broken edit fails the page until it is fixed
+	assets["templates/page.html"] = &fstest.MapFile{Data: []byte("{{ .Broken ")}
+	if _, err := dev.lookup("page.html"); err == nil || !strings.Contains(err.Error(), "templates/page.html") {
+		t.Errorf("got error %v for a broken template", err)
+	}
+	assets["templates/page.html"] = &fstest.MapFile{Data: []byte("third")}
+	if got := execute(t, dev, "page.html"); got != "third" {
+		t.Errorf("got %q once the template is fixed", got)
+	}
+}
+
+func TestNewTemplateSetErrors(t *testing.T) {
+	if _, err := newTemplateSet(fstest.MapFS{"templates/page.html": {Data: []byte("{{ end }}")}}, false); err == nil {
+		t.Error("got no error for a broken template")
+	}
+	if _, err := newTemplateSet(fstest.MapFS{"static/js/live.js": {Data: []byte("")}}, true); err == nil || err.Error() != "no templates found" {
+		t.Errorf("got error %v without templates", err)
+	}
+}